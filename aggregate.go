@@ -0,0 +1,60 @@
+package optimistic
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+const (
+	contextKeyAggregate   = "optimistic:aggregate"
+	saveAfterAssociations = "gorm:save_after_associations"
+)
+
+// WithAggregateBump bumps the version of a model whenever an update of it writes its
+// associations, treating the model and its children as one aggregate. Appending through
+// the Association API and saving children with FullSaveAssociations then move the parent's
+// version even when none of its own columns change, and fail with ErrOptimisticLock when
+// the parent was loaded at an older version:
+//
+//	db.Use(optimistic.NewOptimisticLock(optimistic.WithAggregateBump()))
+//
+//	err := db.Model(&order).Association("Lines").Append(&line)
+//	// order.Version moved on
+//
+// Association deletes and clears write the children alone and leave the parent as is.
+func WithAggregateBump() ConfigOption {
+	return func(cfg *Config) {
+		cfg.aggregateBump = true
+	}
+}
+
+// savesAssociations reports whether the update saves associations of its model, which
+// gorm does for every selected relationship holding a value.
+func (p *Plugin) savesAssociations(stmt *gorm.Statement) bool {
+	rv := reflect.Indirect(stmt.ReflectValue)
+	if !p.aggregateBump || rv.Kind() != reflect.Struct || len(stmt.Schema.Relationships.Relations) == 0 {
+		return false
+	}
+	selectColumns, restricted := stmt.SelectAndOmitColumns(false, true)
+	for name, rel := range stmt.Schema.Relationships.Relations {
+		if v, ok := selectColumns[name]; (ok && !v) || (!ok && restricted) {
+			continue
+		}
+		if _, zero := rel.Field.ValueOf(stmt.Context, rv); !zero {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyAggregate fails an aggregate update whose model changed concurrently before gorm
+// saves its associations, so the children roll back with the model.
+func (p *Plugin) verifyAggregate(db *gorm.DB) {
+	if !p.aggregateBump || db.Error != nil || db.DryRun || db.RowsAffected > 0 {
+		return
+	}
+	if _, ok := db.InstanceGet(contextKeyAggregate); ok {
+		_ = db.AddError(ErrOptimisticLock)
+	}
+}
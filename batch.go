@@ -0,0 +1,210 @@
+package optimistic
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const contextKeyBatchConflict = "optimistic:batch_conflict"
+
+// BatchConflictError reports the elements of a batch update, `db.Model(&rows).Updates(v)`,
+// whose rows changed since they were loaded. errors.Is(err, ErrOptimisticLock) holds for
+// every BatchConflictError.
+type BatchConflictError struct {
+	// Table the batch targeted.
+	Table string
+	// Indexes of the conflicting elements in the slice, in order.
+	Indexes []int
+	// Keys of the conflicting elements, by index: their primary key value, or a []any of
+	// the values of a composite key.
+	Keys []any
+	// Rows counts the elements of the batch.
+	Rows int
+}
+
+func (e *BatchConflictError) Error() string {
+	return fmt.Sprintf("%s on %s: %d of %d rows", ErrOptimisticLock, e.Table, len(e.Indexes), e.Rows)
+}
+
+func (e *BatchConflictError) Unwrap() error { return ErrOptimisticLock }
+
+// DeleteConflictError reports the elements of a batch delete, `db.Delete(&rows)`, whose
+// rows changed since they were loaded. errors.Is(err, ErrOptimisticLock) holds for every
+// DeleteConflictError.
+type DeleteConflictError struct {
+	// Table the batch targeted.
+	Table string
+	// Indexes of the conflicting elements in the slice, in order.
+	Indexes []int
+	// Failed holds the keys of the conflicting elements, by index: their primary key
+	// value, or a []any of the values of a composite key.
+	Failed []any
+	// Rows counts the elements of the batch.
+	Rows int
+}
+
+func (e *DeleteConflictError) Error() string {
+	return fmt.Sprintf("%s on %s: %d of %d rows not deleted", ErrOptimisticLock, e.Table, len(e.Failed), e.Rows)
+}
+
+func (e *DeleteConflictError) Unwrap() error { return ErrOptimisticLock }
+
+// batchUpdate turns the update of a slice of versioned rows into one guarded update per
+// element, each checking and bumping the element's own version:
+//
+//	err := db.Model(&orders).Updates(map[string]any{"status": "shipped"}).Error
+//	var be *optimistic.BatchConflictError
+//	if errors.As(err, &be) {
+//		// orders[be.Indexes[0]] changed since it was loaded
+//	}
+//
+// The statement gorm builds for the batch is not executed. Elements that did not conflict
+// are updated, and committed with the statement's transaction, before the conflicts are
+// reported; any other error fails the batch as a whole.
+func (p *Plugin) batchUpdate(stmt *gorm.Statement) {
+	if stmt.DryRun {
+		return
+	}
+	// the rows are written one at a time, and read back by their own statements
+	delete(stmt.Clauses, "RETURNING")
+	stmt.ConnPool = &batchConnPool{
+		guardedConnPool: guardedConnPool{ConnPool: stmt.ConnPool, stmt: stmt},
+		p:               p,
+		write: func(row *gorm.DB) *gorm.DB {
+			if len(stmt.Selects) > 0 {
+				row = row.Select(stmt.Selects)
+			}
+			if len(stmt.Omits) > 0 {
+				row = row.Omit(stmt.Omits...)
+			}
+			return row.Updates(stmt.Dest)
+		},
+		conflict: func(indexes []int, keys []any) error {
+			return &BatchConflictError{Table: stmt.Table, Indexes: indexes, Keys: keys, Rows: reflect.Indirect(stmt.ReflectValue).Len()}
+		},
+	}
+}
+
+// batchDelete turns the delete of a slice of versioned rows into one guarded delete per
+// element, each checking the element's own version:
+//
+//	err := db.Delete(&orders).Error
+//	var de *optimistic.DeleteConflictError
+//	if errors.As(err, &de) {
+//		// the rows keyed de.Failed changed since they were loaded
+//	}
+//
+// Elements that did not conflict are deleted, and committed with the statement's
+// transaction, before the conflicts are reported. Elements without a version are deleted
+// unguarded, and deletes of a slice with conditions of their own are left to gorm.
+func (p *Plugin) batchDelete(stmt *gorm.Statement) {
+	if stmt.DryRun {
+		return
+	}
+	if _, ok := stmt.Clauses[clause.Where{}.Name()]; ok {
+		return
+	}
+	delete(stmt.Clauses, "RETURNING")
+	stmt.ConnPool = &batchConnPool{
+		guardedConnPool: guardedConnPool{ConnPool: stmt.ConnPool, stmt: stmt},
+		p:               p,
+		write: func(row *gorm.DB) *gorm.DB {
+			return row.Delete(row.Statement.Model)
+		},
+		conflict: func(indexes []int, keys []any) error {
+			return &DeleteConflictError{Table: stmt.Table, Indexes: indexes, Failed: keys, Rows: reflect.Indirect(stmt.ReflectValue).Len()}
+		},
+	}
+}
+
+// reportBatch fails a batch update or delete some of whose elements conflicted. It runs
+// once the statement's transaction committed the other elements.
+func (p *Plugin) reportBatch(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	if kind := reflect.Indirect(db.Statement.ReflectValue).Kind(); kind != reflect.Slice && kind != reflect.Array {
+		return
+	}
+	if err, ok := db.InstanceGet(contextKeyBatchConflict); ok {
+		_ = db.AddError(err.(error))
+	}
+}
+
+type batchConnPool struct {
+	guardedConnPool
+	p *Plugin
+	// write writes the element row is the model of, failing with ErrOptimisticLock on
+	// conflict
+	write func(row *gorm.DB) *gorm.DB
+	// conflict returns the error reporting the conflicting elements
+	conflict func(indexes []int, keys []any) error
+}
+
+// ExecContext writes the elements of the batch in place of the batch statement.
+func (c *batchConnPool) ExecContext(ctx context.Context, _ string, _ ...interface{}) (sql.Result, error) {
+	stmt := c.stmt
+	stmt.ConnPool = c.ConnPool
+	// only the elements' statements are logged
+	stmt.SQL.Reset()
+	stmt.Vars = nil
+	rv := reflect.Indirect(stmt.ReflectValue)
+	identity := c.p.identityFields(stmt.Schema)
+	var (
+		indexes []int
+		keys    []any
+		written int64
+	)
+	for i := 0; i < rv.Len(); i++ {
+		elem := rv.Index(i)
+		if elem.Kind() != reflect.Ptr {
+			if !elem.CanAddr() {
+				copied := reflect.New(elem.Type())
+				copied.Elem().Set(elem)
+				elem = copied
+			} else {
+				elem = elem.Addr()
+			}
+		}
+		fresh := stmt.DB.Session(&gorm.Session{NewDB: true, SkipHooks: true, Context: ctx})
+		// Must reset Error
+		fresh.Error = nil
+		row := c.write(fresh.Model(elem.Interface()))
+		switch {
+		case errors.Is(row.Error, ErrOptimisticLock):
+			indexes = append(indexes, i)
+			keys = append(keys, batchKey(ctx, identity, elem.Elem()))
+		case row.Error != nil:
+			return nil, row.Error
+		default:
+			written += row.RowsAffected
+		}
+	}
+	if len(indexes) > 0 {
+		stmt.DB.InstanceSet(contextKeyConflicted, true)
+		stmt.DB.InstanceSet(contextKeyBatchConflict, c.conflict(indexes, keys))
+	}
+	return driver.RowsAffected(written), nil
+}
+
+// batchKey returns the key BatchConflictError lists for the element rv.
+func batchKey(ctx context.Context, identity []*schema.Field, rv reflect.Value) any {
+	if len(identity) == 1 {
+		key, _ := identityValue(ctx, identity[0], rv)
+		return key
+	}
+	key := make([]any, 0, len(identity))
+	for _, f := range identity {
+		val, _ := identityValue(ctx, f, rv)
+		key = append(key, val)
+	}
+	return key
+}
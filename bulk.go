@@ -0,0 +1,209 @@
+package optimistic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrPartialUpdate is returned (wrapped) by ParallelUpdate when some items were not
+// updated; their BulkResult says why.
+var ErrPartialUpdate = errors.New("items not updated")
+
+// BulkPolicy controls how ParallelUpdate retries items whose update failed with a
+// Retryable error.
+type BulkPolicy[T any] struct {
+	// Attempts bounds the writes of an item, the first included; below 1 means 1.
+	Attempts int
+	// Backoff returns the pause before retry n, counting from 1; nil retries right away.
+	Backoff func(n int) time.Duration
+	// Merge reapplies the changes of a conflicting item to current, the row as stored now,
+	// and returns the value to retry with, or nil to skip the item. Conflicts are not
+	// retried without Merge; transient errors are retried with the item as is.
+	Merge func(ctx context.Context, item, current *T) *T
+}
+
+// BulkResult is the outcome of one item of ParallelUpdate.
+type BulkResult struct {
+	// Index of the item in the items passed.
+	Index int
+	// Attempts counts the writes made.
+	Attempts int
+	// Skipped is whether Merge dropped the item.
+	Skipped bool
+	// Err is the error of the last write, or ctx's error for items never attempted.
+	Err error
+}
+
+// ParallelUpdate updates each of items, like db.Updates(&items[i]), with up to
+// concurrency guarded updates in flight, for batch jobs over many versioned rows:
+//
+//	g, ctx := errgroup.WithContext(ctx)
+//	g.Go(func() error {
+//		_, err := optimistic.ParallelUpdate(ctx, db, orders, 8, optimistic.BulkPolicy[Order]{
+//			Attempts: 3,
+//			Backoff:  func(n int) time.Duration { return time.Duration(n) * 10 * time.Millisecond },
+//			Merge: func(ctx context.Context, item, current *Order) *Order {
+//				current.Status = item.Status
+//				return current
+//			},
+//		})
+//		return err
+//	})
+//
+// Items are updated in place, and results are returned in their order. Items may be
+// structs or pointers to them; Merge of pointer items gets pointers to the pointers, and
+// the struct a pointer item points to is updated rather than the pointer replaced. The
+// error wraps ErrPartialUpdate when any item failed, or is ctx's error when ctx ended
+// first.
+func ParallelUpdate[T any](ctx context.Context, db *gorm.DB, items []T, concurrency int, policy BulkPolicy[T]) ([]BulkResult, error) {
+	results := make([]BulkResult, len(items))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range max(concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = updateItem(ctx, db, &items[i], policy)
+				results[i].Index = i
+			}
+		}()
+	}
+	next := 0
+feed:
+	for ; next < len(items) && ctx.Err() == nil; next++ {
+		select {
+		case indexes <- next:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	for i := next; i < len(items); i++ {
+		results[i] = BulkResult{Index: i, Err: ctx.Err()}
+	}
+	if next < len(items) {
+		return results, ctx.Err()
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%w: %d of %d", ErrPartialUpdate, failed, len(items))
+	}
+	return results, nil
+}
+
+// updateItem writes item, retrying it as policy allows.
+func updateItem[T any](ctx context.Context, db *gorm.DB, item *T, policy BulkPolicy[T]) BulkResult {
+	tx := db.WithContext(ctx)
+	// gorm writes pointers to structs, so pointer items are written as they are
+	target := any(item)
+	pointer := reflect.ValueOf(item).Elem()
+	if pointer.Kind() != reflect.Ptr {
+		pointer = reflect.Value{}
+	} else {
+		target = pointer.Interface()
+	}
+	var r BulkResult
+	for {
+		r.Attempts++
+		r.Err = tx.Updates(target).Error
+		if !Retryable(r.Err) || r.Attempts >= policy.Attempts {
+			return r
+		}
+		if errors.Is(r.Err, ErrOptimisticLock) {
+			if policy.Merge == nil {
+				return r
+			}
+			loaded, _, err := loadCurrent(tx, target)
+			if err != nil {
+				r.Err = err
+				return r
+			}
+			current, ok := loaded.(*T)
+			if !ok {
+				// loadCurrent returns a pointer to the struct, which is T for pointer items
+				loadedT := loaded.(T)
+				current = &loadedT
+			}
+			merged := policy.Merge(ctx, item, current)
+			switch {
+			case merged == nil, pointer.IsValid() && reflect.ValueOf(*merged).IsNil():
+				r.Skipped, r.Err = true, nil
+				return r
+			case pointer.IsValid():
+				pointer.Elem().Set(reflect.ValueOf(*merged).Elem())
+			default:
+				*item = *merged
+			}
+		}
+		if policy.Backoff != nil {
+			select {
+			case <-ctx.Done():
+				r.Err = ctx.Err()
+				return r
+			case <-time.After(policy.Backoff(r.Attempts)):
+			}
+		}
+	}
+}
+
+const bulkBumpClauseName = "optimistic:bulk_bump"
+
+// BulkBump bumps the version of every row a bulk update changes, so readers comparing
+// versions see those rows changed:
+//
+//	err := db.Model(&Order{}).Clauses(optimistic.BulkBump{}).
+//		Where("status = ?", "open").
+//		Updates(map[string]any{"status": "expired"}).Error
+//
+// Bulk updates target no single loaded row, so they are not guarded; integer versions are
+// incremented row by row, and the rows of other strategies all get the same new version.
+// Updates of a loaded model are guarded and bumped as usual, with or without BulkBump.
+type BulkBump struct{}
+
+func (x BulkBump) Name() string                 { return bulkBumpClauseName }
+func (x BulkBump) Build(clause.Builder)         {}
+func (x BulkBump) MergeClause(c *clause.Clause) { c.Expression = x }
+
+// bulkBump appends the version bump to the SET clause of an update carrying BulkBump.
+func (p *Plugin) bulkBump(stmt *gorm.Statement) {
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return
+	}
+	val, ok := p.nextVersion(stmt, f)
+	if !ok {
+		return
+	}
+	if _, assigned := val.(dbBump); assigned {
+		// the database bumps xmin and rowversion versions itself
+		return
+	}
+	bump := clause.Assignment{Column: clause.Column{Name: f.DBName}, Value: val}
+	if c, ok := stmt.Clauses[clause.Set{}.Name()]; ok {
+		set, _ := c.Expression.(clause.Set)
+		c.Expression = append(set, bump)
+		stmt.Clauses[clause.Set{}.Name()] = c
+		return
+	}
+	var set clause.Set
+	p.collectAssignments(stmt, f, &set)
+	if len(set) == 0 {
+		return
+	}
+	stmt.AddClause(append(set, bump))
+}
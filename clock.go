@@ -0,0 +1,67 @@
+package optimistic
+
+import (
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// WithClock stamps time and unixnano versions and the time component of generated
+// versions, such as ULIDs, with clock instead of gorm's NowFunc.
+func WithClock(clock func() time.Time) ConfigOption {
+	return func(cfg *Config) {
+		cfg.clock = clock
+	}
+}
+
+// WithUUIDSource draws the random bits of UUID versions from r instead of crypto/rand,
+// for example a seeded source in tests. Reads are serialized, and writes fail when they
+// do.
+func WithUUIDSource(r io.Reader) ConfigOption {
+	return func(cfg *Config) {
+		cfg.uuidSource = &lockedReader{r: r}
+	}
+}
+
+// WithULIDEntropy draws the entropy of ULID versions from r instead of crypto/rand. ULIDs
+// generated within the same millisecond stay monotonic. Reads are serialized, and writes
+// fail when they do.
+func WithULIDEntropy(r io.Reader) ConfigOption {
+	return func(cfg *Config) {
+		cfg.ulidEntropy = &lockedReader{r: r}
+	}
+}
+
+// now is the time new versions are generated at.
+func (p *Plugin) now(db *gorm.DB) time.Time {
+	if p.clock != nil {
+		return p.clock()
+	}
+	return db.NowFunc()
+}
+
+// lockedReader serializes reads of a reader that is not safe for concurrent use.
+type lockedReader struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func (l *lockedReader) Read(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Read(b)
+}
+
+// unixNanoVersion returns the unixnano version of f for a write now, the time in
+// nanoseconds, or the nanosecond after current should the clock not have moved past it.
+func (p *Plugin) unixNanoVersion(db *gorm.DB, f *schema.Field, current any) any {
+	n := p.now(db).UnixNano()
+	if c, ok := asUint64(current); ok && int64(c) >= n {
+		n = int64(c) + 1
+	}
+	return reflect.ValueOf(n).Convert(f.IndirectFieldType).Interface()
+}
@@ -0,0 +1,128 @@
+package optimistic
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const contextKeyCoalesced = "optimistic:coalesced"
+
+// WithCoalescing serializes concurrent guarded updates of the same row within this process,
+// so they reach the database one at a time instead of racing each other into conflicts.
+// An update waits at most window for the row before it proceeds anyway, which bounds the
+// delay and keeps it from deadlocking against row locks held by open transactions.
+//
+// Rows are only held for the duration of the update statement, not the transaction
+// surrounding it. Updates from other processes are not coordinated.
+func WithCoalescing(window time.Duration) ConfigOption {
+	return func(cfg *Config) {
+		cfg.coalescer = newCoalescer(window)
+	}
+}
+
+// coalescer is a keyed mutex over (table, identity) row keys.
+type coalescer struct {
+	window time.Duration
+	mu     sync.Mutex
+	rows   map[string]*rowLock
+}
+
+type rowLock struct {
+	held chan struct{}
+	refs int
+}
+
+// coalescedRow marks a row key as held in the context of the update holding it, so
+// updates it issues itself, such as conflict resolution retries, do not wait on it.
+type coalescedRow string
+
+func newCoalescer(window time.Duration) *coalescer {
+	return &coalescer{window: window, rows: map[string]*rowLock{}}
+}
+
+// acquire waits until key is free, window elapses or ctx is done, and returns the func
+// releasing the row; it is a no-op when the row was not acquired.
+func (c *coalescer) acquire(ctx context.Context, key string) func() {
+	c.mu.Lock()
+	l, ok := c.rows[key]
+	if !ok {
+		l = &rowLock{held: make(chan struct{}, 1)}
+		c.rows[key] = l
+	}
+	l.refs++
+	c.mu.Unlock()
+
+	timer := time.NewTimer(c.window)
+	defer timer.Stop()
+	select {
+	case l.held <- struct{}{}:
+		var once sync.Once
+		return func() {
+			once.Do(func() {
+				<-l.held
+				c.done(key, l)
+			})
+		}
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	c.done(key, l)
+	return func() {}
+}
+
+func (c *coalescer) done(key string, l *rowLock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l.refs--; l.refs == 0 {
+		delete(c.rows, key)
+	}
+}
+
+// coalesceUpdate holds the row targeted by a guarded update until releaseCoalesced runs.
+func (p *Plugin) coalesceUpdate(db *gorm.DB) {
+	if p.coalescer == nil || db.DryRun || db.Statement.Unscoped || db.Statement.Schema == nil {
+		return
+	}
+	stmt := db.Statement
+	if reflect.Indirect(stmt.ReflectValue).Kind() != reflect.Struct || !isTargetedModelUpdate(stmt) {
+		return
+	}
+	if p.findVersionField(stmt.Schema) == nil {
+		return
+	}
+	key := p.rowKey(stmt)
+	if stmt.Context.Value(coalescedRow(key)) != nil {
+		return
+	}
+	release := p.coalescer.acquire(stmt.Context, key)
+	stmt.Context = context.WithValue(stmt.Context, coalescedRow(key), true)
+	db.InstanceSet(contextKeyCoalesced, release)
+}
+
+// releaseCoalesced releases the row held by coalesceUpdate.
+func (p *Plugin) releaseCoalesced(db *gorm.DB) {
+	if p.coalescer == nil {
+		return
+	}
+	if release, ok := db.InstanceGet(contextKeyCoalesced); ok {
+		release.(func())()
+	}
+}
+
+// rowKey identifies the row targeted by stmt across statements.
+func (p *Plugin) rowKey(stmt *gorm.Statement) string {
+	var b strings.Builder
+	b.WriteString(stmt.Table)
+	identity, _ := p.stmtIdentity(stmt)
+	for _, f := range identity {
+		val, _ := identityValue(stmt.Context, f, stmt.ReflectValue)
+		_, _ = fmt.Fprintf(&b, "\x00%v", val)
+	}
+	return b.String()
+}
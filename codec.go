@@ -0,0 +1,163 @@
+package optimistic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// contextKeyStoredVersion marks statements that left stored versions in their model, for
+// encodeVersions to encode once the plugin is done comparing them.
+const contextKeyStoredVersion = "optimistic:stored_version"
+
+// ErrVersionCodec is returned (wrapped) when a VersionCodec fails to encode or decode.
+var ErrVersionCodec = errors.New("version codec failed")
+
+// VersionCodec transforms versions between the version column and models, for example
+// to obfuscate counters or salt them per tenant, so models, and the API responses made of
+// them, never carry the stored value:
+//
+//	type xorCodec uint64
+//
+//	func (c xorCodec) Encode(_ context.Context, _ string, v any) (any, error) { return v.(uint64) ^ uint64(c), nil }
+//	func (c xorCodec) Decode(_ context.Context, _ string, v any) (any, error) { return v.(uint64) ^ uint64(c), nil }
+//
+// Decode must invert Encode, and both must return values of the version field's type.
+// Zero versions are never transformed.
+type VersionCodec interface {
+	// Encode turns the stored version of a row of table into the one models carry.
+	Encode(ctx context.Context, table string, version any) (any, error)
+	// Decode turns the version a model carries back into the stored one.
+	Decode(ctx context.Context, table string, version any) (any, error)
+}
+
+// WithVersionCodec transforms versions with codec as rows are read into models and
+// written from them. The plugin compares and bumps stored versions, so guards, AtLeast,
+// FindFresh and Conflicting take encoded versions, and ConflictReport and VersionChange
+// carry encoded ones. GuardSQL renders the decoded, stored version.
+func WithVersionCodec(codec VersionCodec) ConfigOption {
+	return func(cfg *Config) {
+		cfg.codec = codec
+	}
+}
+
+// encodeVersion turns the stored version of a row of table into the one models carry.
+func (p *Plugin) encodeVersion(ctx context.Context, table string, version any) (any, error) {
+	if p.codec == nil || isZeroVersion(version) {
+		return version, nil
+	}
+	encoded, err := p.codec.Encode(ctx, table, version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrVersionCodec, table, err)
+	}
+	return encoded, nil
+}
+
+// decodeVersion turns the version a model of table carries into the stored one.
+func (p *Plugin) decodeVersion(ctx context.Context, table string, version any) (any, error) {
+	if p.codec == nil || isZeroVersion(version) {
+		return version, nil
+	}
+	decoded, err := p.codec.Decode(ctx, table, version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrVersionCodec, table, err)
+	}
+	return decoded, nil
+}
+
+func isZeroVersion(version any) bool {
+	v := reflect.ValueOf(version)
+	return !v.IsValid() || v.IsZero()
+}
+
+// storedVersions marks ctx for reads that keep stored versions, because the plugin
+// compares them with the ones it wrote.
+type storedVersions struct{}
+
+// keepStored returns ctx marked so that queries under it do not encode versions.
+func (p *Plugin) keepStored(ctx context.Context) context.Context {
+	if p.codec == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, storedVersions{}, true)
+}
+
+// codeRows encodes, or decodes, the version field f of each row of model type in rv.
+func (p *Plugin) codeRows(stmt *gorm.Statement, f *schema.Field, rv reflect.Value, decode bool) error {
+	code := p.encodeVersion
+	if decode {
+		code = p.decodeVersion
+	}
+	rv = reflect.Indirect(rv)
+	rows := []reflect.Value{rv}
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		rows = rows[:0]
+		for i := 0; i < rv.Len(); i++ {
+			rows = append(rows, reflect.Indirect(rv.Index(i)))
+		}
+	}
+	for _, row := range rows {
+		if row.Kind() != reflect.Struct || row.Type() != stmt.Schema.ModelType {
+			continue
+		}
+		version, zero := f.ValueOf(stmt.Context, row)
+		if zero {
+			continue
+		}
+		coded, err := code(stmt.Context, stmt.Table, version)
+		if err != nil {
+			return err
+		}
+		if err := f.Set(stmt.Context, row, coded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeVersions encodes the versions of rows read by a query, and of models a create or
+// update left stored versions in.
+func (p *Plugin) encodeVersions(db *gorm.DB) {
+	if p.codec == nil || db.DryRun || db.Statement.Schema == nil {
+		return
+	}
+	stmt := db.Statement
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return
+	}
+	if stored, _ := db.InstanceGet(contextKeyStoredVersion); stored == true {
+		db.InstanceSet(contextKeyStoredVersion, false)
+	} else if !p.readsVersion(db, f) {
+		return
+	}
+	if err := p.codeRows(stmt, f, stmt.ReflectValue, false); err != nil {
+		_ = db.AddError(err)
+	}
+}
+
+// readsVersion reports whether db is a query that scanned the version column into rows.
+func (p *Plugin) readsVersion(db *gorm.DB, f *schema.Field) bool {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Context.Value(storedVersions{}) != nil || stmt.SQL.Len() == 0 {
+		return false
+	}
+	if _, isQuery := stmt.Clauses["SELECT"]; !isQuery {
+		return false
+	}
+	return len(stmt.Selects) == 0 || slices.ContainsFunc(stmt.Selects, func(col string) bool {
+		return col == "*" || col == f.DBName || col == f.Name
+	})
+}
+
+// markStored notes whether db's model holds stored versions at the moment.
+func (p *Plugin) markStored(db *gorm.DB, stored bool) {
+	if p.codec != nil {
+		db.InstanceSet(contextKeyStoredVersion, stored)
+	}
+}
@@ -0,0 +1,120 @@
+package optimistic
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// columnTypes are the recommended version column definitions by dialect and strategy.
+// They hold the driver values of the strategies' types: integers, UUIDs as their 36
+// character text, ULIDs as their 16 bytes, KSUIDs and xids as their 27 and 20 character
+// text, times with at least microseconds and string tokens of up to 64 characters, and
+// SQL Server's own rowversion tokens.
+var columnTypes = map[string]map[Strategy]string{
+	"postgres": {
+		StrategyInt:       "bigint",
+		StrategyUUID:      "uuid",
+		StrategyULID:      "bytea",
+		StrategyTime:      "timestamptz",
+		StrategyString:    "varchar(64)",
+		StrategyKSUID:     "char(27)",
+		StrategyXID:       "char(20)",
+		StrategySnowflake: "bigint",
+		StrategyUnixNano:  "bigint",
+		StrategyHLC:       "bigint",
+		StrategySequence:  "bigint",
+	},
+	"mysql": {
+		StrategyInt:       "bigint unsigned",
+		StrategyUUID:      "char(36)",
+		StrategyULID:      "binary(16)",
+		StrategyTime:      "datetime(6)",
+		StrategyString:    "varchar(64)",
+		StrategyKSUID:     "char(27)",
+		StrategyXID:       "char(20)",
+		StrategySnowflake: "bigint",
+		StrategyUnixNano:  "bigint",
+		StrategyHLC:       "bigint",
+	},
+	"oracle": {
+		StrategyInt:       "NUMBER(20)",
+		StrategyUUID:      "VARCHAR2(36)",
+		StrategyULID:      "RAW(16)",
+		StrategyTime:      "TIMESTAMP WITH TIME ZONE",
+		StrategyString:    "VARCHAR2(64)",
+		StrategyKSUID:     "CHAR(27)",
+		StrategyXID:       "CHAR(20)",
+		StrategySnowflake: "NUMBER(20)",
+		StrategyUnixNano:  "NUMBER(20)",
+		StrategyHLC:       "NUMBER(20)",
+		StrategySequence:  "NUMBER(20)",
+	},
+	"sqlite": {
+		StrategyInt:       "integer",
+		StrategyUUID:      "text",
+		StrategyULID:      "blob",
+		StrategyTime:      "datetime",
+		StrategyString:    "text",
+		StrategyKSUID:     "text",
+		StrategyXID:       "text",
+		StrategySnowflake: "integer",
+		StrategyUnixNano:  "integer",
+		StrategyHLC:       "integer",
+	},
+	"sqlserver": {
+		StrategyRowVersion: "rowversion",
+	},
+}
+
+// ColumnType returns the recommended column type of versions of strategy on dialect, a
+// dialector name such as "postgres", or "" when there is no recommendation:
+//
+//	optimistic.ColumnType("oracle", optimistic.StrategyULID) // RAW(16)
+//
+// AutoMigrate applies them to version fields without a `type` tag.
+func ColumnType(dialect string, strategy Strategy) string {
+	return columnTypes[dialect][strategy]
+}
+
+// AutoMigrate runs db.AutoMigrate for models, creating their version and group version
+// columns with the ColumnType of db's dialect unless their fields have a `type` tag:
+//
+//	err := optimistic.AutoMigrate(db, &Order{}, &Invoice{})
+//
+// The column types are kept in the models' cached schemas, so later migrations through
+// db.AutoMigrate use them as well.
+func AutoMigrate(db *gorm.DB, models ...any) error {
+	p := pluginFor(db)
+	dialect := db.Dialector.Name()
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		f := p.findVersionField(stmt.Schema)
+		if f == nil {
+			continue
+		}
+		strategy, err := p.versionStrategy(f)
+		if err != nil {
+			return err
+		}
+		advise(f, ColumnType(dialect, strategy))
+		groups, err := versionGroups(stmt.Schema)
+		if err != nil {
+			return err
+		}
+		for _, g := range groups {
+			advise(g.field, ColumnType(dialect, StrategyInt))
+		}
+	}
+	return db.AutoMigrate(models...)
+}
+
+// advise gives f the column type columnType unless its tag names one.
+func advise(f *schema.Field, columnType string) {
+	if _, typed := f.TagSettings["TYPE"]; typed || columnType == "" {
+		return
+	}
+	f.DataType = schema.DataType(columnType)
+}
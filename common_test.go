@@ -2,19 +2,28 @@ package optimistic_test
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"testing"
 	"time"
 
 	oracle "github.com/cmmoran/gorm-oracle"
 	"github.com/docker/go-connections/nat"
+	glebarez "github.com/glebarez/sqlite"
 	"github.com/google/uuid"
 	"github.com/oklog/ulid/v2"
+	"github.com/rs/xid"
+	"github.com/segmentio/ksuid"
 	"github.com/stretchr/testify/require"
 	tc "github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/mysql"
@@ -23,6 +32,7 @@ import (
 	gmysql "gorm.io/driver/mysql"
 	gpgx "gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
+	"gorm.io/driver/sqlserver"
 	"gorm.io/gorm"
 	gormlogger "gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
@@ -31,11 +41,12 @@ import (
 )
 
 const (
-	testAll      = "all"
-	testOracle   = "oracle"
-	testSqlite   = "sqlite"
-	testPostgres = "postgres"
-	testMysql    = "mysql"
+	testAll       = "all"
+	testOracle    = "oracle"
+	testSqlite    = "sqlite"
+	testPostgres  = "postgres"
+	testMysql     = "mysql"
+	testSqlserver = "sqlserver"
 )
 
 type TestModelPtr struct {
@@ -127,7 +138,7 @@ type TestOracleModelULIDVersion struct {
 	Description string    `gorm:"type:text;"`
 	Code        uint64    `gorm:"type:numeric;"`
 	Enabled     bool      `gorm:"type:bool;"`
-	Version     ulid.ULID `gorm:"type:ulid;not null;version:ulid"`
+	Version     ulid.ULID `gorm:"not null;version:ulid"`
 }
 
 func (TestOracleModelULIDVersion) TableName() string {
@@ -153,7 +164,7 @@ type TestMysqlModelTimeVersion struct {
 	Code        uint64    `gorm:"type:numeric;"`
 	Enabled     bool      `gorm:"type:bool;"`
 	StartTime   time.Time `gorm:"type:timestamp(6)"`
-	Version     time.Time `gorm:"type:timestamp(6);not null;version"`
+	Version     time.Time `gorm:"not null;version"`
 }
 
 func (TestMysqlModelTimeVersion) TableName() string {
@@ -166,7 +177,7 @@ type TestOracleModelTimeVersion struct {
 	Code        uint64    `gorm:"type:numeric;"`
 	Enabled     bool      `gorm:"type:bool;"`
 	StartTime   time.Time `gorm:"type:TIMESTAMP WITH TIME ZONE"`
-	Version     time.Time `gorm:"type:TIMESTAMP WITH TIME ZONE;not null;version"`
+	Version     time.Time `gorm:"not null;version"`
 }
 
 func (TestOracleModelTimeVersion) TableName() string {
@@ -179,13 +190,369 @@ type TestPostgresModelTimeVersion struct {
 	Code        uint64    `gorm:"type:numeric;"`
 	Enabled     bool      `gorm:"type:bool;"`
 	StartTime   time.Time `gorm:"type:timestamp(6);"`
-	Version     time.Time `gorm:"type:timestamp(6);not null;version"`
+	Version     time.Time `gorm:"not null;version"`
 }
 
 func (TestPostgresModelTimeVersion) TableName() string {
 	return "test_models_time_version"
 }
 
+type TestModelTypedVersion struct {
+	ID          uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string `gorm:"type:text;"`
+	Code        uint64 `gorm:"type:numeric;"`
+	Version     optimistic.Version
+}
+
+func (TestModelTypedVersion) TableName() string {
+	return "test_models_typed_version"
+}
+
+type TestModelCompositeKey struct {
+	TenantID    uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Code        uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Description string `gorm:"type:text;"`
+	Version     uint64 `gorm:"type:numeric;not null;version"`
+}
+
+func (TestModelCompositeKey) TableName() string {
+	return "test_models_composite_key"
+}
+
+type TestModelNaturalKey struct {
+	Scope       string `gorm:"type:varchar(64);uniqueIndex:idx_natural_key;identity"`
+	Name        string `gorm:"type:varchar(64);uniqueIndex:idx_natural_key;identity"`
+	Description string `gorm:"type:text;"`
+	Version     uint64 `gorm:"type:numeric;not null;version"`
+}
+
+func (TestModelNaturalKey) TableName() string {
+	return "test_models_natural_key"
+}
+
+type TestModelParent struct {
+	ID          uint64           `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string           `gorm:"type:text;"`
+	Version     uint64           `gorm:"type:numeric;not null;version"`
+	Children    []TestModelChild `gorm:"foreignKey:ParentID"`
+}
+
+func (TestModelParent) TableName() string {
+	return "test_models_parent"
+}
+
+type TestModelChild struct {
+	ID          uint64 `gorm:"autoIncrement;primaryKey"`
+	ParentID    uint64
+	Description string `gorm:"type:text;"`
+	Version     uint64 `gorm:"type:numeric;not null;version"`
+}
+
+func (TestModelChild) TableName() string {
+	return "test_models_child"
+}
+
+type TestModelSoftDelete struct {
+	ID          uint64         `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string         `gorm:"type:text;"`
+	Version     uint64         `gorm:"type:numeric;not null;version"`
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+}
+
+func (TestModelSoftDelete) TableName() string {
+	return "test_models_soft_delete"
+}
+
+type TestModelColumnNames struct {
+	ID      uint64 `gorm:"column:RowID;<-:create;autoIncrement;primaryKey"`
+	Label   string `gorm:"column:RowLabel;type:varchar(64);"`
+	Version uint64 `gorm:"column:RowVersion;type:numeric;not null;version"`
+}
+
+func (TestModelColumnNames) TableName() string {
+	return "test_models_column_names"
+}
+
+type TestModelUUIDKey struct {
+	ID          uuid.UUID `gorm:"type:varchar(36);primaryKey"`
+	Description string    `gorm:"type:varchar(64);"`
+	Version     uint64    `gorm:"type:numeric;not null;version"`
+}
+
+func (TestModelUUIDKey) TableName() string {
+	return "test_models_uuid_key"
+}
+
+type TestModelPtrKey struct {
+	ID          *uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string  `gorm:"type:varchar(64);"`
+	Version     uint64  `gorm:"type:numeric;not null;version"`
+}
+
+func (TestModelPtrKey) TableName() string {
+	return "test_models_ptr_key"
+}
+
+type TestModelCounter struct {
+	ID          uint64  `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string  `gorm:"type:varchar(64);"`
+	Views       int64   `gorm:"merge:sum"`
+	Score       float64 `gorm:"merge:sum"`
+	Version     uint64  `gorm:"type:numeric;not null;version"`
+}
+
+func (TestModelCounter) TableName() string {
+	return "test_models_counter"
+}
+
+type TestModelGrouped struct {
+	ID              uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Name            string `gorm:"type:varchar(64);group:profile"`
+	Email           string `gorm:"type:varchar(64);group:profile"`
+	ProfileVersion  uint64 `gorm:"type:numeric;not null;version_group:profile"`
+	Theme           string `gorm:"type:varchar(64);group:settings"`
+	SettingsVersion uint64 `gorm:"type:numeric;not null;version_group:settings"`
+	Status          string `gorm:"type:varchar(64);"`
+	Version         uint64 `gorm:"type:numeric;not null;version"`
+}
+
+func (TestModelGrouped) TableName() string {
+	return "test_models_grouped"
+}
+
+type TestModelFingerprint struct {
+	ID          uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Status      string `gorm:"type:varchar(64);"`
+	Note        string `gorm:"type:varchar(64);"`
+	Fingerprint string `gorm:"-;fingerprint"`
+	Version     uint64 `gorm:"type:numeric;not null;version"`
+}
+
+func (TestModelFingerprint) TableName() string {
+	return "test_models_fingerprint"
+}
+
+// TestModelHooked records the version its AfterUpdate hook observes in hookedVersions.
+type TestModelHooked struct {
+	ID          uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string `gorm:"type:varchar(64);"`
+	Version     uint64 `gorm:"type:numeric;not null;version"`
+}
+
+func (TestModelHooked) TableName() string {
+	return "test_models_hooked"
+}
+
+// hookedVersions maps the IDs of TestModelHooked rows to the version AfterUpdate saw last.
+var hookedVersions sync.Map
+
+func (m *TestModelHooked) AfterUpdate(*gorm.DB) error {
+	hookedVersions.Store(m.ID, m.Version)
+	return nil
+}
+
+// TestModelStampedVersion versions by a column gorm stamps on update itself.
+type TestModelStampedVersion struct {
+	ID          uint64    `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string    `gorm:"type:varchar(64);"`
+	UpdatedAt   time.Time `gorm:"version"`
+}
+
+// TestModelAliasedVersion maps a second field to its version column.
+type TestModelAliasedVersion struct {
+	ID          uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string `gorm:"type:varchar(64);"`
+	Revision    uint64 `gorm:"column:version;->"`
+	Version     uint64 `gorm:"type:numeric;not null;version"`
+}
+
+// TestModelTwoVersions declares two version fields.
+type TestModelTwoVersions struct {
+	ID          uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string `gorm:"type:varchar(64);"`
+	Version     uint64 `gorm:"type:numeric;not null;version"`
+	Revision    uint64 `gorm:"type:numeric;not null;version"`
+}
+
+// VersionedBase carries the key and version of the models embedding it.
+type VersionedBase struct {
+	ID      uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Version uint64 `gorm:"type:numeric;not null;version"`
+}
+
+// TestModelEmbeddedVersion inherits its version field from an embedded struct.
+type TestModelEmbeddedVersion struct {
+	VersionedBase
+	Description string `gorm:"type:varchar(64);"`
+}
+
+// Revision is embedded with the version tag on the embedding field.
+type Revision struct {
+	By      string `gorm:"type:varchar(64);"`
+	Version uint64 `gorm:"type:numeric;not null"`
+}
+
+// TestModelPrefixedVersion versions by the prefixed Version column of an embedded struct.
+type TestModelPrefixedVersion struct {
+	ID          uint64   `gorm:"<-:create;autoIncrement;primaryKey"`
+	Revision    Revision `gorm:"embedded;embeddedPrefix:rev_;version"`
+	Description string   `gorm:"type:varchar(64);"`
+}
+
+// Edition is a Versioner counting versions as "e1", "e2" and so on.
+type Edition string
+
+func (e Edition) Next(time.Time) any {
+	n, _ := strconv.Atoi(strings.TrimPrefix(string(e), "e"))
+	return Edition("e" + strconv.Itoa(n+1))
+}
+
+func (e Edition) IsZero() bool { return e == "" }
+
+func (e Edition) Equal(other any) bool {
+	switch o := other.(type) {
+	case Edition:
+		return e == o
+	case string:
+		return string(e) == o
+	default:
+		return false
+	}
+}
+
+// TestModelCustomVersion versions by the Versioner Edition.
+type TestModelCustomVersion struct {
+	ID          uint64  `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string  `gorm:"type:varchar(64);"`
+	Edition     Edition `gorm:"type:varchar(32);not null;version"`
+}
+
+// TestModelStringVersion keeps its version in a VARCHAR column.
+type TestModelStringVersion struct {
+	ID          uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string `gorm:"type:varchar(64);"`
+	Revision    string `gorm:"type:varchar(64);not null;version"`
+}
+
+// TestModelKSUIDVersion keeps its version as KSUID text.
+type TestModelKSUIDVersion struct {
+	ID          uint64      `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string      `gorm:"type:varchar(64);"`
+	Version     ksuid.KSUID `gorm:"type:varchar(27);not null;version"`
+}
+
+func (TestModelKSUIDVersion) TableName() string {
+	return "test_model_ksuid_versions"
+}
+
+// TestModelXIDVersion keeps its version as xid text, selected by its tag.
+type TestModelXIDVersion struct {
+	ID          uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string `gorm:"type:varchar(64);"`
+	Version     xid.ID `gorm:"type:varchar(20);not null;version:xid"`
+}
+
+func (TestModelXIDVersion) TableName() string {
+	return "test_model_xid_versions"
+}
+
+// TestModelSnowflakeVersion selects snowflake versions by its tag.
+type TestModelSnowflakeVersion struct {
+	ID          uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string `gorm:"type:varchar(64);"`
+	Version     int64  `gorm:"not null;version:snowflake"`
+}
+
+// TestModelUnixNanoVersion keeps its version as nanoseconds since the Unix epoch.
+type TestModelUnixNanoVersion struct {
+	ID          uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string `gorm:"type:varchar(64);"`
+	Version     int64  `gorm:"not null;version:unixnano"`
+}
+
+// TestModelHLCVersion keeps its version as a hybrid logical clock.
+type TestModelHLCVersion struct {
+	ID          uint64         `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string         `gorm:"type:varchar(64);"`
+	Version     optimistic.HLC `gorm:"not null;version"`
+}
+
+// TestModelSequenceVersion draws its versions from a database sequence. It is migrated
+// by its test, on the databases that have sequences.
+type TestModelSequenceVersion struct {
+	ID          uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string `gorm:"type:varchar(64);"`
+	Version     int64  `gorm:"not null;version:sequence=test_model_versions_seq"`
+}
+
+// labelled is a column value printing as its label rather than the value it stores.
+type labelled struct {
+	value, label string
+}
+
+func (l labelled) Value() (driver.Value, error) { return l.value, nil }
+func (l labelled) String() string               { return l.label }
+
+// TestModelCustomTag marks its version with the tag name given to WithTagName.
+type TestModelCustomTag struct {
+	ID          uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string `gorm:"type:varchar(64);"`
+	Rev         uint64 `gorm:"not null;revision"`
+}
+
+// TestModelXminVersion is guarded by the postgres xmin system column. It is migrated by
+// its test, on postgres.
+type TestModelXminVersion struct {
+	ID          uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string `gorm:"type:varchar(64);"`
+	Version     uint32 `gorm:"column:xmin;->;-:migration;version:xmin"`
+}
+
+// TestModelRekeyCounter and TestModelRekeyUUID are the same table before and after Rekey
+// moves its integer versions to UUID ones. They are migrated by their test.
+type TestModelRekeyCounter struct {
+	ID          uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string `gorm:"type:varchar(64);"`
+	Version     uint64 `gorm:"not null;version"`
+}
+
+func (TestModelRekeyCounter) TableName() string { return "test_model_rekeys" }
+
+type TestModelRekeyUUID struct {
+	ID          uint64    `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string    `gorm:"type:varchar(64);"`
+	Version     uuid.UUID `gorm:"not null;version:uuid"`
+}
+
+func (TestModelRekeyUUID) TableName() string { return "test_model_rekeys" }
+
+// TestModelRowVersion is guarded by a SQL Server rowversion column. It is the only model
+// migrated on SQL Server.
+type TestModelRowVersion struct {
+	ID          uint64                `gorm:"<-:create;autoIncrement;primaryKey"`
+	Description string                `gorm:"type:varchar(64);"`
+	Version     optimistic.RowVersion `gorm:"type:rowversion;->;version"`
+}
+
+// TestModelTeam has its players as a many2many association through the versioned join
+// model TestModelTeamPlayer.
+type TestModelTeam struct {
+	ID      uint64            `gorm:"<-:create;autoIncrement;primaryKey"`
+	Name    string            `gorm:"type:varchar(64);"`
+	Players []TestModelPlayer `gorm:"many2many:test_model_team_players;"`
+}
+
+type TestModelPlayer struct {
+	ID   uint64 `gorm:"<-:create;autoIncrement;primaryKey"`
+	Name string `gorm:"type:varchar(64);"`
+}
+
+type TestModelTeamPlayer struct {
+	TestModelTeamID   uint64 `gorm:"primaryKey"`
+	TestModelPlayerID uint64 `gorm:"primaryKey"`
+	Version           uint64 `gorm:"type:numeric;not null;version"`
+}
+
 var baseTestModels = []interface{}{
 	&TestModel{},
 	&TestModelWithTime{},
@@ -194,10 +561,33 @@ var baseTestModels = []interface{}{
 	&TestModelUUIDVersion{},
 	&TestModelULIDVersion{},
 	&TestModelTimeVersion{},
+	&TestModelTypedVersion{},
+	&TestModelCompositeKey{},
+	&TestModelNaturalKey{},
+	&TestModelParent{},
+	&TestModelChild{},
+	&TestModelSoftDelete{},
+	&TestModelColumnNames{},
+	&TestModelUUIDKey{},
+	&TestModelPtrKey{},
+	&TestModelCounter{},
+	&TestModelGrouped{},
+	&TestModelFingerprint{},
+	&TestModelHooked{},
+	&TestModelEmbeddedVersion{},
+	&TestModelPrefixedVersion{},
+	&TestModelCustomVersion{},
+	&TestModelStringVersion{},
+	&TestModelKSUIDVersion{},
+	&TestModelXIDVersion{},
+	&TestModelSnowflakeVersion{},
+	&TestModelUnixNanoVersion{},
+	&TestModelHLCVersion{},
 }
 
 var testModels = map[string][]interface{}{
-	"sqlite": baseTestModels,
+	"sqlite":    baseTestModels,
+	"sqlserver": {&TestModelRowVersion{}},
 	"mysql": {
 		&TestModel{},
 		&TestModelWithTime{},
@@ -206,6 +596,28 @@ var testModels = map[string][]interface{}{
 		&TestModelUUIDVersion{},
 		&TestModelULIDVersion{},
 		&TestMysqlModelTimeVersion{},
+		&TestModelTypedVersion{},
+		&TestModelCompositeKey{},
+		&TestModelNaturalKey{},
+		&TestModelParent{},
+		&TestModelChild{},
+		&TestModelSoftDelete{},
+		&TestModelColumnNames{},
+		&TestModelUUIDKey{},
+		&TestModelPtrKey{},
+		&TestModelCounter{},
+		&TestModelGrouped{},
+		&TestModelFingerprint{},
+		&TestModelHooked{},
+		&TestModelEmbeddedVersion{},
+		&TestModelPrefixedVersion{},
+		&TestModelCustomVersion{},
+		&TestModelStringVersion{},
+		&TestModelKSUIDVersion{},
+		&TestModelXIDVersion{},
+		&TestModelSnowflakeVersion{},
+		&TestModelUnixNanoVersion{},
+		&TestModelHLCVersion{},
 	},
 	"oracle": {
 		&TestModel{},
@@ -215,6 +627,28 @@ var testModels = map[string][]interface{}{
 		&TestOracleModelUUIDVersion{},
 		&TestOracleModelULIDVersion{},
 		&TestOracleModelTimeVersion{},
+		&TestModelTypedVersion{},
+		&TestModelCompositeKey{},
+		&TestModelNaturalKey{},
+		&TestModelParent{},
+		&TestModelChild{},
+		&TestModelSoftDelete{},
+		&TestModelColumnNames{},
+		&TestModelUUIDKey{},
+		&TestModelPtrKey{},
+		&TestModelCounter{},
+		&TestModelGrouped{},
+		&TestModelFingerprint{},
+		&TestModelHooked{},
+		&TestModelEmbeddedVersion{},
+		&TestModelPrefixedVersion{},
+		&TestModelCustomVersion{},
+		&TestModelStringVersion{},
+		&TestModelKSUIDVersion{},
+		&TestModelXIDVersion{},
+		&TestModelSnowflakeVersion{},
+		&TestModelUnixNanoVersion{},
+		&TestModelHLCVersion{},
 	},
 	"postgres": {
 		&TestModel{},
@@ -224,6 +658,28 @@ var testModels = map[string][]interface{}{
 		&TestModelUUIDVersion{},
 		&TestModelULIDVersion{},
 		&TestPostgresModelTimeVersion{},
+		&TestModelTypedVersion{},
+		&TestModelCompositeKey{},
+		&TestModelNaturalKey{},
+		&TestModelParent{},
+		&TestModelChild{},
+		&TestModelSoftDelete{},
+		&TestModelColumnNames{},
+		&TestModelUUIDKey{},
+		&TestModelPtrKey{},
+		&TestModelCounter{},
+		&TestModelGrouped{},
+		&TestModelFingerprint{},
+		&TestModelHooked{},
+		&TestModelEmbeddedVersion{},
+		&TestModelPrefixedVersion{},
+		&TestModelCustomVersion{},
+		&TestModelStringVersion{},
+		&TestModelKSUIDVersion{},
+		&TestModelXIDVersion{},
+		&TestModelSnowflakeVersion{},
+		&TestModelUnixNanoVersion{},
+		&TestModelHLCVersion{},
 	},
 }
 
@@ -315,6 +771,15 @@ func setupDatabase(t testingT, skip ...bool) (*gorm.DB, context.Context) {
 			testDbContexts[testMysql] = startMysqlDatabase(t)
 		})
 		return setupMysqlDatabase(t, skip...)
+	case testSqlserver:
+		dbOnce.Do(func() {
+			if dbPass, ok := os.LookupEnv("MSSQL_PASS"); !ok {
+				dbPass = "Optimistic-Test1"
+				_ = os.Setenv("MSSQL_PASS", dbPass)
+			}
+			testDbContexts[testSqlserver] = startSqlserverDatabase(t)
+		})
+		return setupSqlserverDatabase(t, skip...)
 	}
 
 	return setupSqliteDatabase(t, skip...)
@@ -367,7 +832,7 @@ func startMysqlDatabase(t testingT) context.Context {
 	require.NoError(t, err, "failed to migrate models")
 
 	_ = db.Migrator().DropTable(testModels["mysql"]...)
-	err = db.Migrator().AutoMigrate(testModels["mysql"]...)
+	err = optimistic.AutoMigrate(db, testModels["mysql"]...)
 	require.NoError(t, err, "failed to migrate models")
 
 	sqlDb, _ := db.DB()
@@ -470,7 +935,7 @@ func startPostgresDatabase(t testingT) context.Context {
 	db, _ := setupPostgresDatabase(t)
 	err = db.Migrator().DropTable(testModels[testPostgres]...)
 	require.NoError(t, err, "failed to drop test tables")
-	err = db.Migrator().AutoMigrate(testModels[testPostgres]...)
+	err = optimistic.AutoMigrate(db, testModels[testPostgres]...)
 	require.NoError(t, err, "failed to migrate models")
 	sqlDb, _ := db.DB()
 	if sqlDb != nil {
@@ -512,7 +977,7 @@ func setupPostgresDatabase(t testingT, skip ...bool) (*gorm.DB, context.Context)
 		require.NoError(t, err)
 	}
 
-	//err = db.Migrator().AutoMigrate(testModels["postgres"]...)
+	//err = optimistic.AutoMigrate(db, testModels["postgres"]...)
 	//require.NoError(t, err, "failed to migrate models")
 
 	//err = pgContainer.Snapshot(testDbContext)
@@ -611,7 +1076,7 @@ func startOracleDatabase(t testingT) context.Context {
 	db, _ := setupOracleDatabase(t)
 	err = db.Migrator().DropTable(testModels["oracle"]...)
 	require.NoError(t, err, "failed to drop test tables")
-	err = db.Migrator().AutoMigrate(testModels["oracle"]...)
+	err = optimistic.AutoMigrate(db, testModels["oracle"]...)
 	require.NoError(t, err, "failed to migrate models")
 	sqlDb, _ := db.DB()
 	if sqlDb != nil {
@@ -680,14 +1145,168 @@ func setupOracleDatabase(t testingT, skip ...bool) (*gorm.DB, context.Context) {
 	return db, testDbContexts[testOracle]
 }
 
+func startSqlserverDatabase(t testingT) context.Context {
+	var (
+		err  error
+		ctx  = testDbContexts[testSqlserver]
+		pass = os.Getenv("MSSQL_PASS")
+		host string
+		port string
+	)
+	if _, ok := os.LookupEnv("MSSQL_SKIP_CONTAINER"); !ok {
+		req := tc.ContainerRequest{
+			Image:        "mcr.microsoft.com/mssql/server:2022-latest",
+			ExposedPorts: []string{"1433/tcp"},
+			Env: map[string]string{
+				"ACCEPT_EULA":       "Y",
+				"MSSQL_SA_PASSWORD": pass,
+			},
+			WaitingFor: wait.ForLog("SQL Server is now ready for client connections").WithStartupTimeout(2 * time.Minute),
+		}
+
+		msContainer, mserr := tc.GenericContainer(ctx, tc.GenericContainerRequest{
+			ContainerRequest: req,
+			Started:          true,
+			Logger:           &ow{testingT: t},
+		})
+		require.NoError(t, mserr, "failed to start container")
+		var mapped nat.Port
+		host, err = msContainer.Host(ctx)
+		require.NoError(t, err, "Failed to get container host")
+		if envHost, envHostOk := os.LookupEnv("OVERRIDE_HOST"); envHostOk && host == "localhost" && len(envHost) > 0 {
+			host = envHost
+		}
+		mapped, err = msContainer.MappedPort(ctx, "1433")
+		require.NoError(t, err, "Failed to get mapped port")
+		port = mapped.Port()
+		ctx = context.WithValue(ctx, "db", msContainer)
+	} else {
+		host = os.Getenv("MSSQL_HOST")
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		port = os.Getenv("MSSQL_PORT")
+		if port == "" {
+			port = "1433"
+		}
+	}
+	dsn := fmt.Sprintf("sqlserver://sa:%s@%s?database=master", url.QueryEscape(pass), net.JoinHostPort(host, port))
+	ctx = context.WithValue(ctx, "dsn", dsn)
+	testDbContexts[testSqlserver] = ctx
+
+	db, _ := setupSqlserverDatabase(t)
+	err = db.Migrator().DropTable(testModels[testSqlserver]...)
+	require.NoError(t, err, "failed to drop test tables")
+	err = optimistic.AutoMigrate(db, testModels[testSqlserver]...)
+	require.NoError(t, err, "failed to migrate models")
+	sqlDb, _ := db.DB()
+	if sqlDb != nil {
+		_ = sqlDb.Close()
+	}
+
+	return testDbContexts[testSqlserver]
+}
+
+func setupSqlserverDatabase(t testingT, skip ...bool) (*gorm.DB, context.Context) {
+	dsn, _ := findDbContextInfo(testDbContexts[testSqlserver])
+	l := gormlogger.New(&ow{testingT: t}, gormlogger.Config{
+		SlowThreshold: time.Second,
+		Colorful:      true,
+		LogLevel:      gormlogger.Info,
+	})
+	db, err := gorm.Open(sqlserver.Open(dsn), &gorm.Config{
+		Logger: l,
+		NowFunc: func() time.Time {
+			return time.Now().UTC().Truncate(time.Microsecond)
+		},
+	})
+	require.NoError(t, err, "failed to connect to sqlserver database")
+	if len(skip) == 0 || !skip[0] {
+		err = db.Use(optimistic.NewOptimisticLock())
+		require.NoError(t, err)
+	}
+
+	return db, testDbContexts[testSqlserver]
+}
+
+// standInConn stands in for a connection to a database the sandbox lacks. It records the
+// statements it is sent, answers queries by running reply on db and executions with
+// affected rows.
+type standInConn struct {
+	db         *sql.DB
+	reply      string
+	affected   int64
+	statements []string
+}
+
+// openStandInConn opens dialect, "postgres" or "sqlserver", over a standInConn answering
+// from an in-memory SQLite database, with the plugin configured by options installed.
+func openStandInConn(t testingT, dialect string, options ...optimistic.ConfigOption) (*gorm.DB, *standInConn) {
+	replies, _ := setupSqliteDatabase(t, true)
+	sqlDb, err := replies.DB()
+	require.NoError(t, err)
+	conn := &standInConn{db: sqlDb}
+	var dialector gorm.Dialector
+	switch dialect {
+	case testPostgres:
+		dialector = gpgx.New(gpgx.Config{Conn: conn})
+	case testSqlserver:
+		dialector = sqlserver.New(sqlserver.Config{Conn: conn})
+	default:
+		panic("no stand-in for " + dialect)
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{SkipDefaultTransaction: true})
+	require.NoError(t, err)
+	require.NoError(t, db.Use(optimistic.NewOptimisticLock(options...)))
+	return db, conn
+}
+
+func (c *standInConn) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.statements = append(c.statements, query)
+	return c.db.PrepareContext(ctx, c.reply)
+}
+
+func (c *standInConn) ExecContext(_ context.Context, query string, _ ...interface{}) (sql.Result, error) {
+	c.statements = append(c.statements, query)
+	return driver.RowsAffected(c.affected), nil
+}
+
+func (c *standInConn) QueryContext(ctx context.Context, query string, _ ...interface{}) (*sql.Rows, error) {
+	c.statements = append(c.statements, query)
+	return c.db.QueryContext(ctx, c.reply)
+}
+
+func (c *standInConn) QueryRowContext(ctx context.Context, query string, _ ...interface{}) *sql.Row {
+	c.statements = append(c.statements, query)
+	return c.db.QueryRowContext(ctx, c.reply)
+}
+
+// BeginTx begins a stand-in transaction, which commits and rolls back nothing.
+func (c *standInConn) BeginTx(context.Context, *sql.TxOptions) (gorm.ConnPool, error) {
+	return standInTx{c}, nil
+}
+
+type standInTx struct {
+	*standInConn
+}
+
+func (standInTx) Commit() error   { return nil }
+func (standInTx) Rollback() error { return nil }
+
 func setupSqliteDatabase(t testingT, skip ...bool) (*gorm.DB, context.Context) {
 	l := gormlogger.New(&ow{testingT: t}, gormlogger.Config{
 		SlowThreshold: time.Second,
 		Colorful:      true,
 		LogLevel:      gormlogger.Info,
 	})
-	// Initialize a SQLite database in memory for testing
-	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:memdb-%s?mode=memory&cache=shared", uuid.New().String())), &gorm.Config{
+	// Initialize a SQLite database in memory for testing; SQLITE_DRIVER=glebarez runs the
+	// suite against the CGO-free driver instead
+	dsn := fmt.Sprintf("file:memdb-%s?mode=memory&cache=shared", uuid.New().String())
+	dialector := sqlite.Open(dsn)
+	if os.Getenv("SQLITE_DRIVER") == "glebarez" {
+		dialector = glebarez.Open(dsn)
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{
 		SkipDefaultTransaction: false,
 		Logger:                 l,
 		NowFunc: func() time.Time {
@@ -704,8 +1323,86 @@ func setupSqliteDatabase(t testingT, skip ...bool) (*gorm.DB, context.Context) {
 	// Migrate the schema for TestModel
 	err = db.Migrator().DropTable(testModels["sqlite"]...)
 	require.NoError(t, err, "failed to drop test tables")
-	err = db.Migrator().AutoMigrate(testModels["sqlite"]...)
+	err = optimistic.AutoMigrate(db, testModels["sqlite"]...)
 	require.NoError(t, err)
 
 	return db, testDbContexts[testSqlite]
 }
+
+// openBenchDatabase opens a private in-memory SQLite database holding test_models, with
+// the plugin installed, configured by options, when guarded is set.
+func openBenchDatabase(b *testing.B, guarded bool, options ...optimistic.ConfigOption) *gorm.DB {
+	b.Helper()
+	dsn := fmt.Sprintf("file:bench-%s?mode=memory&cache=shared", uuid.New().String())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 gormlogger.Discard,
+	})
+	require.NoError(b, err)
+	require.NoError(b, db.AutoMigrate(&TestModel{}))
+	if guarded {
+		require.NoError(b, db.Use(optimistic.NewOptimisticLock(options...)))
+	}
+	return db
+}
+
+// mapCache is an optimistic.Cache counting its writes.
+type mapCache struct {
+	mu      sync.Mutex
+	entries map[string]any
+	sets    int
+}
+
+func (c *mapCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.entries[key]
+	return v, ok
+}
+
+func (c *mapCache) Set(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = value
+	c.sets++
+}
+
+// xorCodec is an optimistic.VersionCodec salting uint64 versions per table.
+type xorCodec uint64
+
+func (c xorCodec) salt(table string) uint64 {
+	return uint64(c) ^ uint64(len(table))<<32
+}
+
+func (c xorCodec) Encode(_ context.Context, table string, v any) (any, error) {
+	n, ok := v.(uint64)
+	if !ok {
+		return nil, fmt.Errorf("unexpected version %T", v)
+	}
+	return n ^ c.salt(table), nil
+}
+
+func (c xorCodec) Decode(ctx context.Context, table string, v any) (any, error) {
+	return c.Encode(ctx, table, v)
+}
+
+// zonedClock stamps time versions in a zone east of UTC, unlike the one drivers read them
+// back in.
+func zonedClock() time.Time {
+	return time.Now().In(time.FixedZone("UTC+05:30", 5*3600+30*60))
+}
+
+// brokenQueue is an optimistic.ReconcileQueue whose Dequeue always fails, counting the
+// calls.
+type brokenQueue struct {
+	dequeues atomic.Int64
+}
+
+func (q *brokenQueue) Enqueue(context.Context, optimistic.Reconciliation) error {
+	return optimistic.ErrQueueFull
+}
+
+func (q *brokenQueue) Dequeue(context.Context) (optimistic.Reconciliation, error) {
+	q.dequeues.Add(1)
+	return optimistic.Reconciliation{}, errors.New("queue unavailable")
+}
@@ -0,0 +1,282 @@
+package optimistic
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	checkedDeleteClauseName = "optimistic:checked_delete"
+	contextKeyDeleteTargets = "optimistic:delete_targets"
+)
+
+// CheckedDelete makes a scoped delete check-then-delete safe for batch cleanup jobs:
+//
+//	err := db.Clauses(optimistic.CheckedDelete{}).
+//		Where("enabled = ?", false).
+//		Delete(&Model{}).Error
+//	if errors.Is(err, optimistic.ErrOptimisticLock) {
+//		// a matching row changed while it was being deleted; nothing was deleted
+//	}
+//
+// The versions of the matching rows are captured within the delete's transaction, and
+// only rows still at their captured version are deleted. If any of them changed in the
+// meantime the delete fails with ErrOptimisticLock and is rolled back, which requires
+// the default transaction or one of your own. Rows that start matching the conditions
+// after the capture are left alone.
+type CheckedDelete struct{}
+
+func (x CheckedDelete) Name() string                 { return checkedDeleteClauseName }
+func (x CheckedDelete) Build(clause.Builder)         {}
+func (x CheckedDelete) MergeClause(c *clause.Clause) { c.Expression = x }
+
+// captureDelete narrows a CheckedDelete to the (identity, version) pairs it matches now.
+func (p *Plugin) captureDelete(db *gorm.DB) {
+	if db.Error != nil || db.DryRun {
+		return
+	}
+	stmt := db.Statement
+	if _, ok := stmt.Clauses[checkedDeleteClauseName]; !ok || stmt.Schema == nil || stmt.SQL.Len() > 0 {
+		return
+	}
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		_ = db.AddError(fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Table))
+		return
+	}
+	where, ok := stmt.Clauses[clause.Where{}.Name()].Expression.(clause.Where)
+	if !ok {
+		where = clause.Where{}
+	}
+	where.Exprs = append(where.Exprs, primaryKeyConds(stmt)...)
+	if len(where.Exprs) == 0 {
+		// leave unconditional deletes to gorm's own checks
+		return
+	}
+
+	identity := p.identityFields(stmt.Schema)
+	columns := []string{f.DBName}
+	for _, idf := range identity {
+		columns = append(columns, idf.DBName)
+	}
+	rows := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+	// the captured versions guard the delete, so they are kept as stored
+	fresh := db.Session(&gorm.Session{NewDB: true, SkipHooks: true, Context: p.keepStored(stmt.Context)})
+	// Must reset Error
+	fresh.Error = nil
+	if stmt.Unscoped {
+		fresh = fresh.Unscoped()
+	}
+	if err := fresh.Table(stmt.Table).Select(columns).Clauses(where).Find(rows.Interface()).Error; err != nil {
+		_ = db.AddError(err)
+		return
+	}
+
+	rows = rows.Elem()
+	targets := make([]clause.Expression, 0, rows.Len())
+	for i := 0; i < rows.Len(); i++ {
+		row := &gorm.Statement{DB: db, Context: stmt.Context, Schema: stmt.Schema, ReflectValue: rows.Index(i)}
+		version, _ := f.ValueOf(stmt.Context, rows.Index(i))
+		targets = append(targets, clause.And(append(identityConds(row, identity), clause.Eq{
+			Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName},
+			Value:  version,
+		})...))
+	}
+	if len(targets) == 0 {
+		stmt.AddClause(clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "1 = 0"}}})
+	} else {
+		stmt.AddClause(clause.Where{Exprs: []clause.Expression{clause.Or(targets...)}})
+	}
+	db.InstanceSet(contextKeyDeleteTargets, int64(len(targets)))
+}
+
+// guardDelete adds the version guard to deletes of a loaded model, so `db.Delete(&m)`
+// fails with ErrOptimisticLock when the row changed since m was read:
+//
+//	err := db.Clauses(optimistic.Conflict{AttachCurrent: true}).Delete(&order).Error
+//	var ce *optimistic.ConflictError
+//	if errors.As(err, &ce) {
+//		// ce.Current is the row as it is now
+//	}
+//
+// Deletes without a loaded version, such as `db.Delete(&Order{}, id)`, and Unscoped
+// deletes are left alone. Deletes of a slice are guarded element by element, see
+// batchDelete.
+func (p *Plugin) guardDelete(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || (db.DryRun && !p.dryRunGuards) || stmt.Unscoped || stmt.SQL.Len() > 0 {
+		return
+	}
+	if _, checked := stmt.Clauses[checkedDeleteClauseName]; checked || isForced(stmt) {
+		return
+	}
+	rv := reflect.Indirect(stmt.ReflectValue)
+	if !isTargetedModelUpdate(stmt) {
+		return
+	}
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return
+	}
+	if rv.Kind() == reflect.Slice {
+		p.batchDelete(stmt)
+		return
+	}
+	modelVal, zero := f.ValueOf(stmt.Context, rv)
+	if zero {
+		return
+	}
+	oldVal, err := p.decodeVersion(stmt.Context, stmt.Table, modelVal)
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	var exprs []clause.Expression
+	if len(stmt.Schema.PrimaryFields) == 0 {
+		exprs = identityConds(stmt, p.identityFields(stmt.Schema))
+	}
+	stmt.AddClause(clause.Where{Exprs: append(exprs, clause.Eq{
+		Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName},
+		Value:  oldVal,
+	})})
+	if !stmt.DryRun {
+		db.InstanceSet(contextKeyFromVersion, oldVal)
+	}
+}
+
+// verifyDelete fails a guarded delete that deleted no row, and a CheckedDelete that did
+// not delete every captured row.
+func (p *Plugin) verifyDelete(db *gorm.DB) {
+	if db.Error != nil || db.DryRun {
+		return
+	}
+	if _, guarded := db.InstanceGet(contextKeyFromVersion); guarded && db.RowsAffected == 0 {
+		_ = db.AddError(ErrOptimisticLock)
+		return
+	}
+	targets, ok := db.InstanceGet(contextKeyDeleteTargets)
+	if !ok {
+		return
+	}
+	if db.RowsAffected != targets.(int64) {
+		_ = db.AddError(ErrOptimisticLock)
+	}
+}
+
+// resolveDelete runs the Conflict clause of a guarded delete that deleted no row. The
+// current row is attached with AttachCurrent and passed to OnVersionMismatch, whose
+// result is deleted instead, guarded by its own version; nil leaves the row alone.
+//
+// A delete carrying clause.Returning fills the model with the deleted row, or on conflict
+// with the row that survived:
+//
+//	err := db.Clauses(clause.Returning{}).Delete(&order).Error
+//	if errors.Is(err, optimistic.ErrOptimisticLock) {
+//		// order holds the current row
+//	}
+func (p *Plugin) resolveDelete(db *gorm.DB) {
+	if !errors.Is(db.Error, ErrOptimisticLock) {
+		return
+	}
+	if _, guarded := db.InstanceGet(contextKeyFromVersion); !guarded {
+		return
+	}
+	db.InstanceSet(contextKeyConflicted, true)
+	expected, _ := db.InstanceGet(contextKeyFromVersion)
+	encoded, _ := p.encodeVersion(db.Statement.Context, db.Statement.Table, expected)
+	report := newConflictReport(db.Statement, encoded)
+	defer p.describeConflict(db, report)
+	returning, returns := db.Statement.Clauses["RETURNING"].Expression.(clause.Returning)
+	conflict, ok := conflictClause(db.Statement)
+	if !returns && (!ok || (conflict.OnVersionMismatch == nil && !conflict.AttachCurrent)) {
+		return
+	}
+	current, err := p.reload(db, db.Statement)
+	if err != nil {
+		// the row is gone, so there is nothing to resolve against
+		attachCurrent(db, conflict, nil)
+		return
+	}
+	if f := p.findVersionField(db.Statement.Schema); f != nil {
+		report.CurrentVersion, _ = f.ValueOf(db.Statement.Context, reflect.ValueOf(current))
+	}
+	if conflict.OnVersionMismatch == nil {
+		attachCurrent(db, conflict, current)
+		if returns {
+			returnRow(db.Statement, returning, current)
+		}
+		return
+	}
+
+	reporter := newDiffReporter()
+	cmp.Diff(db.Statement.ReflectValue.Interface(), anyDeref(current), cmp.Reporter(reporter), cmp.Exporter(exportAll))
+	resolved := conflict.OnVersionMismatch(current, reporter.Diff())
+	if resolved == nil {
+		db.Logger.Warn(db.Statement.Context, "[%s] canceled delete of %s at version %s on conflict",
+			p.Name(), db.Statement.Table, p.versionString(expected))
+		attachCurrent(db, conflict, current)
+		if returns {
+			returnRow(db.Statement, returning, current)
+		}
+		return
+	}
+	retry := db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+	// Must reset Error
+	retry.Error = nil
+	if returns {
+		retry = retry.Clauses(returning)
+	}
+	retry = retry.Delete(resolved)
+	if p.strictRetry && retry.Error == nil && retry.RowsAffected == 0 {
+		retry.Error = &RetryError{Table: db.Statement.Table, Attempts: 2}
+	}
+	db.Error = retry.Error
+	db.RowsAffected = retry.RowsAffected
+	if returns && retry.Error == nil {
+		returnRow(db.Statement, returning, resolved)
+	}
+}
+
+// returnRow copies the columns returning names, or all of them, from row into the model of
+// stmt, as RETURNING would have.
+func returnRow(stmt *gorm.Statement, returning clause.Returning, row any) {
+	rv := reflect.Indirect(stmt.ReflectValue)
+	src := reflect.Indirect(reflect.ValueOf(row))
+	if rv.Kind() != reflect.Struct || !rv.CanSet() || src.Type() != rv.Type() {
+		return
+	}
+	columns := make(map[string]bool, len(returning.Columns))
+	for _, c := range returning.Columns {
+		columns[c.Name] = true
+	}
+	for _, f := range stmt.Schema.Fields {
+		if f.DBName == "" || (len(columns) > 0 && !columns[f.DBName] && !columns["*"]) {
+			continue
+		}
+		f.ReflectValueOf(stmt.Context, rv).Set(f.ReflectValueOf(stmt.Context, src))
+	}
+}
+
+// primaryKeyConds returns the primary key conditions gorm derives for a delete from the
+// values it was given, which it only adds once the delete is built.
+func primaryKeyConds(stmt *gorm.Statement) []clause.Expression {
+	var exprs []clause.Expression
+	targets := []reflect.Value{stmt.ReflectValue}
+	if stmt.Model != nil && stmt.Dest != stmt.Model {
+		targets = append(targets, reflect.ValueOf(stmt.Model))
+	}
+	for _, target := range targets {
+		_, queryValues := schema.GetIdentityFieldValuesMap(stmt.Context, target, stmt.Schema.PrimaryFields)
+		column, values := schema.ToQueryValues(stmt.Table, stmt.Schema.PrimaryFieldDBNames, queryValues)
+		if len(values) > 0 {
+			exprs = append(exprs, clause.IN{Column: column, Values: values})
+		}
+	}
+	return exprs
+}
@@ -0,0 +1,19 @@
+// Package optimistic adds optimistic locking to GORM. Models carry a version field, which
+// every targeted update and delete checks and bumps, so a write made from a stale copy of
+// a row fails with ErrOptimisticLock instead of overwriting the writes it missed:
+//
+//	db.Use(optimistic.NewOptimisticLock())
+//
+// # Upgrading
+//
+// UUID and ULID versions are no longer generated by this package. Their generators live in
+// the uuidversion and ulidversion packages, which keep github.com/google/uuid and
+// github.com/oklog/ulid/v2 out of programs that do not use them. Programs with UUID or
+// ULID version fields must import them for their side effect, or writes of those models
+// fail with ErrNoGenerator:
+//
+//	import (
+//		_ "github.com/cmmoran/optimistic/ulidversion"
+//		_ "github.com/cmmoran/optimistic/uuidversion"
+//	)
+package optimistic
@@ -0,0 +1,117 @@
+package optimistic
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ConflictError is a version conflict carrying details about the blocked statement.
+// errors.Is(err, ErrOptimisticLock) holds for every ConflictError. Guarded updates and
+// deletes whose conflict is left unresolved fail with one, so an API can answer with the
+// row's key and versions without querying it again:
+//
+//	var ce *optimistic.ConflictError
+//	if errors.As(err, &ce) {
+//		// 409 Conflict, expected ce.ExpectedVersion but the row is at ce.CurrentVersion
+//	}
+type ConflictError struct {
+	// Table the guarded statement targeted.
+	Table string
+	// PrimaryKey holds the key columns of the row, by column name: its primary key, or its
+	// identity columns.
+	PrimaryKey map[string]any
+	// ExpectedVersion is the version the statement was guarded by, as the model carried it.
+	ExpectedVersion any
+	// Current is a pointer to the freshly loaded row that blocked the statement. It is only
+	// populated when the statement carried Conflict{AttachCurrent: true} and is nil when the
+	// row no longer exists.
+	Current any
+	// CurrentVersion is the version of the current row, read when the conflict is reported
+	// or taken from the rows a query failing AssertVersion loaded. It is nil when the row no
+	// longer exists.
+	CurrentVersion any
+	// Fingerprint is the one the model carried, and CurrentFingerprint the current row's,
+	// for models with a fingerprint field. CurrentFingerprint is empty when the row no
+	// longer exists.
+	Fingerprint, CurrentFingerprint string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s on %s", ErrOptimisticLock, e.Table)
+}
+
+// SameFingerprint reports whether the row changed since the model was loaded, but none of
+// its significant columns did, so the update can safely be retried at CurrentVersion:
+//
+//	var ce *optimistic.ConflictError
+//	if errors.As(err, &ce) && ce.SameFingerprint() {
+//		order.Version = ce.CurrentVersion.(uint64)
+//		err = db.Updates(&order).Error
+//	}
+func (e *ConflictError) SameFingerprint() bool {
+	return e.Fingerprint != "" && e.Fingerprint == e.CurrentFingerprint
+}
+
+func (e *ConflictError) Unwrap() error { return ErrOptimisticLock }
+
+// RetryError reports, under WithStrictRetry, a Conflict handler's merged value that
+// updated no row when written. Both errors.Is(err, ErrRetryNotApplied) and
+// errors.Is(err, ErrOptimisticLock) hold for every RetryError.
+type RetryError struct {
+	// Table the guarded statement targeted.
+	Table string
+	// Attempts counts the writes made, the aborted update included.
+	Attempts int
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s on %s after %d attempts", ErrRetryNotApplied, e.Table, e.Attempts)
+}
+
+func (e *RetryError) Unwrap() []error { return []error{ErrRetryNotApplied, ErrOptimisticLock} }
+
+// newConflictError builds the error for a statement whose guard matched no row, loading
+// the current row when the statement asked for it.
+func newConflictError(stmt *gorm.Statement) error {
+	ce := &ConflictError{Table: stmt.Table}
+	if conflict, ok := conflictClause(stmt); ok && conflict.AttachCurrent {
+		if current, _, err := loadCurrent(stmt.DB, stmt.Model); err == nil {
+			ce.Current = current
+		}
+	}
+	return ce
+}
+
+func conflictClause(stmt *gorm.Statement) (Conflict, bool) {
+	c, ok := stmt.Clauses[conflictClauseName]
+	if !ok {
+		return Conflict{}, false
+	}
+	conflict, ok := c.Expression.(Conflict)
+	return conflict, ok
+}
+
+// attachCurrent replaces the bare ErrOptimisticLock of an aborted update with a
+// *ConflictError carrying current when the statement asked for it.
+func attachCurrent(db *gorm.DB, conflict Conflict, current any) {
+	if conflict.AttachCurrent && db.Error == ErrOptimisticLock {
+		db.Error = &ConflictError{Table: db.Statement.Table, Current: current}
+	}
+}
+
+// TransientError reports, under WithTransientErrors, a guarded update that failed with a
+// driver error worth retrying, such as a serialization failure or a deadlock. Both
+// errors.Is(err, ErrTransient) and errors.Is(err, Err) hold for every TransientError.
+type TransientError struct {
+	// Table the guarded statement targeted.
+	Table string
+	// Err is the error the update failed with.
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return fmt.Sprintf("%s on %s: %v", ErrTransient, e.Table, e.Err)
+}
+
+func (e *TransientError) Unwrap() []error { return []error{ErrTransient, e.Err} }
@@ -0,0 +1,50 @@
+package optimistic
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const expectVersionClauseName = "optimistic:expect_version"
+
+// ExpectVersion guards an update by Version in place of the version the model carries. Use
+// Expect to construct it. It only guards writes; AssertedVersion, from AssertVersion,
+// checks reads.
+type ExpectVersion struct {
+	Version any
+}
+
+// Expect returns an update clause guarding the update of a model by version, such as the
+// one an API request carries, instead of the model's own version field:
+//
+//	order := Order{ID: req.ID, Status: req.Status}
+//	err := db.Clauses(optimistic.Expect(req.Version)).Updates(&order).Error
+//	if errors.Is(err, optimistic.ErrOptimisticLock) {
+//		// the client's copy is stale; 409 Conflict
+//	}
+//
+// version is set on the model before the update, converted to the field's type like a
+// scanned column, so the model carries it on conflict and the new version on success.
+// Use AssertVersion to check the version of queried rows.
+func Expect(version any) ExpectVersion {
+	return ExpectVersion{Version: version}
+}
+
+func (x ExpectVersion) Name() string                 { return expectVersionClauseName }
+func (x ExpectVersion) Build(clause.Builder)         {}
+func (x ExpectVersion) MergeClause(c *clause.Clause) { c.Expression = x }
+
+// expectVersion sets the version of the ExpectVersion clause of stmt on its model.
+func expectVersion(stmt *gorm.Statement, f *schema.Field) error {
+	c, ok := stmt.Clauses[expectVersionClauseName]
+	if !ok {
+		return nil
+	}
+	if stmt.ReflectValue.Kind() != reflect.Struct || !stmt.ReflectValue.CanAddr() {
+		return gorm.ErrInvalidValue
+	}
+	return f.Set(stmt.Context, stmt.ReflectValue, c.Expression.(ExpectVersion).Version)
+}
@@ -0,0 +1,127 @@
+package optimistic
+
+import (
+	"runtime/debug"
+
+	"gorm.io/gorm"
+)
+
+// modulePath is the module path reported in build info.
+const modulePath = "github.com/cmmoran/optimistic"
+
+// FeatureSet describes the plugin build and what it does on the connection it was
+// initialized for, for frameworks branching on capabilities or serving diagnostics.
+type FeatureSet struct {
+	// Version of the plugin module from the build info, "(devel)" when unknown.
+	Version string `json:"version"`
+	// Dialect is the name of the connection's dialector.
+	Dialect string `json:"dialect"`
+	// Strategies are the version strategies the plugin supports.
+	Strategies []Strategy `json:"strategies"`
+	// Tables maps the tables whose versions the plugin handled so far to their strategy.
+	Tables map[string]Strategy `json:"tables"`
+	// Returning is whether updates read the written row back through RETURNING.
+	Returning bool `json:"returning"`
+	// VersionOnlyReturning is whether RETURNING reads back only key and version columns.
+	VersionOnlyReturning bool `json:"versionOnlyReturning"`
+	// DryRunGuards is whether DryRun updates carry the plugin's clauses.
+	DryRunGuards bool `json:"dryRunGuards"`
+	// LockingQueries is whether rows read by locking queries are held as by GetForUpdate.
+	LockingQueries bool `json:"lockingQueries"`
+	// UpsertGuard is whether OnConflict creates only update rows at their loaded version.
+	UpsertGuard bool `json:"upsertGuard"`
+	// AggregateBump is whether updates writing associations bump the model's version.
+	AggregateBump bool `json:"aggregateBump"`
+	// SkipNoopUpdates is whether updates that would not change their row are skipped.
+	SkipNoopUpdates bool `json:"skipNoopUpdates"`
+	// Registry is the table mirroring row versions, see WithVersionRegistry.
+	Registry string `json:"registry,omitempty"`
+	// RowLocking is whether the database locks rows read by GetForUpdate.
+	RowLocking bool `json:"rowLocking"`
+
+	StrictTags     bool             `json:"strictTags"`
+	StrictRetry    bool             `json:"strictRetry"`
+	Coalescing     bool             `json:"coalescing"`
+	Reconciliation bool             `json:"reconciliation"`
+	CommitHooks    bool             `json:"commitHooks"`
+	VersionCodec   bool             `json:"versionCodec"`
+	Transient      bool             `json:"transient"`
+	KeyPolicy      KeyPolicy        `json:"keyPolicy"`
+	Schemaless     SchemalessPolicy `json:"schemaless"`
+}
+
+// Features reports the plugin's build and active features; see FeaturesOf for a plugin
+// installed on a *gorm.DB.
+func (p *Plugin) Features() FeatureSet {
+	fs := FeatureSet{
+		Version:              moduleVersion(),
+		Dialect:              p.dialect,
+		Strategies:           []Strategy{StrategyInt, StrategyUUID, StrategyULID, StrategyTime, StrategyCustom, StrategyString, StrategyKSUID, StrategyXID, StrategySnowflake, StrategyUnixNano, StrategyHLC, StrategySequence, StrategyXmin, StrategyRowVersion},
+		Tables:               map[string]Strategy{},
+		Returning:            p.returning,
+		RowLocking:           p.dialect != "" && p.dialect != "sqlite",
+		VersionOnlyReturning: p.returning && p.versionOnlyReturning,
+		DryRunGuards:         p.dryRunGuards,
+		LockingQueries:       p.lockingQueries,
+		Registry:             p.registryTable,
+		UpsertGuard:          p.upsertGuard,
+		AggregateBump:        p.aggregateBump,
+		SkipNoopUpdates:      p.skipNoops,
+		StrictTags:           p.strictTags,
+		StrictRetry:          p.strictRetry,
+		Coalescing:           p.coalescer != nil,
+		Reconciliation:       p.reconcileQueue != nil,
+		CommitHooks:          len(p.committedHooks) > 0,
+		VersionCodec:         p.codec != nil,
+		Transient:            len(p.transientClassifiers) > 0,
+		KeyPolicy:            p.keyPolicy,
+		Schemaless:           p.schemaless,
+	}
+	if p.plans != nil {
+		p.plans.Range(func(key, value any) bool {
+			if plan := value.(*versionPlan); plan.err == nil && plan.strategy != strategyUnknown {
+				fs.Tables[plan.table] = plan.strategy
+			}
+			return true
+		})
+	}
+	return fs
+}
+
+// FeaturesOf reports the features of the plugin installed on db:
+//
+//	if fs, ok := optimistic.FeaturesOf(db); ok && !fs.Returning {
+//		// updates are read back with a SELECT
+//	}
+func FeaturesOf(db *gorm.DB) (FeatureSet, bool) {
+	if db == nil || db.Config == nil {
+		return FeatureSet{}, false
+	}
+	p, ok := db.Config.Plugins[Plugin{}.Name()].(*Plugin)
+	if !ok {
+		return FeatureSet{}, false
+	}
+	return p.Features(), true
+}
+
+func moduleVersion() string {
+	var version string
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == modulePath {
+			version = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path != modulePath {
+				continue
+			}
+			version = dep.Version
+			if dep.Replace != nil {
+				version = dep.Replace.Version
+			}
+		}
+	}
+	if version == "" {
+		return "(devel)"
+	}
+	return version
+}
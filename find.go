@@ -0,0 +1,293 @@
+package optimistic
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// FindFresh loads into dest only the rows whose stored version differs from the
+// version recorded for their primary key in pks. Keys of pks are primary key
+// values and values are the versions the caller currently holds, e.g. a cache:
+//
+//	var stale []User
+//	err := optimistic.FindFresh(db, map[any]any{1: uint64(3), 2: uint64(7)}, &stale)
+//
+// Rows that no longer exist are simply absent from dest. Models must have a single
+// primary key and a version field.
+func FindFresh[T any](db *gorm.DB, pks map[any]any, dest *[]T) error {
+	if len(pks) == 0 {
+		*dest = (*dest)[:0]
+		return nil
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(dest); err != nil {
+		return err
+	}
+	p := pluginFor(db)
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Schema.Name)
+	}
+	if len(stmt.Schema.PrimaryFields) != 1 {
+		return fmt.Errorf("optimistic: FindFresh requires a single primary key, %s has %d", stmt.Schema.Name, len(stmt.Schema.PrimaryFields))
+	}
+
+	pkCol := clause.Column{Table: clause.CurrentTable, Name: stmt.Schema.PrimaryFields[0].DBName}
+	verCol := clause.Column{Table: clause.CurrentTable, Name: f.DBName}
+	keys := make([]any, 0, len(pks))
+	pairs := make([]any, 0, len(pks))
+	for pk, version := range pks {
+		version, err := p.decodeVersion(db.Statement.Context, stmt.Schema.Table, version)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, pk)
+		pairs = append(pairs, []any{pk, version})
+	}
+
+	return db.Where(clause.IN{Column: pkCol, Values: keys}).
+		Where(staleVersions(db, pkCol, verCol, pairs)).
+		Find(dest).Error
+}
+
+// WouldConflict reports, without writing, whether updating model now would hit a version
+// conflict, so a UI can warn that a record changed while it was being edited before the
+// user saves:
+//
+//	if conflict, current, err := optimistic.WouldConflict(db, &doc); err == nil && conflict {
+//		// show current.(*Doc) next to the user's edits
+//	}
+//
+// current is a pointer to the stored row. A row that no longer exists counts as a conflict
+// and current is nil.
+func WouldConflict(db *gorm.DB, model any) (bool, any, error) {
+	current, stmt, err := loadCurrent(db, model)
+	if stmt == nil {
+		return false, nil, err
+	}
+	f := pluginFor(db).findVersionField(stmt.Schema)
+	if f == nil {
+		return false, nil, fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Schema.Name)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	expected, _ := f.ValueOf(stmt.Context, stmt.ReflectValue)
+	stored, _ := f.ValueOf(stmt.Context, reflect.ValueOf(current))
+	return !valuesEqual(db.Dialector.Name(), expected, stored), current, nil
+}
+
+// Cache holds rows for CachedFind, keyed by table and primary key. It must be safe for
+// concurrent use if CachedFind is called concurrently.
+type Cache interface {
+	Get(key string) (any, bool)
+	Set(key string, value any)
+}
+
+// CachedFind loads the row of model, identified by its primary key, from cache when the
+// cached copy is still at the stored version, which a version-only SELECT checks, and
+// from the database otherwise, refreshing cache:
+//
+//	u := &User{ID: 7}
+//	err := optimistic.CachedFind(db, cache, u)
+//
+// Cached values are pointers to rows, copied shallowly into model, so callers must not
+// modify slices or maps they share with the cache. A missing row returns
+// gorm.ErrRecordNotFound.
+func CachedFind(db *gorm.DB, cache Cache, model any) error {
+	stmt, err := parseTarget(db, model)
+	if err != nil {
+		return err
+	}
+	if !stmt.ReflectValue.CanSet() {
+		return gorm.ErrInvalidValue
+	}
+	p := pluginFor(db)
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Schema.Name)
+	}
+	key := p.rowKey(stmt)
+	if cached, ok := cache.Get(key); ok {
+		if cv := reflect.Indirect(reflect.ValueOf(cached)); cv.Type() == stmt.ReflectValue.Type() {
+			stored, err := p.storedVersion(db, stmt, f)
+			if err != nil {
+				return err
+			}
+			if version, _ := f.ValueOf(stmt.Context, cv); valuesEqual(db.Dialector.Name(), version, stored) {
+				stmt.ReflectValue.Set(cv)
+				return nil
+			}
+		}
+	}
+	current, err := p.reload(db, stmt)
+	if err != nil {
+		return err
+	}
+	stmt.ReflectValue.Set(reflect.Indirect(reflect.ValueOf(current)))
+	cache.Set(key, current)
+	return nil
+}
+
+// storedVersion selects only the version column of the row targeted by stmt.
+func (p *Plugin) storedVersion(db *gorm.DB, stmt *gorm.Statement, f *schema.Field) (any, error) {
+	fresh := db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+	// Must reset Error
+	fresh.Error = nil
+	probe := reflect.New(stmt.Schema.ModelType)
+	err := fresh.Model(probe.Interface()).
+		Select(f.DBName).
+		Where(clause.Where{Exprs: identityConds(stmt, p.identityFields(stmt.Schema))}).
+		Take(probe.Interface()).Error
+	if err != nil {
+		return nil, err
+	}
+	version, _ := f.ValueOf(stmt.Context, probe)
+	return version, nil
+}
+
+// Conflicting returns the subset of models whose stored version differs from theirs,
+// including models whose row no longer exists, e.g. so a sync client can validate a large
+// local cache:
+//
+//	stale, err := optimistic.Conflicting(db, cachedUsers)
+//
+// models may mix model types; each table is checked with a single `(pk, version) IN (...)`
+// query. Models whose identifying fields are not all set are skipped.
+func Conflicting[T any](db *gorm.DB, models []T) ([]T, error) {
+	type group struct {
+		stmt    *gorm.Statement
+		version *schema.Field
+		indexes []int
+	}
+	p := pluginFor(db)
+	var groups []*group
+	byTable := map[string]*group{}
+	for i, model := range models {
+		stmt := &gorm.Statement{DB: db, Context: db.Statement.Context}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		g, ok := byTable[stmt.Table]
+		if !ok {
+			f := p.findVersionField(stmt.Schema)
+			if f == nil {
+				return nil, fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Schema.Name)
+			}
+			g = &group{stmt: stmt, version: f}
+			byTable[stmt.Table] = g
+			groups = append(groups, g)
+		}
+		g.indexes = append(g.indexes, i)
+	}
+
+	var conflicting []T
+	for _, g := range groups {
+		identity := p.identityFields(g.stmt.Schema)
+		columns := make([]clause.Column, 0, len(identity)+1)
+		names := make([]string, 0, len(identity)+1)
+		for _, f := range identity {
+			columns = append(columns, clause.Column{Table: clause.CurrentTable, Name: f.DBName})
+			names = append(names, f.DBName)
+		}
+		columns = append(columns, clause.Column{Table: clause.CurrentTable, Name: g.version.DBName})
+		names = append(names, g.version.DBName)
+
+		tuples := make([]any, 0, len(g.indexes))
+		keys := make(map[int]string, len(g.indexes))
+		for _, i := range g.indexes {
+			rv := reflect.Indirect(reflect.ValueOf(models[i]))
+			key, ok := identityKey(g.stmt, identity, rv)
+			if !ok {
+				continue
+			}
+			keys[i] = key
+			tuple := make([]any, 0, len(columns))
+			for _, f := range identity {
+				val, _ := f.ValueOf(g.stmt.Context, rv)
+				tuple = append(tuple, val)
+			}
+			version, _ := g.version.ValueOf(g.stmt.Context, rv)
+			version, err := p.decodeVersion(g.stmt.Context, g.stmt.Table, version)
+			if err != nil {
+				return nil, err
+			}
+			tuples = append(tuples, append(tuple, version))
+		}
+		if len(tuples) == 0 {
+			continue
+		}
+
+		current := reflect.New(reflect.SliceOf(g.stmt.Schema.ModelType))
+		err := db.Select(names).Where(matchingTuples(db, columns, tuples)).Find(current.Interface()).Error
+		if err != nil {
+			return nil, err
+		}
+		current = current.Elem()
+		// the same row may be passed at several versions, so match versions as well
+		stored := make(map[string]any, current.Len())
+		for j := 0; j < current.Len(); j++ {
+			key, _ := identityKey(g.stmt, identity, current.Index(j))
+			stored[key], _ = g.version.ValueOf(g.stmt.Context, current.Index(j))
+		}
+		for _, i := range g.indexes {
+			key, ok := keys[i]
+			if !ok {
+				continue
+			}
+			version, _ := g.version.ValueOf(g.stmt.Context, reflect.Indirect(reflect.ValueOf(models[i])))
+			if storedVersion, ok := stored[key]; !ok || !valuesEqual(g.stmt.DB.Dialector.Name(), version, storedVersion) {
+				conflicting = append(conflicting, models[i])
+			}
+		}
+	}
+	return conflicting, nil
+}
+
+// identityKey renders the identifying values of rv, reporting false when one is not set.
+func identityKey(stmt *gorm.Statement, identity []*schema.Field, rv reflect.Value) (string, bool) {
+	var b strings.Builder
+	for _, f := range identity {
+		val, zero := identityValue(stmt.Context, f, rv)
+		if zero {
+			return "", false
+		}
+		_, _ = fmt.Fprintf(&b, "%v\x00", val)
+	}
+	return b.String(), true
+}
+
+// staleVersions builds the `(pk, version) NOT IN (...)` predicate, falling back to
+// an expanded `NOT ((pk = ? AND version = ?) OR ...)` on dialects without row values.
+func staleVersions(db *gorm.DB, pkCol, verCol clause.Column, pairs []any) clause.Expression {
+	return clause.Not(matchingTuples(db, []clause.Column{pkCol, verCol}, pairs))
+}
+
+// matchingTuples builds the `(col, ...) IN (...)` predicate, falling back to an expanded
+// `(col = ? AND ...) OR ...` on dialects without row values.
+func matchingTuples(db *gorm.DB, columns []clause.Column, tuples []any) clause.Expression {
+	switch db.Dialector.Name() {
+	case "postgres", "mysql", "sqlite", "oracle":
+		return clause.IN{Column: columns, Values: tuples}
+	default:
+		exprs := make([]clause.Expression, 0, len(tuples))
+		for _, tuple := range tuples {
+			values := tuple.([]any)
+			eqs := make([]clause.Expression, 0, len(columns))
+			for i, col := range columns {
+				eqs = append(eqs, clause.Eq{Column: col, Value: values[i]})
+			}
+			exprs = append(exprs, clause.And(eqs...))
+		}
+		return clause.Or(exprs...)
+	}
+}
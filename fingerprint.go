@@ -0,0 +1,152 @@
+package optimistic
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// fingerprintTagName tags a string field, `gorm:"-;fingerprint"`, the plugin fills with a
+// short hash of the row's significant columns whenever rows are read or written:
+//
+//	type Order struct {
+//		ID          uint64
+//		Status      string
+//		Note        string
+//		Fingerprint string `gorm:"-;fingerprint"`
+//		Version     uint64 `gorm:"version"`
+//	}
+//
+// The significant columns are those named with WithSignificantColumns, or every column
+// but the key, timestamps and versions. A *ConflictError of a model with a fingerprint
+// carries the one the model was loaded with and the current row's, so callers can tell a
+// conflict that changed none of the columns they care about, see SameFingerprint.
+const fingerprintTagName = "FINGERPRINT"
+
+// fingerprintField returns the string field of sch tagged `fingerprint`, or nil.
+func fingerprintField(sch *schema.Schema) *schema.Field {
+	for _, f := range sch.Fields {
+		if _, ok := f.TagSettings[fingerprintTagName]; ok && f.FieldType.Kind() == reflect.String {
+			return f
+		}
+	}
+	return nil
+}
+
+// fingerprintColumns returns the fields of sch making up fingerprints, in schema order.
+func (p *Plugin) fingerprintColumns(sch *schema.Schema, f, fp *schema.Field, groups []*versionGroup) []*schema.Field {
+	significant, configured := p.significantColumns[sch.Table]
+	var fields []*schema.Field
+	for _, sf := range sch.Fields {
+		if sf.DBName == "" || !sf.Readable || sf == fp {
+			continue
+		}
+		if configured {
+			if _, ok := significant[sf.DBName]; ok {
+				fields = append(fields, sf)
+			}
+			continue
+		}
+		if sf == f || sf.PrimaryKey || sf.AutoCreateTime > 0 || sf.AutoUpdateTime > 0 ||
+			slices.ContainsFunc(groups, func(g *versionGroup) bool { return g.field == sf }) {
+			continue
+		}
+		fields = append(fields, sf)
+	}
+	return fields
+}
+
+// fingerprintOf hashes the fields of row rv into a short hex string.
+func fingerprintOf(ctx context.Context, fields []*schema.Field, rv reflect.Value) string {
+	h := sha256.New()
+	for _, sf := range fields {
+		val, _ := sf.ValueOf(ctx, rv)
+		switch v := derefValue(val).(type) {
+		case time.Time:
+			_, _ = fmt.Fprintf(h, "%s=%s\x00", sf.DBName, v.UTC().Format(time.RFC3339Nano))
+		case []byte:
+			_, _ = fmt.Fprintf(h, "%s=%x\x00", sf.DBName, v)
+		default:
+			_, _ = fmt.Fprintf(h, "%s=%v\x00", sf.DBName, v)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// stampFingerprints fills the fingerprints of the rows read, created or written by db.
+func (p *Plugin) stampFingerprints(db *gorm.DB) {
+	if db.Error != nil || db.DryRun || db.Statement.Schema == nil {
+		return
+	}
+	stmt := db.Statement
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return
+	}
+	plan := p.planFor(f)
+	if plan.fingerprint == nil {
+		return
+	}
+	if _, updating := stmt.Clauses[clause.Set{}.Name()]; updating && !isTargetedModelUpdate(stmt) {
+		return
+	}
+	stamp := func(rv reflect.Value) {
+		rv = reflect.Indirect(rv)
+		if rv.Kind() == reflect.Struct && rv.Type() == stmt.Schema.ModelType {
+			_ = plan.fingerprint.Set(stmt.Context, rv, fingerprintOf(stmt.Context, plan.fingerprinted, rv))
+		}
+	}
+	switch rv := reflect.Indirect(stmt.ReflectValue); rv.Kind() {
+	case reflect.Struct:
+		stamp(rv)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			stamp(rv.Index(i))
+		}
+	}
+}
+
+// fingerprintConflict turns the version conflict of a model with a fingerprint into a
+// *ConflictError carrying the fingerprints and version of the model and the current row.
+func (p *Plugin) fingerprintConflict(db *gorm.DB) {
+	stmt := db.Statement
+	f := p.findVersionField(stmt.Schema)
+	if f == nil || reflect.Indirect(stmt.ReflectValue).Kind() != reflect.Struct {
+		return
+	}
+	plan := p.planFor(f)
+	if plan.fingerprint == nil {
+		return
+	}
+	var ce *ConflictError
+	switch {
+	case errors.As(db.Error, &ce):
+	case db.Error == ErrOptimisticLock:
+		ce = &ConflictError{Table: stmt.Table}
+	default:
+		// resolved, or failed otherwise
+		return
+	}
+	fp, _ := plan.fingerprint.ValueOf(stmt.Context, stmt.ReflectValue)
+	ce.Fingerprint, _ = fp.(string)
+	current := ce.Current
+	if current == nil {
+		if reloaded, err := p.reload(db, stmt); err == nil {
+			current = reloaded
+		}
+	}
+	if rv := reflect.Indirect(reflect.ValueOf(current)); rv.IsValid() && rv.Kind() == reflect.Struct {
+		ce.CurrentVersion, _ = f.ValueOf(stmt.Context, rv)
+		ce.CurrentFingerprint = fingerprintOf(stmt.Context, plan.fingerprinted, rv)
+	}
+	db.Error = ce
+}
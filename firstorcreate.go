@@ -0,0 +1,103 @@
+package optimistic
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FirstOrCreate is db.FirstOrCreate(dest, conds...) for versioned models, safe against a
+// concurrent writer creating the row between the lookup and the create:
+//
+//	err := optimistic.FirstOrCreate(db.Clauses(optimistic.Conflict{
+//		OnVersionMismatch: func(current any, diff map[string]optimistic.Change) any {
+//			winner := current.(*Account)
+//			winner.Plan = "trial"
+//			return winner
+//		},
+//	}).Where(Account{Email: email}).Attrs(Account{Plan: "trial"}), &account)
+//
+// When the create fails with a duplicate key, the winner's row is reloaded by the same
+// conditions into dest, as FirstOrCreate would have found it; Assign is not applied to it.
+// The OnVersionMismatch handler of a Conflict clause on db then receives the winner and its
+// differences from the model that lost the race. A non-nil result is updated in the
+// winner's place, guarded by the winner's version, and stored in dest.
+//
+// Duplicates are recognized through the dialector's gorm.ErrorTranslator. PostgreSQL
+// aborts a transaction on the duplicate, so within one of your own run FirstOrCreate in a
+// nested db.Transaction, which is rolled back to a savepoint instead.
+func FirstOrCreate(db *gorm.DB, dest any, conds ...any) error {
+	stmt, err := parseTarget(db, dest)
+	if err != nil {
+		return err
+	}
+	if pluginFor(db).findVersionField(stmt.Schema) == nil {
+		return fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Table)
+	}
+	// the lookup's conditions, to find the winner by
+	var where clause.Where
+	if c, ok := db.Statement.Clauses[where.Name()]; ok {
+		where, _ = c.Expression.(clause.Where)
+	}
+	err = db.FirstOrCreate(dest, conds...).Error
+	if !errors.Is(translateError(db, err), gorm.ErrDuplicatedKey) {
+		return err
+	}
+
+	lost := reflect.New(stmt.ReflectValue.Type())
+	lost.Elem().Set(stmt.ReflectValue)
+	current := reflect.New(stmt.ReflectValue.Type())
+	fresh := db.Session(&gorm.Session{NewDB: true})
+	// Must reset Error
+	fresh.Error = nil
+	if len(where.Exprs) > 0 {
+		fresh = fresh.Clauses(where)
+	}
+	if fresh.Take(current.Interface(), conds...).Error != nil {
+		// the duplicate is not the row looked up
+		return err
+	}
+	stmt.ReflectValue.Set(current.Elem())
+	conflict, ok := conflictClause(db.Statement)
+	if !ok || conflict.OnVersionMismatch == nil {
+		return nil
+	}
+	reporter := newDiffReporter()
+	cmp.Diff(lost.Elem().Interface(), current.Elem().Interface(), cmp.Reporter(reporter), cmp.Exporter(exportAll))
+	resolved := conflict.OnVersionMismatch(current.Interface(), reporter.Diff())
+	if resolved == nil {
+		return nil
+	}
+	retry := db.Session(&gorm.Session{NewDB: true})
+	// Must reset Error
+	retry.Error = nil
+	if err := retry.Updates(resolved).Error; err != nil {
+		return err
+	}
+	stmt.ReflectValue.Set(reflect.Indirect(reflect.ValueOf(resolved)))
+	return nil
+}
+
+// FirstOrInit is db.FirstOrInit(dest, conds...) for versioned models. When no row matches,
+// the version of dest is reset along with the initialization, so a version left over in a
+// reused dest, or passed with Attrs, cannot guard or seed the row once it is saved: the
+// create that saves it seeds the initial version.
+func FirstOrInit(db *gorm.DB, dest any, conds ...any) error {
+	stmt, err := parseTarget(db, dest)
+	if err != nil {
+		return err
+	}
+	f := pluginFor(db).findVersionField(stmt.Schema)
+	if f == nil {
+		return fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Table)
+	}
+	result := db.FirstOrInit(dest, conds...)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	return f.Set(stmt.Context, stmt.ReflectValue, reflect.Zero(f.FieldType).Interface())
+}
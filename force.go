@@ -0,0 +1,29 @@
+package optimistic
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const forceClauseName = "optimistic:force"
+
+// Force runs the update or delete of a loaded model without its version guard, for admin
+// overrides and repair jobs that must win over concurrent writers:
+//
+//	err := db.Clauses(optimistic.Force{}).Updates(&order).Error
+//
+// Unlike Unscoped, soft-delete scoping still applies, and updates still bump the version,
+// so other holders of the row see it changed. The model carries the version written where
+// the dialect returns it, or reads it back otherwise. A forced update matching no row fails
+// with gorm.ErrRecordNotFound.
+type Force struct{}
+
+func (x Force) Name() string                 { return forceClauseName }
+func (x Force) Build(clause.Builder)         {}
+func (x Force) MergeClause(c *clause.Clause) { c.Expression = x }
+
+// isForced reports whether stmt carries Force.
+func isForced(stmt *gorm.Statement) bool {
+	_, ok := stmt.Clauses[forceClauseName]
+	return ok
+}
@@ -0,0 +1,102 @@
+package optimistic
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// ErrNoGenerator is returned for UUID, ULID, KSUID and xid versions when the package
+// generating their values has not been imported.
+var ErrNoGenerator = errors.New("no generator registered for version strategy")
+
+// Generator produces a new version value for a UUID, ULID, KSUID or xid strategy, or for
+// string versions, see WithStringGenerator. now is the time of the write, entropy the
+// source set with WithUUIDSource or WithULIDEntropy, or nil for the generator's own, and
+// params are the version tag's parameters. UUID and ULID values must be of a 16-byte array type that
+// database drivers can store, such as uuid.UUID, KSUID values of a 20-byte one, such as
+// ksuid.KSUID, and xid values of a 12-byte one, such as xid.ID.
+type Generator func(now time.Time, entropy io.Reader, params map[string]string) (any, error)
+
+// generators maps strategies to their Generator.
+var generators sync.Map
+
+// generatorPackages name the packages registering the built-in generators.
+var generatorPackages = map[Strategy]string{
+	StrategyUUID:  "github.com/cmmoran/optimistic/uuidversion",
+	StrategyULID:  "github.com/cmmoran/optimistic/ulidversion",
+	StrategyKSUID: "github.com/cmmoran/optimistic/ksuidversion",
+	StrategyXID:   "github.com/cmmoran/optimistic/xidversion",
+}
+
+// RegisterGenerator makes strategy generate its values with gen. The uuidversion,
+// ulidversion, ksuidversion and xidversion packages register theirs when imported, which keeps their
+// dependencies out of programs that only use numeric or time versions:
+//
+//	import _ "github.com/cmmoran/optimistic/uuidversion"
+func RegisterGenerator(strategy Strategy, gen Generator) {
+	generators.Store(strategy, gen)
+}
+
+// generatorFor returns the Generator of strategy, or an error naming the package to import.
+func generatorFor(strategy Strategy) (Generator, error) {
+	if gen, ok := generators.Load(strategy); ok {
+		return gen.(Generator), nil
+	}
+	return nil, fmt.Errorf("%w: %s, import %s", ErrNoGenerator, strategy, generatorPackages[strategy])
+}
+
+// stringTokenLen is how many random bytes the default string versions hold, hex encoded.
+const stringTokenLen = 16
+
+// WithStringGenerator generates the versions of string version fields with gen instead of
+// random 32 character hex tokens, for schemas keeping revisions as external IDs or in a
+// format of their own:
+//
+//	db.Use(optimistic.NewOptimisticLock(optimistic.WithStringGenerator(
+//		func(now time.Time, _ io.Reader, _ map[string]string) (any, error) {
+//			return now.UTC().Format("20060102150405.000000"), nil
+//		})))
+//
+// gen must return a string, or a value of the field's type, and never the same one twice
+// for a row. entropy is always nil. An error from gen fails the write.
+func WithStringGenerator(gen Generator) ConfigOption {
+	return func(cfg *Config) {
+		cfg.stringGenerator = gen
+	}
+}
+
+// hexToken is the default Generator of string versions.
+func hexToken(time.Time, io.Reader, map[string]string) (any, error) {
+	b := make([]byte, stringTokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// stringVersion generates a new version of the string version field f, converted to the
+// field's type. Generator failures are added to db.
+func (p *Plugin) stringVersion(db *gorm.DB, f *schema.Field, params map[string]string) any {
+	gen := p.stringGenerator
+	if gen == nil {
+		gen = hexToken
+	}
+	val, err := gen(p.now(db), nil, params)
+	if err != nil {
+		_ = db.AddError(fmt.Errorf("%s version: %w", StrategyString, err))
+		return nil
+	}
+	if rv := reflect.ValueOf(val); rv.IsValid() && rv.Type() != f.IndirectFieldType && rv.Type().ConvertibleTo(f.IndirectFieldType) {
+		return rv.Convert(f.IndirectFieldType).Interface()
+	}
+	return val
+}
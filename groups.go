@@ -0,0 +1,171 @@
+package optimistic
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	// versionGroupTagName tags integer fields, `gorm:"version_group:profile"`, versioning
+	// the columns tagged with their group, `gorm:"group:profile"`, apart from the row.
+	versionGroupTagName = "VERSION_GROUP"
+	groupTagName        = "GROUP"
+
+	// contextKeyGroupVersions holds the groupBumps of a guarded update.
+	contextKeyGroupVersions = "optimistic:group_versions"
+)
+
+// versionGroup is a version field guarding the columns of its group instead of the
+// row's version field, so concurrent edits of different groups of a row do not conflict:
+//
+//	type Account struct {
+//		ID              uint64
+//		Name            string `gorm:"group:profile"`
+//		Email           string `gorm:"group:profile"`
+//		ProfileVersion  uint64 `gorm:"version_group:profile"`
+//		Theme           string `gorm:"group:settings"`
+//		SettingsVersion uint64 `gorm:"version_group:settings"`
+//		Status          string
+//		Version         uint64 `gorm:"version"`
+//	}
+//
+// Updates assigning only grouped columns are guarded and bumped by the versions of their
+// groups alone; updates assigning other columns are also guarded and bumped by the row's
+// version. Struct updates assign every non-zero field, so edits of a single group assign
+// their columns with Select or a map.
+type versionGroup struct {
+	name    string
+	field   *schema.Field
+	columns map[string]struct{}
+}
+
+// groupBump is a group version an update writes.
+type groupBump struct {
+	field *schema.Field
+	to    any
+}
+
+// versionGroups returns the version groups of sch.
+func versionGroups(sch *schema.Schema) ([]*versionGroup, error) {
+	var groups []*versionGroup
+	for _, f := range sch.Fields {
+		name := strings.ToLower(f.TagSettings[versionGroupTagName])
+		if name == "" {
+			continue
+		}
+		if !isNumericKind(f.FieldType.Kind()) {
+			return nil, fmt.Errorf("%w: %s.%s versions group %q but is not an integer", ErrInvalidVersionTag, sch.Name, f.Name, name)
+		}
+		groups = append(groups, &versionGroup{name: name, field: f, columns: map[string]struct{}{}})
+	}
+	for _, f := range sch.Fields {
+		name := strings.ToLower(f.TagSettings[groupTagName])
+		if name == "" {
+			continue
+		}
+		i := slices.IndexFunc(groups, func(g *versionGroup) bool { return g.name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %s.%s is in group %q, which has no version_group field", ErrInvalidVersionTag, sch.Name, f.Name, name)
+		}
+		groups[i].columns[f.DBName] = struct{}{}
+	}
+	return groups, nil
+}
+
+// bumpGroups bumps the version of each group set assigns a column of, and returns the
+// guards of those groups. own is false when set assigns grouped columns only, so the
+// row's version neither guards nor changes.
+func (p *Plugin) bumpGroups(stmt *gorm.Statement, f *schema.Field, set *clause.Set) (guards clause.Where, own bool) {
+	groups := p.planFor(f).groups
+	rv := reflect.Indirect(stmt.ReflectValue)
+	if len(groups) == 0 || rv.Kind() != reflect.Struct {
+		return guards, true
+	}
+	// group versions are the plugin's to assign
+	*set = slices.DeleteFunc(*set, func(a clause.Assignment) bool {
+		return slices.ContainsFunc(groups, func(g *versionGroup) bool { return g.field.DBName == a.Column.Name })
+	})
+	touched := make([]bool, len(groups))
+	for _, a := range *set {
+		i := slices.IndexFunc(groups, func(g *versionGroup) bool {
+			_, ok := g.columns[a.Column.Name]
+			return ok
+		})
+		switch {
+		case i >= 0:
+			touched[i] = true
+		case a.Column.Name != f.DBName:
+			own = true
+		}
+	}
+	var bumps []groupBump
+	for i, g := range groups {
+		if !touched[i] {
+			continue
+		}
+		from, _ := g.field.ValueOf(stmt.Context, rv)
+		to := incremented(g.field.FieldType, from)
+		*set = append(*set, clause.Assignment{Column: clause.Column{Name: g.field.DBName}, Value: to})
+		guards.Exprs = append(guards.Exprs, clause.Eq{
+			Column: clause.Column{Table: clause.CurrentTable, Name: g.field.DBName},
+			Value:  from,
+		})
+		bumps = append(bumps, groupBump{field: g.field, to: to})
+	}
+	if len(bumps) == 0 {
+		return guards, true
+	}
+	if !stmt.DryRun {
+		stmt.DB.InstanceSet(contextKeyGroupVersions, bumps)
+	}
+	return guards, own
+}
+
+// groupReturning reads back the columns an update of grouped columns assigned, leaving
+// the model's other columns as loaded, as they are without RETURNING.
+func groupReturning(stmt *gorm.Statement) clause.Returning {
+	var columns []clause.Column
+	if c, ok := stmt.Clauses[clause.Set{}.Name()]; ok {
+		set, _ := c.Expression.(clause.Set)
+		for _, a := range set {
+			columns = append(columns, clause.Column{Name: a.Column.Name})
+		}
+	}
+	return clause.Returning{Columns: columns}
+}
+
+// setGroupVersions assigns the group versions db wrote to its model.
+func (p *Plugin) setGroupVersions(db *gorm.DB) {
+	bumps, _ := db.InstanceGet(contextKeyGroupVersions)
+	rv := reflect.Indirect(db.Statement.ReflectValue)
+	for _, b := range bumps.([]groupBump) {
+		_ = b.field.Set(db.Statement.Context, rv, b.to)
+	}
+}
+
+// seedGroups starts the zero group versions of the new row elem at 1.
+func (p *Plugin) seedGroups(db *gorm.DB, elem reflect.Value, f *schema.Field) {
+	for _, g := range p.planFor(f).groups {
+		if _, zero := g.field.ValueOf(db.Statement.Context, elem); zero {
+			_ = g.field.Set(db.Statement.Context, elem, uint64(1))
+		}
+	}
+}
+
+// incremented returns the integer version v plus one, as a value of typ.
+func incremented(typ reflect.Type, v any) any {
+	n, _ := asUint64(v)
+	next := reflect.New(typ).Elem()
+	if next.CanInt() {
+		next.SetInt(int64(n) + 1)
+	} else {
+		next.SetUint(n + 1)
+	}
+	return next.Interface()
+}
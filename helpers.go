@@ -0,0 +1,415 @@
+package optimistic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	// ErrPreconditionFailed is returned (wrapped in a *PreconditionError) by UpdateIf when the
+	// version still matched but one of the caller's conditions no longer holds.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrAlreadyExists is returned by Put when a model without a version collides with an
+	// existing row.
+	ErrAlreadyExists = errors.New("record already exists")
+)
+
+// PreconditionError describes why a guarded UpdateIf did not apply.
+type PreconditionError struct {
+	// Column is the first condition column that no longer holds; it is empty when the
+	// stored version moved on.
+	Column string
+	// Current is a pointer to the freshly loaded row.
+	Current any
+}
+
+func (e *PreconditionError) Error() string {
+	if e.Column == "" {
+		return ErrOptimisticLock.Error()
+	}
+	return fmt.Sprintf("%s: %s", ErrPreconditionFailed, e.Column)
+}
+
+// Unwrap returns ErrOptimisticLock for version mismatches and ErrPreconditionFailed otherwise.
+func (e *PreconditionError) Unwrap() error {
+	if e.Column == "" {
+		return ErrOptimisticLock
+	}
+	return ErrPreconditionFailed
+}
+
+// UpdateIf updates model (like db.Updates(model)) only when its version still matches
+// and every column in conds still holds the given value:
+//
+//	err := optimistic.UpdateIf(db, &order, map[string]any{"status": "open"})
+//	var pe *optimistic.PreconditionError
+//	if errors.As(err, &pe) {
+//		current := pe.Current.(*Order)
+//		...
+//	}
+//
+// When the update does not apply the row is reloaded and returned in a *PreconditionError
+// naming the failed precondition.
+func UpdateIf(db *gorm.DB, model any, conds map[string]any) error {
+	cols := make([]string, 0, len(conds))
+	for col := range conds {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	tx := db.Model(model)
+	for _, col := range cols {
+		tx = tx.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: col}, Value: conds[col]})
+	}
+	err := tx.Updates(model).Error
+	if !errors.Is(err, ErrOptimisticLock) {
+		return err
+	}
+
+	current, stmt, lerr := loadCurrent(db, model)
+	if lerr != nil {
+		return err
+	}
+	f := pluginFor(db).findVersionField(stmt.Schema)
+	if f != nil {
+		expected, _ := f.ValueOf(stmt.Context, stmt.ReflectValue)
+		stored, _ := f.ValueOf(stmt.Context, reflect.ValueOf(current))
+		if !valuesEqual(db.Dialector.Name(), expected, stored) {
+			return &PreconditionError{Current: current}
+		}
+	}
+	for _, col := range cols {
+		cf := stmt.Schema.LookUpField(col)
+		if cf == nil {
+			continue
+		}
+		stored, _ := cf.ValueOf(stmt.Context, reflect.ValueOf(current))
+		if !valuesEqual(db.Dialector.Name(), conds[col], stored) {
+			return &PreconditionError{Column: col, Current: current}
+		}
+	}
+	return &PreconditionError{Current: current}
+}
+
+// Put stores model with create-if-absent, update-if-current semantics: a model whose
+// version is zero is created, and any other model is updated (like db.Updates(model))
+// only if its version still matches:
+//
+//	switch err := optimistic.Put(db, &doc); {
+//	case errors.Is(err, optimistic.ErrAlreadyExists):
+//		// created concurrently; load it and retry as an update
+//	case errors.Is(err, optimistic.ErrOptimisticLock):
+//		// stale version
+//	case errors.Is(err, gorm.ErrRecordNotFound):
+//		// the row to update no longer exists
+//	}
+//
+// Duplicates are recognized through the dialector's gorm.ErrorTranslator.
+func Put(db *gorm.DB, model any) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	f := pluginFor(db).findVersionField(stmt.Schema)
+	if f == nil {
+		return fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Schema.Table)
+	}
+	if _, zero := f.ValueOf(db.Statement.Context, reflect.Indirect(reflect.ValueOf(model))); zero {
+		err := db.Create(model).Error
+		if errors.Is(translateError(db, err), gorm.ErrDuplicatedKey) {
+			return fmt.Errorf("%w: %w", ErrAlreadyExists, err)
+		}
+		return err
+	}
+
+	err := db.Updates(model).Error
+	if !errors.Is(err, ErrOptimisticLock) {
+		return err
+	}
+	if _, _, lerr := loadCurrent(db, model); errors.Is(lerr, gorm.ErrRecordNotFound) {
+		return lerr
+	}
+	return err
+}
+
+// translateError maps driver errors to gorm's, unless gorm already did.
+func translateError(db *gorm.DB, err error) error {
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok && err != nil && !db.TranslateError {
+		return translator.Translate(err)
+	}
+	return err
+}
+
+// GuardSQL renders the `pk = ? AND version = ?` predicate guarding an update of model
+// under the plugin installed on db, for hand-written statements:
+//
+//	cond, args, err := optimistic.GuardSQL(db, &order)
+//	if err != nil {
+//		return err
+//	}
+//	res := db.Exec("UPDATE orders SET status = ?, version = version + 1 WHERE "+cond,
+//		append([]any{"shipped"}, args...)...)
+//	if res.Error == nil && res.RowsAffected == 0 {
+//		// conflict
+//	}
+//
+// The predicate takes the version field under the plugin's tag name, see WithTagName, and
+// the identifying fields it guards by, see WithIdentityColumns, and its version argument
+// is decoded with the plugin's codec. Column names follow db's naming strategy and are not
+// quoted. It fails with ErrNoVersionField when the plugin does not guard model, and with
+// gorm.ErrPrimaryKeyRequired when the identifying fields are not all set.
+func GuardSQL(db *gorm.DB, model any) (cond string, args []any, err error) {
+	stmt, err := parseTarget(db, model)
+	if err != nil {
+		return "", nil, err
+	}
+	p := pluginFor(db)
+	vf := p.findVersionField(stmt.Schema)
+	if vf == nil {
+		return "", nil, fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Table)
+	}
+	fields := p.identityFields(stmt.Schema)
+	if _, ok := registryKey(stmt, fields, stmt.ReflectValue); !ok {
+		return "", nil, gorm.ErrPrimaryKeyRequired
+	}
+	preds := make([]string, 0, len(fields)+1)
+	for _, f := range slices.Concat(fields, []*schema.Field{vf}) {
+		val, _ := f.ValueOf(stmt.Context, stmt.ReflectValue)
+		if f == vf {
+			if val, err = p.decodeVersion(stmt.Context, stmt.Table, val); err != nil {
+				return "", nil, err
+			}
+		}
+		preds = append(preds, f.DBName+" = ?")
+		args = append(args, val)
+	}
+	return strings.Join(preds, " AND "), args, nil
+}
+
+// State is a copy of a model captured by Snapshot.
+type State[T any] struct {
+	value T
+}
+
+// Snapshot captures the loaded state of m, including its version, so it can be put back
+// with Restore after a failed update:
+//
+//	snap := optimistic.Snapshot(&m)
+//	m.Description = input
+//	if err := db.Updates(&m).Error; errors.Is(err, optimistic.ErrOptimisticLock) {
+//		optimistic.Restore(&m, snap)
+//	}
+//
+// The copy is shallow: pointers, slices and maps still share their targets with m.
+func Snapshot[T any](m *T) State[T] {
+	return State[T]{value: *m}
+}
+
+// Restore resets m to the state captured by Snapshot.
+func Restore[T any](m *T, snap State[T]) {
+	*m = snap.value
+}
+
+var tyDeletedAt = reflect.TypeOf(gorm.DeletedAt{})
+
+// Undelete restores the soft-deleted row of model only if its version still matches,
+// bumping the version, so undo flows cannot resurrect a row that was modified after it
+// was deleted:
+//
+//	if err := optimistic.Undelete(db, &order); errors.Is(err, optimistic.ErrOptimisticLock) {
+//		// the row changed since it was deleted, or it is no longer deleted
+//	}
+//
+// model must have a gorm.DeletedAt field. On success its deleted marker is cleared and its
+// version set to the new value.
+func Undelete(db *gorm.DB, model any) error {
+	p := pluginFor(db)
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Schema.Table)
+	}
+	var deletedAt *schema.Field
+	for _, sf := range stmt.Schema.Fields {
+		if sf.FieldType == tyDeletedAt {
+			deletedAt = sf
+			break
+		}
+	}
+	if deletedAt == nil {
+		return fmt.Errorf("optimistic: %s has no gorm.DeletedAt field", stmt.Schema.Name)
+	}
+	strategy, err := p.versionStrategy(f)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if db.Statement != nil && db.Statement.Context != nil {
+		ctx = db.Statement.Context
+	}
+	stmt.ReflectValue = reflect.Indirect(reflect.ValueOf(model))
+	stmt.Context = ctx
+	if !isTargetedModelUpdate(stmt) {
+		return gorm.ErrMissingWhereClause
+	}
+	oldVal, _ := f.ValueOf(ctx, stmt.ReflectValue)
+	if oldVal, err = p.decodeVersion(ctx, stmt.Table, oldVal); err != nil {
+		return err
+	}
+	var next any
+	values := map[string]any{deletedAt.DBName: nil}
+	switch strategy {
+	case StrategyInt:
+		n, _ := asUint64(oldVal)
+		next = n + 1
+		values[f.DBName] = next
+	case StrategyXmin, StrategyRowVersion:
+		// the database bumps these itself, read back below
+	default:
+		// sequence versions report failures on the session, leaving db alone
+		gen := db.Session(&gorm.Session{})
+		if next = p.newVersionValue(gen, f, strategy, oldVal); gen.Error != nil {
+			return gen.Error
+		}
+		values[f.DBName] = next
+	}
+
+	// unscoped, so neither the soft-delete scope nor the plugin's own guard applies
+	tx := db.Unscoped().Model(model)
+	if len(stmt.Schema.PrimaryFields) == 0 {
+		tx = tx.Where(clause.Where{Exprs: identityConds(stmt, p.identityFields(stmt.Schema))})
+	}
+	tx = tx.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName}, Value: oldVal}).
+		Where(clause.Neq{Column: clause.Column{Table: clause.CurrentTable, Name: deletedAt.DBName}, Value: nil}).
+		Updates(values)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return newConflictError(tx.Statement)
+	}
+	if err := deletedAt.Set(ctx, stmt.ReflectValue, gorm.DeletedAt{}); err != nil {
+		return err
+	}
+	if strategy == StrategyXmin || strategy == StrategyRowVersion {
+		if next, err = p.storedVersion(db.WithContext(p.keepStored(ctx)), stmt, f); err != nil {
+			return err
+		}
+	}
+	if next, err = p.encodeVersion(ctx, stmt.Table, next); err != nil {
+		return err
+	}
+	return f.Set(ctx, stmt.ReflectValue, next)
+}
+
+// Refresh re-reads the row of model by primary key, or identity columns, into model
+// itself, typically after a conflict:
+//
+//	if err := db.Updates(&m).Error; errors.Is(err, optimistic.ErrOptimisticLock) {
+//		err = optimistic.Refresh(db, &m)
+//	}
+//
+// Only columns are refreshed. Association fields keep their in-memory values unless named
+// in associations, which are preloaded anew.
+func Refresh(db *gorm.DB, model any, associations ...string) error {
+	if reflect.ValueOf(model).Kind() != reflect.Ptr {
+		return gorm.ErrInvalidValue
+	}
+	current, stmt, err := loadCurrent(db, model, associations...)
+	if err != nil {
+		return err
+	}
+	src := reflect.Indirect(reflect.ValueOf(current))
+	for _, f := range stmt.Schema.Fields {
+		if f.DBName == "" {
+			continue
+		}
+		f.ReflectValueOf(stmt.Context, stmt.ReflectValue).Set(f.ReflectValueOf(stmt.Context, src))
+	}
+	for _, name := range associations {
+		// nested preloads refresh the top-level association they hang off
+		name, _, _ = strings.Cut(name, ".")
+		if rel, ok := stmt.Schema.Relationships.Relations[name]; ok {
+			rel.Field.ReflectValueOf(stmt.Context, stmt.ReflectValue).Set(rel.Field.ReflectValueOf(stmt.Context, src))
+		}
+	}
+	return nil
+}
+
+// loadCurrent reads the persisted row for model by primary key into a new value of the
+// same type, returning it together with the statement describing model.
+func loadCurrent(db *gorm.DB, model any, associations ...string) (any, *gorm.Statement, error) {
+	stmt, err := parseTarget(db, model)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := pluginFor(db).reload(db, stmt, associations...)
+	return current, stmt, err
+}
+
+// parseTarget returns a statement describing model, which must be a struct or a pointer
+// to one.
+func parseTarget(db *gorm.DB, model any) (*gorm.Statement, error) {
+	stmt := &gorm.Statement{DB: db, Context: context.Background()}
+	if db.Statement != nil && db.Statement.Context != nil {
+		stmt.Context = db.Statement.Context
+	}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	stmt.ReflectValue = reflect.Indirect(reflect.ValueOf(model))
+	if stmt.ReflectValue.Kind() != reflect.Struct {
+		return nil, gorm.ErrInvalidValue
+	}
+	return stmt, nil
+}
+
+// valuesEqual compares two column values of dialect, treating integers of different types
+// as equal when they hold the same number, times as equal when sameInstant says so and
+// text as equal whether it is held as a string or as bytes. Values of other kinds must be
+// deeply equal.
+func valuesEqual(dialect string, a, b any) bool {
+	if v, ok := a.(Versioner); ok {
+		return v.Equal(b)
+	}
+	if x, ok := asUint64(a); ok {
+		y, ok := asUint64(b)
+		return ok && x == y
+	}
+	if x, ok := a.(time.Time); ok {
+		y, ok := b.(time.Time)
+		return ok && sameInstant(dialect, x, y)
+	}
+	if x, ok := asText(a); ok {
+		y, ok := asText(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// asText returns v, a string or bytes of any named type, as a string.
+func asText(v any) (string, bool) {
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Kind() == reflect.String:
+		return rv.String(), true
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+		return string(rv.Bytes()), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,68 @@
+package optimistic
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// hlcCounterBits is how many low bits of an HLC hold its logical counter.
+const hlcCounterBits = 16
+
+// HLC is a hybrid logical clock version: the millisecond of its write since the Unix
+// epoch in the high bits and a logical counter in the low 16. Fields of type HLC, or
+// int64 and uint64 fields tagged `version:hlc`, are versioned with StrategyHLC:
+//
+//	type Order struct {
+//		ID      uint64
+//		Version optimistic.HLC `gorm:"not null;version"`
+//	}
+//
+// A new version is the wall clock with a zero counter, unless that is not past the
+// version loaded or the latest one the plugin generated; then it is the larger of
+// those with the counter advanced. Versions of a row thus strictly increase even when
+// writes share a millisecond or clocks step back, while they stay close to wall time.
+// HLCs are stored as integers, so no column precision truncates them.
+type HLC int64
+
+// Time returns the wall clock millisecond of h.
+func (h HLC) Time() time.Time {
+	return time.UnixMilli(int64(h) >> hlcCounterBits)
+}
+
+// Counter returns the logical counter of h.
+func (h HLC) Counter() uint16 {
+	return uint16(h & (1<<hlcCounterBits - 1))
+}
+
+var tyHLC = reflect.TypeOf(HLC(0))
+
+// hlcClock is the latest HLC a plugin generated.
+type hlcClock struct {
+	mu   sync.Mutex
+	last int64
+}
+
+// next returns the HLC for a write at now following both current, the version replaced,
+// and the latest HLC of the clock.
+func (c *hlcClock) next(now time.Time, current int64) int64 {
+	wall := now.UnixMilli() << hlcCounterBits
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next := max(c.last, current) + 1
+	if wall > next {
+		next = wall
+	}
+	c.last = next
+	return next
+}
+
+// hlcVersion returns the HLC version of f following current, converted to the field's
+// type.
+func (p *Plugin) hlcVersion(db *gorm.DB, f *schema.Field, current any) any {
+	c, _ := asUint64(current)
+	return reflect.ValueOf(p.hlc.next(p.now(db), int64(c))).Convert(f.IndirectFieldType).Interface()
+}
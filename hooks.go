@@ -0,0 +1,311 @@
+package optimistic
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// VersionChange describes a row whose version was bumped by an update.
+type VersionChange struct {
+	Table      string
+	PrimaryKey map[string]any
+	From       any
+	To         any
+}
+
+// VersionChangeHook receives version changes; see WithVersionChangeHook and
+// WithCommittedVersionChangeHook.
+type VersionChangeHook func(ctx context.Context, change VersionChange)
+
+// WithVersionChangeHook calls hook from within each successful guarded update, before
+// the surrounding transaction (if any) commits.
+func WithVersionChangeHook(hook VersionChangeHook) ConfigOption {
+	return func(cfg *Config) {
+		cfg.changeHooks = append(cfg.changeHooks, hook)
+	}
+}
+
+// WithCommittedVersionChangeHook calls hook only once the transaction containing the
+// update has committed, and never for updates that were rolled back, so publishers and
+// caches do not act on writes that did not persist. Updates outside a transaction are
+// delivered right away.
+//
+// Delivery wraps the connection pool so transaction commits can be observed. Changes made
+// within a savepoint that is later rolled back are still delivered when the outer
+// transaction commits.
+func WithCommittedVersionChangeHook(hook VersionChangeHook) ConfigOption {
+	return func(cfg *Config) {
+		cfg.committedHooks = append(cfg.committedHooks, hook)
+	}
+}
+
+// emitVersionChange reports the version bump of a successful guarded update.
+func (p *Plugin) emitVersionChange(db *gorm.DB) {
+	if len(p.changeHooks) == 0 && len(p.committedHooks) == 0 {
+		return
+	}
+	if db.Error != nil || db.DryRun || db.Statement.Unscoped || db.RowsAffected == 0 || Conflicted(db) {
+		return
+	}
+	stmt := db.Statement
+	if !isTargetedModelUpdate(stmt) || reflect.Indirect(stmt.ReflectValue).Kind() != reflect.Struct {
+		return
+	}
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return
+	}
+	if _, ok := db.InstanceGet(contextKeyToVersion); !ok {
+		return
+	}
+	change := VersionChange{
+		Table:      stmt.Table,
+		PrimaryKey: newConflictReport(stmt, nil).PrimaryKey,
+	}
+	change.From, _ = db.InstanceGet(contextKeyFromVersion)
+	change.To, _ = f.ValueOf(stmt.Context, stmt.ReflectValue)
+	// the model is encoded once the plugin is done with it
+	change.From, _ = p.encodeVersion(stmt.Context, stmt.Table, change.From)
+	change.To, _ = p.encodeVersion(stmt.Context, stmt.Table, change.To)
+
+	for _, hook := range p.changeHooks {
+		hook(stmt.Context, change)
+	}
+	if len(p.committedHooks) == 0 {
+		return
+	}
+	if tx, ok := trackedTx(stmt.ConnPool); ok {
+		tx.queue(change)
+		return
+	}
+	deliver(stmt.Context, p.committedHooks, []VersionChange{change})
+}
+
+// propagateVersion settles a guarded update of a model with an AfterUpdate hook before
+// gorm runs the hook, ahead of the plugin's verification: a conflict fails the update so
+// the hook does not run, and the model is given the versions written unless RETURNING
+// read them back already. Hooks thus always observe the bumped version.
+func (p *Plugin) propagateVersion(supportsReturning bool) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		stmt := db.Statement
+		if db.Error != nil || db.DryRun || stmt.SkipHooks || stmt.Schema == nil || !stmt.Schema.AfterUpdate {
+			return
+		}
+		if !isTargetedModelUpdate(stmt) || reflect.Indirect(stmt.ReflectValue).Kind() != reflect.Struct {
+			return
+		}
+		f := p.findVersionField(stmt.Schema)
+		if f == nil {
+			return
+		}
+		to, guarded := p.writtenVersion(db, f)
+		grouped := false
+		if len(p.planFor(f).groups) > 0 {
+			_, grouped = db.InstanceGet(contextKeyGroupVersions)
+		}
+		if !guarded && !grouped {
+			return
+		}
+		if db.RowsAffected == 0 {
+			_ = db.AddError(ErrOptimisticLock)
+			return
+		}
+		if supportsReturning {
+			return
+		}
+		if grouped {
+			p.setGroupVersions(db)
+		}
+		if guarded {
+			if encoded, err := p.encodeVersion(stmt.Context, stmt.Table, to); err == nil {
+				_ = f.Set(stmt.Context, stmt.ReflectValue, encoded)
+			}
+		}
+	}
+}
+
+// NarrowedUpdate describes conditions the plugin added to an update's WHERE clause, such
+// as the primary key and version guards. An update matching no rows is explained by the
+// effective WHERE clause rather than the one it was given.
+type NarrowedUpdate struct {
+	Table string
+	// Original is the WHERE clause the update was given, empty if it had none.
+	Original string
+	// Effective is the WHERE clause the update runs with.
+	Effective string
+}
+
+// NarrowHook receives narrowed updates; see WithNarrowHook.
+type NarrowHook func(ctx context.Context, update NarrowedUpdate)
+
+// WithNarrowHook calls hook before each update whose WHERE clause the plugin extended. The
+// clauses are rendered with their values inlined, for logging:
+//
+//	optimistic.WithNarrowHook(func(ctx context.Context, u optimistic.NarrowedUpdate) {
+//		slog.DebugContext(ctx, "update narrowed", "table", u.Table, "where", u.Original, "effective", u.Effective)
+//	})
+func WithNarrowHook(hook NarrowHook) ConfigOption {
+	return func(cfg *Config) {
+		cfg.narrowHooks = append(cfg.narrowHooks, hook)
+	}
+}
+
+// emitNarrowed reports the statement's WHERE clause as extended from original.
+func (p *Plugin) emitNarrowed(stmt *gorm.Statement, original clause.Where) {
+	if len(p.narrowHooks) == 0 || stmt.DryRun {
+		return
+	}
+	var effective clause.Where
+	if c, ok := stmt.Clauses[clause.Where{}.Name()]; ok {
+		effective, _ = c.Expression.(clause.Where)
+	}
+	if len(effective.Exprs) == len(original.Exprs) {
+		return
+	}
+	update := NarrowedUpdate{
+		Table:     stmt.Table,
+		Original:  explainWhere(stmt, original),
+		Effective: explainWhere(stmt, effective),
+	}
+	for _, hook := range p.narrowHooks {
+		hook(stmt.Context, update)
+	}
+}
+
+// explainWhere renders where's conditions for stmt with their values inlined.
+func explainWhere(stmt *gorm.Statement, where clause.Where) string {
+	if len(where.Exprs) == 0 {
+		return ""
+	}
+	scratch := &gorm.Statement{
+		DB:      stmt.DB,
+		Context: stmt.Context,
+		Table:   stmt.Table,
+		Schema:  stmt.Schema,
+		Clauses: map[string]clause.Clause{},
+	}
+	where.Build(scratch)
+	return stmt.DB.Dialector.Explain(scratch.SQL.String(), scratch.Vars...)
+}
+
+func deliver(ctx context.Context, hooks []VersionChangeHook, changes []VersionChange) {
+	for _, change := range changes {
+		for _, hook := range hooks {
+			hook(ctx, change)
+		}
+	}
+}
+
+// notifyingPool wraps the connection pool so transactions it begins hold back version
+// changes until they commit, and remember the rows they hold FOR UPDATE.
+type notifyingPool struct {
+	gorm.ConnPool
+	hooks []VersionChangeHook
+}
+
+func (c *notifyingPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	var (
+		tx  gorm.ConnPool
+		err error
+	)
+	switch beginner := c.ConnPool.(type) {
+	case gorm.TxBeginner:
+		tx, err = beginner.BeginTx(ctx, opts)
+	case gorm.ConnPoolBeginner:
+		tx, err = beginner.BeginTx(ctx, opts)
+	default:
+		return nil, gorm.ErrInvalidTransaction
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &notifyingTx{ConnPool: tx, ctx: ctx, hooks: c.hooks}, nil
+}
+
+func (c *notifyingPool) GetDBConn() (*sql.DB, error) {
+	return sqlDB(c.ConnPool)
+}
+
+type notifyingTx struct {
+	gorm.ConnPool
+	ctx   context.Context
+	hooks []VersionChangeHook
+
+	mu      sync.Mutex
+	pending []VersionChange
+	locked  *lockedRows
+}
+
+// lockedRows returns the rows the transaction's locking queries hold.
+func (t *notifyingTx) lockedRows() *lockedRows {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.locked == nil {
+		t.locked = &lockedRows{versions: map[string]any{}}
+	}
+	return t.locked
+}
+
+func (t *notifyingTx) queue(change VersionChange) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, change)
+}
+
+func (t *notifyingTx) Commit() error {
+	committer, ok := t.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+	if err := committer.Commit(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	deliver(t.ctx, t.hooks, pending)
+	return nil
+}
+
+func (t *notifyingTx) Rollback() error {
+	t.mu.Lock()
+	t.pending = nil
+	t.mu.Unlock()
+	if committer, ok := t.ConnPool.(gorm.TxCommitter); ok {
+		return committer.Rollback()
+	}
+	return gorm.ErrInvalidTransaction
+}
+
+func (t *notifyingTx) GetDBConn() (*sql.DB, error) {
+	return sqlDB(t.ConnPool)
+}
+
+// StmtContext binds stmt to the transaction, for gorm's prepared statements.
+func (t *notifyingTx) StmtContext(ctx context.Context, stmt *sql.Stmt) *sql.Stmt {
+	if tx, ok := t.ConnPool.(gorm.Tx); ok {
+		return tx.StmtContext(ctx, stmt)
+	}
+	return stmt
+}
+
+// trackedTx returns the state of the transaction pool runs in when it was begun on the
+// plugin's pool, seeing through gorm's prepared statements.
+func trackedTx(pool gorm.ConnPool) (*notifyingTx, bool) {
+	if prepared, ok := pool.(*gorm.PreparedStmtTX); ok {
+		pool = prepared.Tx
+	}
+	tx, ok := pool.(*notifyingTx)
+	return tx, ok
+}
+
+// sqlDB resolves the *sql.DB behind pool the way gorm's DB() does.
+func sqlDB(pool gorm.ConnPool) (*sql.DB, error) {
+	return (&gorm.DB{Config: &gorm.Config{ConnPool: pool}}).DB()
+}
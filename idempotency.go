@@ -0,0 +1,53 @@
+package optimistic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// IdempotencyKey returns a key identifying the version transition the guarded update tx
+// ran made, derived from the table, primary key and the versions written from and to, for
+// deduplicating webhooks and events emitted for it:
+//
+//	res := db.Updates(&order)
+//	if key, ok := optimistic.IdempotencyKey(res); ok {
+//		publisher.Publish(ctx, key, OrderChanged{ID: order.ID, Status: order.Status})
+//	}
+//
+// The key is the same whenever the same row moves between the same versions, in any
+// process, so a retried emission carries the key of the first one. It is false for
+// statements that did not change the version of a single row, including conflicts and
+// conflicts resolved by a Conflict handler, whose retry made a transition of its own.
+func IdempotencyKey(tx *gorm.DB) (string, bool) {
+	if tx == nil || tx.Statement == nil || tx.Error != nil || tx.RowsAffected == 0 || Conflicted(tx) {
+		return "", false
+	}
+	stmt := tx.Statement
+	rv := reflect.Indirect(stmt.ReflectValue)
+	if !isTargetedModelUpdate(stmt) || rv.Kind() != reflect.Struct {
+		return "", false
+	}
+	p := pluginFor(tx)
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return "", false
+	}
+	to, ok := p.writtenVersion(tx, f)
+	if !ok {
+		return "", false
+	}
+	key, ok := registryKey(stmt, p.identityFields(stmt.Schema), rv)
+	if !ok {
+		return "", false
+	}
+	from, _ := tx.InstanceGet(contextKeyFromVersion)
+	h := sha256.New()
+	for _, part := range []string{stmt.Table, key, RegistryVersion(from), RegistryVersion(to)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16]), true
+}
@@ -0,0 +1,71 @@
+package optimistic
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const identityClauseName = "optimistic:identity"
+
+// ErrUnknownIdentityColumn is returned for ByColumns naming a column the model lacks.
+var ErrUnknownIdentityColumn = errors.New("unknown identity column")
+
+// IdentityColumns makes a guarded update target its row by the given columns, typically a
+// unique natural key, instead of the primary key. Use ByColumns to construct it:
+//
+//	err := db.Clauses(optimistic.ByColumns("email")).
+//		Updates(&User{Email: "ann@example.com", Name: "Ann", Version: 3}).Error
+//
+// The update is guarded by the columns plus the version, and the primary key may be
+// left unset. The columns should identify a single row.
+type IdentityColumns struct {
+	Columns []string
+}
+
+// ByColumns returns an update clause identifying the row by columns.
+func ByColumns(columns ...string) IdentityColumns {
+	return IdentityColumns{Columns: columns}
+}
+
+func (x IdentityColumns) Name() string                 { return identityClauseName }
+func (x IdentityColumns) Build(clause.Builder)         {}
+func (x IdentityColumns) MergeClause(c *clause.Clause) { c.Expression = x }
+
+// stmtIdentity returns the fields identifying the row stmt targets, and whether they were
+// named with ByColumns rather than derived from the schema.
+func (p *Plugin) stmtIdentity(stmt *gorm.Statement) ([]*schema.Field, bool) {
+	c, ok := stmt.Clauses[identityClauseName]
+	if !ok {
+		return p.identityFields(stmt.Schema), false
+	}
+	x, ok := c.Expression.(IdentityColumns)
+	if !ok || len(x.Columns) == 0 {
+		return p.identityFields(stmt.Schema), false
+	}
+	fields := make([]*schema.Field, 0, len(x.Columns))
+	for _, col := range x.Columns {
+		if f := stmt.Schema.LookUpField(col); f != nil && f.DBName != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields, true
+}
+
+// checkIdentity reports ByColumns naming columns stmt's model does not have.
+func checkIdentity(stmt *gorm.Statement) error {
+	c, ok := stmt.Clauses[identityClauseName]
+	if !ok {
+		return nil
+	}
+	x, _ := c.Expression.(IdentityColumns)
+	for _, col := range x.Columns {
+		if f := stmt.Schema.LookUpField(col); f == nil || f.DBName == "" {
+			return fmt.Errorf("%w: %s.%s", ErrUnknownIdentityColumn, stmt.Table, col)
+		}
+	}
+	return nil
+}
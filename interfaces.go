@@ -0,0 +1,95 @@
+package optimistic
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Locker writes versioned models under the version guard, see Put, UpdateIf, Transition
+// and GetForUpdate.
+type Locker interface {
+	Put(ctx context.Context, model any) error
+	UpdateIf(ctx context.Context, model any, conds map[string]any) error
+	Transition(ctx context.Context, model any, column string, allowed map[string][]string, to string) error
+	GetForUpdate(ctx context.Context, model any) error
+}
+
+// ConflictResolver inspects and settles conflicts, see WouldConflict and ResolveAll.
+type ConflictResolver interface {
+	WouldConflict(ctx context.Context, model any) (bool, any, error)
+	ResolveAll(ctx context.Context, conflicts []ConflictReport, policy ResolvePolicy) error
+}
+
+// Retryer runs read-modify-writes again when they fail with a Retryable error.
+type Retryer interface {
+	// Retry calls fn up to attempts times, until it returns nil or an error that is not
+	// Retryable, and returns fn's last error.
+	Retry(ctx context.Context, attempts int, fn func(ctx context.Context) error) error
+}
+
+// Helpers binds the plugin helpers to a *gorm.DB so application services can depend on
+// Locker, ConflictResolver and Retryer, and unit-test conflict flows with fakes or
+// generated mocks instead of a database:
+//
+//	type OrderService struct {
+//		locks optimistic.Locker
+//		retry optimistic.Retryer
+//	}
+//
+//	svc := OrderService{locks: optimistic.NewHelpers(db), retry: optimistic.NewHelpers(db)}
+//
+// GetForUpdate needs Helpers bound to a transaction.
+type Helpers struct {
+	db *gorm.DB
+}
+
+var (
+	_ Locker           = (*Helpers)(nil)
+	_ ConflictResolver = (*Helpers)(nil)
+	_ Retryer          = (*Helpers)(nil)
+)
+
+// NewHelpers returns the helpers bound to db.
+func NewHelpers(db *gorm.DB) *Helpers {
+	return &Helpers{db: db}
+}
+
+func (h *Helpers) Put(ctx context.Context, model any) error {
+	return Put(h.db.WithContext(ctx), model)
+}
+
+func (h *Helpers) UpdateIf(ctx context.Context, model any, conds map[string]any) error {
+	return UpdateIf(h.db.WithContext(ctx), model, conds)
+}
+
+func (h *Helpers) Transition(ctx context.Context, model any, column string, allowed map[string][]string, to string) error {
+	return Transition(h.db.WithContext(ctx), model, column, allowed, to)
+}
+
+func (h *Helpers) GetForUpdate(ctx context.Context, model any) error {
+	return GetForUpdate(h.db.WithContext(ctx), model)
+}
+
+func (h *Helpers) WouldConflict(ctx context.Context, model any) (bool, any, error) {
+	return WouldConflict(h.db.WithContext(ctx), model)
+}
+
+func (h *Helpers) ResolveAll(ctx context.Context, conflicts []ConflictReport, policy ResolvePolicy) error {
+	return ResolveAll(h.db.WithContext(ctx), conflicts, policy)
+}
+
+// Retry calls fn up to attempts times, at least once, until it returns nil or an error
+// that is not Retryable. It stops early when ctx is done.
+func (h *Helpers) Retry(ctx context.Context, attempts int, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; attempt < max(attempts, 1); attempt++ {
+		if err = fn(ctx); !Retryable(err) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,31 @@
+// Package ksuidversion generates KSUID versions with github.com/segmentio/ksuid. Import it
+// for its side effect wherever models have KSUID version fields:
+//
+//	import _ "github.com/cmmoran/optimistic/ksuidversion"
+//
+// KSUIDs order by the second they were generated in, with random payloads within it.
+package ksuidversion
+
+import (
+	"io"
+	"time"
+
+	"github.com/segmentio/ksuid"
+
+	"github.com/cmmoran/optimistic"
+)
+
+func init() {
+	optimistic.RegisterGenerator(optimistic.StrategyKSUID, generate)
+}
+
+func generate(now time.Time, entropy io.Reader, _ map[string]string) (any, error) {
+	if entropy == nil {
+		return ksuid.NewRandomWithTime(now)
+	}
+	payload := make([]byte, 16)
+	if _, err := io.ReadFull(entropy, payload); err != nil {
+		return nil, err
+	}
+	return ksuid.FromParts(now, payload)
+}
@@ -0,0 +1,195 @@
+package optimistic
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNoTransaction is returned by GetForUpdate outside a transaction.
+var ErrNoTransaction = errors.New("not in a transaction")
+
+// lockedRows are the versions of the rows a transaction holds FOR UPDATE, by row key.
+type lockedRows struct {
+	mu       sync.Mutex
+	versions map[string]any
+}
+
+// GetForUpdate loads model by primary key, or identity columns, with `SELECT ... FOR
+// UPDATE` and remembers its version on tx:
+//
+//	err := db.Transaction(func(tx *gorm.DB) error {
+//		if err := optimistic.GetForUpdate(tx, &account); err != nil {
+//			return err
+//		}
+//		account.Balance -= amount
+//		return tx.Updates(&account).Error
+//	})
+//
+// Later updates of the row within tx still bump its version but leave out the version
+// predicate, since the lock already keeps other writers out. The rows are remembered by the
+// transaction, not by tx, which is left as it was. SQLite has no row locks, so there the
+// predicate is kept, as it is in transactions begun on a connection pool the plugin was
+// not installed on.
+func GetForUpdate(tx *gorm.DB, model any) error {
+	if _, ok := tx.Statement.ConnPool.(gorm.TxCommitter); !ok {
+		return ErrNoTransaction
+	}
+	p := pluginFor(tx)
+	stmt := &gorm.Statement{DB: tx, Context: tx.Statement.Context}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	stmt.ReflectValue = reflect.Indirect(reflect.ValueOf(model))
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Schema.Table)
+	}
+
+	// Find, as Oracle rejects FOR UPDATE together with the row limit of First
+	q := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate})
+	if len(stmt.Schema.PrimaryFields) == 0 {
+		q = q.Where(clause.Where{Exprs: identityConds(stmt, p.identityFields(stmt.Schema))})
+	}
+	result := q.Find(model)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	held, ok := trackedTx(tx.Statement.ConnPool)
+	if !ok {
+		return nil
+	}
+	rows := held.lockedRows()
+	version, _ := f.ValueOf(stmt.Context, stmt.ReflectValue)
+	rows.mu.Lock()
+	rows.versions[p.rowKey(stmt)] = version
+	rows.mu.Unlock()
+	return nil
+}
+
+// WithLockingQueries treats rows a transaction read with a locking query of its own, such
+// as `tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).First(&account)`,
+// as held the way GetForUpdate holds them:
+//
+//	db.Use(optimistic.NewOptimisticLock(optimistic.WithLockingQueries()))
+//
+// Later updates of such a row within the transaction, at the version it was read with,
+// leave out the version predicate, so lock contention shows as waiting rather than as
+// updates matching no row. Locking queries are observed by wrapping the connection pool.
+func WithLockingQueries() ConfigOption {
+	return func(cfg *Config) {
+		cfg.lockingQueries = true
+	}
+}
+
+// lockingForUpdate reports whether stmt locks the rows it reads against updates.
+func lockingForUpdate(stmt *gorm.Statement) bool {
+	c, ok := stmt.Clauses[clause.Locking{}.Name()]
+	if !ok {
+		return false
+	}
+	locking, ok := c.Expression.(clause.Locking)
+	return ok && strings.HasSuffix(strings.ToUpper(locking.Strength), clause.LockingStrengthUpdate)
+}
+
+// trackLockingQuery remembers the versions of the rows a locking query of a transaction
+// read, as GetForUpdate does.
+func (p *Plugin) trackLockingQuery(db *gorm.DB) {
+	if !p.lockingQueries || db.Error != nil || db.DryRun || db.RowsAffected == 0 {
+		return
+	}
+	stmt := db.Statement
+	tx, ok := trackedTx(stmt.ConnPool)
+	if !ok || !lockingForUpdate(stmt) {
+		return
+	}
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return
+	}
+	rows := tx.lockedRows()
+	track := func(rv reflect.Value) {
+		rv = reflect.Indirect(rv)
+		if rv.Kind() != reflect.Struct || rv.Type() != stmt.Schema.ModelType {
+			return
+		}
+		row := &gorm.Statement{DB: db, Context: stmt.Context, Table: stmt.Table, Schema: stmt.Schema, Clauses: stmt.Clauses, ReflectValue: rv}
+		version, _ := f.ValueOf(stmt.Context, rv)
+		rows.mu.Lock()
+		rows.versions[p.rowKey(row)] = version
+		rows.mu.Unlock()
+	}
+	switch rv := reflect.Indirect(stmt.ReflectValue); rv.Kind() {
+	case reflect.Struct:
+		track(rv)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			track(rv.Index(i))
+		}
+	}
+}
+
+// heldRows returns the rows the statement's transaction holds FOR UPDATE, those of
+// GetForUpdate and those of locking queries, or nil.
+func heldRows(stmt *gorm.Statement) *lockedRows {
+	tx, ok := trackedTx(stmt.ConnPool)
+	if !ok {
+		return nil
+	}
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	return tx.locked
+}
+
+// lockedAt reports whether the row stmt targets is held FOR UPDATE by the statement's
+// transaction at version.
+func (p *Plugin) lockedAt(stmt *gorm.Statement, version any) bool {
+	if !rowLocking(stmt.DB) {
+		return false
+	}
+	rows := heldRows(stmt)
+	if rows == nil {
+		return false
+	}
+	rows.mu.Lock()
+	locked, ok := rows.versions[p.rowKey(stmt)]
+	rows.mu.Unlock()
+	return ok && valuesEqual(stmt.DB.Dialector.Name(), locked, version)
+}
+
+// trackLocked records the version a successful update gave a row held FOR UPDATE.
+func (p *Plugin) trackLocked(db *gorm.DB) {
+	stmt := db.Statement
+	if reflect.Indirect(stmt.ReflectValue).Kind() != reflect.Struct {
+		return
+	}
+	rows := heldRows(stmt)
+	if rows == nil || db.Error != nil || db.RowsAffected == 0 || Conflicted(db) {
+		return
+	}
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return
+	}
+	key := p.rowKey(stmt)
+	version, _ := f.ValueOf(stmt.Context, stmt.ReflectValue)
+	rows.mu.Lock()
+	if _, ok := rows.versions[key]; ok {
+		rows.versions[key] = version
+	}
+	rows.mu.Unlock()
+}
+
+// rowLocking reports whether the database locks rows read FOR UPDATE.
+func rowLocking(db *gorm.DB) bool {
+	return db.Dialector.Name() != "sqlite"
+}
@@ -0,0 +1,290 @@
+package optimistic
+
+import (
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Membership changes the many2many association of a model, guarded against concurrent
+// changes of the same memberships. See Members.
+type Membership struct {
+	db    *gorm.DB
+	model any
+	name  string
+}
+
+// Members returns the many2many association name of model, whose join table has a version
+// field, with Append, Replace and Delete guarded against concurrent membership changes:
+//
+//	db.SetupJoinTable(&User{}, "Languages", &UserLanguage{}) // UserLanguage has a Version
+//
+//	err := optimistic.Members(db, &user, "Languages").Append(&de)
+//	if errors.Is(err, optimistic.ErrOptimisticLock) {
+//		// user speaks de already
+//	}
+//
+// Append fails when a value is a member already, Delete when a value is no member anymore,
+// and Replace when the members stored differ from those model holds, so Replace expects
+// model to be loaded with the association preloaded. A failed change is rolled back as a
+// whole; otherwise the association is changed as gorm's own Association API would.
+func Members(db *gorm.DB, model any, name string) *Membership {
+	return &Membership{db: db, model: model, name: name}
+}
+
+// Append adds values to the members, failing with ErrOptimisticLock if any of them is a
+// member already.
+func (m *Membership) Append(values ...any) error {
+	return m.change(func(tx *gorm.DB, j *joinTarget) error {
+		if err := j.add(tx, j.flatten(values)); err != nil {
+			return err
+		}
+		return tx.Model(m.model).Association(m.name).Append(values...)
+	})
+}
+
+// Delete removes values from the members, failing with ErrOptimisticLock if any of them
+// is no member anymore.
+func (m *Membership) Delete(values ...any) error {
+	return m.change(func(tx *gorm.DB, j *joinTarget) error {
+		if err := j.remove(tx, j.flatten(values)); err != nil {
+			return err
+		}
+		return tx.Model(m.model).Association(m.name).Delete(values...)
+	})
+}
+
+// Replace makes values the members, failing with ErrOptimisticLock if the members stored
+// are not those model holds.
+func (m *Membership) Replace(values ...any) error {
+	return m.change(func(tx *gorm.DB, j *joinTarget) error {
+		loaded := j.flatten([]any{j.rel.Field.ReflectValueOf(j.stmt.Context, j.stmt.ReflectValue).Interface()})
+		stored, err := j.stored(tx)
+		if err != nil {
+			return err
+		}
+		held := make(map[string]bool, len(loaded))
+		for _, elem := range loaded {
+			held[j.key(elem)] = true
+			if !stored[j.key(elem)] {
+				return ErrOptimisticLock
+			}
+		}
+		if len(held) != len(stored) {
+			return ErrOptimisticLock
+		}
+
+		var added []reflect.Value
+		keep := make(map[string]bool)
+		for _, elem := range j.flatten(values) {
+			if k := j.key(elem); k != "" && held[k] {
+				keep[k] = true
+			} else {
+				added = append(added, elem)
+			}
+		}
+		var removed []reflect.Value
+		for _, elem := range loaded {
+			if !keep[j.key(elem)] {
+				removed = append(removed, elem)
+			}
+		}
+		if err := j.remove(tx, removed); err != nil {
+			return err
+		}
+		if err := j.add(tx, added); err != nil {
+			return err
+		}
+		return tx.Model(m.model).Association(m.name).Replace(values...)
+	})
+}
+
+// change runs fn in a transaction of its own on the join table of the association.
+func (m *Membership) change(fn func(tx *gorm.DB, j *joinTarget) error) error {
+	stmt, err := parseTarget(m.db, m.model)
+	if err != nil {
+		return err
+	}
+	rel := stmt.Schema.Relationships.Relations[m.name]
+	if rel == nil || rel.Type != schema.Many2Many || rel.JoinTable == nil {
+		return fmt.Errorf("%w: %s", gorm.ErrUnsupportedRelation, m.name)
+	}
+	if pluginFor(m.db).findVersionField(rel.JoinTable) == nil {
+		return fmt.Errorf("%w: %s", ErrNoVersionField, rel.JoinTable.Table)
+	}
+	j := &joinTarget{stmt: stmt, rel: rel}
+	for _, ref := range rel.References {
+		switch {
+		case ref.OwnPrimaryKey:
+			v, zero := ref.PrimaryKey.ValueOf(stmt.Context, stmt.ReflectValue)
+			if zero {
+				return gorm.ErrPrimaryKeyRequired
+			}
+			j.owner = append(j.owner, clause.Eq{Column: clause.Column{Name: ref.ForeignKey.DBName}, Value: v})
+		case ref.PrimaryValue != "":
+			j.owner = append(j.owner, clause.Eq{Column: clause.Column{Name: ref.ForeignKey.DBName}, Value: ref.PrimaryValue})
+		default:
+			j.refs = append(j.refs, ref)
+		}
+	}
+	fresh := m.db.Session(&gorm.Session{NewDB: true})
+	// Must reset Error
+	fresh.Error = nil
+	return fresh.Transaction(func(tx *gorm.DB) error {
+		return fn(tx, j)
+	})
+}
+
+// joinTarget holds the join rows of one owner in a many2many association.
+type joinTarget struct {
+	stmt *gorm.Statement
+	rel  *schema.Relationship
+	// owner narrows the join table to the owner's rows
+	owner []clause.Expression
+	// refs pair the join table's columns with the members' keys
+	refs []*schema.Reference
+}
+
+// flatten returns the struct values among values and the slices in them, addressable
+// where they were given by pointer or in a slice.
+func (j *joinTarget) flatten(values []any) []reflect.Value {
+	var elems []reflect.Value
+	for _, v := range values {
+		rv := reflect.Indirect(reflect.ValueOf(v))
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < rv.Len(); i++ {
+				if elem := reflect.Indirect(rv.Index(i)); elem.Kind() == reflect.Struct {
+					elems = append(elems, elem)
+				}
+			}
+		case reflect.Struct:
+			elems = append(elems, rv)
+		default:
+		}
+	}
+	return elems
+}
+
+// key returns the key of the member elem, or "" if it has none yet.
+func (j *joinTarget) key(elem reflect.Value) string {
+	parts := make([]any, 0, len(j.refs))
+	for _, ref := range j.refs {
+		v, zero := ref.PrimaryKey.ValueOf(j.stmt.Context, elem)
+		if zero {
+			return ""
+		}
+		parts = append(parts, v)
+	}
+	return fmt.Sprint(parts...)
+}
+
+// pairs returns the condition matching the join rows of the owner and elems.
+func (j *joinTarget) pairs(elems []reflect.Value) clause.Expression {
+	exprs := make([]clause.Expression, 0, len(elems))
+	for _, elem := range elems {
+		eqs := append(make([]clause.Expression, 0, len(j.owner)+len(j.refs)), j.owner...)
+		for _, ref := range j.refs {
+			v, _ := ref.PrimaryKey.ValueOf(j.stmt.Context, elem)
+			eqs = append(eqs, clause.Eq{Column: clause.Column{Name: ref.ForeignKey.DBName}, Value: v})
+		}
+		exprs = append(exprs, clause.And(eqs...))
+	}
+	return clause.Or(exprs...)
+}
+
+// add inserts the join rows of elems, creating the members that have no key yet, and
+// fails with ErrOptimisticLock if any of the rows exists already.
+func (j *joinTarget) add(tx *gorm.DB, elems []reflect.Value) error {
+	joins := reflect.MakeSlice(reflect.SliceOf(reflect.PointerTo(j.rel.JoinTable.ModelType)), 0, len(elems))
+	seen := make(map[string]bool, len(elems))
+	for _, elem := range elems {
+		if j.key(elem) == "" {
+			if !elem.CanAddr() {
+				return gorm.ErrInvalidValue
+			}
+			if err := tx.Omit(clause.Associations).Create(elem.Addr().Interface()).Error; err != nil {
+				return err
+			}
+		}
+		k := j.key(elem)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		row := reflect.New(j.rel.JoinTable.ModelType)
+		for _, ref := range j.rel.References {
+			var v any
+			switch {
+			case ref.OwnPrimaryKey:
+				v, _ = ref.PrimaryKey.ValueOf(j.stmt.Context, j.stmt.ReflectValue)
+			case ref.PrimaryValue != "":
+				v = ref.PrimaryValue
+			default:
+				v, _ = ref.PrimaryKey.ValueOf(j.stmt.Context, elem)
+			}
+			if err := ref.ForeignKey.Set(j.stmt.Context, row, v); err != nil {
+				return err
+			}
+		}
+		joins = reflect.Append(joins, row)
+	}
+	if joins.Len() == 0 {
+		return nil
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(joins.Interface())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected < int64(joins.Len()) {
+		return ErrOptimisticLock
+	}
+	return nil
+}
+
+// remove deletes the join rows of elems, failing with ErrOptimisticLock if any of them is
+// gone already.
+func (j *joinTarget) remove(tx *gorm.DB, elems []reflect.Value) error {
+	keyed := make([]reflect.Value, 0, len(elems))
+	seen := make(map[string]bool, len(elems))
+	for _, elem := range elems {
+		// members without a key were never stored
+		if k := j.key(elem); k != "" && !seen[k] {
+			seen[k] = true
+			keyed = append(keyed, elem)
+		}
+	}
+	if len(keyed) == 0 {
+		return nil
+	}
+	result := tx.Where(j.pairs(keyed)).Delete(reflect.New(j.rel.JoinTable.ModelType).Interface())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected < int64(len(keyed)) {
+		return ErrOptimisticLock
+	}
+	return nil
+}
+
+// stored returns the keys of the owner's members as stored.
+func (j *joinTarget) stored(tx *gorm.DB) (map[string]bool, error) {
+	rows := reflect.New(reflect.SliceOf(j.rel.JoinTable.ModelType))
+	if err := tx.Where(clause.And(j.owner...)).Find(rows.Interface()).Error; err != nil {
+		return nil, err
+	}
+	rows = rows.Elem()
+	keys := make(map[string]bool, rows.Len())
+	for i := 0; i < rows.Len(); i++ {
+		parts := make([]any, 0, len(j.refs))
+		for _, ref := range j.refs {
+			v, _ := ref.ForeignKey.ValueOf(j.stmt.Context, rows.Index(i))
+			parts = append(parts, v)
+		}
+		keys[fmt.Sprint(parts...)] = true
+	}
+	return keys, nil
+}
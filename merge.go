@@ -0,0 +1,274 @@
+package optimistic
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	// mergeTagName tags numeric fields, `gorm:"merge:sum"`, whose concurrent changes are
+	// merged as deltas instead of conflicting.
+	mergeTagName = "MERGE"
+	mergeSum     = "sum"
+
+	// baseCapacity bounds the rows remembered as merge bases.
+	baseCapacity = 4096
+	// maxCounterMerges bounds the merges of an update whose merged write conflicts again.
+	maxCounterMerges = 3
+)
+
+// merging counts the counter merges the context's update went through.
+type merging struct{}
+
+// counterFields returns the fields of sch tagged `merge:sum`.
+func counterFields(sch *schema.Schema) []*schema.Field {
+	var counters []*schema.Field
+	for _, f := range sch.Fields {
+		if strings.EqualFold(f.TagSettings[mergeTagName], mergeSum) && isCounterKind(f.FieldType.Kind()) {
+			counters = append(counters, f)
+		}
+	}
+	return counters
+}
+
+func isCounterKind(k reflect.Kind) bool {
+	return isNumericKind(k) || k == reflect.Float32 || k == reflect.Float64
+}
+
+// baseStore remembers rows of tables with counters as they were read or written, by
+// version, so a conflicting update can tell the changes it made from those made since.
+type baseStore struct {
+	mu   sync.Mutex
+	rows map[string]map[string]any
+	// keys rings through the stored keys in insertion order to evict the oldest
+	keys []string
+	next int
+}
+
+func newBaseStore() *baseStore {
+	return &baseStore{rows: map[string]map[string]any{}, keys: make([]string, baseCapacity)}
+}
+
+func (s *baseStore) put(key string, row map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rows[key]; !ok {
+		delete(s.rows, s.keys[s.next])
+		s.keys[s.next] = key
+		s.next = (s.next + 1) % len(s.keys)
+	}
+	s.rows[key] = row
+}
+
+func (s *baseStore) get(key string) (map[string]any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, ok := s.rows[key]
+	return row, ok
+}
+
+// baseKey identifies the row rv of sch at version.
+func baseKey(ctx context.Context, sch *schema.Schema, rv reflect.Value, version any) (string, bool) {
+	if len(sch.PrimaryFields) == 0 {
+		return "", false
+	}
+	var b strings.Builder
+	b.WriteString(sch.Table)
+	for _, pf := range sch.PrimaryFields {
+		val, zero := pf.ValueOf(ctx, rv)
+		if zero {
+			return "", false
+		}
+		_, _ = fmt.Fprintf(&b, "\x00%v", val)
+	}
+	if t, ok := version.(time.Time); ok {
+		version = t.UnixNano()
+	}
+	_, _ = fmt.Fprintf(&b, "@%v", version)
+	return b.String(), true
+}
+
+// rememberBases records the rows read, created or written by db as merge bases of tables
+// with counters.
+func (p *Plugin) rememberBases(db *gorm.DB) {
+	if p.bases == nil || db.Error != nil || db.DryRun || db.Statement.Schema == nil {
+		return
+	}
+	stmt := db.Statement
+	f := p.findVersionField(stmt.Schema)
+	if f == nil || len(p.planFor(f).counters) == 0 {
+		return
+	}
+	if _, updating := stmt.Clauses[clause.Set{}.Name()]; updating && (Conflicted(db) || !isTargetedModelUpdate(stmt)) {
+		return
+	}
+	remember := func(rv reflect.Value) {
+		// rows scanned into other types than the model's are no bases
+		rv = reflect.Indirect(rv)
+		if rv.Kind() != reflect.Struct || rv.Type() != stmt.Schema.ModelType {
+			return
+		}
+		version, _ := f.ValueOf(stmt.Context, rv)
+		key, ok := baseKey(stmt.Context, stmt.Schema, rv, version)
+		if !ok {
+			return
+		}
+		row := make(map[string]any, len(stmt.Schema.DBNames))
+		for _, name := range stmt.Schema.DBNames {
+			val, _ := stmt.Schema.FieldsByDBName[name].ValueOf(stmt.Context, rv)
+			// pointed-to values may change under the caller
+			row[name] = derefValue(val)
+		}
+		p.bases.put(key, row)
+	}
+	switch rv := reflect.Indirect(stmt.ReflectValue); rv.Kind() {
+	case reflect.Struct:
+		remember(rv)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			remember(rv.Index(i))
+		}
+	}
+}
+
+// mergeCounters settles a conflict whose only collisions are on counters: their deltas
+// since the version the update expected are added to the current values, other columns
+// the update assigned are kept when nobody else changed them since, and the result is
+// written under the current version. It reports whether it took care of the conflict.
+func (p *Plugin) mergeCounters(db *gorm.DB, report *ConflictReport) bool {
+	stmt := db.Statement
+	f := p.findVersionField(stmt.Schema)
+	if p.bases == nil || f == nil {
+		return false
+	}
+	counters := p.planFor(f).counters
+	if len(counters) == 0 {
+		return false
+	}
+	merges, _ := stmt.Context.Value(merging{}).(int)
+	if merges >= maxCounterMerges {
+		return false
+	}
+	rv := reflect.Indirect(stmt.ReflectValue)
+	c, ok := stmt.Clauses[clause.Set{}.Name()]
+	if rv.Kind() != reflect.Struct || !ok {
+		return false
+	}
+	set, _ := c.Expression.(clause.Set)
+	expected, _ := db.InstanceGet(contextKeyFromVersion)
+	key, ok := baseKey(stmt.Context, stmt.Schema, rv, expected)
+	if !ok {
+		return false
+	}
+	base, ok := p.bases.get(key)
+	if !ok {
+		return false
+	}
+	current, err := p.reload(db, stmt)
+	if err != nil {
+		return false
+	}
+	cur := reflect.Indirect(reflect.ValueOf(current))
+
+	changes := make(map[string]any, len(set))
+	for _, a := range set {
+		if a.Column.Name == f.DBName {
+			continue
+		}
+		field := stmt.Schema.LookUpField(a.Column.Name)
+		if field == nil {
+			return false
+		}
+		now, _ := field.ValueOf(stmt.Context, cur)
+		_, isExpr := a.Value.(clause.Expression)
+		counter := slices.Contains(counters, field)
+		switch {
+		case counter && isExpr:
+			// expressions such as `hits + 1` apply their delta to whatever is stored
+			changes[a.Column.Name] = a.Value
+		case counter:
+			merged, ok := addDelta(field.FieldType, now, a.Value, base[a.Column.Name])
+			if !ok {
+				return false
+			}
+			changes[a.Column.Name] = merged
+		case field.AutoUpdateTime > 0, sameValue(stmt.DB.Dialector.Name(), now, base[a.Column.Name]), !isExpr && sameValue(stmt.DB.Dialector.Name(), now, a.Value):
+			changes[a.Column.Name] = a.Value
+		default:
+			// someone else changed the column too
+			return false
+		}
+	}
+
+	ctx := context.WithValue(stmt.Context, merging{}, merges+1)
+	retry := db.Session(&gorm.Session{NewDB: true, SkipHooks: true, Context: ctx})
+	// Must reset Error
+	retry.Error = nil
+	retry = retry.Model(current).Updates(changes)
+	if p.strictRetry && retry.Error == nil && retry.RowsAffected == 0 {
+		retry.Error = &RetryError{Table: stmt.Table, Attempts: 2}
+	}
+	db.Error = retry.Error
+	db.RowsAffected = retry.RowsAffected
+	report.CurrentVersion, _ = f.ValueOf(stmt.Context, cur)
+	report.Resolution = ResolutionMerged
+	if retry.Error != nil {
+		report.Resolution = ResolutionMergeFailed
+	}
+	reflect.Indirect(reflect.ValueOf(stmt.Model)).Set(cur)
+	p.markStored(db, false)
+	return true
+}
+
+// addDelta returns current plus the change from base to attempted, as a value of typ.
+func addDelta(typ reflect.Type, current, attempted, base any) (any, bool) {
+	c, a, b := reflect.ValueOf(current), reflect.ValueOf(attempted), reflect.ValueOf(base)
+	for _, v := range []reflect.Value{c, a, b} {
+		if !v.IsValid() || !v.CanConvert(typ) {
+			return nil, false
+		}
+	}
+	c, a, b = c.Convert(typ), a.Convert(typ), b.Convert(typ)
+	sum := reflect.New(typ).Elem()
+	switch {
+	case sum.CanInt():
+		sum.SetInt(c.Int() + a.Int() - b.Int())
+	case sum.CanUint():
+		sum.SetUint(c.Uint() + a.Uint() - b.Uint())
+	case sum.CanFloat():
+		sum.SetFloat(c.Float() + a.Float() - b.Float())
+	default:
+		return nil, false
+	}
+	return sum.Interface(), true
+}
+
+// sameValue reports whether x and y hold the same column value on dialect, looking
+// through pointers.
+func sameValue(dialect string, x, y any) bool {
+	return valuesEqual(dialect, derefValue(x), derefValue(y))
+}
+
+// derefValue returns what v points to, or nil for nil pointers.
+func derefValue(v any) any {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	return rv.Interface()
+}
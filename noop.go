@@ -0,0 +1,102 @@
+package optimistic
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const contextKeyUnchanged = "optimistic:unchanged"
+
+// WithSkipNoopUpdates skips guarded updates that would write the values the row holds
+// already, leaving the row and its version alone, so idempotent writers such as PUT
+// handlers do not churn versions:
+//
+//	db.Use(optimistic.NewOptimisticLock(optimistic.WithSkipNoopUpdates()))
+//
+//	res := db.Updates(&order) // nothing changed since order was loaded
+//	if optimistic.Unchanged(res) {
+//		// no statement ran and order.Version is as loaded
+//	}
+//
+// The row is read before the update to compare against, an extra SELECT for every
+// guarded update. Updates assigning SQL expressions, forced updates, touches and updates
+// with conditions of their own always run, as do updates of rows at another version,
+// which fail with ErrOptimisticLock as usual. Columns gorm sets on every update, such as
+// UpdatedAt, are not compared.
+func WithSkipNoopUpdates() ConfigOption {
+	return func(cfg *Config) {
+		cfg.skipNoops = true
+	}
+}
+
+// Unchanged reports whether the update executed by tx was skipped because it would not
+// have changed the row, see WithSkipNoopUpdates.
+func Unchanged(tx *gorm.DB) bool {
+	if tx == nil || tx.Statement == nil {
+		return false
+	}
+	unchanged, _ := tx.InstanceGet(contextKeyUnchanged)
+	return unchanged == true
+}
+
+// skipNoop skips the update of stmt when set would write the values its row holds at the
+// version from already, and reports whether it did.
+func (p *Plugin) skipNoop(stmt *gorm.Statement, f *schema.Field, set clause.Set, from any) bool {
+	if !p.skipNoops || stmt.DryRun || isForced(stmt) || len(set) == 0 || reflect.Indirect(stmt.ReflectValue).Kind() != reflect.Struct {
+		return false
+	}
+	if _, ok := stmt.Clauses[clause.Where{}.Name()]; ok {
+		return false
+	}
+	current, err := p.reload(stmt.DB, stmt)
+	if err != nil {
+		return false
+	}
+	row := reflect.Indirect(reflect.ValueOf(current))
+	if stored, _ := f.ValueOf(stmt.Context, row); !valuesEqual(stmt.DB.Dialector.Name(), stored, from) {
+		// let the guard report the conflict
+		return false
+	}
+	for _, a := range set {
+		field := stmt.Schema.LookUpField(a.Column.Name)
+		if field == nil {
+			return false
+		}
+		if field.AutoUpdateTime > 0 {
+			continue
+		}
+		if _, expr := a.Value.(clause.Expression); expr {
+			return false
+		}
+		stored, _ := field.ValueOf(stmt.Context, row)
+		if !valuesEqual(stmt.DB.Dialector.Name(), derefValue(a.Value), derefValue(stored)) {
+			return false
+		}
+	}
+
+	stmt.DB.InstanceSet(contextKeyUnchanged, true)
+	// gorm refuses updates without conditions before they reach the pool
+	p.skipGuard(stmt, f)
+	delete(stmt.Clauses, "RETURNING")
+	stmt.ConnPool = &noopConnPool{guardedConnPool: guardedConnPool{ConnPool: stmt.ConnPool, stmt: stmt}}
+	return true
+}
+
+type noopConnPool struct {
+	guardedConnPool
+}
+
+// ExecContext skips the update the pool stands in for.
+func (c *noopConnPool) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	c.stmt.ConnPool = c.ConnPool
+	// the skipped statement is not logged
+	c.stmt.SQL.Reset()
+	c.stmt.Vars = nil
+	return driver.RowsAffected(0), nil
+}
@@ -1,15 +1,20 @@
 package optimistic
 
 import (
-	"crypto/rand"
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
-	"github.com/google/uuid"
-	"github.com/oklog/ulid/v2"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"gorm.io/gorm/schema"
@@ -20,17 +25,45 @@ const (
 	afterCreateCallback  = "gorm:after_create"
 	beforeUpdateCallback = "gorm:update"
 	afterUpdateCallback  = "gorm:after_update"
-
-	contextKeyFromVersion = "optimistic:from_version"
-	contextKeyToVersion   = "optimistic:to_version"
-	conflictClauseName    = "optimistic:conflict"
+	queryCallback        = "gorm:query"
+	deleteCallback       = "gorm:delete"
+	afterDeleteCallback  = "gorm:after_delete"
+
+	contextKeyFromVersion    = "optimistic:from_version"
+	contextKeyToVersion      = "optimistic:to_version"
+	contextKeyConflicted     = "optimistic:conflicted"
+	contextKeyConflictReport = "optimistic:conflict_report"
+	contextKeyGuardedUpsert  = "optimistic:guarded_upsert"
+	conflictClauseName       = "optimistic:conflict"
+
+	defaultTagName = "VERSION"
 )
 
 var (
 	ErrOptimisticLock = errors.New("optimistic lock conflict")
-	ulidEntropy       = ulid.Monotonic(rand.Reader, 0)
-	tyTime            = reflect.TypeOf(time.Time{})
-	ty16Byte          = reflect.TypeOf((*[16]byte)(nil)).Elem()
+	ErrNoVersionField = errors.New("no version field")
+	// ErrVersionTagMismatch is returned in strict mode when a version field's tag names a
+	// strategy its type cannot hold, or names none where the type is ambiguous.
+	ErrVersionTagMismatch = errors.New("version tag does not match field type")
+	// ErrNoSchema is returned under SchemalessReject for updates without a model.
+	ErrNoSchema = errors.New("update has no schema")
+	// ErrNarrowedUpdate is returned under KeyReject for updates the plugin would narrow to
+	// the model's primary key.
+	ErrNarrowedUpdate = errors.New("update would be narrowed to the model's primary key")
+	// ErrRetryNotApplied is returned under WithStrictRetry when a Conflict handler's merged
+	// value matched no row either.
+	ErrRetryNotApplied = errors.New("resolved update was not applied")
+	// ErrVersionColumnCollision is returned for models whose version column is also
+	// stamped by gorm as a timestamp, or mapped by another field.
+	ErrVersionColumnCollision = errors.New("version column collides with another mapping")
+	// ErrMultipleVersionFields is returned for models declaring more than one version field.
+	ErrMultipleVersionFields = errors.New("multiple version fields")
+
+	tyTime      = reflect.TypeOf(time.Time{})
+	ty16Byte    = reflect.TypeOf((*[16]byte)(nil)).Elem()
+	ty20Byte    = reflect.TypeOf((*[20]byte)(nil)).Elem()
+	ty12Byte    = reflect.TypeOf((*[12]byte)(nil)).Elem()
+	schemaCache = &sync.Map{}
 )
 
 type Config struct {
@@ -51,8 +84,118 @@ type Config struct {
 	//		Version uuid.UUID	`gorm:"version:uuid"`
 	//	}
 	tagName string
+	// numericCheck controls how the RETURNING value of a numeric version bump is verified
+	numericCheck NumericCheck
+	// includedTables, when non-empty, limits the plugin to these tables
+	includedTables map[string]struct{}
+	// excludedTables are never guarded by the plugin
+	excludedTables map[string]struct{}
+	// reloadRetries is how many extra times a non-RETURNING update reloads the row while
+	// the reloaded version does not match the one just written
+	reloadRetries int
+	// reloadDelay is the pause between reload attempts
+	reloadDelay time.Duration
+	// identityColumns maps tables without a primary key to the columns identifying a row
+	identityColumns map[string][]string
+	// strictTags picks the version strategy from the tag value alone
+	strictTags bool
+	// changeHooks are called from within each successful guarded update
+	changeHooks []VersionChangeHook
+	// committedHooks are called once the update's transaction has committed
+	committedHooks []VersionChangeHook
+	// schemaless controls updates the plugin cannot inspect because they have no schema
+	schemaless SchemalessPolicy
+	// significantColumns maps tables to the columns whose changes bump the version
+	significantColumns map[string]map[string]struct{}
+	// coalescer serializes concurrent updates of the same row, see WithCoalescing
+	coalescer *coalescer
+	// keyPolicy controls narrowing updates with their own conditions to the model's key
+	keyPolicy KeyPolicy
+	// narrowHooks are called whenever the plugin adds conditions to an update's WHERE
+	narrowHooks []NarrowHook
+	// reconcileQueue receives unresolved conflicts of tables with a merge policy
+	reconcileQueue ReconcileQueue
+	// reconcileAttempts bounds how often a reconciled value is retried on conflict
+	reconcileAttempts int
+	// mergePolicies maps tables to the policies reconciling their queued conflicts
+	mergePolicies map[string]MergePolicy
+	// strictRetry fails resolved retries that update no row
+	strictRetry bool
+	// clock replaces gorm's NowFunc for generated versions
+	clock func() time.Time
+	// uuidSource and ulidEntropy replace crypto/rand for generated versions
+	uuidSource  io.Reader
+	ulidEntropy io.Reader
+	// stringGenerator generates string versions, see WithStringGenerator
+	stringGenerator Generator
+	// models are parsed on Initialize so their tables are known before first use
+	models []any
+	// codec transforms versions between the version column and models
+	codec VersionCodec
+	// transientClassifiers tell driver errors of guarded updates worth retrying
+	transientClassifiers []ErrorClassifier
+	// versionOnlyReturning reads back only the key and version columns of guarded updates
+	versionOnlyReturning bool
+	// versionStringer renders versions in logs and error messages
+	versionStringer VersionStringer
+	// dryRunGuards adds guards to DryRun updates, see WithDryRunGuards
+	dryRunGuards bool
+	// lockingQueries trusts row locks taken by locking queries, see WithLockingQueries
+	lockingQueries bool
+	// registryTable mirrors the versions of written rows, see WithVersionRegistry
+	registryTable string
+	// upsertGuard guards the updates of OnConflict creates, see WithUpsertGuard
+	upsertGuard bool
+	// aggregateBump bumps models whose updates write associations, see WithAggregateBump
+	aggregateBump bool
+	// skipNoops skips updates that would not change their row, see WithSkipNoopUpdates
+	skipNoops bool
+	// snowflake generates the versions of snowflake fields, see WithSnowflakeVersions
+	snowflake *snowflakeSource
 }
 
+// KeyPolicy controls guarded updates of a model whose statement has its own WHERE
+// conditions but none on the primary key, such as
+// `db.Model(&m).Where("status = ?", "open").Updates(...)`. Updates without conditions of
+// their own always target the model's row, as they do in gorm.
+type KeyPolicy int
+
+const (
+	// KeyInject narrows the update to the model's row by adding `pk = ?`.
+	KeyInject KeyPolicy = iota
+	// KeyOmit leaves the conditions alone and guards the update by version only, so it
+	// updates every matching row still at the model's version.
+	KeyOmit
+	// KeyReject fails the update with ErrNarrowedUpdate instead of narrowing it.
+	KeyReject
+)
+
+// SchemalessPolicy controls updates without a schema, such as `db.Table(...).Updates(map)`,
+// which the plugin cannot check for a version field and therefore cannot guard.
+type SchemalessPolicy int
+
+const (
+	// SchemalessIgnore lets schemaless updates run unguarded without notice.
+	SchemalessIgnore SchemalessPolicy = iota
+	// SchemalessWarn lets schemaless updates run unguarded and logs a warning.
+	SchemalessWarn
+	// SchemalessReject fails schemaless updates with ErrNoSchema.
+	SchemalessReject
+)
+
+// NumericCheck controls how a bumped numeric version returned by the database is verified.
+type NumericCheck int
+
+const (
+	// NumericExact requires the returned version to equal the previous version + 1.
+	NumericExact NumericCheck = iota
+	// NumericIncreased accepts any returned version greater than the previous one, tolerating
+	// triggers or other plugins that also bump the column.
+	NumericIncreased
+	// NumericAny accepts whatever version the database returned once the guarded update matched.
+	NumericAny
+)
+
 type ConfigOption func(*Config)
 
 func WithTagName(tagName string) ConfigOption {
@@ -67,26 +210,188 @@ func WithDisableReturning() ConfigOption {
 	}
 }
 
+// WithDryRunGuards adds the version guard, bump and RETURNING clauses to updates run with
+// DryRun as well, so tooling inspecting their SQL sees the statements that run:
+//
+//	stmt := db.Session(&gorm.Session{DryRun: true}).Updates(&order).Statement
+//	fmt.Println(stmt.SQL.String()) // UPDATE ... WHERE `id` = ? AND `version` = ?
+//
+// The statements record nothing for the plugin's later callbacks, and narrow hooks are
+// not called for them.
+func WithDryRunGuards() ConfigOption {
+	return func(cfg *Config) {
+		cfg.dryRunGuards = true
+	}
+}
+
+// WithVersionOnlyReturning reads back only the key and version columns of guarded updates
+// through `RETURNING` instead of the whole row, for tables whose triggers or defaults
+// rewrite other columns on update. Those columns then keep the values the model wrote,
+// and only the version decides whether the update verified.
+func WithVersionOnlyReturning() ConfigOption {
+	return func(cfg *Config) {
+		cfg.versionOnlyReturning = true
+	}
+}
+
+// WithNumericCheck sets how numeric version bumps are verified on dialects with `RETURNING`.
+func WithNumericCheck(check NumericCheck) ConfigOption {
+	return func(cfg *Config) {
+		cfg.numericCheck = check
+	}
+}
+
+// WithTables limits the plugin to the given tables so it can be rolled out table by table.
+// Models mapped to any other table are left untouched.
+func WithTables(include ...string) ConfigOption {
+	return func(cfg *Config) {
+		if cfg.includedTables == nil {
+			cfg.includedTables = make(map[string]struct{}, len(include))
+		}
+		for _, table := range include {
+			cfg.includedTables[table] = struct{}{}
+		}
+	}
+}
+
+// WithExcludedTables disables the plugin for the given tables.
+func WithExcludedTables(exclude ...string) ConfigOption {
+	return func(cfg *Config) {
+		if cfg.excludedTables == nil {
+			cfg.excludedTables = make(map[string]struct{}, len(exclude))
+		}
+		for _, table := range exclude {
+			cfg.excludedTables[table] = struct{}{}
+		}
+	}
+}
+
+// WithReloadRetries verifies updates on dialects without `RETURNING` against the reloaded
+// row. While the reloaded version is not the one just written the row is reloaded up to
+// retries more times, delay apart, before the update fails with ErrOptimisticLock. This
+// tolerates proxies or replicas that briefly serve stale reads right after a write.
+func WithReloadRetries(retries int, delay time.Duration) ConfigOption {
+	return func(cfg *Config) {
+		cfg.reloadRetries = retries
+		cfg.reloadDelay = delay
+	}
+}
+
+// WithIdentityColumns declares the natural key of a table without a primary key, so
+// updates and deletes of its models are guarded by those columns plus the version. The
+// same can be declared on the model with the `identity` tag:
+//
+//	type Setting struct {
+//		Scope   string `gorm:"uniqueIndex:idx_setting;identity"`
+//		Key     string `gorm:"uniqueIndex:idx_setting;identity"`
+//		Value   string
+//		Version uint64 `gorm:"version"`
+//	}
+func WithIdentityColumns(table string, columns ...string) ConfigOption {
+	return func(cfg *Config) {
+		if cfg.identityColumns == nil {
+			cfg.identityColumns = make(map[string][]string)
+		}
+		cfg.identityColumns[table] = append(cfg.identityColumns[table], columns...)
+	}
+}
+
+// WithSignificantColumns only guards and bumps updates of table that assign at least one
+// of columns, so bookkeeping writes such as `last_login_at` leave the version alone and
+// cannot conflict with business edits. Other updates of table run unguarded.
+func WithSignificantColumns(table string, columns ...string) ConfigOption {
+	return func(cfg *Config) {
+		if cfg.significantColumns == nil {
+			cfg.significantColumns = make(map[string]map[string]struct{})
+		}
+		if cfg.significantColumns[table] == nil {
+			cfg.significantColumns[table] = make(map[string]struct{})
+		}
+		for _, col := range columns {
+			cfg.significantColumns[table][col] = struct{}{}
+		}
+	}
+}
+
+// WithStrictTags chooses the version strategy solely from the tag value
+// (`version:int`, `version:uuid`, `version:ulid` or `version:time`) instead of sniffing
+// type names, and fails statements with ErrVersionTagMismatch when the tag contradicts the
+// field type. A bare `version` tag is still accepted on numeric and time.Time fields,
+// whose strategy is unambiguous.
+func WithStrictTags() ConfigOption {
+	return func(cfg *Config) {
+		cfg.strictTags = true
+	}
+}
+
+// WithStrictRetry fails an update whose Conflict handler merged a value that, written
+// again, updated no row, with a *RetryError instead of reporting success with
+// RowsAffected 0. Without it such a merge is silently lost.
+func WithStrictRetry() ConfigOption {
+	return func(cfg *Config) {
+		cfg.strictRetry = true
+	}
+}
+
+// WithKeyPolicy sets how updates with their own conditions are narrowed to the model's
+// row; they are narrowed by default.
+func WithKeyPolicy(policy KeyPolicy) ConfigOption {
+	return func(cfg *Config) {
+		cfg.keyPolicy = policy
+	}
+}
+
+// WithSchemalessPolicy sets how updates without a schema are handled; they are ignored
+// by default.
+func WithSchemalessPolicy(policy SchemalessPolicy) ConfigOption {
+	return func(cfg *Config) {
+		cfg.schemaless = policy
+	}
+}
+
 func WithConfig(cfg Config) ConfigOption {
 	return func(c *Config) {
 		*c = cfg
 	}
 }
 
-// Plugin wires up optimistic‐locking callbacks.
+// Plugin wires up optimistic‐locking callbacks. They are callbacks rather than model
+// hooks, so sessions with SkipHooks are guarded as well.
 type Plugin struct {
 	*Config
+	// plans caches the versionPlan of each version field
+	plans *sync.Map
+	// bases remembers rows of tables with counters to merge their conflicts
+	bases *baseStore
+	// hlc is the clock of hlc versions
+	hlc *hlcClock
+	// dialect and returning describe the connection the plugin was initialized for
+	dialect   string
+	returning bool
 }
 
 func (Plugin) Name() string { return "optimistic_lock" }
 
 func (p *Plugin) Initialize(db *gorm.DB) error {
-	// Simple dialect check: MySQL doesn’t support RETURNING.
-	supportsReturning := db.Dialector.Name() != "mysql"
+	// MySQL doesn’t support RETURNING on updates, and drivers only register it where the
+	// database supports it, e.g. SQLite 3.35+ with either the CGO or the CGO-free driver.
+	supportsReturning := db.Dialector.Name() != "mysql" && registersReturning(db.Callback().Update().Clauses)
 	if p.disableReturning {
 		supportsReturning = false
 	}
+	p.dialect, p.returning = db.Dialector.Name(), supportsReturning
 	p.tagName = strings.ToUpper(p.tagName)
+	for _, model := range p.models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		if f := p.findVersionField(stmt.Schema); f != nil {
+			if plan := p.planFor(f); plan.err != nil {
+				return plan.err
+			}
+		}
+	}
 
 	// CREATE → seed and verify initial version
 	_ = db.Callback().Create().
@@ -95,22 +400,148 @@ func (p *Plugin) Initialize(db *gorm.DB) error {
 	_ = db.Callback().Create().
 		After(afterCreateCallback).
 		Register("optimistic:verify_create", p.verifyCreate)
+	// the registry is written within the statement's transaction
+	_ = db.Callback().Create().
+		After(beforeCreateCallback).
+		Before(afterCreateCallback).
+		Register("optimistic:register_versions", p.registerCreated)
+	_ = db.Callback().Create().
+		After("optimistic:verify_create").
+		Register("optimistic:remember_bases", p.rememberBases)
+	_ = db.Callback().Create().
+		After("optimistic:remember_bases").
+		Register("optimistic:encode_versions", p.encodeVersions)
+	_ = db.Callback().Create().
+		After("optimistic:verify_create").
+		Register("optimistic:stamp_fingerprints", p.stampFingerprints)
 
 	// UPDATE → inject SET/WHERE, then verify, then optionally resolve conflicts
 	_ = db.Callback().Update().
 		Before(beforeUpdateCallback).
 		Register("optimistic:modify_update", p.modifyUpdate(supportsReturning))
+	_ = db.Callback().Update().
+		After(beforeUpdateCallback).
+		Before(afterUpdateCallback).
+		Register("optimistic:classify_error", p.classifyError)
+	_ = db.Callback().Update().
+		After("optimistic:classify_error").
+		Before(afterUpdateCallback).
+		Register("optimistic:register_versions", p.registerUpdated)
+	_ = db.Callback().Update().
+		After(beforeUpdateCallback).
+		Before(saveAfterAssociations).
+		Register("optimistic:verify_aggregate", p.verifyAggregate)
+	// model AfterUpdate hooks run before the verification below
+	_ = db.Callback().Update().
+		After("optimistic:classify_error").
+		Before(afterUpdateCallback).
+		Register("optimistic:propagate_version", p.propagateVersion(supportsReturning))
 	_ = db.Callback().Update().
 		After(afterUpdateCallback).
 		Register("optimistic:verify_update", p.verifyUpdate(supportsReturning))
 	_ = db.Callback().Update().
 		After(afterUpdateCallback).
 		Register("optimistic:resolve_conflict", p.resolveConflict)
+	_ = db.Callback().Update().
+		After("optimistic:resolve_conflict").
+		Register("optimistic:version_changed", p.emitVersionChange)
+	// batch conflicts are reported once the other rows are committed
+	_ = db.Callback().Update().
+		After(afterUpdateCallback).
+		Register("optimistic:report_batch", p.reportBatch)
+
+	_ = db.Callback().Update().
+		After("optimistic:version_changed").
+		Register("optimistic:remember_bases", p.rememberBases)
+	_ = db.Callback().Update().
+		After("optimistic:remember_bases").
+		Register("optimistic:encode_versions", p.encodeVersions)
+	_ = db.Callback().Update().
+		After("optimistic:version_changed").
+		Register("optimistic:stamp_fingerprints", p.stampFingerprints)
+	_ = db.Callback().Update().
+		After("optimistic:encode_versions").
+		Register("optimistic:track_locked", p.trackLocked)
+
+	// concurrent updates of the same row take turns around the whole update, transaction included
+	_ = db.Callback().Update().
+		Before("*").
+		Register("optimistic:coalesce", p.coalesceUpdate)
+	_ = db.Callback().Update().
+		After("*").
+		Register("optimistic:release_coalesced", p.releaseCoalesced)
+
+	// DELETE → guard deletes of loaded models, check-then-delete for scoped deletes
+	// carrying CheckedDelete
+	_ = db.Callback().Delete().
+		Before(deleteCallback).
+		Register("optimistic:guard_delete", p.guardDelete)
+	_ = db.Callback().Delete().
+		Before(deleteCallback).
+		Register("optimistic:capture_delete", p.captureDelete)
+	// gorm appends callbacks ordered after a sorted one last, past the commit
+	_ = db.Callback().Delete().
+		Before(afterDeleteCallback).
+		Register("optimistic:verify_delete", p.verifyDelete)
+	_ = db.Callback().Delete().
+		After("optimistic:verify_delete").
+		Before(afterDeleteCallback).
+		Register("optimistic:resolve_delete", p.resolveDelete)
+	_ = db.Callback().Delete().
+		After(deleteCallback).
+		Before(afterDeleteCallback).
+		Register("optimistic:unregister_versions", p.unregisterDeleted)
+	// batch conflicts are reported once the other rows are committed
+	_ = db.Callback().Delete().
+		After(afterDeleteCallback).
+		Register("optimistic:report_batch", p.reportBatch)
+
+	// committed hooks and row locks need to observe transactions begun on this pool, unless
+	// it is a transaction already
+	if _, inTx := db.ConnPool.(gorm.TxCommitter); !inTx {
+		pool := &notifyingPool{ConnPool: db.ConnPool, hooks: p.committedHooks}
+		db.ConnPool = pool
+		if db.Statement != nil {
+			db.Statement.ConnPool = pool
+		}
+	}
+
+	// QUERY → xmin versions are not part of `SELECT *`
+	_ = db.Callback().Query().
+		Before(queryCallback).
+		Register("optimistic:select_xmin", p.selectXmin)
+	// optional minimum-version guard for read-your-writes
+	_ = db.Callback().Query().
+		Before(queryCallback).
+		Register("optimistic:inject_min_version", p.injectMinVersion)
+	_ = db.Callback().Query().
+		After(queryCallback).
+		Register("optimistic:verify_min_version", p.verifyMinVersion)
+	// rows read are the bases counter merges compute their deltas from
+	_ = db.Callback().Query().
+		After(queryCallback).
+		Register("optimistic:remember_bases", p.rememberBases)
+	// versions leave the plugin encoded, see WithVersionCodec
+	_ = db.Callback().Query().
+		After("optimistic:remember_bases").
+		Register("optimistic:encode_versions", p.encodeVersions)
+	_ = db.Callback().Query().
+		After(queryCallback).
+		Register("optimistic:stamp_fingerprints", p.stampFingerprints)
+	_ = db.Callback().Query().
+		After("optimistic:encode_versions").
+		Register("optimistic:track_locking_query", p.trackLockingQuery)
+	// asserted versions are compared as models carry them
+	_ = db.Callback().Query().
+		After("optimistic:encode_versions").
+		Register("optimistic:assert_version", p.assertVersion)
 
 	return nil
 }
 
-// initializeVersion sets version=1/UUID/ULID/time.Now() on new records.
+// initializeVersion sets version=1/UUID/ULID/time.Now() on new records. Association
+// children are created by statements of their own, which run through here as well, as
+// does every batch of CreateInBatches with Dest holding just its elements.
 func (p *Plugin) initializeVersion(db *gorm.DB) {
 	if db.DryRun || db.Statement.Unscoped {
 		return
@@ -119,15 +550,34 @@ func (p *Plugin) initializeVersion(db *gorm.DB) {
 	if f == nil {
 		return
 	}
-	ft := f.StructField.Type
+	strategy, err := p.versionStrategy(f)
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
 	dest := reflect.ValueOf(db.Statement.Dest)
 	if dest.Kind() == reflect.Ptr {
 		dest = dest.Elem()
 	}
 
+	// guarded upserts keep the loaded version of existing rows to compare against
+	upsert := p.guardsUpsert(db)
+	seed := func(elem reflect.Value) {
+		if upsert {
+			if _, zero := f.ValueOf(db.Statement.Context, elem); !zero {
+				if err := p.codeRows(db.Statement, f, elem, true); err != nil {
+					_ = db.AddError(err)
+				}
+				return
+			}
+		}
+		p.setInitialVersion(db, elem, f, strategy)
+	}
+	p.markStored(db, true)
+
 	switch dest.Kind() {
 	case reflect.Struct:
-		p.setInitialVersion(db, dest, f, ft)
+		seed(dest)
 	case reflect.Slice:
 		for i := 0; i < dest.Len(); i++ {
 			elem := dest.Index(i)
@@ -135,43 +585,65 @@ func (p *Plugin) initializeVersion(db *gorm.DB) {
 				elem = elem.Elem()
 			}
 			if elem.Kind() == reflect.Struct {
-				p.setInitialVersion(db, elem, f, ft)
+				seed(elem)
 			}
 		}
 	default:
 	}
+	switch {
+	case strategy == StrategyXmin:
+		// postgres assigns xmin on inserts and upserts alike
+		db.Statement.AddClause(createReturning(db.Statement, f))
+	case strategy == StrategyRowVersion:
+		// SQL Server assigns rowversions itself, read back once created
+	case upsert:
+		p.guardUpsert(db, f, strategy, countRows(dest))
+	case p.bumpsUpsert(db):
+		p.bumpUpsert(db, f, strategy)
+	case strategy == StrategyTime && p.returnsOnCreate(db):
+		// the column may keep less precision than NowFunc; read back what was stored
+		db.Statement.AddClause(createReturning(db.Statement, f))
+	}
 }
 
 func (p *Plugin) setInitialVersion(
 	db *gorm.DB,
 	elem reflect.Value,
 	f *schema.Field,
-	structFieldType reflect.Type,
+	strategy Strategy,
 ) {
 	ctx := db.Statement.Context
-	switch {
-	case isNumericKind(structFieldType.Kind()):
+	switch strategy {
+	case StrategyInt:
 		_ = f.Set(ctx, elem, uint64(1))
-	case ty16Byte.AssignableTo(structFieldType):
-		if p.paramIs(f, "ulid") || strings.Contains(strings.ToLower(structFieldType.Name()), "ulid") {
-			_ = f.Set(ctx, elem, ulid.MustNew(ulid.Timestamp(db.NowFunc()), ulidEntropy))
-		} else {
-			_ = f.Set(ctx, elem, uuid.New())
-		}
-	case structFieldType == tyTime:
-		_ = f.Set(ctx, elem, db.NowFunc())
+	case StrategyULID, StrategyUUID, StrategyKSUID, StrategyXID, StrategySnowflake, StrategyUnixNano, StrategyHLC, StrategySequence, StrategyTime, StrategyCustom, StrategyString:
+		_ = f.Set(ctx, elem, p.newVersionValue(db, f, strategy, nil))
 	}
+	p.seedGroups(db, elem, f)
 }
 
 // verifyCreate ensures the initial version is correct (1, non-zero UUID/ULID, or time).
 func (p *Plugin) verifyCreate(db *gorm.DB) {
-	if db.DryRun || db.Statement.Unscoped {
+	if db.Error != nil || db.DryRun || db.Statement.Unscoped {
+		return
+	}
+	if verifyUpsert(db) {
 		return
 	}
 	f := p.findVersionField(db.Statement.Schema)
 	if f == nil {
 		return
 	}
+	if p.bumpsUpsert(db) {
+		// existing rows were bumped rather than seeded
+		if !p.returnsOnCreate(db) {
+			p.readBackVersions(db, f)
+		}
+		return
+	}
+	if strategy, _ := p.versionStrategy(f); (strategy == StrategyTime || strategy == StrategyRowVersion) && !p.returnsOnCreate(db) {
+		p.readBackVersions(db, f)
+	}
 	dest := reflect.ValueOf(db.Statement.Dest)
 	if dest.Kind() == reflect.Ptr {
 		dest = dest.Elem()
@@ -207,15 +679,24 @@ func (p *Plugin) checkInitialVersionField(db *gorm.DB, v reflect.Value, f *schem
 		return
 	}
 
-	switch {
+	switch strategy := p.planFor(f).strategy; {
+	case implementsVersioner(ft):
+		if v, ok := asVersioner(rv.Interface(), ft); !ok || v.IsZero() {
+			_ = db.AddError(ErrOptimisticLock)
+		}
+	case strategy == StrategySnowflake, strategy == StrategyUnixNano, strategy == StrategyHLC, strategy == StrategySequence,
+		strategy == StrategyXmin, strategy == StrategyRowVersion:
+		// OK
 	case isNumericKind(ft.Kind()):
-		if rv.Uint() != 1 {
+		if n, _ := asUint64(rv.Interface()); n != 1 {
 			_ = db.AddError(ErrOptimisticLock)
 		}
-	case ty16Byte.AssignableTo(ft):
+	case ty16Byte.AssignableTo(ft), ty20Byte.AssignableTo(ft), ty12Byte.AssignableTo(ft):
 		// OK
 	case ft == tyTime:
 		// OK
+	case ft.Kind() == reflect.String:
+		// OK
 	default:
 		_ = db.AddError(ErrOptimisticLock)
 	}
@@ -224,10 +705,21 @@ func (p *Plugin) checkInitialVersionField(db *gorm.DB, v reflect.Value, f *schem
 // modifyUpdate injects the SET … and WHERE … clauses for the update.
 func (p *Plugin) modifyUpdate(supportsReturning bool) func(db *gorm.DB) {
 	return func(db *gorm.DB) {
-		if db.DryRun || db.Statement.Unscoped {
+		if (db.DryRun && !p.dryRunGuards) || db.Statement.Unscoped {
+			return
+		}
+		if db.Statement.Schema == nil {
+			p.handleSchemaless(db)
+			return
+		}
+		if err := checkIdentity(db.Statement); err != nil {
+			_ = db.AddError(err)
 			return
 		}
 		if !isTargetedModelUpdate(db.Statement) {
+			if _, ok := db.Statement.Clauses[bulkBumpClauseName]; ok {
+				p.bulkBump(db.Statement)
+			}
 			return
 		}
 		stmt := db.Statement
@@ -235,77 +727,162 @@ func (p *Plugin) modifyUpdate(supportsReturning bool) func(db *gorm.DB) {
 		if f == nil {
 			return
 		}
+		if _, err := p.versionStrategy(f); err != nil {
+			_ = db.AddError(err)
+			return
+		}
+		if err := expectVersion(stmt, f); err != nil {
+			_ = db.AddError(err)
+			return
+		}
+		if kind := reflect.Indirect(stmt.ReflectValue).Kind(); kind == reflect.Slice || kind == reflect.Array {
+			p.batchUpdate(stmt)
+			return
+		}
 
 		// 1) stash old version
-		oldVal, _ := f.ValueOf(stmt.Context, stmt.ReflectValue)
-		stmt.DB.InstanceSet(contextKeyFromVersion, oldVal)
+		modelVal, _ := f.ValueOf(stmt.Context, stmt.ReflectValue)
+		oldVal, err := p.decodeVersion(stmt.Context, stmt.Table, modelVal)
+		if err != nil {
+			_ = db.AddError(err)
+			return
+		}
+		if !stmt.DryRun {
+			stmt.DB.InstanceSet(contextKeyFromVersion, oldVal)
+		}
 
 		// 2) build or merge SET clause
+		var (
+			groups clause.Where
+			own    bool
+		)
+		// writing associations changes the aggregate, whatever the model's own columns do
+		aggregate := p.savesAssociations(stmt)
+		if aggregate && !stmt.DryRun {
+			stmt.DB.InstanceSet(contextKeyAggregate, true)
+		}
+		// so does a touch, which changes nothing else
+		_, touch := stmt.Clauses[touchClauseName]
+		forced := aggregate || touch
 		if c, ok := stmt.Clauses[clause.Set{}.Name()]; ok {
 			set := c.Expression.(clause.Set)
-			p.bumpVersion(stmt, f, &set)
+			if !forced && !p.significant(stmt, set) {
+				p.skipGuard(stmt, f)
+				return
+			}
+			if !forced && p.skipNoop(stmt, f, set, oldVal) {
+				return
+			}
+			if groups, own = p.bumpGroups(stmt, f, &set); own || forced {
+				own = true
+				p.bumpVersion(stmt, f, &set)
+			}
 			c.Expression = set
 		} else {
 			var set clause.Set
 			p.collectAssignments(stmt, f, &set)
-			if len(set) == 0 {
+			if len(set) == 0 && !forced {
 				stmt.Omits = append(stmt.Omits, f.DBName)
 				return
 			}
-			p.bumpVersion(stmt, f, &set)
+			if !forced && !p.significant(stmt, set) {
+				stmt.AddClause(set)
+				p.skipGuard(stmt, f)
+				return
+			}
+			if !forced && p.skipNoop(stmt, f, set, oldVal) {
+				stmt.AddClause(set)
+				return
+			}
+			if groups, own = p.bumpGroups(stmt, f, &set); own || forced {
+				own = true
+				p.bumpVersion(stmt, f, &set)
+			}
 			stmt.AddClause(set)
 		}
 
 		// 3) inject WHERE version = oldVal (plus PK, plus RETURNING if supported)
-		p.injectWhereVersion(stmt, f, oldVal, supportsReturning)
+		if own {
+			p.injectWhereVersion(stmt, f, oldVal, supportsReturning, !isForced(stmt) && !p.lockedAt(stmt, modelVal))
+		} else {
+			// only grouped columns change, so their group versions guard the update alone
+			p.skipGuard(stmt, f)
+			if supportsReturning {
+				stmt.AddClauseIfNotExists(groupReturning(stmt))
+			}
+		}
+		if len(groups.Exprs) > 0 && !isForced(stmt) {
+			stmt.AddClause(groups)
+		}
+	}
+}
+
+// handleSchemaless applies the SchemalessPolicy to an update the plugin cannot guard.
+func (p *Plugin) handleSchemaless(db *gorm.DB) {
+	if !p.tableEnabled(db.Statement.Table) {
+		return
+	}
+	switch p.schemaless {
+	case SchemalessWarn:
+		db.Logger.Warn(db.Statement.Context, "[%s] update of %q has no schema and is not guarded", p.Name(), db.Statement.Table)
+	case SchemalessReject:
+		_ = db.AddError(fmt.Errorf("%w: %s", ErrNoSchema, db.Statement.Table))
+	default:
 	}
 }
 
 func (p *Plugin) collectAssignments(stmt *gorm.Statement, f *schema.Field, set *clause.Set) {
-	// map-based updates
+	// map-based updates; keys are sorted like gorm's own ConvertToAssignments so the
+	// generated SQL is stable, and values (including clause.Expr) are passed through as-is
 	if m, ok := stmt.Dest.(map[string]interface{}); ok {
-		for col, val := range m {
-			name := stmt.NamingStrategy.ColumnName("", col)
-			if name == f.DBName {
-				continue
+		cols := make([]string, 0, len(m))
+		for col := range m {
+			cols = append(cols, col)
+		}
+		sort.Strings(cols)
+		for _, col := range cols {
+			val := m[col]
+			if sub, isSub := val.(*gorm.DB); isSub {
+				val = []interface{}{sub}
 			}
-			if sf := stmt.Schema.LookUpField(name); sf != nil {
-				if len(sf.DBName) == 0 || !sf.Updatable {
+			// keys may be field or column names; the schema resolves either to the column
+			if sf := stmt.Schema.LookUpField(col); sf != nil {
+				if len(sf.DBName) == 0 || !sf.Updatable || sf == f {
 					continue
 				}
 				*set = append(*set, clause.Assignment{
-					Column: clause.Column{Name: name},
+					Column: clause.Column{Name: sf.DBName},
 					Value:  val,
 				})
 			}
 		}
 		return
 	}
-	// struct-based updates
-	selectCols, restrict := stmt.SelectAndOmitColumns(false, true)
-	for k, v := range selectCols {
-		k = stmt.NamingStrategy.ColumnName("", k)
-		selectCols[k] = v
+	// struct-based updates; selected columns are keyed by column name already, and without
+	// Select or Omit every updatable non-zero field is assigned
+	var (
+		selectCols map[string]bool
+		restrict   bool
+	)
+	if len(stmt.Selects) > 0 || len(stmt.Omits) > 0 {
+		selectCols, restrict = stmt.SelectAndOmitColumns(false, true)
 	}
+	identity, _ := p.stmtIdentity(stmt)
 	for _, sf := range stmt.Schema.Fields {
 		if sf == nil || len(sf.DBName) == 0 || !sf.Updatable {
 			continue
 		}
-		name := stmt.NamingStrategy.ColumnName("", sf.DBName)
-		if sf.PrimaryKey || name == f.DBName || !sf.Updatable {
+		if sf.PrimaryKey || slices.Contains(identity, sf) || sf == f {
 			continue
 		}
-		sel := selectCols[name]
-		if restrict {
-			if !sel {
-				continue
-			}
-		} else {
-			if _, zero := sf.ValueOf(stmt.Context, stmt.ReflectValue); zero && !sel {
-				continue
-			}
+		sel := selectCols[sf.DBName]
+		if restrict && !sel {
+			continue
+		}
+		val, zero := sf.ValueOf(stmt.Context, stmt.ReflectValue)
+		if !restrict && zero && !sel {
+			continue
 		}
-		val, _ := sf.ValueOf(stmt.Context, stmt.ReflectValue)
 		*set = append(*set, clause.Assignment{
 			Column: clause.Column{Name: sf.DBName},
 			Value:  val,
@@ -313,7 +890,34 @@ func (p *Plugin) collectAssignments(stmt *gorm.Statement, f *schema.Field, set *
 	}
 }
 
-// bumpVersion appends version‐bump to the SET clause and saves the “to” value.
+// significant reports whether set assigns a WithSignificantColumns column, or none is set.
+func (p *Plugin) significant(stmt *gorm.Statement, set clause.Set) bool {
+	columns, ok := p.significantColumns[stmt.Schema.Table]
+	if !ok {
+		return true
+	}
+	for _, a := range set {
+		if _, ok := columns[a.Column.Name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// skipGuard lets a targeted update run without the version guard. gorm only derives the
+// primary key conditions when it builds the SET clause itself, so they are added here.
+func (p *Plugin) skipGuard(stmt *gorm.Statement, f *schema.Field) {
+	stmt.Omits = append(stmt.Omits, f.DBName)
+	var existing clause.Where
+	if c, ok := stmt.Clauses[clause.Where{}.Name()]; ok {
+		existing, _ = c.Expression.(clause.Where)
+	}
+	identity, _ := p.stmtIdentity(stmt)
+	stmt.AddClause(clause.Where{Exprs: identityConds(stmt, identity)})
+	p.emitNarrowed(stmt, existing)
+}
+
+// bumpVersion appends the version bump to the SET clause and saves the "to" value.
 func (p *Plugin) bumpVersion(
 	stmt *gorm.Statement,
 	f *schema.Field,
@@ -322,28 +926,73 @@ func (p *Plugin) bumpVersion(
 	if set == nil || !isTargetedModelUpdate(stmt) {
 		return
 	}
-	ft := f.StructField.Type
-	name := stmt.NamingStrategy.ColumnName("", f.DBName)
-
-	col := clause.Column{Name: name}
+	val, ok := p.nextVersion(stmt, f)
+	if !ok {
+		return
+	}
+	if _, assigned := val.(dbBump); assigned {
+		// the database bumps the version itself, as long as the row is written
+		if len(*set) == 0 {
+			*set = append(*set, p.touchAssignment(stmt, f))
+		}
+	} else {
+		// SET targets cannot be table-qualified on every dialect, the bump expression can
+		*set = append(*set, clause.Assignment{Column: clause.Column{Name: f.DBName}, Value: val})
+	}
+	if !stmt.DryRun {
+		stmt.DB.InstanceSet(contextKeyToVersion, val)
+	}
+}
 
-	var val any
-	switch {
-	case isNumericKind(ft.Kind()):
-		val = clause.Expr{SQL: "? + 1", Vars: []any{col}}
-	case ty16Byte.AssignableTo(ft):
-		if p.paramIs(f, "ulid") || strings.Contains(strings.ToLower(f.FieldType.Name()), "ulid") {
-			val = ulid.MustNew(ulid.Timestamp(stmt.DB.NowFunc()), ulidEntropy)
-		} else {
-			val = uuid.New()
+// nextVersion returns the SET value bumping the version f: an increment of the column for
+// integer versions, a new version otherwise.
+func (p *Plugin) nextVersion(stmt *gorm.Statement, f *schema.Field) (any, bool) {
+	plan := p.planFor(f)
+	if plan.err != nil {
+		return nil, false
+	}
+	switch strategy := plan.strategy; strategy {
+	case StrategyInt:
+		return plan.bump, true
+	case StrategyULID, StrategyUUID, StrategyKSUID, StrategyXID, StrategySnowflake, StrategySequence, StrategyTime, StrategyString:
+		return p.newVersionValue(stmt.DB, f, strategy, nil), true
+	case StrategyXmin, StrategyRowVersion:
+		return dbBump{}, true
+	case StrategyCustom, StrategyUnixNano, StrategyHLC:
+		// custom, unixnano and hlc versions follow the loaded one; bulk updates have none
+		var current any
+		if rv := reflect.Indirect(stmt.ReflectValue); rv.Kind() == reflect.Struct {
+			current, _ = f.ValueOf(stmt.Context, rv)
+			current, _ = p.decodeVersion(stmt.Context, stmt.Table, current)
 		}
-	case ft == tyTime:
-		val = stmt.DB.NowFunc()
+		return p.newVersionValue(stmt.DB, f, strategy, current), true
 	default:
-		return
+		return nil, false
+	}
+}
+
+// dbBump is the next version of versions the database assigns itself, xmin and rowversion
+// ones.
+type dbBump struct{}
+
+// writtenVersion returns the version the guarded update db ran wrote, as stored, or false
+// when db did not bump the row's version.
+func (p *Plugin) writtenVersion(db *gorm.DB, f *schema.Field) (any, bool) {
+	to, ok := db.InstanceGet(contextKeyToVersion)
+	if !ok {
+		return nil, false
+	}
+	if _, assigned := to.(dbBump); assigned {
+		// read back with RETURNING or OUTPUT
+		return f.ValueOf(db.Statement.Context, db.Statement.ReflectValue)
 	}
-	*set = append(*set, clause.Assignment{Column: col, Value: val})
-	stmt.DB.InstanceSet(contextKeyToVersion, val)
+	// integer versions are bumped in SQL
+	if p.planFor(f).strategy == StrategyInt {
+		from, _ := db.InstanceGet(contextKeyFromVersion)
+		n, _ := asUint64(from)
+		return n + 1, true
+	}
+	return to, true
 }
 
 func isTargetedModelUpdate(stmt *gorm.Statement) bool {
@@ -357,12 +1006,17 @@ func isTargetedModelUpdate(stmt *gorm.Statement) bool {
 	}
 	switch val.Kind() {
 	case reflect.Struct:
-		pk := stmt.Schema.PrioritizedPrimaryField
-		if pk == nil {
+		// every identity field must be set, so composite keys are targeted as a whole
+		fields, _ := pluginFor(stmt.DB).stmtIdentity(stmt)
+		if len(fields) == 0 {
 			return false
 		}
-		_, isZero := pk.ValueOf(stmt.Context, val)
-		return !isZero
+		for _, pk := range fields {
+			if isZeroField(stmt.Context, pk, val) {
+				return false
+			}
+		}
+		return true
 	case reflect.Slice:
 		return val.Len() > 0
 	default:
@@ -375,6 +1029,7 @@ func (p *Plugin) injectWhereVersion(
 	f *schema.Field,
 	oldVal any,
 	supportsReturning bool,
+	guard bool,
 ) {
 	if !isTargetedModelUpdate(stmt) {
 		return
@@ -386,52 +1041,98 @@ func (p *Plugin) injectWhereVersion(
 			existing = wh
 		}
 	}
+	identity, _ := p.stmtIdentity(stmt)
 	additions := clause.Where{
-		Exprs: make([]clause.Expression, 0),
+		Exprs: make([]clause.Expression, 0, len(identity)+1),
 	}
 
 	// ensure PK(s) in WHERE
 	missingPK := false
-	pkVals := make([]any, 0)
-	pkFlds := make([]*schema.Field, 0)
-	for _, pf := range stmt.Schema.PrimaryFields {
+	pkFlds := make([]*schema.Field, 0, len(identity))
+	for _, pf := range identity {
 		hasPK := false
-		val, _ := pf.ValueOf(stmt.Context, stmt.ReflectValue)
 		for _, expr := range existing.Exprs {
 			if eq, ok := expr.(clause.Eq); ok {
-				pfName := stmt.NamingStrategy.ColumnName("", pf.DBName)
-				if name, ok2 := eqColumnName(stmt, eq); ok2 && name == pfName {
+				if name, ok2 := eqColumnName(eq); ok2 && name == pf.DBName {
 					hasPK = true
 					break
 				}
 			}
 		}
 		if !hasPK {
-			pkVals = append(pkVals, val)
 			pkFlds = append(pkFlds, pf)
 			missingPK = true
 		}
 	}
 
+	if missingPK && len(existing.Exprs) > 0 {
+		switch p.keyPolicy {
+		case KeyOmit:
+			missingPK = false
+		case KeyReject:
+			_ = stmt.DB.AddError(fmt.Errorf("%w: %s", ErrNarrowedUpdate, stmt.Table))
+			return
+		default:
+		}
+	}
+
 	if missingPK {
-		for i, pkf := range pkFlds {
+		for _, pkf := range pkFlds {
+			val, _ := pkf.ValueOf(stmt.Context, stmt.ReflectValue)
 			additions.Exprs = append(additions.Exprs, clause.Eq{
-				Column: clause.Column{Name: pkf.DBName},
-				Value:  pkVals[i],
+				Column: clause.Column{Table: clause.CurrentTable, Name: pkf.DBName},
+				Value:  val,
 			})
 		}
 	}
 
-	additions.Exprs = append(additions.Exprs, clause.Eq{
-		Column: clause.Column{Name: f.DBName},
-		Value:  oldVal,
-	})
+	// rows locked FOR UPDATE by the transaction cannot have moved on
+	if guard {
+		additions.Exprs = append(additions.Exprs, clause.Eq{
+			Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName},
+			Value:  oldVal,
+		})
+	}
 
 	stmt.AddClause(additions)
+	p.emitNarrowed(stmt, existing)
+
+	switch {
+	case p.planFor(f).strategy == StrategyRowVersion:
+		// SQL Server has OUTPUT in place of RETURNING
+		p.outputVersion(stmt, f)
+	case supportsReturning && p.versionOnlyReturning:
+		stmt.AddClauseIfNotExists(versionReturning(stmt, identity, f))
+	case supportsReturning:
+		stmt.AddClauseIfNotExists(returningClause(stmt, p.planFor(f).strategy == StrategyXmin))
+	}
+}
 
-	if supportsReturning {
-		stmt.AddClauseIfNotExists(clause.Returning{})
+// returningClause reads back the updated row. With joined tables `RETURNING *` would also
+// return their columns, and system columns such as xmin are not part of it, so columns
+// are listed explicitly for those and whenever explicit is set.
+func returningClause(stmt *gorm.Statement, explicit bool) clause.Returning {
+	if _, joined := stmt.Clauses[clause.From{}.Name()]; !joined && !explicit {
+		return clause.Returning{}
+	}
+	columns := make([]clause.Column, 0, len(stmt.Schema.DBNames))
+	for _, name := range stmt.Schema.DBNames {
+		columns = append(columns, clause.Column{Table: clause.CurrentTable, Name: name})
+	}
+	return clause.Returning{Columns: columns}
+}
+
+// versionReturning reads back only the identity and version columns of the updated row.
+func versionReturning(stmt *gorm.Statement, identity []*schema.Field, f *schema.Field) clause.Returning {
+	table := ""
+	if _, joined := stmt.Clauses[clause.From{}.Name()]; joined {
+		table = clause.CurrentTable
+	}
+	columns := make([]clause.Column, 0, len(identity)+1)
+	for _, cf := range slices.Concat(identity, []*schema.Field{f}) {
+		columns = append(columns, clause.Column{Table: table, Name: cf.DBName})
 	}
+	return clause.Returning{Columns: columns}
 }
 
 // verifyUpdate ensures the DB actually bumped the version.
@@ -449,38 +1150,103 @@ func (p *Plugin) verifyUpdate(supportsReturning bool) func(db *gorm.DB) {
 		}
 		oldAny, _ := db.InstanceGet(contextKeyFromVersion)
 		toAny, _ := db.InstanceGet(contextKeyToVersion)
+		grouped := false
+		if len(p.planFor(f).groups) > 0 {
+			_, grouped = db.InstanceGet(contextKeyGroupVersions)
+		}
+		// the update was not guarded, or failed for other reasons
+		if (toAny == nil && !grouped) || db.Error != nil {
+			return
+		}
 
-		// no rows updated; if toAny was not set → conflict
+		// no rows updated → conflict, or no row at all when unguarded
 		if db.RowsAffected == 0 {
-			if toAny == nil {
+			if isForced(db.Statement) {
+				_ = db.AddError(gorm.ErrRecordNotFound)
 				return
 			}
 			_ = db.AddError(ErrOptimisticLock)
 			return
 		}
+		if grouped {
+			p.setGroupVersions(db)
+		}
+		if toAny == nil {
+			return
+		}
 
-		// RETURNING dialect: compare new vs expected
-		if supportsReturning {
+		// RETURNING dialect, or rowversion read back with OUTPUT: compare new vs expected
+		if supportsReturning || p.planFor(f).strategy == StrategyRowVersion {
+			p.markStored(db, true)
 			newAny, _ := f.ValueOf(db.Statement.Context, db.Statement.ReflectValue)
 
-			if !versionMatches(oldAny, toAny, newAny) {
+			if !isForced(db.Statement) && !p.versionMatches(db.Dialector.Name(), f, oldAny, toAny, newAny) {
 				_ = db.AddError(ErrOptimisticLock)
 			}
 			return
 		}
 
 		// fallback for no RETURNING: reload and overwrite
-		fresh := db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
-		current, err := p.reloadByPK(fresh, db.Statement)
+		current, err := p.reloadVerified(db, f, oldAny, toAny)
 		if err != nil {
 			_ = db.AddError(err)
 			return
 		}
 		reflect.Indirect(reflect.ValueOf(db.Statement.Model)).
 			Set(reflect.Indirect(reflect.ValueOf(current)))
+		p.markStored(db, true)
 	}
 }
 
+// reloadVerified reloads the updated row. With WithReloadRetries it keeps reloading while
+// the version read back is not the one just written, and reports ErrOptimisticLock once
+// the retries are used up.
+func (p *Plugin) reloadVerified(db *gorm.DB, f *schema.Field, oldAny, toAny any) (any, error) {
+	// compare with the stored version just written
+	stored := db.Session(&gorm.Session{NewDB: true, SkipHooks: true, Context: p.keepStored(db.Statement.Context)})
+	for attempt := 0; ; attempt++ {
+		// Must reset Error
+		stored.Error = nil
+		stored.RowsAffected = 0
+		current, err := p.reloadByPK(stored, db.Statement)
+		if err != nil || p.reloadRetries <= 0 || isForced(db.Statement) {
+			return current, err
+		}
+		newAny, _ := f.ValueOf(db.Statement.Context, reflect.ValueOf(current))
+		if p.versionMatches(db.Dialector.Name(), f, oldAny, toAny, newAny) {
+			return current, nil
+		}
+		// the column may store times at a coarser precision than NowFunc, so a time
+		// version only counts as stale while it still reads back as the old value
+		if nt, ok := newAny.(time.Time); ok {
+			if ot, ok := oldAny.(time.Time); ok && !sameInstant(db.Dialector.Name(), ot, nt) {
+				return current, nil
+			}
+		}
+		if attempt >= p.reloadRetries {
+			return nil, ErrOptimisticLock
+		}
+		select {
+		case <-db.Statement.Context.Done():
+			return nil, db.Statement.Context.Err()
+		case <-time.After(p.reloadDelay):
+		}
+	}
+}
+
+// reload reads the row stmt targets into a new value of its model type, using a fresh
+// session so the state of the statement being executed does not leak into the query.
+func (p *Plugin) reload(db *gorm.DB, stmt *gorm.Statement, associations ...string) (any, error) {
+	fresh := db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+	// Must reset Error
+	fresh.Error = nil
+	fresh.RowsAffected = 0
+	for _, name := range associations {
+		fresh = fresh.Preload(name)
+	}
+	return p.reloadByPK(fresh, stmt)
+}
+
 func (p *Plugin) reloadByPK(
 	db *gorm.DB,
 	stmt *gorm.Statement,
@@ -490,10 +1256,15 @@ func (p *Plugin) reloadByPK(
 	if rv.Kind() == reflect.Ptr {
 		rv = rv.Elem()
 	}
-	for _, pf := range stmt.Schema.PrimaryFields {
+	identity, byColumns := p.stmtIdentity(stmt)
+	for _, pf := range identity {
 		val, _ := pf.ValueOf(stmt.Context, stmt.ReflectValue)
 		_ = pf.Set(stmt.Context, reflect.Indirect(reflect.ValueOf(dest)), val)
 	}
+	// gorm only derives conditions from primary keys
+	if len(stmt.Schema.PrimaryFields) == 0 || byColumns {
+		db = db.Clauses(clause.Where{Exprs: identityConds(stmt, identity)})
+	}
 	return dest, db.First(dest).Error
 }
 
@@ -505,74 +1276,479 @@ func (p *Plugin) resolveConflict(db *gorm.DB) {
 	if !errors.Is(db.Error, ErrOptimisticLock) {
 		return
 	}
-	c, ok := db.Statement.Clauses[conflictClauseName]
-	if !ok {
+	db.InstanceSet(contextKeyConflicted, true)
+	expected, _ := db.InstanceGet(contextKeyFromVersion)
+	expected, _ = p.encodeVersion(db.Statement.Context, db.Statement.Table, expected)
+	report := newConflictReport(db.Statement, expected)
+	db.InstanceSet(contextKeyConflictReport, report)
+	defer p.enqueueReconciliation(db, report)
+	defer p.describeConflict(db, report)
+	defer p.fingerprintConflict(db)
+
+	conflict, ok := conflictClause(db.Statement)
+	// Conflict handlers get to resolve counter collisions themselves
+	if (!ok || conflict.OnVersionMismatch == nil) && p.mergeCounters(db, report) {
+		return
+	}
+	if !ok || (conflict.OnVersionMismatch == nil && !conflict.AttachCurrent) {
 		return
 	}
-	conflict := c.Expression.(Conflict)
 
 	// load fresh row
-	fresh := db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
-	// Must reset Error
-	fresh.Error = nil
-	fresh.RowsAffected = 0
-	current, err := p.reloadByPK(fresh, db.Statement)
+	current, err := p.reload(db, db.Statement)
 	if err != nil {
+		// the row is gone, so there is nothing to resolve against
+		attachCurrent(db, conflict, nil)
 		return
 	}
+	if f := p.findVersionField(db.Statement.Schema); f != nil {
+		report.CurrentVersion, _ = f.ValueOf(db.Statement.Context, reflect.ValueOf(current))
+	}
 
-	// compute diff
+	// compute diff between the attempted and the persisted row
+	rv := anyDeref(current)
+	ptr := anyRef(rv)
 	reporter := newDiffReporter()
-	cmp.Diff(db.Statement.ReflectValue.Interface(), current, cmp.Reporter(reporter))
+	cmp.Diff(db.Statement.ReflectValue.Interface(), rv, cmp.Reporter(reporter), cmp.Exporter(exportAll))
+	report.FieldChanges = reporter.Diff()
+	if conflict.OnVersionMismatch == nil {
+		attachCurrent(db, conflict, ptr)
+		return
+	}
 
 	// call user handler
-	rv := anyDeref(current)
-	ptr := anyRef(rv)
-	resolved := conflict.OnVersionMismatch(current, reporter.Diff())
+	resolved := conflict.OnVersionMismatch(current, report.FieldChanges)
 	current = ptr
 
 	switch {
 	case resolved == nil:
-		db.Logger.Warn(db.Statement.Context, "[%s] canceled update on conflict", p.Name())
+		db.Logger.Warn(db.Statement.Context, "[%s] canceled update of %s at version %s on conflict",
+			p.Name(), db.Statement.Table, p.versionString(report.ExpectedVersion))
 		db.RowsAffected = 0
-	case cmp.Equal(current, resolved, cmp.Reporter(reporter.Reset())):
-		db.Logger.Warn(db.Statement.Context, "[%s] accepted current value on conflict", p.Name())
+		report.Resolution = ResolutionCanceled
+		attachCurrent(db, conflict, current)
+	case cmp.Equal(current, resolved, cmp.Reporter(newDiffReporter()), cmp.Exporter(exportAll)):
+		db.Logger.Warn(db.Statement.Context, "[%s] accepted current value of %s at version %s on conflict",
+			p.Name(), db.Statement.Table, p.versionString(report.CurrentVersion))
 		db.RowsAffected = 0
+		report.Resolution = ResolutionAcceptedCurrent
 		reflect.Indirect(reflect.ValueOf(db.Statement.Model)).
 			Set(reflect.Indirect(reflect.ValueOf(current)))
+		p.markStored(db, false)
 	default:
 		// retry update with resolved object
-		retry := fresh.Session(&gorm.Session{NewDB: true}).
-			Model(resolved).
-			Updates(resolved)
+		retry := db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+		// Must reset Error
+		retry.Error = nil
+		retry = retry.Model(resolved).Updates(resolved)
+		if p.strictRetry && retry.Error == nil && retry.RowsAffected == 0 {
+			retry.Error = &RetryError{Table: db.Statement.Table, Attempts: 2}
+		}
 		db.Error = retry.Error
 		db.RowsAffected = retry.RowsAffected
+		report.Resolution = ResolutionMerged
+		if retry.Error != nil {
+			report.Resolution = ResolutionMergeFailed
+		}
 		reflect.Indirect(reflect.ValueOf(db.Statement.Model)).
 			Set(reflect.Indirect(reflect.ValueOf(resolved)))
+		p.markStored(db, false)
+	}
+}
+
+// Conflicted reports whether the statement executed by tx hit a version conflict. It is
+// also true when a Conflict handler resolved the conflict and tx.Error is nil, which lets
+// callers tell a clean write from a resolved one.
+func Conflicted(tx *gorm.DB) bool {
+	if tx == nil || tx.Statement == nil {
+		return false
 	}
+	conflicted, _ := tx.InstanceGet(contextKeyConflicted)
+	return conflicted == true
 }
 
 func (p *Plugin) findVersionField(sch *schema.Schema) *schema.Field {
+	if sch == nil || !p.tableEnabled(sch.Table) {
+		return nil
+	}
+	return versionField(sch, p.tagName)
+}
+
+// tableEnabled reports whether the plugin guards table according to WithTables and
+// WithExcludedTables.
+func (p *Plugin) tableEnabled(table string) bool {
+	if _, excluded := p.excludedTables[table]; excluded {
+		return false
+	}
+	if len(p.includedTables) == 0 {
+		return true
+	}
+	_, included := p.includedTables[table]
+	return included
+}
+
+// VersionField returns the version field of sch under the tag name of the plugin
+// installed on db, see WithTagName, or nil. It takes db, as HasVersion and GuardSQL do,
+// because the tag name is the plugin's; without a plugin on db it is `version`.
+func VersionField(db *gorm.DB, sch *schema.Schema) *schema.Field {
+	return versionField(sch, pluginFor(db).tagName)
+}
+
+// HasVersion reports whether model declares a version field under the tag name of the
+// plugin installed on db, see VersionField. db also parses model, with its naming strategy
+// and schema cache.
+func HasVersion(db *gorm.DB, model any) bool {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return false
+	}
+	return VersionField(db, stmt.Schema) != nil
+}
+
+func versionField(sch *schema.Schema, tagName string) *schema.Field {
 	if sch == nil {
 		return nil
 	}
 	for _, f := range sch.Fields {
-		if _, ok := f.TagSettings[p.tagName]; ok {
+		if isVersionField(f, tagName) {
 			return f
 		}
 	}
 	return nil
 }
 
+// isVersionField reports whether f is typed Version or tagged with tagName. gorm copies the
+// tags of an embedded struct field to every field of the struct, so a tag placed there
+// marks the struct's Version field, or its only field:
+//
+//	type Model struct {
+//		Audit Audit `gorm:"embedded;embeddedPrefix:audit_;version"`
+//	}
+func isVersionField(f *schema.Field, tagName string) bool {
+	if f.FieldType == tyVersion {
+		return true
+	}
+	if _, ok := f.TagSettings[tagName]; !ok {
+		return false
+	}
+	if f.OwnerSchema == nil || f.Name == "Version" {
+		return true
+	}
+	if _, own := schema.ParseTagSetting(f.StructField.Tag.Get("gorm"), ";")[tagName]; own {
+		return true
+	}
+	for _, sf := range f.Schema.Fields {
+		if sf != f && sf.OwnerSchema == f.OwnerSchema {
+			return false
+		}
+	}
+	return true
+}
+
+// Strategy is how a version field is seeded and bumped.
+type Strategy int
+
+const (
+	strategyUnknown Strategy = iota
+	// StrategyInt counts versions up from 1.
+	StrategyInt
+	// StrategyUUID assigns a random UUID per write.
+	StrategyUUID
+	// StrategyULID assigns a ULID per write, ordered by time.
+	StrategyULID
+	// StrategyTime stamps each write with the current time.
+	StrategyTime
+	// StrategyCustom leaves versions to the field's type, see Versioner.
+	StrategyCustom
+	// StrategyString assigns a new token per write, see WithStringGenerator.
+	StrategyString
+	// StrategyKSUID assigns a KSUID per write, ordered by the second.
+	StrategyKSUID
+	// StrategyXID assigns an xid per write, ordered by the second.
+	StrategyXID
+	// StrategySnowflake assigns a snowflake ID per write, see WithSnowflakeVersions.
+	StrategySnowflake
+	// StrategyUnixNano assigns the write's time in nanoseconds since the Unix epoch.
+	StrategyUnixNano
+	// StrategyHLC assigns a hybrid logical clock per write, see HLC.
+	StrategyHLC
+	// StrategySequence draws every version from a database sequence.
+	StrategySequence
+	// StrategyXmin guards rows by postgres's xmin system column.
+	StrategyXmin
+	// StrategyRowVersion guards rows by a SQL Server rowversion column.
+	StrategyRowVersion
+)
+
+// versionStrategy picks the strategy for f from its type, using the tag value to tell
+// ULIDs from UUIDs, or in strict mode from the tag value alone.
+func (p *Plugin) versionStrategy(f *schema.Field) (Strategy, error) {
+	plan := p.planFor(f)
+	return plan.strategy, plan.err
+}
+
+// versionPlan is what every update derives from a version field, resolved once per field
+// so the common integer version costs no tag parsing or expression building per update.
+type versionPlan struct {
+	table    string
+	strategy Strategy
+	err      error
+	// bump is the SET value of integer versions
+	bump any
+	// counters are the fields tagged `merge:sum`
+	counters []*schema.Field
+	// groups are the version groups guarding columns apart from the row
+	groups []*versionGroup
+	// fingerprint is the field tagged `fingerprint`, filled from fingerprinted
+	fingerprint   *schema.Field
+	fingerprinted []*schema.Field
+}
+
+func (p *Plugin) planFor(f *schema.Field) *versionPlan {
+	if p.plans != nil {
+		if plan, ok := p.plans.Load(f); ok {
+			return plan.(*versionPlan)
+		}
+	}
+	plan := &versionPlan{table: f.Schema.Table, counters: counterFields(f.Schema)}
+	plan.err = versionCollision(f)
+	if plan.err == nil {
+		plan.err = p.multipleVersions(f)
+	}
+	if plan.err == nil {
+		plan.strategy, plan.err = p.inferStrategy(f)
+	}
+	if plan.err == nil {
+		plan.err = p.parseVersionTag(f).validate(f, plan.strategy)
+	}
+	if plan.err == nil {
+		plan.groups, plan.err = versionGroups(f.Schema)
+	}
+	if plan.fingerprint = fingerprintField(f.Schema); plan.fingerprint != nil {
+		plan.fingerprinted = p.fingerprintColumns(f.Schema, f, plan.fingerprint, plan.groups)
+	}
+	if plan.err == nil && generatorPackages[plan.strategy] != "" {
+		_, plan.err = generatorFor(plan.strategy)
+	}
+	if plan.err == nil && plan.strategy == StrategyXmin {
+		plan.err = p.checkXmin(f)
+	}
+	if plan.err == nil && plan.strategy == StrategyRowVersion {
+		plan.err = p.checkRowVersion(f)
+	}
+	if _, ok := sequenceQueries[p.dialect]; plan.err == nil && plan.strategy == StrategySequence && p.dialect != "" && !ok {
+		plan.err = fmt.Errorf("%w: %s.%s: %s has no sequences", ErrInvalidVersionTag, f.Schema.Name, f.Name, p.dialect)
+	}
+	if plan.strategy == StrategyInt {
+		plan.bump = clause.Expr{SQL: "? + 1", Vars: []any{clause.Column{Table: clause.CurrentTable, Name: f.DBName}}}
+	}
+	if p.plans != nil {
+		p.plans.Store(f, plan)
+	}
+	return plan
+}
+
+// versionCollision reports a version field gorm also stamps as a create or update time, or
+// whose column another field of the model maps to, as either would write the column
+// behind the plugin's back.
+func versionCollision(f *schema.Field) error {
+	if f.AutoCreateTime > 0 || f.AutoUpdateTime > 0 {
+		return fmt.Errorf("%w: %s.%s is also an autoCreateTime or autoUpdateTime field",
+			ErrVersionColumnCollision, f.Schema.Name, f.Name)
+	}
+	for _, sf := range f.Schema.Fields {
+		if sf == f || sf.DBName != f.DBName || !(sf.Creatable || sf.Updatable || sf.Readable) {
+			continue
+		}
+		return fmt.Errorf("%w: %s.%s and %s.%s both map to column %q",
+			ErrVersionColumnCollision, f.Schema.Name, f.Name, f.Schema.Name, sf.Name, f.DBName)
+	}
+	return nil
+}
+
+// multipleVersions reports a model declaring another version field besides f, the first,
+// as the plugin would guard and bump f alone.
+func (p *Plugin) multipleVersions(f *schema.Field) error {
+	for _, sf := range f.Schema.Fields {
+		if sf != f && isVersionField(sf, p.tagName) {
+			return fmt.Errorf("%w: %s.%s and %s.%s are both version fields",
+				ErrMultipleVersionFields, f.Schema.Name, f.Name, f.Schema.Name, sf.Name)
+		}
+	}
+	return nil
+}
+
+func (p *Plugin) inferStrategy(f *schema.Field) (Strategy, error) {
+	ft := f.StructField.Type
+	if !p.strictTags {
+		switch {
+		case implementsVersioner(ft):
+			return StrategyCustom, nil
+		case ft == tyHLC:
+			return StrategyHLC, nil
+		case ft == tyRowVersion, ft == tyBytes && p.paramIs(f, StrategyNameRowVersion):
+			return StrategyRowVersion, nil
+		case isNumericKind(ft.Kind()):
+			switch {
+			case p.paramIs(f, StrategyNameSequence):
+				return StrategySequence, nil
+			case ft.Kind() == reflect.Uint32 && p.paramIs(f, StrategyNameXmin):
+				return StrategyXmin, nil
+			case !is64BitKind(ft.Kind()):
+			case p.paramIs(f, StrategyNameUnixNano):
+				return StrategyUnixNano, nil
+			case p.paramIs(f, StrategyNameHLC):
+				return StrategyHLC, nil
+			case p.paramIs(f, StrategyNameSnowflake):
+				return StrategySnowflake, nil
+			}
+			return StrategyInt, nil
+		case ty16Byte.AssignableTo(ft):
+			if p.paramIs(f, StrategyNameULID) || strings.Contains(strings.ToLower(ft.Name()), "ulid") {
+				return StrategyULID, nil
+			}
+			return StrategyUUID, nil
+		case ty20Byte.AssignableTo(ft):
+			return StrategyKSUID, nil
+		case ty12Byte.AssignableTo(ft):
+			return StrategyXID, nil
+		case ft == tyTime:
+			return StrategyTime, nil
+		case ft.Kind() == reflect.String:
+			return StrategyString, nil
+		default:
+			return strategyUnknown, nil
+		}
+	}
+
+	var strategy Strategy
+	var fits bool
+	switch p.parseVersionTag(f).kind {
+	case StrategyNameInt:
+		strategy, fits = StrategyInt, isNumericKind(ft.Kind())
+	case StrategyNameUUID:
+		strategy, fits = StrategyUUID, ty16Byte.AssignableTo(ft)
+	case StrategyNameULID:
+		strategy, fits = StrategyULID, ty16Byte.AssignableTo(ft)
+	case StrategyNameTime:
+		strategy, fits = StrategyTime, ft == tyTime
+	case StrategyNameKSUID:
+		strategy, fits = StrategyKSUID, ty20Byte.AssignableTo(ft)
+	case StrategyNameXID:
+		strategy, fits = StrategyXID, ty12Byte.AssignableTo(ft)
+	case StrategyNameSnowflake:
+		strategy, fits = StrategySnowflake, is64BitKind(ft.Kind())
+	case StrategyNameUnixNano:
+		strategy, fits = StrategyUnixNano, is64BitKind(ft.Kind())
+	case StrategyNameHLC:
+		strategy, fits = StrategyHLC, is64BitKind(ft.Kind())
+	case StrategyNameSequence:
+		strategy, fits = StrategySequence, isNumericKind(ft.Kind())
+	case StrategyNameXmin:
+		strategy, fits = StrategyXmin, ft.Kind() == reflect.Uint32
+	case StrategyNameRowVersion:
+		strategy, fits = StrategyRowVersion, fitsRowVersion(ft)
+	case StrategyNameCustom:
+		strategy, fits = StrategyCustom, implementsVersioner(ft)
+	case StrategyNameString:
+		strategy, fits = StrategyString, ft.Kind() == reflect.String
+	case "":
+		// bare tag (or the typed Version field): only unambiguous types qualify
+		switch {
+		case implementsVersioner(ft):
+			strategy, fits = StrategyCustom, true
+		case ft == tyHLC:
+			strategy, fits = StrategyHLC, true
+		case ft == tyRowVersion:
+			strategy, fits = StrategyRowVersion, true
+		case isNumericKind(ft.Kind()):
+			strategy, fits = StrategyInt, true
+		case ft == tyTime:
+			strategy, fits = StrategyTime, true
+		case ft.Kind() == reflect.String:
+			strategy, fits = StrategyString, true
+		}
+	}
+	if !fits {
+		return strategyUnknown, fmt.Errorf("%w: %s.%s (%s) tagged %q",
+			ErrVersionTagMismatch, f.Schema.Name, f.Name, ft, f.TagSettings[p.tagName])
+	}
+	return strategy, nil
+}
+
+// identityTagName marks the natural-key columns of models without a primary key.
+const identityTagName = "IDENTITY"
+
+// identityFields returns the fields identifying a single row: the primary key, or for
+// tables without one the fields tagged `identity` or named with WithIdentityColumns.
+func (p *Plugin) identityFields(sch *schema.Schema) []*schema.Field {
+	if sch == nil {
+		return nil
+	}
+	if len(sch.PrimaryFields) > 0 {
+		return sch.PrimaryFields
+	}
+	var fields []*schema.Field
+	for _, col := range p.identityColumns[sch.Table] {
+		if f := sch.LookUpField(col); f != nil {
+			fields = append(fields, f)
+		}
+	}
+	for _, f := range sch.Fields {
+		if _, ok := f.TagSettings[identityTagName]; ok && !slices.Contains(fields, f) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// identityValue returns the value of the identifying field f of rv, dereferencing pointer
+// keys so equal keys compare and print alike.
+func identityValue(ctx context.Context, f *schema.Field, rv reflect.Value) (any, bool) {
+	val, zero := f.ValueOf(ctx, rv)
+	if v := reflect.ValueOf(val); v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return val, true
+		}
+		return v.Elem().Interface(), zero
+	}
+	return val, zero
+}
+
+// isZeroField reports whether f is zero in the struct rv as f.ValueOf does, without boxing
+// the value of fields declared directly on the model.
+func isZeroField(ctx context.Context, f *schema.Field, rv reflect.Value) bool {
+	if len(f.StructField.Index) == 1 && rv.Kind() == reflect.Struct {
+		return rv.Field(f.StructField.Index[0]).IsZero()
+	}
+	_, zero := f.ValueOf(ctx, rv)
+	return zero
+}
+
+// identityConds matches the row identified by fields, which gorm does not add to
+// statements of its own for tables without a primary key.
+func identityConds(stmt *gorm.Statement, fields []*schema.Field) []clause.Expression {
+	exprs := make([]clause.Expression, 0, len(fields))
+	for _, f := range fields {
+		val, _ := f.ValueOf(stmt.Context, stmt.ReflectValue)
+		exprs = append(exprs, clause.Eq{
+			Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName},
+			Value:  val,
+		})
+	}
+	return exprs
+}
+
 func (p *Plugin) paramIs(f *schema.Field, s ...string) bool {
 	switch len(s) {
 	case 0:
 		return false
 	case 1:
-		return strings.EqualFold(f.TagSettings[p.tagName], s[0])
+		return strings.EqualFold(p.parseVersionTag(f).kind, s[0])
 	default:
+		kind := p.parseVersionTag(f).kind
 		for _, set := range s {
-			if strings.EqualFold(f.TagSettings[p.tagName], set) {
+			if strings.EqualFold(kind, set) {
 				return true
 			}
 		}
@@ -624,26 +1800,141 @@ func anyRef(obj any, wrapPointers ...bool) any {
 	return ptrVal.Interface()
 }
 
-// versionMatches handles numeric, uuid/ulid, and time comparisons.
-func versionMatches(oldAny, toAny, newAny any) bool {
+// versionMatches handles numeric, uuid/ulid, and time comparisons of versions f of
+// dialect.
+func (p *Plugin) versionMatches(dialect string, f *schema.Field, oldAny, toAny, newAny any) bool {
 	switch to := toAny.(type) {
 	case clause.Expr:
-		// numeric branch is the only branch with an Expr
-		old := oldAny.(uint64)
-		return newAny.(uint64) == old+1
+		// numeric branch is the only branch with an Expr; newAny is the value the
+		// database actually returned for the bump expression
+		old, _ := asUint64(oldAny)
+		n, ok := asUint64(newAny)
+		if !ok {
+			return false
+		}
+		switch p.numericCheck {
+		case NumericIncreased:
+			return n > old
+		case NumericAny:
+			return true
+		default:
+			return n == old+1
+		}
 	case time.Time:
-		tNewAny := newAny.(time.Time)
-		tAny := to
-		return tNewAny.UnixNano() == tAny.UnixNano()
-	case uuid.UUID:
-		return reflect.DeepEqual(newAny.(uuid.UUID), to)
-	case ulid.ULID:
-		return reflect.DeepEqual(newAny.(ulid.ULID), to)
+		tNewAny, ok := newAny.(time.Time)
+		return ok && sameStoredTime(dialect, storedTimePrecision(dialect, f), to, tNewAny)
+	case Versioner:
+		return to.Equal(newAny)
+	case dbBump:
+		// any xmin or rowversion read back was written by the update, which only ran at
+		// the old one
+		return true
 	default:
+		// snowflake, unixnano, hlc and sequence versions may read back as another integer
+		// type
+		if x, ok := asUint64(toAny); ok {
+			y, ok := asUint64(newAny)
+			return ok && x == y
+		}
 		return reflect.DeepEqual(newAny, toAny)
 	}
 }
 
+// timeDigits are the fractional second digits time columns keep by dialect unless their
+// field or type names them. SQLite keeps times exactly, and gorm creates MySQL columns
+// with 3 digits.
+var timeDigits = map[string]int{
+	"postgres":  6,
+	"oracle":    6,
+	"mysql":     3,
+	"sqlserver": 7,
+	"sqlite":    9,
+}
+
+// typeDigits finds the fractional second digits a column type names, as in timestamp(6).
+var typeDigits = regexp.MustCompile(`\((\d)\)`)
+
+// storedTimePrecision returns the resolution the column of the time field f keeps on
+// dialect: the field's precision, the one its type names, or the dialect's, microseconds
+// for unknown dialects. Zero means times are kept exactly.
+func storedTimePrecision(dialect string, f *schema.Field) time.Duration {
+	digits, ok := timeDigits[dialect]
+	if !ok {
+		digits = 6
+	}
+	if f.Precision > 0 {
+		digits = f.Precision
+	} else if m := typeDigits.FindStringSubmatch(string(f.DataType)); m != nil {
+		digits, _ = strconv.Atoi(m[1])
+	} else if dialect == "mysql" && f.DataType != schema.Time {
+		// datetime and timestamp columns without digits keep whole seconds
+		digits = 0
+	}
+	if digits >= 9 {
+		return 0
+	}
+	d := time.Second
+	for ; digits > 0; digits-- {
+		d /= 10
+	}
+	return d
+}
+
+// sameStoredTime reports whether stored is written as the database kept it: exactly, or
+// truncated or rounded to precision, the column's.
+func sameStoredTime(dialect string, precision time.Duration, written, stored time.Time) bool {
+	if sameInstant(dialect, written, stored) {
+		return true
+	}
+	return precision > 0 &&
+		(sameInstant(dialect, written.Truncate(precision), stored) || sameInstant(dialect, written.Round(precision), stored))
+}
+
+// zonelessDialects are the dialects whose time columns may drop the zone of the times
+// written, keeping their wall clock, which drivers read back in the session's zone.
+var zonelessDialects = map[string]bool{
+	"postgres":  true,
+	"oracle":    true,
+	"sqlserver": true,
+}
+
+// sameInstant reports whether stored is the time written to a column of dialect,
+// compared as instants. On dialects with columns that drop the zone, stored also matches
+// when it is read back in another zone than written's with written's wall clock.
+func sameInstant(dialect string, written, stored time.Time) bool {
+	if written.Equal(stored) {
+		return true
+	}
+	if !zonelessDialects[dialect] {
+		return false
+	}
+	_, writtenOffset := written.Zone()
+	_, storedOffset := stored.Zone()
+	return writtenOffset != storedOffset && utcWall(written).Equal(utcWall(stored))
+}
+
+// utcWall returns the instant t's wall clock denotes in UTC.
+func utcWall(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// asUint64 converts any signed or unsigned integer value, including named types such
+// as Version, to uint64.
+func asUint64(v any) (uint64, bool) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint(), true
+	default:
+		return 0, false
+	}
+}
+
+// exportAll lets go-cmp look into unexported struct fields of user models.
+func exportAll(reflect.Type) bool { return true }
+
 func isNumericKind(k reflect.Kind) bool {
 	switch k {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
@@ -654,16 +1945,25 @@ func isNumericKind(k reflect.Kind) bool {
 	}
 }
 
-func eqColumnName(stmt *gorm.Statement, expr clause.Expression) (string, bool) {
+// is64BitKind reports whether fields of kind k can hold snowflake, unixnano and hlc
+// versions.
+func is64BitKind(k reflect.Kind) bool {
+	return k == reflect.Int64 || k == reflect.Uint64
+}
+
+// eqColumnName returns the column an equality condition compares. Names are used as
+// written: they are column names already, and running them through the naming strategy
+// again would mangle explicit `column:` names and case-sensitive identifiers.
+func eqColumnName(expr clause.Expression) (string, bool) {
 	eq, ok := expr.(clause.Eq)
 	if !ok {
 		return "", false
 	}
 	switch c := eq.Column.(type) {
 	case clause.Column:
-		return stmt.NamingStrategy.ColumnName("", c.Name), true
+		return c.Name, true
 	case string:
-		return stmt.NamingStrategy.ColumnName("", c), true
+		return c, true
 	default:
 		return "", false
 	}
@@ -672,6 +1972,9 @@ func eqColumnName(stmt *gorm.Statement, expr clause.Expression) (string, bool) {
 // Conflict lets users hook into version mismatches to merge or cancel.
 type Conflict struct {
 	OnVersionMismatch func(current any, diff map[string]Change) any
+	// AttachCurrent reloads the blocking row into the returned *ConflictError when a
+	// guarded delete fails or an update conflict is left unresolved or canceled.
+	AttachCurrent bool
 }
 
 func (x Conflict) Name() string         { return conflictClauseName }
@@ -679,6 +1982,8 @@ func (x Conflict) Build(clause.Builder) {}
 
 func (x Conflict) MergeClause(c *clause.Clause) {
 	if existing, ok := c.Expression.(Conflict); ok {
+		x.AttachCurrent = x.AttachCurrent || existing.AttachCurrent
+		existing.AttachCurrent = x.AttachCurrent
 		if existing.OnVersionMismatch != nil && x.OnVersionMismatch != nil {
 			chained := func(current any, diff map[string]Change) any {
 				interim := existing.OnVersionMismatch(current, diff)
@@ -688,7 +1993,7 @@ func (x Conflict) MergeClause(c *clause.Clause) {
 				}
 				return x.OnVersionMismatch(interim, diff)
 			}
-			c.Expression = Conflict{OnVersionMismatch: chained}
+			c.Expression = Conflict{OnVersionMismatch: chained, AttachCurrent: x.AttachCurrent}
 			return
 		}
 		if existing.OnVersionMismatch != nil {
@@ -702,12 +2007,27 @@ func (x Conflict) MergeClause(c *clause.Clause) {
 // NewOptimisticLock returns the plugin for db.Use(...)
 func NewOptimisticLock(options ...ConfigOption) gorm.Plugin {
 	cfg := &Config{
-		tagName: "version",
+		tagName: defaultTagName,
 	}
 	for _, opt := range options {
 		opt(cfg)
 	}
+	cfg.tagName = strings.ToUpper(cfg.tagName)
 	return &Plugin{
 		Config: cfg,
+		plans:  &sync.Map{},
+		bases:  newBaseStore(),
+		hlc:    &hlcClock{},
+	}
+}
+
+// pluginFor returns the Plugin registered on db, or a default-configured one when
+// the plugin has not been installed.
+func pluginFor(db *gorm.DB) *Plugin {
+	if db != nil && db.Config != nil {
+		if p, ok := db.Config.Plugins[Plugin{}.Name()].(*Plugin); ok {
+			return p
+		}
 	}
+	return NewOptimisticLock().(*Plugin)
 }
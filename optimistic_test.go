@@ -2,34 +2,48 @@ package optimistic_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/oklog/ulid/v2"
 	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/cmmoran/optimistic"
+	_ "github.com/cmmoran/optimistic/ksuidversion"
+	"github.com/cmmoran/optimistic/optimistictest"
+	_ "github.com/cmmoran/optimistic/ulidversion"
+	_ "github.com/cmmoran/optimistic/uuidversion"
+	_ "github.com/cmmoran/optimistic/xidversion"
 )
 
 var (
 	dbOnces = map[string]*sync.Once{
-		testOracle:   {},
-		testPostgres: {},
-		testMysql:    {},
-		testSqlite:   {},
+		testOracle:    {},
+		testPostgres:  {},
+		testMysql:     {},
+		testSqlite:    {},
+		testSqlserver: {},
 	}
 	testDbContexts = map[string]context.Context{
-		testOracle:   context.Background(),
-		testPostgres: context.Background(),
-		testMysql:    context.Background(),
-		testSqlite:   context.Background(),
+		testOracle:    context.Background(),
+		testPostgres:  context.Background(),
+		testMysql:     context.Background(),
+		testSqlite:    context.Background(),
+		testSqlserver: context.Background(),
 	}
 	dbs []string
 )
@@ -85,7 +99,7 @@ func TestMain(m *testing.M) {
 		ok       bool
 	)
 	if testsStr, ok = os.LookupEnv("databases"); !ok || strings.EqualFold(testsStr, "all") {
-		dbs = []string{testSqlite, testOracle, testPostgres, testMysql}
+		dbs = []string{testSqlite, testOracle, testPostgres, testMysql, testSqlserver}
 	} else {
 		dbs = strings.Split(testsStr, ",")
 	}
@@ -372,6 +386,26 @@ func runSuite(testDatabaseName string) func(f *testing.T) {
 					require.NotEqual(t, cver, m.Version, "expected version to be different from previous version")
 					require.EqualValuesf(t, "boo", m.Description, "expected desciption on model to be unchanged")
 				})
+
+				t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "UpdateTimeVersionAcrossZones"), func(t *testing.T) {
+					zoned, _ := setupDatabase(tt, true)
+					require.NoError(t, zoned.Use(optimistic.NewOptimisticLock(optimistic.WithClock(zonedClock))))
+
+					m := &TestMysqlModelTimeVersion{Description: "foo", StartTime: zonedClock()}
+					require.NoError(t, zoned.Create(m).Error)
+
+					// the version comes back from the driver in another zone than it was written in
+					read := &TestMysqlModelTimeVersion{ID: m.ID}
+					require.NoError(t, zoned.First(read).Error)
+					read.Description = "bar"
+					result := zoned.Updates(read)
+					require.NoError(t, result.Error)
+					require.EqualValues(t, 1, result.RowsAffected)
+					require.True(t, read.Version.After(m.Version), "expected version to move forward")
+
+					m.Description = "baz"
+					require.ErrorIs(t, zoned.Updates(m).Error, optimistic.ErrOptimisticLock)
+				})
 			} else if testDatabaseName == testOracle {
 				t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "CreateWithTimeVersion"), func(t *testing.T) {
 					m := &TestOracleModelTimeVersion{Description: "foo"}
@@ -459,6 +493,26 @@ func runSuite(testDatabaseName string) func(f *testing.T) {
 					require.NotEqual(t, cver, m.Version, "expected version to be different from previous version")
 					require.EqualValuesf(t, "boo", m.Description, "expected desciption on model to be unchanged")
 				})
+
+				t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "UpdateTimeVersionAcrossZones"), func(t *testing.T) {
+					zoned, _ := setupDatabase(tt, true)
+					require.NoError(t, zoned.Use(optimistic.NewOptimisticLock(optimistic.WithClock(zonedClock))))
+
+					m := &TestOracleModelTimeVersion{Description: "foo"}
+					require.NoError(t, zoned.Create(m).Error)
+
+					// the version comes back from the driver in another zone than it was written in
+					read := &TestOracleModelTimeVersion{ID: m.ID}
+					require.NoError(t, zoned.First(read).Error)
+					read.Description = "bar"
+					result := zoned.Updates(read)
+					require.NoError(t, result.Error)
+					require.EqualValues(t, 1, result.RowsAffected)
+					require.True(t, read.Version.After(m.Version), "expected version to move forward")
+
+					m.Description = "baz"
+					require.ErrorIs(t, zoned.Updates(m).Error, optimistic.ErrOptimisticLock)
+				})
 			} else if testDatabaseName == testPostgres {
 				t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "CreateWithTimeVersion"), func(t *testing.T) {
 					m := &TestPostgresModelTimeVersion{Description: "foo"}
@@ -545,6 +599,26 @@ func runSuite(testDatabaseName string) func(f *testing.T) {
 					require.NotEqual(t, cver, m.Version, "expected version to be different from previous version")
 					require.EqualValuesf(t, "boo", m.Description, "expected desciption on model to be unchanged")
 				})
+
+				t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "UpdateTimeVersionAcrossZones"), func(t *testing.T) {
+					zoned, _ := setupDatabase(tt, true)
+					require.NoError(t, zoned.Use(optimistic.NewOptimisticLock(optimistic.WithClock(zonedClock))))
+
+					m := &TestPostgresModelTimeVersion{Description: "foo"}
+					require.NoError(t, zoned.Create(m).Error)
+
+					// the version comes back from the driver in another zone than it was written in
+					read := &TestPostgresModelTimeVersion{ID: m.ID}
+					require.NoError(t, zoned.First(read).Error)
+					read.Description = "bar"
+					result := zoned.Updates(read)
+					require.NoError(t, result.Error)
+					require.EqualValues(t, 1, result.RowsAffected)
+					require.True(t, read.Version.After(m.Version), "expected version to move forward")
+
+					m.Description = "baz"
+					require.ErrorIs(t, zoned.Updates(m).Error, optimistic.ErrOptimisticLock)
+				})
 			} else {
 				t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "CreateWithTimeVersion"), func(t *testing.T) {
 					m := &TestModelTimeVersion{Description: "foo"}
@@ -626,6 +700,37 @@ func runSuite(testDatabaseName string) func(f *testing.T) {
 					require.NotEqual(t, cver, m.Version, "expected version to be different from previous version")
 					require.EqualValuesf(t, "boo", m.Description, "expected desciption on model to be unchanged")
 				})
+
+				t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "UpdateTimeVersionAcrossZones"), func(t *testing.T) {
+					zoned, _ := setupDatabase(tt, true)
+					require.NoError(t, zoned.Use(optimistic.NewOptimisticLock(optimistic.WithClock(zonedClock))))
+
+					m := &TestModelTimeVersion{Description: "foo"}
+					require.NoError(t, zoned.Create(m).Error)
+
+					// the version comes back from the driver in another zone than it was written in
+					read := &TestModelTimeVersion{ID: m.ID}
+					require.NoError(t, zoned.First(read).Error)
+					read.Description = "bar"
+					result := zoned.Updates(read)
+					require.NoError(t, result.Error)
+					require.EqualValues(t, 1, result.RowsAffected)
+					require.True(t, read.Version.After(m.Version), "expected version to move forward")
+
+					m.Description = "baz"
+					require.ErrorIs(t, zoned.Updates(m).Error, optimistic.ErrOptimisticLock)
+
+					// the same wall clock in another zone is another instant on columns that keep it
+					shifted := *read
+					v := read.Version
+					shifted.Version = time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), v.Second(), v.Nanosecond(), time.FixedZone("UTC+02:00", 2*3600))
+					conflict, _, err := optimistic.WouldConflict(zoned, &shifted)
+					require.NoError(t, err)
+					require.True(t, conflict, "12:00+02:00 is not 12:00+05:30")
+					conflict, _, err = optimistic.WouldConflict(zoned, read)
+					require.NoError(t, err)
+					require.False(t, conflict)
+				})
 			}
 
 			// Update increments version
@@ -801,25 +906,3534 @@ func runSuite(testDatabaseName string) func(f *testing.T) {
 				require.EqualValues(t, "baz", m2.Description, "expecting description updated to merged persisted value")
 			})
 
-		})
-	}
-}
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "FindFreshLoadsOnlyStaleRows"), func(t *testing.T) {
+				m1 := &TestModel{Description: "fresh"}
+				m2 := &TestModel{Description: "stale"}
+				require.NoError(t, db.Create(m1).Error)
+				require.NoError(t, db.Create(m2).Error)
 
-func TestOptimisticLockingSuite(f *testing.T) {
-	l := slog.Default()
-	for _, db := range dbs {
-		switch db {
-		case testSqlite:
-		default:
-			setupDatabase(&errorF{l: l, db: db})
-		}
-		f.Run("OptimisticLockSuite", runSuite(db))
-		switch db {
-		case testSqlite:
-		default:
-			if _, container := findDbContextInfo(testDbContexts[db]); container != nil {
-				_ = container.Terminate(testDbContexts[db])
-			}
-		}
+				cached := map[any]any{m1.ID: m1.Version, m2.ID: m2.Version}
+				m2.Description = "changed"
+				require.NoError(t, db.Updates(m2).Error)
+
+				var stale []TestModel
+				require.NoError(t, optimistic.FindFresh(db, cached, &stale))
+				require.Len(t, stale, 1)
+				require.EqualValues(t, m2.ID, stale[0].ID)
+				require.EqualValues(t, 2, stale[0].Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "MapUpdatesPreserveExpressions"), func(t *testing.T) {
+				m := &TestModel{Description: "foo", Code: 10}
+				require.NoError(t, db.Create(m).Error)
+
+				require.NoError(t, db.Model(m).Updates(map[string]any{
+					"code":        gorm.Expr("code + ?", 5),
+					"description": "expr",
+				}).Error)
+				require.NoError(t, db.First(m).Error)
+				require.EqualValues(t, 15, m.Code)
+				require.EqualValues(t, "expr", m.Description)
+				require.EqualValues(t, 2, m.Version)
+
+				stale := &TestModel{ID: m.ID, Version: 1}
+				err := db.Model(stale).Updates(map[string]any{"code": gorm.Expr("code + ?", 5)}).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				require.NoError(t, db.First(m).Error)
+				require.EqualValues(t, 15, m.Code, "expression must not apply under a stale version")
+				require.EqualValues(t, 2, m.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "HasVersionAndVersionField"), func(t *testing.T) {
+				require.True(t, optimistic.HasVersion(db, &TestModel{}))
+				require.True(t, optimistic.HasVersion(db, &TestModelUUIDVersion{}))
+				require.False(t, optimistic.HasVersion(db, &TestModelNoVersion{}))
+
+				stmt := &gorm.Statement{DB: db}
+				require.NoError(t, stmt.Parse(&TestModelTimeVersion{}))
+				f := optimistic.VersionField(db, stmt.Schema)
+				require.NotNil(t, f)
+				require.Equal(t, "version", f.DBName)
+
+				// fields are found under the tag name the plugin was configured with
+				tagged, _ := setupDatabase(tt, true)
+				require.NoError(t, tagged.Use(optimistic.NewOptimisticLock(optimistic.WithTagName("revision"))))
+				require.True(t, optimistic.HasVersion(tagged, &TestModelCustomTag{}))
+				require.False(t, optimistic.HasVersion(db, &TestModelCustomTag{}))
+				require.False(t, optimistic.HasVersion(tagged, &TestModelUUIDVersion{}))
+				stmt = &gorm.Statement{DB: tagged}
+				require.NoError(t, stmt.Parse(&TestModelCustomTag{}))
+				f = optimistic.VersionField(tagged, stmt.Schema)
+				require.NotNil(t, f)
+				require.Equal(t, "rev", f.DBName)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "AtLeastGuardsReads"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+
+				fresh := &TestModel{ID: m.ID}
+				require.NoError(t, db.Clauses(optimistic.AtLeast(uint64(2))).First(fresh).Error)
+				require.EqualValues(t, 2, fresh.Version)
+
+				lagging := &TestModel{ID: m.ID}
+				err := db.Clauses(optimistic.AtLeast(uint64(3))).First(lagging).Error
+				require.ErrorIs(t, err, optimistic.ErrStaleRead)
+				var stale *optimistic.StaleReadError
+				require.ErrorAs(t, err, &stale)
+				require.EqualValues(t, 3, stale.MinVersion)
+
+				missing := &TestModel{}
+				err = db.Clauses(optimistic.AtLeast(uint64(1))).First(missing, "id = ?", m.ID+1000).Error
+				require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "TypedVersionWithoutPlugin"), func(t *testing.T) {
+				plain, _ := setupDatabase(tt, true)
+
+				m := &TestModelTypedVersion{Description: "foo"}
+				require.NoError(t, plain.Create(m).Error)
+				require.EqualValues(t, 1, m.Version)
+
+				m.Description = "bar"
+				require.NoError(t, plain.Updates(m).Error)
+				require.EqualValues(t, 2, m.Version)
+
+				stale := &TestModelTypedVersion{ID: m.ID, Description: "baz", Version: 1}
+				results := plain.Updates(stale)
+				require.ErrorIs(t, results.Error, optimistic.ErrOptimisticLock)
+				require.Zero(t, results.RowsAffected)
+				require.EqualValues(t, 1, stale.Version)
+
+				// guarded statements run without RETURNING, so their affected rows are checked
+				results = plain.Clauses(clause.Returning{}).Updates(stale)
+				require.ErrorIs(t, results.Error, optimistic.ErrOptimisticLock)
+				require.EqualValues(t, 1, stale.Version)
+				require.ErrorIs(t, plain.Clauses(clause.Returning{}).Delete(stale).Error, optimistic.ErrOptimisticLock)
+				m.Description = "qux"
+				require.NoError(t, plain.Clauses(clause.Returning{}).Updates(m).Error)
+				require.EqualValues(t, 3, m.Version)
+
+				require.ErrorIs(t, plain.Delete(stale).Error, optimistic.ErrOptimisticLock)
+				require.NoError(t, plain.Delete(m).Error)
+				require.ErrorIs(t, plain.First(&TestModelTypedVersion{ID: m.ID}).Error, gorm.ErrRecordNotFound)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "TypedVersionWithPlugin"), func(t *testing.T) {
+				m := &TestModelTypedVersion{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				require.EqualValues(t, 1, m.Version)
+
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+				require.EqualValues(t, 2, m.Version)
+
+				stale := &TestModelTypedVersion{ID: m.ID, Description: "baz", Version: 1}
+				results := db.Updates(stale)
+				require.ErrorIs(t, results.Error, optimistic.ErrOptimisticLock)
+				require.Zero(t, results.RowsAffected)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "NumericCheckToleratesExternalBumps"), func(t *testing.T) {
+				// simulate another plugin bumping the version column a second time
+				bumpTwice := func(tx *gorm.DB) {
+					if c, ok := tx.Statement.Clauses["SET"]; ok {
+						set := c.Expression.(clause.Set)
+						for i := range set {
+							if set[i].Column.Name == "version" {
+								set[i].Value = gorm.Expr("version + 2")
+							}
+						}
+						c.Expression = set
+						tx.Statement.Clauses["SET"] = c
+					}
+				}
+				exact, _ := setupDatabase(tt, true)
+				require.NoError(t, exact.Use(optimistic.NewOptimisticLock()))
+				require.NoError(t, exact.Callback().Update().Before("gorm:update").Register("test:bump_twice", bumpTwice))
+				tolerant, _ := setupDatabase(tt, true)
+				require.NoError(t, tolerant.Use(optimistic.NewOptimisticLock(optimistic.WithNumericCheck(optimistic.NumericIncreased))))
+				require.NoError(t, tolerant.Callback().Update().Before("gorm:update").Register("test:bump_twice", bumpTwice))
+
+				if testDatabaseName != testMysql {
+					m := &TestModel{Description: "foo"}
+					require.NoError(t, exact.Create(m).Error)
+					m.Description = "bar"
+					require.ErrorIs(t, exact.Updates(m).Error, optimistic.ErrOptimisticLock)
+				}
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, tolerant.Create(m).Error)
+				m.Description = "bar"
+				require.NoError(t, tolerant.Updates(m).Error)
+				require.EqualValues(t, 3, m.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "UpdateIfReportsFailedPrecondition"), func(t *testing.T) {
+				m := &TestModel{Description: "open", Code: 7}
+				require.NoError(t, db.Create(m).Error)
+
+				m.Description = "closed"
+				require.NoError(t, optimistic.UpdateIf(db, m, map[string]any{"code": 7}))
+				require.EqualValues(t, 2, m.Version)
+
+				m.Description = "reopened"
+				err := optimistic.UpdateIf(db, m, map[string]any{"code": 8})
+				require.ErrorIs(t, err, optimistic.ErrPreconditionFailed)
+				var pe *optimistic.PreconditionError
+				require.ErrorAs(t, err, &pe)
+				require.Equal(t, "code", pe.Column)
+				require.EqualValues(t, "closed", pe.Current.(*TestModel).Description)
+
+				// a precondition printing like the stored value is still a different value
+				m.Description = "relabelled"
+				err = optimistic.UpdateIf(db, m, map[string]any{"description": labelled{value: "open", label: "closed"}})
+				require.ErrorIs(t, err, optimistic.ErrPreconditionFailed)
+				require.ErrorAs(t, err, &pe)
+				require.Equal(t, "description", pe.Column)
+
+				stale := &TestModel{ID: m.ID, Description: "stale", Code: 7, Version: 1}
+				err = optimistic.UpdateIf(db, stale, map[string]any{"code": 7})
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				require.ErrorAs(t, err, &pe)
+				require.Empty(t, pe.Column)
+				require.EqualValues(t, 2, pe.Current.(*TestModel).Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "TableAllowAndDenyLists"), func(t *testing.T) {
+				included, _ := setupDatabase(tt, true)
+				require.NoError(t, included.Use(optimistic.NewOptimisticLock(optimistic.WithTables("test_models"))))
+				excluded, _ := setupDatabase(tt, true)
+				require.NoError(t, excluded.Use(optimistic.NewOptimisticLock(optimistic.WithExcludedTables("test_models"))))
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, included.Create(m).Error)
+				require.EqualValues(t, 1, m.Version)
+				w := &TestModelWithTime{Description: "foo"}
+				require.NoError(t, included.Create(w).Error)
+				require.EqualValues(t, 0, w.Version, "tables outside the allow list are not versioned")
+
+				e := &TestModel{Description: "foo"}
+				require.NoError(t, excluded.Create(e).Error)
+				require.EqualValues(t, 0, e.Version, "excluded tables are not versioned")
+				e.Description = "bar"
+				require.NoError(t, excluded.Updates(e).Error)
+				require.EqualValues(t, 0, e.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ConflictedMarksResolvedConflicts"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+
+				m.Description = "clean"
+				tx := db.Updates(m)
+				require.NoError(t, tx.Error)
+				require.False(t, optimistic.Conflicted(tx))
+
+				m2 := &TestModel{ID: m.ID, Description: "bar", Version: 1}
+				tx = db.Clauses(optimistic.Conflict{
+					OnVersionMismatch: func(current any, diffs map[string]optimistic.Change) any {
+						cv := current.(*TestModel)
+						cv.Description = "merged"
+						return cv
+					},
+				}).Updates(m2)
+				require.NoError(t, tx.Error)
+				require.True(t, optimistic.Conflicted(tx))
+				require.EqualValues(t, "merged", m2.Description)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "GuardedDeleteAttachesCurrent"), func(t *testing.T) {
+				plain, _ := setupDatabase(tt, true)
+
+				m := &TestModelTypedVersion{Description: "foo"}
+				require.NoError(t, plain.Create(m).Error)
+				m.Description = "bar"
+				require.NoError(t, plain.Updates(m).Error)
+
+				stale := &TestModelTypedVersion{ID: m.ID, Version: 1}
+				err := plain.Clauses(optimistic.Conflict{AttachCurrent: true}).Delete(stale).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				var ce *optimistic.ConflictError
+				require.ErrorAs(t, err, &ce)
+				require.NotNil(t, ce.Current)
+				current := ce.Current.(*TestModelTypedVersion)
+				require.EqualValues(t, 2, current.Version)
+				require.EqualValues(t, "bar", current.Description)
+
+				err = plain.Delete(stale).Error
+				require.ErrorAs(t, err, &ce)
+				require.Nil(t, ce.Current, "current row is only loaded on request")
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "CompositePrimaryKeyGuard"), func(t *testing.T) {
+				tenant := uint64(time.Now().UnixNano())
+				a := &TestModelCompositeKey{TenantID: tenant, Code: 1, Description: "a"}
+				b := &TestModelCompositeKey{TenantID: tenant, Code: 2, Description: "b"}
+				require.NoError(t, db.Create(a).Error)
+				require.NoError(t, db.Create(b).Error)
+				require.EqualValues(t, 1, a.Version)
+
+				a.Description = "a2"
+				require.NoError(t, db.Updates(a).Error)
+				require.EqualValues(t, 2, a.Version)
+
+				stale := &TestModelCompositeKey{TenantID: tenant, Code: 1, Description: "stale", Version: 1}
+				results := db.Updates(stale)
+				require.ErrorIs(t, results.Error, optimistic.ErrOptimisticLock)
+				require.Zero(t, results.RowsAffected)
+
+				reloaded := &TestModelCompositeKey{TenantID: tenant, Code: 2}
+				require.NoError(t, db.First(reloaded).Error)
+				require.EqualValues(t, 1, reloaded.Version, "sibling row sharing part of the key is untouched")
+				require.EqualValues(t, "b", reloaded.Description)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ConflictReportDescribesResolution"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+
+				tx := db.Updates(&TestModel{ID: m.ID, Description: "baz", Version: 1})
+				require.ErrorIs(t, tx.Error, optimistic.ErrOptimisticLock)
+				report, ok := optimistic.GetConflictReport(tx)
+				require.True(t, ok)
+				require.Equal(t, optimistic.ResolutionUnresolved, report.Resolution)
+				require.EqualValues(t, "test_models", report.Table)
+				require.EqualValues(t, m.ID, report.PrimaryKey["id"])
+				require.EqualValues(t, 1, report.ExpectedVersion)
+
+				tx = db.Clauses(optimistic.Conflict{
+					OnVersionMismatch: func(current any, diffs map[string]optimistic.Change) any {
+						cv := current.(*TestModel)
+						cv.Description = "merged"
+						return cv
+					},
+				}).Updates(&TestModel{ID: m.ID, Description: "baz", Version: 1})
+				require.NoError(t, tx.Error)
+				report, ok = optimistic.GetConflictReport(tx)
+				require.True(t, ok)
+				require.Equal(t, optimistic.ResolutionMerged, report.Resolution)
+				require.EqualValues(t, 2, report.CurrentVersion)
+				require.Contains(t, report.FieldChanges, "{optimistic_test.TestModel}.Description")
+
+				tx = db.Clauses(optimistic.Conflict{
+					OnVersionMismatch: func(current any, diffs map[string]optimistic.Change) any { return nil },
+				}).Updates(&TestModel{ID: m.ID, Description: "baz", Version: 1})
+				report, _ = optimistic.GetConflictReport(tx)
+				require.Equal(t, optimistic.ResolutionCanceled, report.Resolution)
+
+				body, err := json.Marshal(report)
+				require.NoError(t, err)
+				require.Contains(t, string(body), `"resolution":"canceled"`)
+
+				require.NoError(t, db.First(m, m.ID).Error)
+				m.Description = "clean"
+				tx = db.Updates(m)
+				require.NoError(t, tx.Error)
+				_, ok = optimistic.GetConflictReport(tx)
+				require.False(t, ok)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ReloadRetriesToleratesStaleReads"), func(t *testing.T) {
+				// simulate a replica serving the pre-update row for the first few reads
+				staleReads := 0
+				serveStale := func(tx *gorm.DB) {
+					if m, ok := tx.Statement.Dest.(*TestModel); ok && staleReads > 0 {
+						staleReads--
+						m.Version--
+					}
+				}
+				lagging, _ := setupDatabase(tt, true)
+				require.NoError(t, lagging.Use(optimistic.NewOptimisticLock(
+					optimistic.WithDisableReturning(),
+					optimistic.WithReloadRetries(2, time.Millisecond),
+				)))
+				require.NoError(t, lagging.Callback().Query().After("gorm:query").Register("test:serve_stale", serveStale))
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, lagging.Create(m).Error)
+
+				staleReads = 2
+				m.Description = "bar"
+				require.NoError(t, lagging.Updates(m).Error)
+				require.EqualValues(t, 2, m.Version)
+				require.Zero(t, staleReads)
+
+				staleReads = 3
+				m.Description = "baz"
+				require.ErrorIs(t, lagging.Updates(m).Error, optimistic.ErrOptimisticLock)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "NaturalKeyGuard"), func(t *testing.T) {
+				a := &TestModelNaturalKey{Scope: "app", Name: "a", Description: "foo"}
+				b := &TestModelNaturalKey{Scope: "app", Name: "b", Description: "foo"}
+				require.NoError(t, db.Create(a).Error)
+				require.NoError(t, db.Create(b).Error)
+				require.EqualValues(t, 1, a.Version)
+
+				stale := *a
+				a.Description = "bar"
+				require.NoError(t, db.Updates(a).Error)
+				require.EqualValues(t, 2, a.Version)
+
+				stale.Description = "baz"
+				require.ErrorIs(t, db.Updates(&stale).Error, optimistic.ErrOptimisticLock)
+
+				var other TestModelNaturalKey
+				require.NoError(t, db.Where("scope = ? AND name = ?", "app", "b").First(&other).Error)
+				require.EqualValues(t, "foo", other.Description, "only the identified row is updated")
+				require.EqualValues(t, 1, other.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "StrictTagsRejectContradictions"), func(t *testing.T) {
+				strict, _ := setupDatabase(tt, true)
+				require.NoError(t, strict.Use(optimistic.NewOptimisticLock(optimistic.WithStrictTags())))
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, strict.Create(m).Error)
+				require.EqualValues(t, 1, m.Version)
+				m.Description = "bar"
+				require.NoError(t, strict.Updates(m).Error)
+				require.EqualValues(t, 2, m.Version)
+
+				type tagMismatch struct {
+					ID      uint64 `gorm:"primaryKey"`
+					Version uint64 `gorm:"version:uuid"`
+				}
+				require.ErrorIs(t, strict.Create(&tagMismatch{ID: 1}).Error, optimistic.ErrVersionTagMismatch)
+				require.ErrorIs(t, strict.Updates(&tagMismatch{ID: 1, Version: 1}).Error, optimistic.ErrVersionTagMismatch)
+
+				if testDatabaseName != testOracle {
+					// a bare tag cannot tell a ULID from a UUID without sniffing the type name
+					require.ErrorIs(t, strict.Create(&TestModelULIDVersion{Description: "foo"}).Error, optimistic.ErrVersionTagMismatch)
+					u := &TestModelUUIDVersion{Description: "foo"}
+					require.NoError(t, strict.Create(u).Error)
+					require.NotEqual(t, uuid.Nil, u.Version)
+				}
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "VersionTagParameters"), func(t *testing.T) {
+				type truncatedTime struct {
+					ID          uint64    `gorm:"<-:create;primaryKey"`
+					Description string    `gorm:"type:text;"`
+					Version     time.Time `gorm:"not null;version:time,utc,trunc=ms"`
+				}
+				m := &truncatedTime{Description: "foo"}
+				require.NoError(t, db.Table("test_models_time_version").Create(m).Error)
+				require.Equal(t, time.UTC, m.Version.Location())
+				require.Zero(t, m.Version.Nanosecond()%int(time.Millisecond))
+				m.Description = "bar"
+				require.NoError(t, db.Table("test_models_time_version").Updates(m).Error)
+				require.Zero(t, m.Version.Nanosecond()%int(time.Millisecond))
+
+				type orderedUUID struct {
+					ID          uint64    `gorm:"<-:create;primaryKey"`
+					Description string    `gorm:"type:text;"`
+					Version     uuid.UUID `gorm:"not null;version:uuid,v7"`
+				}
+				u := &orderedUUID{Description: "foo"}
+				require.NoError(t, db.Table("test_models_uuid_version").Create(u).Error)
+				require.EqualValues(t, 7, u.Version.Version())
+
+				type badParameter struct {
+					ID      uint64    `gorm:"primaryKey"`
+					Version time.Time `gorm:"version:time,trunc=min"`
+				}
+				require.ErrorIs(t, db.Create(&badParameter{ID: 1}).Error, optimistic.ErrInvalidVersionTag)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "GuardSQLForRawStatements"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+
+				cond, args, err := optimistic.GuardSQL(db, m)
+				require.NoError(t, err)
+				require.Equal(t, "id = ? AND version = ?", cond)
+				require.EqualValues(t, []any{m.ID, m.Version}, args)
+
+				raw := "UPDATE test_models SET description = ?, version = version + 1 WHERE " + cond
+				res := db.Exec(raw, append([]any{"bar"}, args...)...)
+				require.NoError(t, res.Error)
+				require.EqualValues(t, 1, res.RowsAffected)
+
+				res = db.Exec(raw, append([]any{"baz"}, args...)...)
+				require.NoError(t, res.Error)
+				require.EqualValues(t, 0, res.RowsAffected, "stale version no longer matches")
+
+				_, _, err = optimistic.GuardSQL(db, &TestModel{Description: "unsaved"})
+				require.ErrorIs(t, err, gorm.ErrPrimaryKeyRequired)
+				_, _, err = optimistic.GuardSQL(db, &TestModelNoVersion{ID: 1})
+				require.ErrorIs(t, err, optimistic.ErrNoVersionField)
+				_, _, err = optimistic.GuardSQL(db, 1)
+				require.Error(t, err)
+
+				// the installed plugin's tag name and identity columns apply
+				type settingRow struct {
+					Scope string
+					Name  string
+					Rev   uint64 `gorm:"revision"`
+				}
+				configured, _ := setupDatabase(tt, true)
+				require.NoError(t, configured.Use(optimistic.NewOptimisticLock(
+					optimistic.WithTagName("revision"),
+					optimistic.WithIdentityColumns("setting_rows", "scope", "name"),
+				)))
+				cond, args, err = optimistic.GuardSQL(configured, &TestModelCustomTag{ID: 4, Rev: 2})
+				require.NoError(t, err)
+				require.Equal(t, "id = ? AND rev = ?", cond)
+				require.EqualValues(t, []any{uint64(4), uint64(2)}, args)
+				cond, args, err = optimistic.GuardSQL(configured, &settingRow{Scope: "app", Name: "theme", Rev: 3})
+				require.NoError(t, err)
+				require.Equal(t, "scope = ? AND name = ? AND rev = ?", cond)
+				require.EqualValues(t, []any{"app", "theme", uint64(3)}, args)
+				_, _, err = optimistic.GuardSQL(db, &TestModelCustomTag{ID: 4, Rev: 2})
+				require.ErrorIs(t, err, optimistic.ErrNoVersionField)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "CommittedVersionChangeHooks"), func(t *testing.T) {
+				var inStatement, committed []optimistic.VersionChange
+				hooked, _ := setupDatabase(tt, true)
+				require.NoError(t, hooked.Use(optimistic.NewOptimisticLock(
+					optimistic.WithVersionChangeHook(func(_ context.Context, c optimistic.VersionChange) {
+						inStatement = append(inStatement, c)
+					}),
+					optimistic.WithCommittedVersionChangeHook(func(_ context.Context, c optimistic.VersionChange) {
+						committed = append(committed, c)
+					}),
+				)))
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, hooked.Create(m).Error)
+				m.Description = "bar"
+				require.NoError(t, hooked.Updates(m).Error)
+				require.Len(t, inStatement, 1)
+				require.Len(t, committed, 1)
+				require.Equal(t, "test_models", committed[0].Table)
+				require.EqualValues(t, m.ID, committed[0].PrimaryKey["id"])
+				require.EqualValues(t, 1, committed[0].From)
+				require.EqualValues(t, 2, committed[0].To)
+
+				rollback := errors.New("rollback")
+				err := hooked.Transaction(func(tx *gorm.DB) error {
+					m.Description = "baz"
+					require.NoError(t, tx.Updates(m).Error)
+					return rollback
+				})
+				require.ErrorIs(t, err, rollback)
+				require.Len(t, inStatement, 2)
+				require.Len(t, committed, 1, "rolled back changes are not delivered")
+
+				require.NoError(t, hooked.First(m, m.ID).Error)
+				require.NoError(t, hooked.Transaction(func(tx *gorm.DB) error {
+					m.Description = "qux"
+					require.NoError(t, tx.Updates(m).Error)
+					require.Len(t, committed, 1, "delivery waits for the commit")
+					return nil
+				}))
+				require.Len(t, committed, 2)
+				require.EqualValues(t, 3, committed[1].To)
+
+				stale := &TestModel{ID: m.ID, Description: "stale", Version: 1}
+				require.ErrorIs(t, hooked.Updates(stale).Error, optimistic.ErrOptimisticLock)
+				require.Len(t, inStatement, 3)
+				require.Len(t, committed, 2)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "SnapshotRestoresFailedUpdate"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				require.NoError(t, db.Updates(&TestModel{ID: m.ID, Version: 1, Description: "elsewhere"}).Error)
+
+				snap := optimistic.Snapshot(m)
+				m.Description = "bar"
+				m.Code = 7
+				require.ErrorIs(t, db.Updates(m).Error, optimistic.ErrOptimisticLock)
+
+				optimistic.Restore(m, snap)
+				require.EqualValues(t, "foo", m.Description)
+				require.EqualValues(t, 0, m.Code)
+				require.EqualValues(t, 1, m.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "SchemalessUpdatePolicy"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+
+				require.NoError(t, db.Table("test_models").Where("id = ?", m.ID).
+					Updates(map[string]any{"description": "bar"}).Error)
+
+				strict, _ := setupDatabase(tt, true)
+				require.NoError(t, strict.Use(optimistic.NewOptimisticLock(optimistic.WithSchemalessPolicy(optimistic.SchemalessReject))))
+				m = &TestModel{Description: "foo"}
+				require.NoError(t, strict.Create(m).Error)
+				err := strict.Table("test_models").Where("id = ?", m.ID).
+					Updates(map[string]any{"description": "baz"}).Error
+				require.ErrorIs(t, err, optimistic.ErrNoSchema)
+
+				require.NoError(t, strict.First(m, m.ID).Error)
+				require.EqualValues(t, "foo", m.Description)
+				m.Description = "qux"
+				require.NoError(t, strict.Updates(m).Error, "model updates are unaffected")
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "FullSaveAssociationsGuardsChildren"), func(t *testing.T) {
+				if testDatabaseName != testSqlite && testDatabaseName != testPostgres {
+					t.Skip("child upserts are only guarded where ON CONFLICT supports WHERE")
+				}
+				full := db.Session(&gorm.Session{FullSaveAssociations: true})
+
+				parent := &TestModelParent{Description: "parent", Children: []TestModelChild{
+					{Description: "a"},
+					{Description: "b"},
+				}}
+				require.NoError(t, db.Create(parent).Error)
+				require.EqualValues(t, 1, parent.Children[0].Version)
+
+				var loaded TestModelParent
+				require.NoError(t, db.Preload("Children").First(&loaded, parent.ID).Error)
+				loaded.Children[1].Description = "b2"
+				require.NoError(t, full.Save(&loaded).Error)
+				require.EqualValues(t, 2, loaded.Children[0].Version, "saved children carry their bumped version")
+				require.EqualValues(t, 2, loaded.Children[1].Version)
+
+				// a child edited elsewhere must not be clobbered by a stale parent save
+				edited := loaded.Children[0]
+				edited.Description = "edited elsewhere"
+				require.NoError(t, db.Updates(&edited).Error)
+
+				loaded.Description = "parent2"
+				loaded.Children[0].Description = "stale"
+				require.ErrorIs(t, full.Save(&loaded).Error, optimistic.ErrOptimisticLock)
+
+				var child TestModelChild
+				require.NoError(t, db.First(&child, edited.ID).Error)
+				require.EqualValues(t, "edited elsewhere", child.Description)
+				require.EqualValues(t, 3, child.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "UndeleteGuardsRestore"), func(t *testing.T) {
+				m := &TestModelSoftDelete{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				require.NoError(t, db.Delete(m).Error)
+
+				require.NoError(t, optimistic.Undelete(db, m))
+				require.EqualValues(t, 2, m.Version)
+				require.False(t, m.DeletedAt.Valid)
+				require.NoError(t, db.First(&TestModelSoftDelete{}, m.ID).Error)
+
+				// deleted, then modified while deleted: the stale copy must not resurrect it
+				require.NoError(t, db.Delete(m).Error)
+				stale := *m
+				require.NoError(t, db.Unscoped().Updates(&TestModelSoftDelete{ID: m.ID, Description: "bar", Version: 3}).Error)
+				require.ErrorIs(t, optimistic.Undelete(db, &stale), optimistic.ErrOptimisticLock)
+				require.ErrorIs(t, db.First(&TestModelSoftDelete{}, m.ID).Error, gorm.ErrRecordNotFound)
+
+				require.ErrorIs(t, optimistic.Undelete(db, &TestModelNoVersion{ID: 1}), optimistic.ErrNoVersionField)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "RekeyUUIDToULID"), func(t *testing.T) {
+				require.ErrorIs(t, optimistic.Rekey(db, &TestModelXminVersion{}, optimistic.StrategyInt, optimistic.StrategyXmin), optimistic.ErrRekeyUnsupported)
+				require.ErrorIs(t, optimistic.Rekey(db, &TestModelUUIDVersion{}, optimistic.StrategyUUID, optimistic.StrategyULID), optimistic.ErrVersionTagMismatch)
+				if testDatabaseName != testSqlite {
+					t.Skip("uuid and ulid versions only share a text column on sqlite")
+				}
+
+				type uuidRow struct {
+					ID          uint64    `gorm:"<-:create;primaryKey"`
+					Description string    `gorm:"type:text;"`
+					Version     uuid.UUID `gorm:"not null;version:uuid"`
+				}
+				legacy := []uuidRow{{ID: 9001, Description: "a"}, {ID: 9002, Description: "b"}}
+				require.NoError(t, db.Table("test_models_ulid_version").Create(&legacy).Error)
+				fresh := &TestModelULIDVersion{ID: 9003, Description: "c"}
+				require.NoError(t, db.Create(fresh).Error)
+				// binary columns take a ULID carrying UUID version and variant bits by chance for a UUID
+				fresh.Version[8] &^= 0x80
+				require.NoError(t, db.Table("test_models_ulid_version").Where("id = ?", fresh.ID).UpdateColumn("version", fresh.Version).Error)
+
+				require.NoError(t, optimistic.Rekey(db, &TestModelULIDVersion{}, optimistic.StrategyUUID, optimistic.StrategyULID))
+
+				var rows []TestModelULIDVersion
+				require.NoError(t, db.Where("id IN ?", []uint64{9001, 9002, 9003}).Order("id").Find(&rows).Error)
+				require.Len(t, rows, 3)
+				for _, row := range rows {
+					require.NotZero(t, row.Version.Time())
+				}
+				require.Equal(t, fresh.Version, rows[2].Version, "rows already using the target strategy are left alone")
+
+				rows[0].Description = "a2"
+				require.NoError(t, db.Updates(&rows[0]).Error)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ExplicitColumnNamesAreNotRenamed"), func(t *testing.T) {
+				m := &TestModelColumnNames{Label: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				require.EqualValues(t, 1, m.Version)
+
+				m.Label = "bar"
+				require.NoError(t, db.Updates(m).Error)
+				require.EqualValues(t, 2, m.Version)
+
+				require.NoError(t, db.Model(m).Updates(map[string]any{"Label": "baz"}).Error)
+				require.EqualValues(t, 3, m.Version)
+
+				stale := &TestModelColumnNames{ID: m.ID, Label: "stale", Version: 2}
+				require.ErrorIs(t, db.Updates(stale).Error, optimistic.ErrOptimisticLock)
+
+				var loaded TestModelColumnNames
+				require.NoError(t, db.First(&loaded, m.ID).Error)
+				require.EqualValues(t, "baz", loaded.Label)
+				require.EqualValues(t, 3, loaded.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "QualifiedGuardForAliasedAndJoinedUpdates"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+
+				m.Description = "aliased"
+				require.NoError(t, db.Table("test_models AS t").Updates(m).Error)
+				require.EqualValues(t, 2, m.Version)
+
+				if testDatabaseName == testMysql || testDatabaseName == testOracle {
+					return
+				}
+				// the joined table has id and version columns of its own
+				other := &TestModelWithTime{Description: "joined"}
+				require.NoError(t, db.Create(other).Error)
+				tx := db.Model(m).
+					Clauses(clause.From{Tables: []clause.Table{{Name: "test_models_with_time"}}}).
+					Where("test_models_with_time.description = ?", "joined").
+					Updates(map[string]any{"description": "via join"})
+				require.NoError(t, tx.Error)
+				require.EqualValues(t, 1, tx.RowsAffected)
+				require.EqualValues(t, 3, m.Version)
+				require.EqualValues(t, "via join", m.Description)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "TruncatedTimeVersionsReadBack"), func(t *testing.T) {
+				if testDatabaseName != "sqlite" {
+					t.Skip("relies on sqlite triggers to truncate stored versions")
+				}
+				coarse, _ := setupDatabase(tt, true)
+				// sqlite's RETURNING does not see trigger changes, so read back with a reload
+				require.NoError(t, coarse.Use(optimistic.NewOptimisticLock(optimistic.WithDisableReturning())))
+				// store versions with whole seconds only, like a coarse column would
+				for _, event := range []string{"INSERT", "UPDATE OF version"} {
+					require.NoError(t, coarse.Exec(fmt.Sprintf(`CREATE TRIGGER "truncate_version_%d" AFTER %s ON "test_models_time_version"
+						BEGIN UPDATE "test_models_time_version" SET "version" = substr("version", 1, 19) || substr("version", -6) WHERE "id" = NEW."id"; END`,
+						len(event), event)).Error)
+				}
+
+				m := &TestModelTimeVersion{Description: "foo"}
+				require.NoError(t, coarse.Create(m).Error)
+				stored := &TestModelTimeVersion{}
+				require.NoError(t, coarse.First(stored, m.ID).Error)
+				require.True(t, stored.Version.Equal(m.Version), "expected %v, got %v", stored.Version, m.Version)
+
+				m.Description = "bar"
+				require.NoError(t, coarse.Updates(m).Error)
+				require.NoError(t, coarse.First(stored, m.ID).Error)
+				require.True(t, stored.Version.Equal(m.Version), "expected %v, got %v", stored.Version, m.Version)
+
+				m.Description = "baz"
+				require.NoError(t, coarse.Updates(m).Error)
+				require.EqualValues(t, "baz", m.Description)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "AbortedUpdateAttachesCurrent"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+
+				var ce *optimistic.ConflictError
+				stale := &TestModel{ID: m.ID, Description: "baz", Version: 1}
+				err := db.Clauses(optimistic.Conflict{AttachCurrent: true}).Updates(stale).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				require.ErrorAs(t, err, &ce)
+				current := ce.Current.(*TestModel)
+				require.EqualValues(t, 2, current.Version)
+				require.EqualValues(t, "bar", current.Description)
+
+				stale = &TestModel{ID: m.ID, Description: "baz", Version: 1}
+				err = db.Clauses(optimistic.Conflict{
+					OnVersionMismatch: func(current any, diff map[string]optimistic.Change) any { return nil },
+					AttachCurrent:     true,
+				}).Updates(stale).Error
+				require.ErrorAs(t, err, &ce)
+				require.EqualValues(t, "bar", ce.Current.(*TestModel).Description)
+
+				stale = &TestModel{ID: m.ID, Description: "baz", Version: 1}
+				err = db.Updates(stale).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				require.ErrorAs(t, err, &ce)
+				require.Nil(t, ce.Current, "current row is only loaded on request")
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "CoalescingSerializesRowUpdates"), func(t *testing.T) {
+				coalesced, _ := setupDatabase(tt, true)
+				require.NoError(t, coalesced.Use(optimistic.NewOptimisticLock(optimistic.WithCoalescing(time.Second))))
+				var inflight, overlapped atomic.Int32
+				require.NoError(t, coalesced.Callback().Update().Before("gorm:update").Register("test:enter", func(*gorm.DB) {
+					if inflight.Add(1) > 1 {
+						overlapped.Add(1)
+					}
+					time.Sleep(5 * time.Millisecond)
+				}))
+				require.NoError(t, coalesced.Callback().Update().After("gorm:update").Register("test:leave", func(*gorm.DB) {
+					inflight.Add(-1)
+				}))
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, coalesced.Create(m).Error)
+
+				var wg sync.WaitGroup
+				errs := make([]error, 4)
+				for i := range errs {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						stale := &TestModel{ID: m.ID, Description: fmt.Sprintf("bar%d", i), Version: 1}
+						errs[i] = coalesced.Updates(stale).Error
+					}(i)
+				}
+				wg.Wait()
+				require.Zero(t, overlapped.Load(), "updates of the same row overlapped")
+
+				conflicts := 0
+				for _, err := range errs {
+					if err != nil {
+						require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+						conflicts++
+					}
+				}
+				require.Equal(t, len(errs)-1, conflicts)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "RefreshRereadsRowInPlace"), func(t *testing.T) {
+				parent := &TestModelParent{Description: "parent", Children: []TestModelChild{{Description: "a"}}}
+				require.NoError(t, db.Create(parent).Error)
+
+				other := &TestModelParent{}
+				require.NoError(t, db.First(other, parent.ID).Error)
+				other.Description = "edited elsewhere"
+				require.NoError(t, db.Updates(other).Error)
+
+				parent.Description = "mine"
+				require.ErrorIs(t, db.Updates(parent).Error, optimistic.ErrOptimisticLock)
+				parent.Children[0].Description = "unsaved"
+				require.NoError(t, optimistic.Refresh(db, parent))
+				require.EqualValues(t, "edited elsewhere", parent.Description)
+				require.EqualValues(t, 2, parent.Version)
+				require.EqualValues(t, "unsaved", parent.Children[0].Description, "associations are kept unless asked")
+
+				require.NoError(t, optimistic.Refresh(db, parent, "Children"))
+				require.EqualValues(t, "a", parent.Children[0].Description)
+
+				parent.Description = "mine"
+				require.NoError(t, db.Updates(parent).Error)
+				require.EqualValues(t, 3, parent.Version)
+
+				require.ErrorIs(t, optimistic.Refresh(db, &TestModelParent{ID: parent.ID + 1000}), gorm.ErrRecordNotFound)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "CheckedDeleteDetectsChangedRows"), func(t *testing.T) {
+				checked, _ := setupDatabase(tt, true)
+				require.NoError(t, checked.Use(optimistic.NewOptimisticLock()))
+				// simulate a concurrent writer between the capture and the delete
+				var interfere func(tx *gorm.DB)
+				require.NoError(t, checked.Callback().Delete().After("optimistic:capture_delete").Before("gorm:delete").
+					Register("test:interfere", func(tx *gorm.DB) {
+						if interfere != nil {
+							interfere(tx)
+							interfere = nil
+						}
+					}))
+
+				rows := []*TestModel{{Code: 77}, {Code: 77}, {Code: 77}, {Code: 78}}
+				require.NoError(t, checked.Create(rows).Error)
+
+				result := checked.Clauses(optimistic.CheckedDelete{}).Where("code = ?", 77).Delete(&TestModel{})
+				require.NoError(t, result.Error)
+				require.EqualValues(t, 3, result.RowsAffected)
+
+				rows = []*TestModel{{Code: 88}, {Code: 88}}
+				require.NoError(t, checked.Create(rows).Error)
+				interfere = func(tx *gorm.DB) {
+					require.NoError(t, tx.Session(&gorm.Session{NewDB: true}).
+						Model(&TestModel{}).
+						Where("id = ?", rows[1].ID).
+						UpdateColumns(map[string]any{"description": "edited", "version": gorm.Expr("version + 1")}).Error)
+				}
+				err := checked.Clauses(optimistic.CheckedDelete{}).Delete(&TestModel{}, "code = ?", 88).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				var remaining int64
+				require.NoError(t, checked.Model(&TestModel{}).Where("code = ?", 88).Count(&remaining).Error)
+				require.EqualValues(t, 2, remaining, "the whole delete is rolled back")
+
+				result = checked.Clauses(optimistic.CheckedDelete{}).Delete(&TestModel{}, "code = ?", 99)
+				require.NoError(t, result.Error)
+				require.Zero(t, result.RowsAffected)
+				require.ErrorIs(t, checked.Clauses(optimistic.CheckedDelete{}).Delete(&TestModel{}).Error, gorm.ErrMissingWhereClause)
+				require.NoError(t, checked.Model(&TestModel{}).Count(&remaining).Error)
+				require.EqualValues(t, 3, remaining)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "SignificantColumnsBumpVersion"), func(t *testing.T) {
+				selective, _ := setupDatabase(tt, true)
+				require.NoError(t, selective.Use(optimistic.NewOptimisticLock(
+					optimistic.WithSignificantColumns("test_models", "description"),
+				)))
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, selective.Create(m).Error)
+
+				// bookkeeping writes neither bump nor check the version
+				require.NoError(t, selective.Model(m).Update("code", 7).Error)
+				require.EqualValues(t, 1, m.Version)
+				stale := &TestModel{ID: m.ID, Version: 0, Code: 8}
+				require.NoError(t, selective.Model(stale).Updates(map[string]any{"code": 8}).Error)
+
+				m.Description = "bar"
+				require.NoError(t, selective.Updates(m).Error)
+				require.EqualValues(t, 2, m.Version)
+
+				stale = &TestModel{ID: m.ID, Version: 1, Description: "baz"}
+				require.ErrorIs(t, selective.Updates(stale).Error, optimistic.ErrOptimisticLock)
+
+				stored := &TestModel{}
+				require.NoError(t, selective.First(stored, m.ID).Error)
+				require.EqualValues(t, 2, stored.Version)
+				require.EqualValues(t, 8, stored.Code)
+				require.EqualValues(t, "bar", stored.Description)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "PutCreatesOrUpdatesGuarded"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, optimistic.Put(db, m))
+				require.EqualValues(t, 1, m.Version)
+
+				m.Description = "bar"
+				require.NoError(t, optimistic.Put(db, m))
+				require.EqualValues(t, 2, m.Version)
+
+				dup := &TestModel{ID: m.ID, Description: "dup"}
+				err := optimistic.Put(db, dup)
+				require.ErrorIs(t, err, optimistic.ErrAlreadyExists)
+				require.NotErrorIs(t, err, optimistic.ErrOptimisticLock)
+
+				stale := &TestModel{ID: m.ID, Description: "baz", Version: 1}
+				err = optimistic.Put(db, stale)
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				require.NotErrorIs(t, err, optimistic.ErrAlreadyExists)
+
+				missing := &TestModel{ID: m.ID + 1000, Description: "gone", Version: 3}
+				require.ErrorIs(t, optimistic.Put(db, missing), gorm.ErrRecordNotFound)
+
+				stored := &TestModel{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.EqualValues(t, "bar", stored.Description)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "WouldConflictProbesWithoutWriting"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+
+				conflict, current, err := optimistic.WouldConflict(db, m)
+				require.NoError(t, err)
+				require.False(t, conflict)
+				require.EqualValues(t, "foo", current.(*TestModel).Description)
+
+				other := &TestModel{ID: m.ID, Description: "edited elsewhere", Version: m.Version}
+				require.NoError(t, db.Updates(other).Error)
+
+				m.Description = "unsaved edit"
+				conflict, current, err = optimistic.WouldConflict(db, m)
+				require.NoError(t, err)
+				require.True(t, conflict)
+				require.EqualValues(t, "edited elsewhere", current.(*TestModel).Description)
+				require.EqualValues(t, 1, m.Version, "the probe leaves the model alone")
+				require.EqualValues(t, "unsaved edit", m.Description)
+
+				require.NoError(t, db.Delete(other).Error)
+				conflict, current, err = optimistic.WouldConflict(db, m)
+				require.NoError(t, err)
+				require.True(t, conflict)
+				require.Nil(t, current)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ConflictingProbesManyModels"), func(t *testing.T) {
+				current := &TestModel{Description: "current"}
+				edited := &TestModel{Description: "edited"}
+				deleted := &TestModel{Description: "deleted"}
+				parent := &TestModelParent{Description: "parent"}
+				require.NoError(t, db.Create(current).Error)
+				require.NoError(t, db.Create(edited).Error)
+				require.NoError(t, db.Create(deleted).Error)
+				require.NoError(t, db.Create(parent).Error)
+
+				require.NoError(t, db.Updates(&TestModel{ID: edited.ID, Description: "elsewhere", Version: edited.Version}).Error)
+				require.NoError(t, db.Delete(&TestModel{ID: deleted.ID, Version: deleted.Version}).Error)
+				staleParent := &TestModelParent{ID: parent.ID, Version: parent.Version + 1}
+
+				stale, err := optimistic.Conflicting(db, []*TestModel{current, edited, deleted, {Description: "unsaved"}})
+				require.NoError(t, err)
+				require.Equal(t, []*TestModel{edited, deleted}, stale)
+
+				mixed, err := optimistic.Conflicting(db, []any{current, parent, edited, staleParent})
+				require.NoError(t, err)
+				require.Equal(t, []any{edited, staleParent}, mixed)
+
+				none, err := optimistic.Conflicting(db, []*TestModel{current})
+				require.NoError(t, err)
+				require.Empty(t, none)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "GetForUpdateSkipsRedundantGuard"), func(t *testing.T) {
+				locking, _ := setupDatabase(tt, true)
+				require.NoError(t, locking.Use(optimistic.NewOptimisticLock()))
+				var where string
+				require.NoError(t, locking.Callback().Update().After("gorm:update").Register("test:capture_where", func(tx *gorm.DB) {
+					sql := tx.Statement.SQL.String()
+					where = sql[strings.Index(sql, "WHERE"):]
+				}))
+				db := locking
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+
+				err := db.Transaction(func(tx *gorm.DB) error {
+					locked := &TestModel{ID: m.ID}
+					require.NoError(t, optimistic.GetForUpdate(tx, locked))
+					require.EqualValues(t, "foo", locked.Description)
+					require.EqualValues(t, 1, locked.Version)
+
+					for i, description := range []string{"bar", "baz"} {
+						locked.Description = description
+						res := tx.Updates(locked)
+						require.NoError(t, res.Error)
+						require.EqualValues(t, i+2, locked.Version, "the version is still bumped")
+						// SQLite has no row locks, so only there the guard stays
+						require.Equal(t, testDatabaseName == testSqlite, strings.Contains(where, "version"), where)
+					}
+
+					stale := &TestModel{ID: m.ID, Description: "stale", Version: 1}
+					require.ErrorIs(t, tx.Updates(stale).Error, optimistic.ErrOptimisticLock, "other versions stay guarded")
+					return nil
+				})
+				require.NoError(t, err)
+
+				stored := &TestModel{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.EqualValues(t, 3, stored.Version)
+				require.EqualValues(t, "baz", stored.Description)
+
+				require.ErrorIs(t, optimistic.GetForUpdate(db, &TestModel{ID: m.ID}), optimistic.ErrNoTransaction)
+				err = db.Transaction(func(tx *gorm.DB) error {
+					return optimistic.GetForUpdate(tx, &TestModel{ID: m.ID + 1000})
+				})
+				require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "UUIDAndPointerKeysAreTargeted"), func(t *testing.T) {
+				u := &TestModelUUIDKey{ID: uuid.New(), Description: "foo"}
+				require.NoError(t, db.Create(u).Error)
+				require.EqualValues(t, 1, u.Version)
+				u.Description = "bar"
+				require.NoError(t, db.Updates(u).Error)
+				require.EqualValues(t, 2, u.Version)
+				staleU := &TestModelUUIDKey{ID: u.ID, Description: "baz", Version: 1}
+				require.ErrorIs(t, db.Updates(staleU).Error, optimistic.ErrOptimisticLock)
+
+				p := &TestModelPtrKey{Description: "foo"}
+				require.NoError(t, db.Create(p).Error)
+				require.NotNil(t, p.ID)
+				require.EqualValues(t, 1, p.Version)
+				p.Description = "bar"
+				require.NoError(t, db.Updates(p).Error)
+				require.EqualValues(t, 2, p.Version)
+				id := *p.ID
+				staleP := &TestModelPtrKey{ID: &id, Description: "baz", Version: 1}
+				tx := db.Updates(staleP)
+				require.ErrorIs(t, tx.Error, optimistic.ErrOptimisticLock)
+				report, ok := optimistic.GetConflictReport(tx)
+				require.True(t, ok)
+				require.EqualValues(t, id, report.PrimaryKey["id"], "pointer keys are reported by value")
+
+				// rows are matched by key value, not by pointer
+				copied := &TestModelPtrKey{ID: &id, Version: p.Version}
+				stale, err := optimistic.Conflicting(db, []*TestModelPtrKey{copied, staleP})
+				require.NoError(t, err)
+				require.Equal(t, []*TestModelPtrKey{staleP}, stale)
+
+				// zero keys do not target a row
+				untargeted := db.Model(&TestModelUUIDKey{}).Where("description = ?", "nothing").Updates(map[string]any{"description": "x"})
+				require.NoError(t, untargeted.Error)
+				require.Zero(t, untargeted.RowsAffected)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "KeyPolicyControlsNarrowing"), func(t *testing.T) {
+				seed := func(db *gorm.DB) []*TestModel {
+					rows := []*TestModel{{Code: 41}, {Code: 41}, {Code: 41}}
+					for _, m := range rows {
+						require.NoError(t, db.Create(m).Error)
+					}
+					return rows
+				}
+
+				// narrowed by default
+				rows := seed(db)
+				res := db.Model(&TestModel{ID: rows[0].ID, Version: 1}).Where("code = ?", 41).Updates(map[string]any{"enabled": true})
+				require.NoError(t, res.Error)
+				require.EqualValues(t, 1, res.RowsAffected)
+				require.NoError(t, db.Delete(&TestModel{}, "code = ?", 41).Error)
+
+				rejecting, _ := setupDatabase(tt, true)
+				require.NoError(t, rejecting.Use(optimistic.NewOptimisticLock(
+					optimistic.WithKeyPolicy(optimistic.KeyReject),
+				)))
+				rows = seed(rejecting)
+				err := rejecting.Model(&TestModel{ID: rows[0].ID, Version: 1}).Where("code = ?", 41).Updates(map[string]any{"enabled": true}).Error
+				require.ErrorIs(t, err, optimistic.ErrNarrowedUpdate)
+				require.NotErrorIs(t, err, optimistic.ErrOptimisticLock)
+				// without conditions of its own the update still targets the model's row
+				require.NoError(t, rejecting.Model(&TestModel{ID: rows[0].ID, Version: 1}).Updates(map[string]any{"enabled": true}).Error)
+
+				omitting, _ := setupDatabase(tt, true)
+				require.NoError(t, omitting.Use(optimistic.NewOptimisticLock(
+					optimistic.WithKeyPolicy(optimistic.KeyOmit),
+				)))
+				rows = seed(omitting)
+				require.NoError(t, omitting.Model(rows[2]).Update("description", "moved").Error)
+
+				// guarded by version only: every matching row still at version 1
+				require.NoError(t, omitting.Model(&TestModel{ID: rows[0].ID, Version: 1}).Where("code = ?", 41).Updates(map[string]any{"code": 42}).Error)
+
+				var stored []TestModel
+				require.NoError(t, omitting.Order("id").Find(&stored, "code = ?", 42).Error)
+				require.Len(t, stored, 2)
+				for _, s := range stored {
+					require.EqualValues(t, 2, s.Version)
+				}
+			})
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "NarrowHookExplainsEffectiveWhere"), func(t *testing.T) {
+				var narrowed []optimistic.NarrowedUpdate
+				observed, _ := setupDatabase(tt, true)
+				require.NoError(t, observed.Use(optimistic.NewOptimisticLock(
+					optimistic.WithNarrowHook(func(_ context.Context, u optimistic.NarrowedUpdate) {
+						narrowed = append(narrowed, u)
+					}),
+				)))
+
+				m := &TestModel{Code: 43}
+				require.NoError(t, observed.Create(m).Error)
+				require.Empty(t, narrowed)
+
+				stale := &TestModel{ID: m.ID, Version: 5}
+				res := observed.Model(stale).Where("code = ?", 43).Updates(map[string]any{"enabled": true})
+				require.ErrorIs(t, res.Error, optimistic.ErrOptimisticLock)
+				require.Len(t, narrowed, 1)
+				require.Equal(t, "test_models", narrowed[0].Table)
+				require.Equal(t, "code = 43", narrowed[0].Original)
+				require.Contains(t, narrowed[0].Effective, "code = 43")
+				require.Contains(t, narrowed[0].Effective, fmt.Sprintf("= %d", m.ID))
+				require.Contains(t, narrowed[0].Effective, "= 5")
+
+				// without conditions of its own only the effective clause is reported
+				require.NoError(t, observed.Model(m).Update("enabled", true).Error)
+				require.Len(t, narrowed, 2)
+				require.Empty(t, narrowed[1].Original)
+				require.NotEmpty(t, narrowed[1].Effective)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ReconcileSettlesQueuedConflicts"), func(t *testing.T) {
+				queue := optimistic.NewMemoryQueue(8)
+				queued, _ := setupDatabase(tt, true)
+				require.NoError(t, queued.Use(optimistic.NewOptimisticLock(
+					optimistic.WithReconciliation(queue, 3),
+					optimistic.WithMergePolicy("test_models", func(_ context.Context, r optimistic.Reconciliation, current any) any {
+						merged := current.(*TestModel)
+						if code, ok := r.Changes["code"]; ok {
+							merged.Code = code.(uint64)
+						}
+						return merged
+					}),
+				)))
+				require.ErrorIs(t, optimistic.Reconcile(context.Background(), db, 1), optimistic.ErrNoReconcileQueue)
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, queued.Create(m).Error)
+				require.NoError(t, queued.Model(m).Update("description", "bar").Error)
+
+				stale := &TestModel{ID: m.ID, Version: 1, Code: 9}
+				res := queued.Updates(stale)
+				require.ErrorIs(t, res.Error, optimistic.ErrOptimisticLock)
+				report, ok := optimistic.GetConflictReport(res)
+				require.True(t, ok)
+				require.Equal(t, optimistic.ResolutionQueued, report.Resolution)
+
+				// conflicts a handler settled are not queued
+				res = queued.Clauses(optimistic.Conflict{OnVersionMismatch: func(any, map[string]optimistic.Change) any {
+					return nil
+				}}).Updates(&TestModel{ID: m.ID, Version: 1, Code: 10})
+				require.ErrorIs(t, res.Error, optimistic.ErrOptimisticLock)
+
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan error, 1)
+				go func() { done <- optimistic.Reconcile(ctx, queued, 2) }()
+
+				require.Eventually(t, func() bool {
+					stored := &TestModel{}
+					return queued.First(stored, m.ID).Error == nil && stored.Code == 9
+				}, 5*time.Second, 10*time.Millisecond)
+				cancel()
+				require.NoError(t, <-done)
+
+				stored := &TestModel{}
+				require.NoError(t, queued.First(stored, m.ID).Error)
+				require.EqualValues(t, "bar", stored.Description)
+				require.EqualValues(t, 3, stored.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "CreateSeedsAssociationChildren"), func(t *testing.T) {
+				parents := []*TestModelParent{
+					{Description: "a", Children: []TestModelChild{{Description: "a1"}, {Description: "a2"}}},
+					{Description: "b", Children: []TestModelChild{{Description: "b1"}}},
+				}
+				require.NoError(t, db.Create(parents).Error)
+
+				for _, parent := range parents {
+					require.EqualValues(t, 1, parent.Version)
+					for _, child := range parent.Children {
+						require.EqualValues(t, 1, child.Version)
+					}
+					var stored []TestModelChild
+					require.NoError(t, db.Find(&stored, "parent_id = ?", parent.ID).Error)
+					require.Len(t, stored, len(parent.Children))
+					for _, child := range stored {
+						require.EqualValues(t, 1, child.Version)
+					}
+				}
+
+				// seeded children are guarded like any other row
+				child := parents[0].Children[0]
+				child.Description = "a1b"
+				require.NoError(t, db.Updates(&child).Error)
+				require.EqualValues(t, 2, child.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "StrictRetryFailsLostMerges"), func(t *testing.T) {
+				strict, _ := setupDatabase(tt, true)
+				require.NoError(t, strict.Use(optimistic.NewOptimisticLock(optimistic.WithStrictRetry())))
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, strict.Create(m).Error)
+				require.NoError(t, strict.Model(m).Update("description", "bar").Error)
+
+				// the merged value assigns nothing, so its write updates no row
+				empty := optimistic.Conflict{OnVersionMismatch: func(current any, _ map[string]optimistic.Change) any {
+					return &TestModel{ID: m.ID, Version: current.(*TestModel).Version}
+				}}
+				res := strict.Clauses(empty).Updates(&TestModel{ID: m.ID, Version: 1, Code: 5})
+				var retryErr *optimistic.RetryError
+				require.ErrorAs(t, res.Error, &retryErr)
+				require.Equal(t, 2, retryErr.Attempts)
+				require.ErrorIs(t, res.Error, optimistic.ErrRetryNotApplied)
+				require.ErrorIs(t, res.Error, optimistic.ErrOptimisticLock)
+				report, ok := optimistic.GetConflictReport(res)
+				require.True(t, ok)
+				require.Equal(t, optimistic.ResolutionMergeFailed, report.Resolution)
+
+				// merges that apply are unaffected
+				merged := optimistic.Conflict{OnVersionMismatch: func(current any, _ map[string]optimistic.Change) any {
+					cur := current.(*TestModel)
+					cur.Code = 5
+					return cur
+				}}
+				res = strict.Clauses(merged).Updates(&TestModel{ID: m.ID, Version: 1, Code: 5})
+				require.NoError(t, res.Error)
+				stored := &TestModel{}
+				require.NoError(t, strict.First(stored, m.ID).Error)
+				require.EqualValues(t, 5, stored.Code)
+				require.EqualValues(t, 3, stored.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "FeaturesReportActiveModes"), func(t *testing.T) {
+				_, ok := optimistic.FeaturesOf(db)
+				require.True(t, ok)
+
+				described, _ := setupDatabase(tt, true)
+				plugin := optimistic.NewOptimisticLock(
+					optimistic.WithStrictRetry(),
+					optimistic.WithKeyPolicy(optimistic.KeyReject),
+					optimistic.WithCoalescing(time.Second),
+				).(*optimistic.Plugin)
+				require.NoError(t, described.Use(plugin))
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, described.Create(m).Error)
+
+				fs, ok := optimistic.FeaturesOf(described)
+				require.True(t, ok)
+				require.Equal(t, plugin.Features(), fs)
+				require.NotEmpty(t, fs.Version)
+				require.Equal(t, described.Dialector.Name(), fs.Dialect)
+				require.Contains(t, fs.Strategies, optimistic.StrategyULID)
+				require.Equal(t, optimistic.StrategyInt, fs.Tables["test_models"])
+				require.True(t, fs.StrictRetry)
+				require.True(t, fs.Coalescing)
+				require.False(t, fs.Reconciliation)
+				require.Equal(t, optimistic.KeyReject, fs.KeyPolicy)
+
+				_, ok = optimistic.FeaturesOf(&gorm.DB{Config: &gorm.Config{}})
+				require.False(t, ok)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "SkipHooksSessionsStayGuarded"), func(t *testing.T) {
+				skipping := db.Session(&gorm.Session{SkipHooks: true})
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, skipping.Create(m).Error)
+				require.EqualValues(t, 1, m.Version)
+
+				m.Description = "bar"
+				require.NoError(t, skipping.Updates(m).Error)
+				require.EqualValues(t, 2, m.Version)
+
+				stale := &TestModel{ID: m.ID, Version: 1, Description: "baz"}
+				require.ErrorIs(t, skipping.Updates(stale).Error, optimistic.ErrOptimisticLock)
+				require.ErrorIs(t, skipping.Model(&TestModel{ID: m.ID, Version: 1}).Update("code", 3).Error, optimistic.ErrOptimisticLock)
+
+				stored := &TestModel{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.EqualValues(t, "bar", stored.Description)
+				require.EqualValues(t, 2, stored.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "DeterministicVersionsRepeat"), func(t *testing.T) {
+				if testDatabaseName != testSqlite {
+					t.Skip("one database suffices to compare generated versions")
+				}
+				run := func() []any {
+					fresh, _ := setupDatabase(tt, true)
+					require.NoError(t, fresh.Use(optimistic.NewOptimisticLock(optimistictest.Deterministic()...)))
+
+					u := &TestModelUUIDVersion{ID: 1, Description: "foo"}
+					require.NoError(t, fresh.Create(u).Error)
+					first := u.Version
+					u.Description = "bar"
+					require.NoError(t, fresh.Updates(u).Error)
+
+					l := &TestModelULIDVersion{ID: 1, Description: "foo"}
+					require.NoError(t, fresh.Create(l).Error)
+
+					tm := &TestModelTimeVersion{ID: 1, Description: "foo"}
+					require.NoError(t, fresh.Create(tm).Error)
+					return []any{first, u.Version, l.Version, tm.Version.UTC()}
+				}
+
+				once, again := run(), run()
+				require.Equal(t, once, again)
+				require.NotEqual(t, once[0], once[1])
+				require.True(t, once[3].(time.Time).After(optimistictest.Epoch))
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "CachedFindRevalidatesByVersion"), func(t *testing.T) {
+				cache := &mapCache{entries: map[string]any{}}
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+
+				got := &TestModel{ID: m.ID}
+				require.NoError(t, optimistic.CachedFind(db, cache, got))
+				require.EqualValues(t, "foo", got.Description)
+				require.Equal(t, 1, cache.sets)
+
+				// still current: served from the cache
+				got = &TestModel{ID: m.ID}
+				require.NoError(t, optimistic.CachedFind(db, cache, got))
+				require.EqualValues(t, "foo", got.Description)
+				require.EqualValues(t, 1, got.Version)
+				require.Equal(t, 1, cache.sets)
+
+				// stale: reloaded and cached anew
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+				got = &TestModel{ID: m.ID}
+				require.NoError(t, optimistic.CachedFind(db, cache, got))
+				require.EqualValues(t, "bar", got.Description)
+				require.EqualValues(t, 2, got.Version)
+				require.Equal(t, 2, cache.sets)
+
+				require.NoError(t, db.Delete(m).Error)
+				require.ErrorIs(t, optimistic.CachedFind(db, cache, &TestModel{ID: m.ID}), gorm.ErrRecordNotFound)
+				require.ErrorIs(t, optimistic.CachedFind(db, cache, &TestModelNoVersion{ID: 1}), optimistic.ErrNoVersionField)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ByColumnsTargetsNaturalKey"), func(t *testing.T) {
+				m := &TestModel{Description: "foo", Code: 4242}
+				require.NoError(t, db.Create(m).Error)
+				other := &TestModel{Description: "other", Code: 4343}
+				require.NoError(t, db.Create(other).Error)
+
+				byCode := db.Clauses(optimistic.ByColumns("code"))
+				edit := &TestModel{Code: 4242, Version: 1, Description: "bar"}
+				require.NoError(t, byCode.Updates(edit).Error)
+				require.EqualValues(t, 2, edit.Version)
+
+				stale := &TestModel{Code: 4242, Version: 1, Description: "baz"}
+				res := db.Clauses(optimistic.ByColumns("code")).Updates(stale)
+				require.ErrorIs(t, res.Error, optimistic.ErrOptimisticLock)
+				report, ok := optimistic.GetConflictReport(res)
+				require.True(t, ok)
+				require.EqualValues(t, 4242, report.PrimaryKey["code"])
+
+				err := db.Clauses(optimistic.ByColumns("nope")).Updates(&TestModel{Code: 4242, Version: 2, Description: "x"}).Error
+				require.ErrorIs(t, err, optimistic.ErrUnknownIdentityColumn)
+
+				stored := &TestModel{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.EqualValues(t, "bar", stored.Description)
+				require.EqualValues(t, 2, stored.Version)
+				stored = &TestModel{}
+				require.NoError(t, db.First(stored, other.ID).Error)
+				require.EqualValues(t, "other", stored.Description)
+				require.EqualValues(t, 1, stored.Version)
+
+				// without RETURNING the written row is read back by the same columns
+				reading, _ := setupDatabase(tt, true)
+				require.NoError(t, reading.Use(optimistic.NewOptimisticLock(optimistic.WithDisableReturning())))
+				require.NoError(t, reading.Create(&TestModel{Description: "foo", Code: 4444}).Error)
+				edit = &TestModel{Code: 4444, Version: 1, Description: "bar"}
+				require.NoError(t, reading.Clauses(optimistic.ByColumns("code")).Updates(edit).Error)
+				require.EqualValues(t, 2, edit.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "CountersMergeConcurrentDeltas"), func(t *testing.T) {
+				m := &TestModelCounter{Description: "foo", Views: 10, Score: 1.5}
+				require.NoError(t, db.Create(m).Error)
+				load := func() *TestModelCounter {
+					got := &TestModelCounter{}
+					require.NoError(t, db.First(got, m.ID).Error)
+					return got
+				}
+
+				a, b := load(), load()
+				a.Views += 5
+				require.NoError(t, db.Updates(a).Error)
+				b.Views += 3
+				b.Score += 0.5
+				res := db.Updates(b)
+				require.NoError(t, res.Error)
+				require.True(t, optimistic.Conflicted(res))
+				report, ok := optimistic.GetConflictReport(res)
+				require.True(t, ok)
+				require.Equal(t, optimistic.ResolutionMerged, report.Resolution)
+				require.EqualValues(t, 18, b.Views)
+				require.EqualValues(t, 2.0, b.Score)
+				require.EqualValues(t, 3, b.Version)
+
+				// other columns merge when only one side changed them
+				c, d := load(), load()
+				c.Views++
+				require.NoError(t, db.Updates(c).Error)
+				d.Description = "mine"
+				require.NoError(t, db.Updates(d).Error)
+				require.Equal(t, "mine", d.Description)
+				require.EqualValues(t, 19, d.Views)
+
+				// increments by expression apply to the current value
+				stale := &TestModelCounter{ID: m.ID, Version: 1}
+				require.NoError(t, db.Model(stale).Updates(map[string]any{"views": gorm.Expr("views + ?", 1)}).Error)
+				require.EqualValues(t, 20, load().Views)
+
+				// collisions on other columns still conflict
+				e, f := load(), load()
+				e.Description = "theirs"
+				require.NoError(t, db.Updates(e).Error)
+				f.Description = "ours"
+				f.Views++
+				require.ErrorIs(t, db.Updates(f).Error, optimistic.ErrOptimisticLock)
+
+				got := load()
+				require.Equal(t, "theirs", got.Description)
+				require.EqualValues(t, 20, got.Views)
+				require.EqualValues(t, 2.0, got.Score)
+
+				// rows scanned into other types are left alone
+				var views []struct{ ID, Views int64 }
+				require.NoError(t, db.Model(&TestModelCounter{}).Where("id = ?", m.ID).Find(&views).Error)
+				require.EqualValues(t, 20, views[0].Views)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ResolveAllAppliesPolicyInOneTransaction"), func(t *testing.T) {
+				var reports []optimistic.ConflictReport
+				var ids []uint64
+				for _, desc := range []string{"foo", "bar", "baz"} {
+					m := &TestModel{Description: desc}
+					require.NoError(t, db.Create(m).Error)
+					require.NoError(t, db.Updates(&TestModel{ID: m.ID, Version: 1, Description: desc + "-theirs"}).Error)
+					res := db.Updates(&TestModel{ID: m.ID, Version: 1, Description: desc + "-ours"})
+					require.ErrorIs(t, res.Error, optimistic.ErrOptimisticLock)
+					report, ok := optimistic.GetConflictReport(res)
+					require.True(t, ok)
+					reports = append(reports, *report)
+					ids = append(ids, m.ID)
+				}
+				require.NoError(t, db.Delete(&TestModel{ID: ids[2], Version: 2}).Error)
+
+				load := func(id uint64) *TestModel {
+					got := &TestModel{}
+					require.NoError(t, db.First(got, id).Error)
+					return got
+				}
+
+				// a write that conflicts rolls back the whole batch
+				err := optimistic.ResolveAll(db, reports, func(_ context.Context, r optimistic.ConflictReport, current any) any {
+					stale := *current.(*TestModel)
+					stale.Description = "stale"
+					stale.Version = 1
+					return &stale
+				})
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				require.Equal(t, "foo-theirs", load(ids[0]).Description)
+
+				var seen []any
+				err = optimistic.ResolveAll(db, reports, func(_ context.Context, r optimistic.ConflictReport, current any) any {
+					seen = append(seen, r.PrimaryKey["id"])
+					m := current.(*TestModel)
+					if m.ID == ids[1] {
+						return nil
+					}
+					m.Description += "+resolved"
+					return m
+				})
+				require.NoError(t, err)
+				require.Len(t, seen, 2, "rows that are gone are skipped")
+				got := load(ids[0])
+				require.Equal(t, "foo-theirs+resolved", got.Description)
+				require.EqualValues(t, 3, got.Version)
+				got = load(ids[1])
+				require.Equal(t, "bar-theirs", got.Description)
+				require.EqualValues(t, 2, got.Version)
+
+				// tables are known once handled, or from WithModels
+				admin, _ := setupDatabase(tt, true)
+				require.NoError(t, admin.Use(optimistic.NewOptimisticLock()))
+				policy := func(context.Context, optimistic.ConflictReport, any) any { return nil }
+				require.ErrorIs(t, optimistic.ResolveAll(admin, reports, policy), optimistic.ErrUnknownTable)
+				admin, _ = setupDatabase(tt, true)
+				require.NoError(t, admin.Use(optimistic.NewOptimisticLock(optimistic.WithModels(&TestModel{}))))
+				require.NoError(t, optimistic.ResolveAll(admin, reports, policy))
+
+				// keys that would not single out the row are refused before anything is read
+				called := false
+				spy := func(context.Context, optimistic.ConflictReport, any) any { called = true; return nil }
+				for _, key := range []map[string]any{nil, {}, {"description": "foo-theirs+resolved"}, {"id": nil}, {"id": ids[0], "description": "foo"}} {
+					bad := reports[0]
+					bad.PrimaryKey = key
+					require.ErrorIs(t, optimistic.ResolveAll(db, []optimistic.ConflictReport{reports[0], bad}, spy), optimistic.ErrInvalidConflictKey, "%v", key)
+				}
+				require.False(t, called)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "VersionCodecKeepsStoredVersionsInternal"), func(t *testing.T) {
+				codec := xorCodec(0x5eed)
+				enc := func(n uint64) uint64 {
+					v, _ := codec.Encode(context.Background(), "test_models", n)
+					return v.(uint64)
+				}
+				for _, returning := range []bool{true, false} {
+					opts := []optimistic.ConfigOption{optimistic.WithVersionCodec(codec)}
+					var changes []optimistic.VersionChange
+					opts = append(opts, optimistic.WithVersionChangeHook(func(_ context.Context, c optimistic.VersionChange) {
+						changes = append(changes, c)
+					}))
+					if !returning {
+						opts = append(opts, optimistic.WithDisableReturning())
+					}
+					coded, _ := setupDatabase(tt, true)
+					require.NoError(t, coded.Use(optimistic.NewOptimisticLock(opts...)))
+					stored := func(id uint64) (version uint64) {
+						require.NoError(t, coded.Table("test_models").Select("version").Where("id = ?", id).Row().Scan(&version))
+						return version
+					}
+
+					m := &TestModel{Description: "foo"}
+					require.NoError(t, coded.Create(m).Error)
+					require.Equal(t, enc(1), m.Version)
+					require.EqualValues(t, 1, stored(m.ID))
+
+					loaded := &TestModel{}
+					require.NoError(t, coded.First(loaded, m.ID).Error)
+					require.Equal(t, enc(1), loaded.Version)
+					loaded.Description = "bar"
+					require.NoError(t, coded.Updates(loaded).Error)
+					require.Equal(t, enc(2), loaded.Version)
+					require.EqualValues(t, 2, stored(m.ID))
+					require.Equal(t, enc(1), changes[0].From)
+					require.Equal(t, enc(2), changes[0].To)
+
+					stale := &TestModel{ID: m.ID, Version: enc(1), Description: "baz"}
+					res := coded.Updates(stale)
+					require.ErrorIs(t, res.Error, optimistic.ErrOptimisticLock)
+					report, _ := optimistic.GetConflictReport(res)
+					require.Equal(t, enc(1), report.ExpectedVersion)
+
+					res = coded.Clauses(optimistic.Conflict{
+						OnVersionMismatch: func(current any, _ map[string]optimistic.Change) any {
+							require.Equal(t, enc(2), current.(*TestModel).Version)
+							current.(*TestModel).Description = "merged"
+							return current
+						},
+					}).Updates(stale)
+					require.NoError(t, res.Error)
+					require.Equal(t, enc(3), stale.Version)
+					report, _ = optimistic.GetConflictReport(res)
+					require.Equal(t, enc(2), report.CurrentVersion)
+					require.EqualValues(t, 3, stored(m.ID))
+
+					require.NoError(t, coded.Clauses(optimistic.AtLeast(enc(3))).First(&TestModel{}, m.ID).Error)
+					stale.Version = enc(2)
+					conflicting, err := optimistic.Conflicting(coded, []*TestModel{stale})
+					require.NoError(t, err)
+					require.Len(t, conflicting, 1)
+					var fresh []TestModel
+					require.NoError(t, optimistic.FindFresh(coded, map[any]any{m.ID: enc(3)}, &fresh))
+					require.Empty(t, fresh)
+				}
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "TransientErrorsAreRetryable"), func(t *testing.T) {
+				require.True(t, optimistic.IsTransientDriverError(errors.New("ERROR: could not serialize access due to concurrent update (SQLSTATE 40001)")))
+				require.True(t, optimistic.IsTransientDriverError(errors.New("Error 1213 (40001): Deadlock found when trying to get lock")))
+				require.True(t, optimistic.IsTransientDriverError(errors.New("database is locked (5) (SQLITE_BUSY)")))
+				require.False(t, optimistic.IsTransientDriverError(errors.New("no such column: nope")))
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				bad := map[string]any{"description": gorm.Expr("nope")}
+				err := db.Model(m).Updates(bad).Error
+				require.Error(t, err)
+				require.False(t, optimistic.Retryable(err))
+
+				classified, _ := setupDatabase(tt, true)
+				require.NoError(t, classified.Use(optimistic.NewOptimisticLock(optimistic.WithTransientErrors(func(err error) bool {
+					return strings.Contains(err.Error(), "no such column")
+				}))))
+				m = &TestModel{Description: "foo"}
+				require.NoError(t, classified.Create(m).Error)
+				err = classified.Model(m).Updates(bad).Error
+				require.ErrorIs(t, err, optimistic.ErrTransient)
+				require.True(t, optimistic.Retryable(err))
+				var te *optimistic.TransientError
+				require.ErrorAs(t, err, &te)
+				require.Equal(t, "test_models", te.Table)
+				require.Contains(t, te.Err.Error(), "no such column")
+
+				// conflicts stay conflicts, and unguarded updates are left alone
+				err = classified.Updates(&TestModel{ID: m.ID, Version: 7, Description: "bar"}).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				require.True(t, optimistic.Retryable(err))
+				err = classified.Model(&TestModel{}).Where("id = ?", m.ID).Updates(bad).Error
+				require.Error(t, err)
+				require.NotErrorIs(t, err, optimistic.ErrTransient)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "TransitionChecksEdgesAndVersion"), func(t *testing.T) {
+				allowed := map[string][]string{
+					"open": {"paid", "cancelled"},
+					"paid": {"shipped"},
+				}
+				m := &TestModel{Description: "open"}
+				require.NoError(t, db.Create(m).Error)
+				stale := *m
+
+				err := optimistic.Transition(db, m, "description", allowed, "shipped")
+				require.ErrorIs(t, err, optimistic.ErrIllegalTransition)
+				var te *optimistic.TransitionError
+				require.ErrorAs(t, err, &te)
+				require.Equal(t, "open", te.From)
+				require.Equal(t, "shipped", te.To)
+				require.EqualValues(t, 1, m.Version)
+
+				require.NoError(t, optimistic.Transition(db, m, "description", allowed, "paid"))
+				require.Equal(t, "paid", m.Description)
+				require.EqualValues(t, 2, m.Version)
+				fresh := &TestModel{ID: m.ID}
+				require.NoError(t, db.First(fresh).Error)
+				require.Equal(t, "paid", fresh.Description)
+
+				err = optimistic.Transition(db, &stale, "description", allowed, "cancelled")
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				require.NotErrorIs(t, err, optimistic.ErrIllegalTransition)
+				require.Equal(t, "open", stale.Description, "expected state to be kept")
+
+				require.ErrorIs(t, optimistic.Transition(db, m, "missing", allowed, "paid"), gorm.ErrInvalidField)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "HelpersServeServiceInterfaces"), func(t *testing.T) {
+				var (
+					locks    optimistic.Locker           = optimistic.NewHelpers(db)
+					resolver optimistic.ConflictResolver = optimistic.NewHelpers(db)
+					retry    optimistic.Retryer          = optimistic.NewHelpers(db)
+				)
+				ctx := context.Background()
+				m := &TestModel{Description: "open"}
+				require.NoError(t, locks.Put(ctx, m))
+				stale := *m
+				require.NoError(t, locks.Transition(ctx, m, "description", map[string][]string{"open": {"paid"}}, "paid"))
+
+				conflict, current, err := resolver.WouldConflict(ctx, &stale)
+				require.NoError(t, err)
+				require.True(t, conflict)
+				require.EqualValues(t, "paid", current.(*TestModel).Description)
+
+				calls := 0
+				err = retry.Retry(ctx, 3, func(ctx context.Context) error {
+					if calls++; calls > 1 {
+						require.NoError(t, db.First(&stale, stale.ID).Error)
+					}
+					stale.Code = 1
+					return locks.UpdateIf(ctx, &stale, nil)
+				})
+				require.NoError(t, err)
+				require.Equal(t, 2, calls)
+				require.EqualValues(t, 3, stale.Version)
+
+				calls = 0
+				err = retry.Retry(ctx, 3, func(context.Context) error {
+					calls++
+					return optimistic.ErrOptimisticLock
+				})
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				require.Equal(t, 3, calls)
+
+				calls = 0
+				err = retry.Retry(ctx, 3, func(context.Context) error {
+					calls++
+					return gorm.ErrRecordNotFound
+				})
+				require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+				require.Equal(t, 1, calls)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "VersionGroupsGuardTheirColumns"), func(t *testing.T) {
+				for _, returning := range []bool{true, false} {
+					gdb := db
+					if !returning {
+						gdb, _ = setupDatabase(tt, true)
+						require.NoError(t, gdb.Use(optimistic.NewOptimisticLock(optimistic.WithDisableReturning())))
+					}
+					m := &TestModelGrouped{Name: "ann", Email: "ann@example.com", Theme: "light", Status: "open"}
+					require.NoError(t, gdb.Create(m).Error)
+					require.EqualValues(t, 1, m.ProfileVersion)
+					require.EqualValues(t, 1, m.SettingsVersion)
+					require.EqualValues(t, 1, m.Version)
+					profile, settings := *m, *m
+
+					require.NoError(t, gdb.Model(&profile).Updates(map[string]any{"name": "anne"}).Error)
+					require.EqualValues(t, 2, profile.ProfileVersion)
+					require.EqualValues(t, 1, profile.Version)
+
+					// a concurrent edit of another group does not conflict
+					require.NoError(t, gdb.Model(&settings).Updates(map[string]any{"theme": "dark"}).Error)
+					require.EqualValues(t, 2, settings.SettingsVersion)
+					require.EqualValues(t, 1, settings.ProfileVersion)
+
+					// but one of the same group does
+					err := gdb.Model(&settings).Updates(map[string]any{"email": "a@example.com"}).Error
+					require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+
+					// ungrouped columns are guarded by the row's version
+					require.NoError(t, gdb.Model(&profile).Updates(map[string]any{"status": "closed"}).Error)
+					require.EqualValues(t, 2, profile.Version)
+					err = gdb.Model(m).Updates(map[string]any{"status": "void"}).Error
+					require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+
+					fresh := &TestModelGrouped{ID: m.ID}
+					require.NoError(t, gdb.First(fresh).Error)
+					require.Equal(t, "anne", fresh.Name)
+					require.Equal(t, "ann@example.com", fresh.Email)
+					require.Equal(t, "dark", fresh.Theme)
+					require.Equal(t, "closed", fresh.Status)
+					require.EqualValues(t, 2, fresh.ProfileVersion)
+					require.EqualValues(t, 2, fresh.SettingsVersion)
+					require.EqualValues(t, 2, fresh.Version)
+				}
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "VersionOnlyReturningLeavesOtherColumns"), func(t *testing.T) {
+				narrow, _ := setupDatabase(tt, true)
+				require.NoError(t, narrow.Use(optimistic.NewOptimisticLock(optimistic.WithVersionOnlyReturning())))
+				var sql string
+				require.NoError(t, narrow.Callback().Update().After("gorm:update").Register("test:capture_sql", func(tx *gorm.DB) {
+					sql = tx.Statement.SQL.String()
+				}))
+
+				m := &TestModel{Description: "foo", Code: 7}
+				require.NoError(t, narrow.Create(m).Error)
+				partial := &TestModel{ID: m.ID, Description: "bar", Version: m.Version}
+				require.NoError(t, narrow.Updates(partial).Error)
+				require.EqualValues(t, 2, partial.Version)
+				require.Zero(t, partial.Code, "expected columns left out of the update to stay unread")
+				fs, _ := optimistic.FeaturesOf(narrow)
+				if fs.Returning {
+					require.True(t, fs.VersionOnlyReturning)
+					require.Contains(t, sql, "RETURNING")
+					require.NotContains(t, sql, "RETURNING *")
+				}
+
+				stale := &TestModel{ID: m.ID, Description: "baz", Version: 1}
+				require.ErrorIs(t, narrow.Updates(stale).Error, optimistic.ErrOptimisticLock)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "FingerprintTellsIrrelevantConflicts"), func(t *testing.T) {
+				m := &TestModelFingerprint{Status: "open", Note: "first"}
+				require.NoError(t, db.Create(m).Error)
+				require.NotEmpty(t, m.Fingerprint)
+
+				loaded := &TestModelFingerprint{}
+				require.NoError(t, db.First(loaded, m.ID).Error)
+				require.Equal(t, m.Fingerprint, loaded.Fingerprint)
+
+				// a write that leaves the significant columns as they were
+				other := *loaded
+				require.NoError(t, db.Model(&other).Update("status", "open").Error)
+				require.EqualValues(t, 2, other.Version)
+				require.Equal(t, loaded.Fingerprint, other.Fingerprint)
+
+				loaded.Note = "second"
+				err := db.Updates(loaded).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				var ce *optimistic.ConflictError
+				require.ErrorAs(t, err, &ce)
+				require.True(t, ce.SameFingerprint())
+				require.EqualValues(t, 2, ce.CurrentVersion)
+
+				loaded.Version = ce.CurrentVersion.(uint64)
+				require.NoError(t, db.Updates(loaded).Error)
+				require.EqualValues(t, 3, loaded.Version)
+				require.NotEqual(t, m.Fingerprint, loaded.Fingerprint)
+
+				m.Note = "third"
+				err = db.Updates(m).Error
+				require.ErrorAs(t, err, &ce)
+				require.False(t, ce.SameFingerprint())
+				require.Equal(t, loaded.Fingerprint, ce.CurrentFingerprint)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ParallelUpdateRetriesConflicts"), func(t *testing.T) {
+				items := make([]TestModel, 12)
+				for i := range items {
+					items[i] = TestModel{Description: fmt.Sprintf("item %d", i)}
+					require.NoError(t, db.Create(&items[i]).Error)
+				}
+				// every third row moves on behind the batch's back
+				for i := 0; i < len(items); i += 3 {
+					other := items[i]
+					require.NoError(t, db.Model(&other).Update("code", 99).Error)
+				}
+				for i := range items {
+					items[i].Description += " done"
+				}
+
+				results, err := optimistic.ParallelUpdate(context.Background(), db, items, 4, optimistic.BulkPolicy[TestModel]{})
+				require.ErrorIs(t, err, optimistic.ErrPartialUpdate)
+				for i, r := range results {
+					require.Equal(t, i, r.Index)
+					require.Equal(t, 1, r.Attempts)
+					if i%3 == 0 {
+						require.ErrorIs(t, r.Err, optimistic.ErrOptimisticLock)
+					} else {
+						require.NoError(t, r.Err)
+						require.EqualValues(t, 2, items[i].Version)
+					}
+				}
+
+				backoffs := 0
+				var mu sync.Mutex
+				results, err = optimistic.ParallelUpdate(context.Background(), db, items, 4, optimistic.BulkPolicy[TestModel]{
+					Attempts: 2,
+					Backoff: func(n int) time.Duration {
+						mu.Lock()
+						defer mu.Unlock()
+						backoffs++
+						return time.Millisecond
+					},
+					Merge: func(_ context.Context, item, current *TestModel) *TestModel {
+						current.Description = item.Description
+						return current
+					},
+				})
+				require.NoError(t, err)
+				require.Equal(t, 4, backoffs)
+				for i, r := range results {
+					require.NoError(t, r.Err)
+					fresh := &TestModel{ID: items[i].ID}
+					require.NoError(t, db.First(fresh).Error)
+					require.Equal(t, fmt.Sprintf("item %d done", i), fresh.Description)
+					if i%3 == 0 {
+						require.Equal(t, 2, r.Attempts)
+						require.EqualValues(t, 99, fresh.Code)
+					}
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				results, err = optimistic.ParallelUpdate(ctx, db, items, 2, optimistic.BulkPolicy[TestModel]{})
+				require.ErrorIs(t, err, context.Canceled)
+				require.Len(t, results, len(items))
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "VersionColumnCollisionsAreRejected"), func(t *testing.T) {
+				err := db.Create(&TestModelStampedVersion{Description: "foo"}).Error
+				require.ErrorIs(t, err, optimistic.ErrVersionColumnCollision)
+				require.ErrorContains(t, err, "TestModelStampedVersion.UpdatedAt")
+
+				err = db.Create(&TestModelAliasedVersion{Description: "foo"}).Error
+				require.ErrorIs(t, err, optimistic.ErrVersionColumnCollision)
+				require.ErrorContains(t, err, "TestModelAliasedVersion.Revision")
+
+				configured, _ := setupDatabase(tt, true)
+				err = configured.Use(optimistic.NewOptimisticLock(optimistic.WithModels(&TestModel{}, &TestModelAliasedVersion{})))
+				require.ErrorIs(t, err, optimistic.ErrVersionColumnCollision)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "VersionStringerRendersVersions"), func(t *testing.T) {
+				u := uuid.Must(uuid.Parse("0190b2a1-7c3e-7d4f-8a1b-2c3d4e5f6a7b"))
+				require.Equal(t, "4e5f6a7b", optimistic.ShortVersion(u))
+				require.Equal(t, "4e5f6a7b", optimistic.ShortVersion(&u))
+				require.Equal(t, "7", optimistic.ShortVersion(uint64(7)))
+				at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+				require.Equal(t, "2024-05-01T10:00:00Z", optimistic.ShortVersion(at))
+				require.Equal(t, "7", optimistic.VersionString(db, uint64(7)))
+
+				rendered, _ := setupDatabase(tt, true)
+				require.NoError(t, rendered.Use(optimistic.NewOptimisticLock(optimistic.WithVersionStringer(func(v any) string {
+					return fmt.Sprintf("v%v", v)
+				}))))
+				require.Equal(t, "v7", optimistic.VersionString(rendered, uint64(7)))
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, rendered.Create(m).Error)
+				err := rendered.Clauses(optimistic.AtLeast(uint64(5))).First(&TestModel{ID: m.ID}).Error
+				require.ErrorIs(t, err, optimistic.ErrStaleRead)
+				require.ErrorContains(t, err, "version >= v5")
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "VersionRegistryMirrorsWrites"), func(t *testing.T) {
+				_, err := optimistic.RegisteredVersion(db, &TestModel{ID: 1})
+				require.ErrorIs(t, err, optimistic.ErrNoRegistry)
+
+				registered, _ := setupDatabase(tt, true)
+				require.NoError(t, registered.Use(optimistic.NewOptimisticLock(optimistic.WithVersionRegistry(""))))
+				require.NoError(t, optimistic.MigrateRegistry(registered))
+				fs, _ := optimistic.FeaturesOf(registered)
+				require.Equal(t, optimistic.DefaultRegistryTable, fs.Registry)
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, registered.Create(m).Error)
+				current, err := optimistic.RegisteredVersion(registered, m)
+				require.NoError(t, err)
+				require.Equal(t, optimistic.RegistryVersion(m.Version), current)
+
+				stale := *m
+				m.Description = "bar"
+				require.NoError(t, registered.Updates(m).Error)
+				current, err = optimistic.RegisteredVersion(registered, m)
+				require.NoError(t, err)
+				require.Equal(t, "2", current)
+				require.NotEqual(t, optimistic.RegistryVersion(stale.Version), current)
+
+				// conflicts and rolled back writes leave the registry as it was
+				stale.Description = "baz"
+				require.ErrorIs(t, registered.Updates(&stale).Error, optimistic.ErrOptimisticLock)
+				_ = registered.Transaction(func(tx *gorm.DB) error {
+					m.Description = "qux"
+					require.NoError(t, tx.Updates(m).Error)
+					return errors.New("abort")
+				})
+				current, err = optimistic.RegisteredVersion(registered, &TestModel{ID: m.ID})
+				require.NoError(t, err)
+				require.Equal(t, "2", current)
+
+				require.NoError(t, registered.Delete(&TestModel{ID: m.ID}).Error)
+				_, err = optimistic.RegisteredVersion(registered, m)
+				require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "LockingQueriesSkipRedundantGuard"), func(t *testing.T) {
+				for _, trusted := range []bool{false, true} {
+					locking, _ := setupDatabase(tt, true)
+					var opts []optimistic.ConfigOption
+					if trusted {
+						opts = append(opts, optimistic.WithLockingQueries())
+					}
+					require.NoError(t, locking.Use(optimistic.NewOptimisticLock(opts...)))
+					fs, _ := optimistic.FeaturesOf(locking)
+					require.Equal(t, trusted, fs.LockingQueries)
+					var where string
+					require.NoError(t, locking.Callback().Update().After("gorm:update").Register("test:capture_where", func(tx *gorm.DB) {
+						sql := tx.Statement.SQL.String()
+						where = sql[strings.Index(sql, "WHERE"):]
+					}))
+
+					m := &TestModel{Description: "foo"}
+					require.NoError(t, locking.Create(m).Error)
+
+					err := locking.Transaction(func(tx *gorm.DB) error {
+						var locked []TestModel
+						require.NoError(t, tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+							Where("id = ?", m.ID).Find(&locked).Error)
+						require.Len(t, locked, 1)
+
+						for i, description := range []string{"bar", "baz"} {
+							locked[0].Description = description
+							require.NoError(t, tx.Updates(&locked[0]).Error)
+							require.EqualValues(t, i+2, locked[0].Version, "the version is still bumped")
+							// SQLite has no row locks, so there the guard stays
+							skipped := trusted && testDatabaseName != testSqlite
+							require.Equal(t, !skipped, strings.Contains(where, "version"), where)
+						}
+
+						stale := &TestModel{ID: m.ID, Description: "stale", Version: 1}
+						require.ErrorIs(t, tx.Updates(stale).Error, optimistic.ErrOptimisticLock, "other versions stay guarded")
+						return nil
+					})
+					require.NoError(t, err)
+
+					// locks end with their transaction
+					stale := &TestModel{ID: m.ID, Description: "stale", Version: 2}
+					require.ErrorIs(t, locking.Updates(stale).Error, optimistic.ErrOptimisticLock)
+				}
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "IdempotencyKeyNamesTransitions"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				stale := *m
+
+				m.Description = "bar"
+				res := db.Updates(m)
+				require.NoError(t, res.Error)
+				key, ok := optimistic.IdempotencyKey(res)
+				require.True(t, ok)
+				require.Len(t, key, 32)
+				again, ok := optimistic.IdempotencyKey(res)
+				require.True(t, ok)
+				require.Equal(t, key, again, "the key is stable")
+
+				m.Description = "baz"
+				res = db.Updates(m)
+				require.NoError(t, res.Error)
+				next, ok := optimistic.IdempotencyKey(res)
+				require.True(t, ok)
+				require.NotEqual(t, key, next, "each transition has a key of its own")
+
+				stale.Description = "stale"
+				res = db.Updates(&stale)
+				require.ErrorIs(t, res.Error, optimistic.ErrOptimisticLock)
+				_, ok = optimistic.IdempotencyKey(res)
+				require.False(t, ok)
+				_, ok = optimistic.IdempotencyKey(db.Create(&TestModel{Description: "new"}))
+				require.False(t, ok)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ColumnTypesAdviseVersionColumns"), func(t *testing.T) {
+				require.Equal(t, "RAW(16)", optimistic.ColumnType("oracle", optimistic.StrategyULID))
+				require.Equal(t, "uuid", optimistic.ColumnType("postgres", optimistic.StrategyUUID))
+				require.Equal(t, "binary(16)", optimistic.ColumnType("mysql", optimistic.StrategyULID))
+				require.Empty(t, optimistic.ColumnType("clickhouse", optimistic.StrategyInt))
+
+				strategies := map[any]optimistic.Strategy{
+					&TestModelUUIDVersion{}: optimistic.StrategyUUID,
+					&TestModelULIDVersion{}: optimistic.StrategyULID,
+				}
+				for model, strategy := range strategies {
+					columns, err := db.Migrator().ColumnTypes(model)
+					require.NoError(t, err)
+					i := slices.IndexFunc(columns, func(c gorm.ColumnType) bool { return c.Name() == "version" })
+					require.GreaterOrEqual(t, i, 0)
+					advised := optimistic.ColumnType(db.Dialector.Name(), strategy)
+					require.NotEmpty(t, columns[i].DatabaseTypeName())
+					require.True(t, strings.HasPrefix(strings.ToLower(advised), strings.ToLower(columns[i].DatabaseTypeName())),
+						"%s is not %s", columns[i].DatabaseTypeName(), advised)
+				}
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "DryRunGuardsShowProductionSQL"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				m.Description = "dryrun"
+				plain := db.Session(&gorm.Session{DryRun: true}).Updates(m)
+				require.NoError(t, plain.Error)
+				where := plain.Statement.SQL.String()
+				require.NotContains(t, where[strings.Index(where, "WHERE"):], "version")
+
+				guarded, _ := setupDatabase(tt, true)
+				narrowed := 0
+				require.NoError(t, guarded.Use(optimistic.NewOptimisticLock(
+					optimistic.WithDryRunGuards(),
+					optimistic.WithNarrowHook(func(context.Context, optimistic.NarrowedUpdate) { narrowed++ }),
+				)))
+				m = &TestModel{Description: "foo"}
+				require.NoError(t, guarded.Create(m).Error)
+				m.Description = "dryrun"
+				dry := guarded.Session(&gorm.Session{DryRun: true}).Updates(m)
+				require.NoError(t, dry.Error)
+				sql := dry.Statement.SQL.String()
+				require.Contains(t, sql[strings.Index(sql, "WHERE"):], "version", "the version guards the update")
+				require.EqualValues(t, 1, m.Version)
+				require.False(t, optimistic.Conflicted(dry))
+				_, ok := optimistic.IdempotencyKey(dry)
+				require.False(t, ok, "dry runs record nothing")
+				require.Zero(t, narrowed)
+
+				stored := &TestModel{ID: m.ID}
+				require.NoError(t, guarded.First(stored).Error)
+				require.EqualValues(t, 1, stored.Version)
+				require.Equal(t, "foo", stored.Description)
+
+				// the statements that run look the same
+				var executed string
+				require.NoError(t, guarded.Callback().Update().After("gorm:update").Register("test:capture_sql", func(tx *gorm.DB) {
+					executed = tx.Statement.SQL.String()
+				}))
+				require.NoError(t, guarded.Updates(m).Error)
+				require.Equal(t, sql, executed)
+				require.Equal(t, 1, narrowed)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "AfterUpdateHooksSeeBumpedVersion"), func(t *testing.T) {
+				plain, _ := setupDatabase(tt, true)
+				require.NoError(t, plain.Use(optimistic.NewOptimisticLock(optimistic.WithDisableReturning())))
+				for _, db := range []*gorm.DB{db, plain} {
+					m := &TestModelHooked{Description: "foo"}
+					require.NoError(t, db.Create(m).Error)
+					for _, description := range []string{"bar", "baz"} {
+						m.Description = description
+						require.NoError(t, db.Updates(m).Error)
+						seen, _ := hookedVersions.Load(m.ID)
+						require.Equal(t, m.Version, seen)
+					}
+					require.EqualValues(t, 3, m.Version)
+
+					stale := &TestModelHooked{ID: m.ID, Description: "stale", Version: 1}
+					require.ErrorIs(t, db.Updates(stale).Error, optimistic.ErrOptimisticLock)
+					require.EqualValues(t, 1, stale.Version, "conflicts keep the version they were given")
+					seen, _ := hookedVersions.Load(m.ID)
+					require.EqualValues(t, 3, seen)
+				}
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "TagConstantsParseLikeThePlugin"), func(t *testing.T) {
+				tag, err := optimistic.ParseVersionTag(optimistic.StrategyNameTime + "," + optimistic.ParamUTC + "," + optimistic.ParamTrunc + "=ms")
+				require.NoError(t, err)
+				require.Equal(t, optimistic.StrategyTime, tag.Strategy)
+				require.Equal(t, map[string]string{optimistic.ParamUTC: "", optimistic.ParamTrunc: "ms"}, tag.Params)
+
+				for _, bare := range []string{"", optimistic.TagVersion} {
+					tag, err = optimistic.ParseVersionTag(bare)
+					require.NoError(t, err)
+					require.Zero(t, tag)
+				}
+				_, err = optimistic.ParseVersionTag("uuid,trunc=ms")
+				require.ErrorIs(t, err, optimistic.ErrInvalidVersionTag)
+				_, err = optimistic.ParseVersionTag("nanoid")
+				require.ErrorIs(t, err, optimistic.ErrInvalidVersionTag)
+
+				for _, strategy := range []optimistic.Strategy{optimistic.StrategyInt, optimistic.StrategyUUID, optimistic.StrategyULID, optimistic.StrategyTime} {
+					parsed, err := optimistic.ParseStrategy(strategy.String())
+					require.NoError(t, err)
+					require.Equal(t, strategy, parsed)
+				}
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "VersionedDeleteGuardsLoadedModels"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				stale := &TestModel{ID: m.ID, Version: m.Version}
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+
+				results := db.Delete(stale)
+				require.ErrorIs(t, results.Error, optimistic.ErrOptimisticLock)
+				require.Zero(t, results.RowsAffected)
+
+				err := db.Clauses(optimistic.Conflict{AttachCurrent: true}).Delete(stale).Error
+				var ce *optimistic.ConflictError
+				require.ErrorAs(t, err, &ce)
+				require.EqualValues(t, m.Version, ce.Current.(*TestModel).Version)
+
+				err = db.Clauses(optimistic.Conflict{
+					OnVersionMismatch: func(current any, diffs map[string]optimistic.Change) any {
+						return nil
+					},
+				}).Delete(stale).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				require.NoError(t, db.First(&TestModel{}, m.ID).Error, "canceled delete keeps the row")
+
+				err = db.Clauses(optimistic.Conflict{
+					OnVersionMismatch: func(current any, diffs map[string]optimistic.Change) any {
+						require.NotEmpty(t, diffs)
+						return current
+					},
+				}).Delete(stale).Error
+				require.NoError(t, err)
+				require.ErrorIs(t, db.First(&TestModel{}, m.ID).Error, gorm.ErrRecordNotFound)
+
+				fresh := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(fresh).Error)
+				require.NoError(t, db.Delete(&TestModel{ID: fresh.ID, Version: fresh.Version}).Error)
+
+				unversioned := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(unversioned).Error)
+				unversioned.Description = "bar"
+				require.NoError(t, db.Updates(unversioned).Error)
+				results = db.Delete(&TestModel{}, unversioned.ID)
+				require.NoError(t, results.Error, "deletes without a loaded version are not guarded")
+				require.EqualValues(t, 1, results.RowsAffected)
+
+				soft := &TestModelSoftDelete{Description: "foo"}
+				require.NoError(t, db.Create(soft).Error)
+				staleSoft := &TestModelSoftDelete{ID: soft.ID, Version: soft.Version}
+				soft.Description = "bar"
+				require.NoError(t, db.Updates(soft).Error)
+				require.ErrorIs(t, db.Delete(staleSoft).Error, optimistic.ErrOptimisticLock)
+				require.NoError(t, db.Delete(soft).Error)
+				require.ErrorIs(t, db.First(&TestModelSoftDelete{}, soft.ID).Error, gorm.ErrRecordNotFound)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "BatchUpdatesGuardEachRow"), func(t *testing.T) {
+				rows := []TestModel{{Code: 51}, {Code: 51}, {Code: 51}}
+				require.NoError(t, db.Create(&rows).Error)
+
+				results := db.Model(&rows).Updates(map[string]any{"description": "batched"})
+				require.NoError(t, results.Error)
+				require.EqualValues(t, 3, results.RowsAffected)
+				for _, row := range rows {
+					require.EqualValues(t, 2, row.Version)
+				}
+
+				concurrent := rows[1]
+				concurrent.Description = "concurrent"
+				require.NoError(t, db.Updates(&concurrent).Error)
+
+				results = db.Model(&rows).Updates(TestModel{Description: "again"})
+				var be *optimistic.BatchConflictError
+				require.ErrorAs(t, results.Error, &be)
+				require.ErrorIs(t, results.Error, optimistic.ErrOptimisticLock)
+				require.True(t, optimistic.Conflicted(results))
+				require.Equal(t, []int{1}, be.Indexes)
+				require.Equal(t, []any{rows[1].ID}, be.Keys)
+				require.Equal(t, 3, be.Rows)
+				require.EqualValues(t, 2, results.RowsAffected)
+				require.EqualValues(t, 3, rows[0].Version)
+				require.EqualValues(t, 2, rows[1].Version)
+
+				var stored []TestModel
+				require.NoError(t, db.Order("id").Find(&stored, []uint64{rows[0].ID, rows[1].ID, rows[2].ID}).Error)
+				require.Equal(t, []string{"again", "concurrent", "again"},
+					[]string{stored[0].Description, stored[1].Description, stored[2].Description},
+					"rows that did not conflict are committed")
+				require.EqualValues(t, 3, stored[2].Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "UpsertsBumpAndGuardVersions"), func(t *testing.T) {
+				if testDatabaseName == testOracle {
+					t.Skip("oracle builds upserts as MERGE")
+				}
+				rows := []*TestModel{{Code: 61}, {Code: 61}}
+				require.NoError(t, db.Create(&rows).Error)
+
+				rows[0].Description = "saved"
+				require.NoError(t, db.Save(&rows).Error)
+				require.EqualValues(t, 2, rows[0].Version, "upserted rows are bumped, not reset")
+				require.EqualValues(t, 2, rows[1].Version)
+
+				rows[0].Description = "upserted"
+				require.NoError(t, db.Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "id"}},
+					DoUpdates: clause.AssignmentColumns([]string{"description", "version"}),
+				}).Create(rows[0]).Error)
+				require.EqualValues(t, 3, rows[0].Version, "explicit version assignments are replaced by the bump")
+				stored := &TestModel{ID: rows[0].ID}
+				require.NoError(t, db.First(stored).Error)
+				require.EqualValues(t, 3, stored.Version)
+				require.Equal(t, "upserted", stored.Description)
+
+				if testDatabaseName != testSqlite && testDatabaseName != testPostgres {
+					return
+				}
+				guarded, _ := setupDatabase(tt, true)
+				require.NoError(t, guarded.Use(optimistic.NewOptimisticLock(optimistic.WithUpsertGuard())))
+				fs, _ := optimistic.FeaturesOf(guarded)
+				require.True(t, fs.UpsertGuard)
+
+				saved := &TestModel{Code: 61}
+				require.NoError(t, guarded.Create(saved).Error)
+				concurrent := *saved
+				concurrent.Description = "concurrent"
+				require.NoError(t, guarded.Updates(&concurrent).Error)
+
+				stale := &TestModel{ID: saved.ID, Description: "stale", Version: saved.Version}
+				err := guarded.Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "id"}},
+					DoUpdates: clause.AssignmentColumns([]string{"description"}),
+				}).Create(stale).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				current := &TestModel{}
+				require.NoError(t, guarded.First(current, saved.ID).Error)
+				require.Equal(t, "concurrent", current.Description, "stale rows are not overwritten")
+
+				concurrent.Description = "current"
+				require.NoError(t, guarded.Save([]*TestModel{&concurrent}).Error)
+				require.EqualValues(t, 3, concurrent.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "CreateInBatchesSeedsEveryChunk"), func(t *testing.T) {
+				rows := make([]TestModel, 5)
+				results := db.CreateInBatches(&rows, 2)
+				require.NoError(t, results.Error)
+				require.EqualValues(t, 5, results.RowsAffected)
+				for _, row := range rows {
+					require.NotZero(t, row.ID)
+					require.EqualValues(t, 1, row.Version)
+				}
+				rows[4].Description = "last chunk"
+				require.NoError(t, db.Updates(&rows[4]).Error)
+				require.EqualValues(t, 2, rows[4].Version)
+
+				uuids := []*TestModelUUIDVersion{{}, {}, {}}
+				require.NoError(t, db.CreateInBatches(uuids, 2).Error)
+				seen := map[uuid.UUID]bool{}
+				for _, row := range uuids {
+					stored := &TestModelUUIDVersion{}
+					require.NoError(t, db.First(stored, row.ID).Error)
+					require.Equal(t, row.Version, stored.Version)
+					require.False(t, seen[row.Version], "every element gets its own version")
+					seen[row.Version] = true
+				}
+
+				if testDatabaseName != testSqlite {
+					return
+				}
+				times := []*TestModelTimeVersion{{}, {}, {}}
+				require.NoError(t, db.CreateInBatches(times, 2).Error)
+				for _, row := range times {
+					stored := &TestModelTimeVersion{}
+					require.NoError(t, db.First(stored, row.ID).Error)
+					require.True(t, row.Version.Equal(stored.Version), "versions are read back per chunk")
+				}
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "AggregateBumpOnAssociationSaves"), func(t *testing.T) {
+				parent := &TestModelParent{Description: "parent"}
+				require.NoError(t, db.Create(parent).Error)
+				require.NoError(t, db.Model(parent).Association("Children").Append(&TestModelChild{Description: "a"}))
+				require.EqualValues(t, 1, parent.Version, "parents are left alone by default")
+
+				aggregated, _ := setupDatabase(tt, true)
+				require.NoError(t, aggregated.Use(optimistic.NewOptimisticLock(optimistic.WithAggregateBump())))
+				fs, _ := optimistic.FeaturesOf(aggregated)
+				require.True(t, fs.AggregateBump)
+
+				parent = &TestModelParent{Description: "parent"}
+				require.NoError(t, aggregated.Create(parent).Error)
+				stale := *parent
+				require.NoError(t, aggregated.Model(parent).Association("Children").Append(&TestModelChild{Description: "a"}))
+				require.EqualValues(t, 2, parent.Version)
+
+				err := aggregated.Model(&stale).Association("Children").Append(&TestModelChild{Description: "b"})
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				var count int64
+				require.NoError(t, aggregated.Model(&TestModelChild{}).Where("parent_id = ?", parent.ID).Count(&count).Error)
+				require.EqualValues(t, 1, count, "children of a conflicting aggregate roll back")
+
+				parent.Children[0].Description = "a2"
+				full := aggregated.Session(&gorm.Session{FullSaveAssociations: true})
+				require.NoError(t, full.Select("Children").Updates(parent).Error)
+				require.EqualValues(t, 3, parent.Version)
+				stored := &TestModelParent{}
+				require.NoError(t, aggregated.First(stored, parent.ID).Error)
+				require.EqualValues(t, 3, stored.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "BulkBumpVersionsUntargetedUpdates"), func(t *testing.T) {
+				rows := []*TestModel{{Code: 71}, {Code: 71}, {Code: 72}}
+				require.NoError(t, db.Create(&rows).Error)
+				require.NoError(t, db.Model(&TestModel{}).Clauses(optimistic.BulkBump{}).
+					Where("code = ?", 71).
+					Updates(map[string]any{"description": "bulk"}).Error)
+				require.NoError(t, db.Model(&TestModel{}).Where("code = ?", 72).Update("description", "plain").Error)
+
+				var stored []TestModel
+				require.NoError(t, db.Order("id").Find(&stored, []uint64{rows[0].ID, rows[1].ID, rows[2].ID}).Error)
+				require.EqualValues(t, 2, stored[0].Version)
+				require.EqualValues(t, 2, stored[1].Version)
+				require.EqualValues(t, "bulk", stored[1].Description)
+				require.EqualValues(t, 1, stored[2].Version, "bulk updates are not bumped without BulkBump")
+
+				rows[0].Description = "stale"
+				require.ErrorIs(t, db.Updates(rows[0]).Error, optimistic.ErrOptimisticLock)
+
+				m := &TestModelUUIDVersion{Code: 73}
+				require.NoError(t, db.Create(m).Error)
+				require.NoError(t, db.Model(&TestModelUUIDVersion{}).Clauses(optimistic.BulkBump{}).
+					Where("code = ?", 73).
+					Update("description", "bulk").Error)
+				current := &TestModelUUIDVersion{}
+				require.NoError(t, db.First(current, m.ID).Error)
+				require.NotEqual(t, m.Version, current.Version)
+				require.NotEqual(t, uuid.Nil, current.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "AssertVersionDetectsStaleReads"), func(t *testing.T) {
+				m := &TestModel{Code: 81}
+				require.NoError(t, db.Create(m).Error)
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+
+				loaded := &TestModel{}
+				require.NoError(t, db.Clauses(optimistic.AssertVersion(2)).First(loaded, m.ID).Error)
+				require.Equal(t, "bar", loaded.Description)
+
+				err := db.Clauses(optimistic.AssertVersion(uint64(1))).First(&TestModel{}, m.ID).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				var ce *optimistic.ConflictError
+				require.ErrorAs(t, err, &ce)
+				require.EqualValues(t, 2, ce.CurrentVersion)
+
+				var found []TestModel
+				err = db.Clauses(optimistic.AssertVersion(2)).Where("code = ?", 81).Find(&found).Error
+				require.NoError(t, err)
+				require.NoError(t, db.Create(&TestModel{Code: 81}).Error)
+				err = db.Clauses(optimistic.AssertVersion(2)).Where("code = ?", 81).Find(&found).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock, "every row found must be at the version")
+
+				err = db.Clauses(optimistic.AssertVersion(2)).First(&TestModel{}, m.ID+1000).Error
+				require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+				err = db.Clauses(optimistic.AssertVersion(1)).First(&TestModelNoVersion{}).Error
+				require.ErrorIs(t, err, optimistic.ErrNoVersionField)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "RunWithRetryRerunsConflicts"), func(t *testing.T) {
+				m := &TestModel{Code: 91}
+				require.NoError(t, db.Create(m).Error)
+
+				runs := 0
+				err := optimistic.RunWithRetry(db, func(tx *gorm.DB) error {
+					runs++
+					current := &TestModel{}
+					if err := tx.First(current, m.ID).Error; err != nil {
+						return err
+					}
+					if runs == 1 {
+						// the row moves on between the read and the write
+						require.NoError(t, tx.Session(&gorm.Session{NewDB: true}).
+							Model(&TestModel{}).
+							Where("id = ?", m.ID).
+							UpdateColumns(map[string]any{"version": gorm.Expr("version + 1")}).Error)
+					}
+					current.Description = "retried"
+					return tx.Updates(current).Error
+				}, optimistic.Backoff(time.Millisecond, 2*time.Millisecond), optimistic.Jitter(0.5))
+				require.NoError(t, err)
+				require.Equal(t, 2, runs)
+				stored := &TestModel{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.Equal(t, "retried", stored.Description)
+				require.EqualValues(t, 2, stored.Version, "the failed run rolled back")
+
+				runs = 0
+				err = optimistic.RunWithRetry(db, func(tx *gorm.DB) error {
+					runs++
+					return optimistic.ErrOptimisticLock
+				}, optimistic.MaxAttempts(4), optimistic.Backoff(0, 0))
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				require.Equal(t, 4, runs)
+
+				runs = 0
+				err = optimistic.RunWithRetry(db, func(tx *gorm.DB) error {
+					runs++
+					return gorm.ErrRecordNotFound
+				})
+				require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+				require.Equal(t, 1, runs, "other errors are not retried")
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ExecCheckedGuardsRawUpdates"), func(t *testing.T) {
+				m := &TestModel{Code: 101}
+				require.NoError(t, db.Create(m).Error)
+				stale := *m
+
+				require.NoError(t, optimistic.ExecChecked(db, m, "UPDATE test_models SET code = code + ?, description = ?", 1, "raw"))
+				require.EqualValues(t, 2, m.Version)
+				stored := &TestModel{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.EqualValues(t, 102, stored.Code)
+				require.Equal(t, "raw", stored.Description)
+				require.EqualValues(t, 2, stored.Version)
+
+				err := optimistic.ExecChecked(db, &stale, "UPDATE test_models SET code = ?", 0)
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+
+				require.NoError(t, optimistic.ExecChecked(db, m,
+					"UPDATE test_models SET description = ? WHERE code = ? OR code = ?", "where", 102, 0))
+				require.EqualValues(t, 3, m.Version)
+				err = optimistic.ExecChecked(db, m, "UPDATE test_models SET description = ? WHERE code = ?", "miss", 0)
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock, "the statement's own conditions still apply")
+
+				uuidModel := &TestModelUUIDVersion{Code: 101}
+				require.NoError(t, db.Create(uuidModel).Error)
+				before := uuidModel.Version
+				require.NoError(t, optimistic.ExecChecked(db, uuidModel, "UPDATE test_models_uuid_version SET description = ?", "raw"))
+				require.NotEqual(t, before, uuidModel.Version)
+				uuidModel.Description = "after raw"
+				require.NoError(t, db.Updates(uuidModel).Error, "the new version is the stored one")
+
+				require.ErrorIs(t, optimistic.ExecChecked(db, m, "DELETE FROM test_models"), optimistic.ErrRawStatement)
+				require.ErrorIs(t, optimistic.ExecChecked(db, &TestModel{}, "UPDATE test_models SET code = 1"), gorm.ErrPrimaryKeyRequired)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "MultipleVersionFieldsAreRejected"), func(t *testing.T) {
+				err := db.Create(&TestModelTwoVersions{Description: "foo"}).Error
+				require.ErrorIs(t, err, optimistic.ErrMultipleVersionFields)
+				require.ErrorContains(t, err, "TestModelTwoVersions.Version and TestModelTwoVersions.Revision")
+				require.ErrorIs(t, db.Updates(&TestModelTwoVersions{ID: 1, Version: 1}).Error, optimistic.ErrMultipleVersionFields)
+
+				configured, _ := setupDatabase(tt, true)
+				err = configured.Use(optimistic.NewOptimisticLock(optimistic.WithModels(&TestModelTwoVersions{})))
+				require.ErrorIs(t, err, optimistic.ErrMultipleVersionFields)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "EmbeddedVersionFields"), func(t *testing.T) {
+				embedded := &TestModelEmbeddedVersion{Description: "foo"}
+				require.NoError(t, db.Create(embedded).Error)
+				require.EqualValues(t, 1, embedded.Version)
+				stale := &TestModelEmbeddedVersion{}
+				require.NoError(t, db.First(stale, embedded.ID).Error)
+				embedded.Description = "bar"
+				require.NoError(t, db.Updates(embedded).Error)
+				require.EqualValues(t, 2, embedded.Version)
+				stale.Description = "baz"
+				require.ErrorIs(t, db.Updates(stale).Error, optimistic.ErrOptimisticLock)
+
+				stmt := &gorm.Statement{DB: db}
+				require.NoError(t, stmt.Parse(&TestModelPrefixedVersion{}))
+				require.Equal(t, "rev_version", optimistic.VersionField(db, stmt.Schema).DBName, "the tag on the embedding field marks Version")
+				prefixed := &TestModelPrefixedVersion{Revision: Revision{By: "alice"}, Description: "foo"}
+				require.NoError(t, db.Create(prefixed).Error)
+				require.EqualValues(t, 1, prefixed.Revision.Version)
+				prefixedStale := &TestModelPrefixedVersion{}
+				require.NoError(t, db.First(prefixedStale, prefixed.ID).Error)
+				require.NoError(t, db.Model(prefixed).Updates(map[string]any{"description": "bar"}).Error)
+				require.EqualValues(t, 2, prefixed.Revision.Version)
+				err := db.Model(prefixedStale).Updates(map[string]any{"rev_by": "bob"}).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "TouchBumpsVersionsAlone"), func(t *testing.T) {
+				m := &TestModel{Description: "foo", Code: 7}
+				require.NoError(t, db.Create(m).Error)
+				stale := &TestModel{}
+				require.NoError(t, db.First(stale, m.ID).Error)
+
+				require.NoError(t, optimistic.Touch(db, m))
+				require.EqualValues(t, 2, m.Version)
+				stored := &TestModel{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.EqualValues(t, 2, stored.Version)
+				require.Equal(t, "foo", stored.Description)
+				require.EqualValues(t, 7, stored.Code)
+
+				require.ErrorIs(t, optimistic.Touch(db, stale), optimistic.ErrOptimisticLock, "a second claimant loses")
+				require.EqualValues(t, 1, stale.Version)
+
+				uuidModel := &TestModelUUIDVersion{Description: "foo"}
+				require.NoError(t, db.Create(uuidModel).Error)
+				before := uuidModel.Version
+				require.NoError(t, optimistic.Touch(db, uuidModel))
+				require.NotEqual(t, before, uuidModel.Version)
+
+				require.ErrorIs(t, optimistic.Touch(db, &TestModel{}), gorm.ErrPrimaryKeyRequired)
+				require.ErrorIs(t, optimistic.Touch(db, &TestModelNoVersion{ID: 1}), optimistic.ErrNoVersionField)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ExpectGuardsByClientVersions"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+
+				request := &TestModel{ID: m.ID, Description: "stale"}
+				err := db.Clauses(optimistic.Expect(1)).Updates(request).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				require.EqualValues(t, 1, request.Version)
+
+				request = &TestModel{ID: m.ID, Description: "current"}
+				require.NoError(t, db.Clauses(optimistic.Expect(2)).Updates(request).Error)
+				require.EqualValues(t, 3, request.Version)
+
+				target := &TestModel{ID: m.ID}
+				require.NoError(t, db.Model(target).Clauses(optimistic.Expect("3")).Updates(map[string]any{"code": 5}).Error)
+				require.EqualValues(t, 4, target.Version)
+				stored := &TestModel{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.Equal(t, "current", stored.Description)
+				require.EqualValues(t, 5, stored.Code)
+				require.EqualValues(t, 4, stored.Version)
+
+				uuidModel := &TestModelUUIDVersion{Description: "foo"}
+				require.NoError(t, db.Create(uuidModel).Error)
+				uuidRequest := &TestModelUUIDVersion{ID: uuidModel.ID, Description: "bar"}
+				require.NoError(t, db.Clauses(optimistic.Expect(uuidModel.Version.String())).Updates(uuidRequest).Error)
+				require.NotEqual(t, uuidModel.Version, uuidRequest.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ForceSkipsVersionGuards"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				stale := *m
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+
+				stale.Description = "forced"
+				require.NoError(t, db.Clauses(optimistic.Force{}).Updates(&stale).Error)
+				require.EqualValues(t, 3, stale.Version, "the forced update still bumps")
+				stored := &TestModel{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.Equal(t, "forced", stored.Description)
+				require.EqualValues(t, 3, stored.Version)
+
+				m.Description = "lost"
+				require.ErrorIs(t, db.Updates(m).Error, optimistic.ErrOptimisticLock, "holders of the row see it changed")
+				require.NoError(t, db.Clauses(optimistic.Force{}).Delete(m).Error)
+				require.ErrorIs(t, db.First(&TestModel{}, m.ID).Error, gorm.ErrRecordNotFound)
+
+				deleted := &TestModelSoftDelete{Description: "foo"}
+				require.NoError(t, db.Create(deleted).Error)
+				require.NoError(t, db.Delete(deleted).Error)
+				deleted.Description = "bar"
+				err := db.Clauses(optimistic.Force{}).Updates(deleted).Error
+				require.ErrorIs(t, err, gorm.ErrRecordNotFound, "soft-deleted rows stay out of scope")
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "BatchDeletesReportConflictedRows"), func(t *testing.T) {
+				rows := []TestModel{{Description: "a"}, {Description: "b"}, {Description: "c"}}
+				require.NoError(t, db.Create(&rows).Error)
+				moved := rows[1]
+				moved.Description = "moved"
+				require.NoError(t, db.Updates(&moved).Error)
+
+				err := db.Delete(&rows).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				var de *optimistic.DeleteConflictError
+				require.ErrorAs(t, err, &de)
+				require.Equal(t, []int{1}, de.Indexes)
+				require.Equal(t, []any{rows[1].ID}, de.Failed)
+				require.Equal(t, 3, de.Rows)
+
+				var left []TestModel
+				require.NoError(t, db.Where("id IN ?", []uint64{rows[0].ID, rows[1].ID, rows[2].ID}).Find(&left).Error)
+				require.Len(t, left, 1, "the other rows are deleted")
+				require.Equal(t, "moved", left[0].Description)
+
+				require.NoError(t, db.Delete(&left).Error)
+				require.ErrorIs(t, db.First(&TestModel{}, rows[1].ID).Error, gorm.ErrRecordNotFound)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "FirstOrCreateLoadsRaceWinners"), func(t *testing.T) {
+				racy, _ := setupDatabase(tt, true)
+				require.NoError(t, racy.Use(optimistic.NewOptimisticLock()))
+				var (
+					racing atomic.Bool
+					name   string
+				)
+				// the winner is created between FirstOrCreate's lookup and its create
+				require.NoError(t, racy.Callback().Query().After("gorm:query").Register("test:race", func(tx *gorm.DB) {
+					if _, ok := tx.Statement.Dest.(*TestModelNaturalKey); ok && tx.RowsAffected == 0 && racing.CompareAndSwap(true, false) {
+						winner := &TestModelNaturalKey{Scope: "race", Name: name, Description: "winner"}
+						require.NoError(t, tx.Session(&gorm.Session{NewDB: true}).Create(winner).Error)
+					}
+				}))
+
+				racing.Store(true)
+				name = "a"
+				account := &TestModelNaturalKey{}
+				err := optimistic.FirstOrCreate(racy.Where(TestModelNaturalKey{Scope: "race", Name: "a"}).
+					Attrs(TestModelNaturalKey{Description: "loser"}), account)
+				require.NoError(t, err)
+				require.False(t, racing.Load(), "the create raced")
+				require.Equal(t, "winner", account.Description)
+				require.EqualValues(t, 1, account.Version)
+
+				racing.Store(true)
+				name = "b"
+				var diffs map[string]optimistic.Change
+				account = &TestModelNaturalKey{}
+				err = optimistic.FirstOrCreate(racy.Clauses(optimistic.Conflict{
+					OnVersionMismatch: func(current any, diff map[string]optimistic.Change) any {
+						diffs = diff
+						winner := current.(*TestModelNaturalKey)
+						winner.Description = "merged"
+						return winner
+					},
+				}).Where(TestModelNaturalKey{Scope: "race", Name: "b"}).Attrs(TestModelNaturalKey{Description: "loser"}), account)
+				require.NoError(t, err)
+				require.NotEmpty(t, diffs)
+				require.Equal(t, "merged", account.Description)
+				require.EqualValues(t, 2, account.Version)
+				stored := &TestModelNaturalKey{}
+				require.NoError(t, racy.Where(TestModelNaturalKey{Scope: "race", Name: "b"}).First(stored).Error)
+				require.Equal(t, "merged", stored.Description)
+
+				reused := &TestModel{ID: 0, Version: 7}
+				require.NoError(t, optimistic.FirstOrInit(db.Where(TestModel{Description: "first or init"}), reused))
+				require.Equal(t, "first or init", reused.Description)
+				require.Zero(t, reused.Version, "the init branch resets the version")
+				require.NoError(t, db.Create(reused).Error)
+				require.EqualValues(t, 1, reused.Version)
+				found := &TestModel{Version: 7}
+				require.NoError(t, optimistic.FirstOrInit(db.Where(TestModel{Description: "first or init"}), found))
+				require.Equal(t, reused.ID, found.ID)
+				require.EqualValues(t, 1, found.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ConflictErrorsCarryKeysAndVersions"), func(t *testing.T) {
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+
+				var ce *optimistic.ConflictError
+				stale := &TestModel{ID: m.ID, Description: "baz", Version: 1}
+				err := db.Updates(stale).Error
+				require.ErrorAs(t, err, &ce)
+				require.Equal(t, map[string]any{"id": m.ID}, ce.PrimaryKey)
+				require.EqualValues(t, 1, ce.ExpectedVersion)
+				require.EqualValues(t, 2, ce.CurrentVersion)
+				require.Nil(t, ce.Current)
+
+				err = db.Delete(stale).Error
+				require.ErrorAs(t, err, &ce)
+				require.Equal(t, map[string]any{"id": m.ID}, ce.PrimaryKey)
+				require.EqualValues(t, 1, ce.ExpectedVersion)
+				require.EqualValues(t, 2, ce.CurrentVersion)
+
+				natural := &TestModelNaturalKey{Scope: "conflict", Name: "a", Description: "foo"}
+				require.NoError(t, db.Create(natural).Error)
+				gone := *natural
+				require.NoError(t, db.Delete(natural).Error)
+				gone.Description = "bar"
+				err = db.Updates(&gone).Error
+				require.ErrorAs(t, err, &ce)
+				require.Equal(t, map[string]any{"scope": "conflict", "name": "a"}, ce.PrimaryKey)
+				require.Nil(t, ce.CurrentVersion, "the row no longer exists")
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "DeleteReturningFillsModels"), func(t *testing.T) {
+				if fs, _ := optimistic.FeaturesOf(db); !fs.Returning {
+					t.Skip("RETURNING is not supported")
+				}
+				m := &TestModel{Description: "foo", Code: 7}
+				require.NoError(t, db.Create(m).Error)
+				other := &TestModel{}
+				require.NoError(t, db.First(other, m.ID).Error)
+				other.Description = "bar"
+				require.NoError(t, db.Updates(other).Error)
+
+				stale := &TestModel{ID: m.ID, Version: 1}
+				err := db.Clauses(clause.Returning{}).Delete(stale).Error
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock)
+				require.Equal(t, "bar", stale.Description, "expected the surviving row")
+				require.EqualValues(t, 2, stale.Version)
+				require.EqualValues(t, 7, stale.Code)
+				var ce *optimistic.ConflictError
+				require.ErrorAs(t, err, &ce)
+				require.EqualValues(t, 2, ce.CurrentVersion)
+
+				deleted := &TestModel{ID: m.ID, Version: 2}
+				require.NoError(t, db.Clauses(clause.Returning{}).Delete(deleted).Error)
+				require.Equal(t, "bar", deleted.Description, "expected the deleted row")
+				require.EqualValues(t, 7, deleted.Code)
+				require.ErrorIs(t, db.First(&TestModel{}, m.ID).Error, gorm.ErrRecordNotFound)
+
+				resolving := &TestModel{Description: "baz"}
+				require.NoError(t, db.Create(resolving).Error)
+				require.NoError(t, db.Model(&TestModel{ID: resolving.ID, Version: 1}).Update("code", 3).Error)
+				stale = &TestModel{ID: resolving.ID, Version: 1}
+				err = db.Clauses(clause.Returning{}, optimistic.Conflict{OnVersionMismatch: func(current any, _ map[string]optimistic.Change) any {
+					return current
+				}}).Delete(stale).Error
+				require.NoError(t, err)
+				require.EqualValues(t, 3, stale.Code, "expected the row deleted on resolution")
+				require.ErrorIs(t, db.First(&TestModel{}, resolving.ID).Error, gorm.ErrRecordNotFound)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "MembersGuardJoinRows"), func(t *testing.T) {
+				require.NoError(t, db.SetupJoinTable(&TestModelTeam{}, "Players", &TestModelTeamPlayer{}))
+				require.NoError(t, db.AutoMigrate(&TestModelTeam{}, &TestModelPlayer{}, &TestModelTeamPlayer{}))
+
+				team := &TestModelTeam{Name: "red"}
+				require.NoError(t, db.Create(team).Error)
+				ann, bob := &TestModelPlayer{Name: "ann"}, &TestModelPlayer{Name: "bob"}
+				require.NoError(t, optimistic.Members(db, team, "Players").Append(ann, bob))
+				require.Len(t, team.Players, 2)
+				var join TestModelTeamPlayer
+				require.NoError(t, db.Where("test_model_player_id = ?", ann.ID).First(&join).Error)
+				require.EqualValues(t, 1, join.Version)
+
+				// another writer adds and removes players meanwhile
+				other := &TestModelTeam{}
+				require.NoError(t, db.Preload("Players").First(other, team.ID).Error)
+				cid := &TestModelPlayer{Name: "cid"}
+				require.NoError(t, optimistic.Members(db, other, "Players").Append(cid))
+				require.NoError(t, optimistic.Members(db, other, "Players").Delete(bob))
+
+				err := optimistic.Members(db, team, "Players").Append(cid)
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock, "cid joined already")
+				err = optimistic.Members(db, team, "Players").Delete(bob)
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock, "bob left already")
+				err = optimistic.Members(db, team, "Players").Replace(ann)
+				require.ErrorIs(t, err, optimistic.ErrOptimisticLock, "team holds stale players")
+				require.Equal(t, 2, int(db.Model(team).Association("Players").Count()), "failed changes roll back")
+
+				require.NoError(t, db.Preload("Players").First(team, team.ID).Error)
+				dan := &TestModelPlayer{Name: "dan"}
+				require.NoError(t, optimistic.Members(db, team, "Players").Replace(ann, dan))
+				var stored []TestModelPlayer
+				require.NoError(t, db.Model(team).Association("Players").Find(&stored))
+				require.ElementsMatch(t, []string{"ann", "dan"}, []string{stored[0].Name, stored[1].Name})
+
+				err = optimistic.Members(db, &TestModel{ID: 1}, "Players").Append(ann)
+				require.ErrorIs(t, err, gorm.ErrUnsupportedRelation)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "NoopUpdatesAreSkipped"), func(t *testing.T) {
+				idle, _ := setupDatabase(tt, true)
+				require.NoError(t, idle.Use(optimistic.NewOptimisticLock(optimistic.WithSkipNoopUpdates())))
+
+				m := &TestModel{Description: "foo", Code: 7}
+				require.NoError(t, idle.Create(m).Error)
+				res := idle.Updates(m)
+				require.NoError(t, res.Error)
+				require.True(t, optimistic.Unchanged(res))
+				require.EqualValues(t, 1, m.Version)
+				res = idle.Model(m).Updates(map[string]any{"description": "foo", "code": 7})
+				require.NoError(t, res.Error)
+				require.True(t, optimistic.Unchanged(res))
+				stored := &TestModel{}
+				require.NoError(t, idle.First(stored, m.ID).Error)
+				require.EqualValues(t, 1, stored.Version, "expected the version to stay")
+
+				m.Description = "bar"
+				res = idle.Updates(m)
+				require.NoError(t, res.Error)
+				require.False(t, optimistic.Unchanged(res))
+				require.EqualValues(t, 2, m.Version)
+
+				stale := &TestModel{ID: m.ID, Description: "bar", Code: 7, Version: 1}
+				require.ErrorIs(t, idle.Updates(stale).Error, optimistic.ErrOptimisticLock, "stale rows still conflict")
+
+				res = idle.Model(m).Update("code", gorm.Expr("code"))
+				require.NoError(t, res.Error)
+				require.False(t, optimistic.Unchanged(res), "expressions always run")
+				require.EqualValues(t, 3, m.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "VersionerTypesVersionFields"), func(t *testing.T) {
+				m := &TestModelCustomVersion{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				require.Equal(t, Edition("e1"), m.Edition)
+
+				stale := *m
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+				require.Equal(t, Edition("e2"), m.Edition)
+				stored := &TestModelCustomVersion{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.Equal(t, Edition("e2"), stored.Edition)
+
+				stale.Description = "baz"
+				require.ErrorIs(t, db.Updates(&stale).Error, optimistic.ErrOptimisticLock)
+				require.ErrorIs(t, db.Delete(&stale).Error, optimistic.ErrOptimisticLock)
+				require.NoError(t, db.Model(m).Update("description", "qux").Error)
+				require.Equal(t, Edition("e3"), m.Edition)
+
+				fs, _ := optimistic.FeaturesOf(db)
+				require.Equal(t, optimistic.StrategyCustom, fs.Tables["test_model_custom_versions"])
+				strategy, err := optimistic.ParseStrategy("custom")
+				require.NoError(t, err)
+				require.Equal(t, optimistic.StrategyCustom, strategy)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "StringVersionFields"), func(t *testing.T) {
+				m := &TestModelStringVersion{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				require.Len(t, m.Revision, 32)
+				first := m.Revision
+
+				stale := *m
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+				require.Len(t, m.Revision, 32)
+				require.NotEqual(t, first, m.Revision)
+				stale.Description = "baz"
+				require.ErrorIs(t, db.Updates(&stale).Error, optimistic.ErrOptimisticLock)
+
+				var n atomic.Int64
+				revs, _ := setupDatabase(tt, true)
+				require.NoError(t, revs.Use(optimistic.NewOptimisticLock(optimistic.WithStringGenerator(
+					func(time.Time, io.Reader, map[string]string) (any, error) {
+						return fmt.Sprintf("rev-%d", n.Add(1)), nil
+					}))))
+				g := &TestModelStringVersion{Description: "foo"}
+				require.NoError(t, revs.Create(g).Error)
+				require.Equal(t, "rev-1", g.Revision)
+				require.NoError(t, revs.Model(g).Update("description", "bar").Error)
+				require.Equal(t, "rev-2", g.Revision)
+				stored := &TestModelStringVersion{}
+				require.NoError(t, revs.First(stored, g.ID).Error)
+				require.Equal(t, "rev-2", stored.Revision)
+
+				exhausted := errors.New("revisions exhausted")
+				fail := false
+				failing, _ := setupDatabase(tt, true)
+				require.NoError(t, failing.Use(optimistic.NewOptimisticLock(optimistic.WithStringGenerator(
+					func(time.Time, io.Reader, map[string]string) (any, error) {
+						if fail {
+							return nil, exhausted
+						}
+						return "rev-1", nil
+					}))))
+				f := &TestModelStringVersion{Description: "foo"}
+				require.NoError(t, failing.Create(f).Error)
+				fail = true
+				require.ErrorIs(t, failing.Model(f).Update("description", "bar").Error, exhausted)
+				require.ErrorIs(t, failing.Create(&TestModelStringVersion{Description: "baz"}).Error, exhausted)
+				stored = &TestModelStringVersion{}
+				require.NoError(t, failing.First(stored, f.ID).Error)
+				require.Equal(t, "foo", stored.Description)
+				require.Equal(t, "rev-1", stored.Revision)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "KSUIDVersionFields"), func(t *testing.T) {
+				m := &TestModelKSUIDVersion{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				require.False(t, m.Version.IsNil())
+				first := m.Version
+
+				stale := *m
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+				require.NotEqual(t, first, m.Version)
+				stale.Description = "baz"
+				require.ErrorIs(t, db.Updates(&stale).Error, optimistic.ErrOptimisticLock)
+
+				stored := &TestModelKSUIDVersion{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.Equal(t, m.Version, stored.Version)
+				require.Equal(t, "bar", stored.Description)
+
+				fs, _ := optimistic.FeaturesOf(db)
+				require.Equal(t, optimistic.StrategyKSUID, fs.Tables["test_model_ksuid_versions"])
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "XIDVersionFields"), func(t *testing.T) {
+				m := &TestModelXIDVersion{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				require.False(t, m.Version.IsNil())
+				first := m.Version
+
+				stale := *m
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+				require.NotEqual(t, first, m.Version)
+				stale.Description = "baz"
+				require.ErrorIs(t, db.Updates(&stale).Error, optimistic.ErrOptimisticLock)
+
+				stored := &TestModelXIDVersion{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.Equal(t, m.Version, stored.Version)
+				require.Equal(t, "bar", stored.Description)
+
+				// a model whose version was never loaded is not updated
+				unloaded := &TestModelXIDVersion{ID: m.ID, Description: "qux"}
+				require.ErrorIs(t, db.Updates(unloaded).Error, optimistic.ErrOptimisticLock)
+
+				fs, _ := optimistic.FeaturesOf(db)
+				require.Equal(t, optimistic.StrategyXID, fs.Tables["test_model_xid_versions"])
+				strategy, err := optimistic.ParseStrategy("xid")
+				require.NoError(t, err)
+				require.Equal(t, optimistic.StrategyXID, strategy)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "SnowflakeVersions"), func(t *testing.T) {
+				m := &TestModelSnowflakeVersion{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				require.Greater(t, m.Version, int64(1))
+				first := m.Version
+
+				stale := *m
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+				require.Greater(t, m.Version, first)
+				stale.Description = "baz"
+				require.ErrorIs(t, db.Updates(&stale).Error, optimistic.ErrOptimisticLock)
+
+				fs, _ := optimistic.FeaturesOf(db)
+				require.Equal(t, optimistic.StrategySnowflake, fs.Tables["test_model_snowflake_versions"])
+
+				// the option generates the IDs of tagged fields on its node and leaves counters alone
+				flakes, _ := setupDatabase(tt, true)
+				require.NoError(t, flakes.Use(optimistic.NewOptimisticLock(optimistic.WithSnowflakeVersions(3))))
+				g := &TestModelSnowflakeVersion{Description: "foo"}
+				require.NoError(t, flakes.Create(g).Error)
+				seeded := g.Version
+				require.Equal(t, int64(3), seeded>>12&1023)
+				for _, d := range []string{"bar", "baz", "qux"} {
+					prev := g.Version
+					require.NoError(t, flakes.Model(g).Update("description", d).Error)
+					require.Greater(t, g.Version, prev)
+				}
+				stored := &TestModelSnowflakeVersion{}
+				require.NoError(t, flakes.First(stored, g.ID).Error)
+				require.Equal(t, g.Version, stored.Version)
+				old := &TestModelSnowflakeVersion{ID: g.ID, Description: "stale", Version: seeded}
+				require.ErrorIs(t, flakes.Updates(old).Error, optimistic.ErrOptimisticLock)
+
+				counted := &TestModel{Description: "foo"}
+				require.NoError(t, flakes.Create(counted).Error)
+				require.EqualValues(t, 1, counted.Version)
+				counted.Description = "bar"
+				require.NoError(t, flakes.Updates(counted).Error)
+				require.EqualValues(t, 2, counted.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "UnixNanoVersions"), func(t *testing.T) {
+				before := time.Now().UnixNano()
+				m := &TestModelUnixNanoVersion{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				require.GreaterOrEqual(t, m.Version, before)
+				first := m.Version
+
+				stale := *m
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+				require.Greater(t, m.Version, first)
+				stale.Description = "baz"
+				require.ErrorIs(t, db.Updates(&stale).Error, optimistic.ErrOptimisticLock)
+
+				stored := &TestModelUnixNanoVersion{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.Equal(t, m.Version, stored.Version)
+
+				fs, _ := optimistic.FeaturesOf(db)
+				require.Equal(t, optimistic.StrategyUnixNano, fs.Tables["test_model_unix_nano_versions"])
+
+				// a clock that does not move still changes the version
+				at := time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)
+				frozen, _ := setupDatabase(tt, true)
+				require.NoError(t, frozen.Use(optimistic.NewOptimisticLock(optimistic.WithClock(func() time.Time { return at }))))
+				g := &TestModelUnixNanoVersion{Description: "foo"}
+				require.NoError(t, frozen.Create(g).Error)
+				require.Equal(t, at.UnixNano(), g.Version)
+				require.NoError(t, frozen.Model(g).Update("description", "bar").Error)
+				require.Equal(t, at.UnixNano()+1, g.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "HLCVersions"), func(t *testing.T) {
+				m := &TestModelHLCVersion{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				require.NotZero(t, m.Version)
+				stale := *m
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+				require.Greater(t, m.Version, stale.Version)
+				stale.Description = "baz"
+				require.ErrorIs(t, db.Updates(&stale).Error, optimistic.ErrOptimisticLock)
+
+				fs, _ := optimistic.FeaturesOf(db)
+				require.Equal(t, optimistic.StrategyHLC, fs.Tables["test_model_hlc_versions"])
+
+				// writes within one millisecond, or after the clock stepped back, advance the counter
+				at := time.Date(2030, time.May, 1, 0, 0, 0, 0, time.UTC)
+				now := at
+				frozen, _ := setupDatabase(tt, true)
+				require.NoError(t, frozen.Use(optimistic.NewOptimisticLock(optimistic.WithClock(func() time.Time { return now }))))
+				g := &TestModelHLCVersion{Description: "foo"}
+				require.NoError(t, frozen.Create(g).Error)
+				require.True(t, at.Equal(g.Version.Time()))
+				require.Zero(t, g.Version.Counter())
+				for i, d := range []string{"bar", "baz"} {
+					require.NoError(t, frozen.Model(g).Update("description", d).Error)
+					require.True(t, at.Equal(g.Version.Time()))
+					require.EqualValues(t, i+1, g.Version.Counter())
+				}
+				now = at.Add(-time.Second)
+				prev := g.Version
+				require.NoError(t, frozen.Model(g).Update("description", "qux").Error)
+				require.Greater(t, g.Version, prev)
+				now = at.Add(time.Second)
+				require.NoError(t, frozen.Model(g).Update("description", "quux").Error)
+				require.True(t, now.Equal(g.Version.Time()))
+				require.Zero(t, g.Version.Counter())
+
+				stored := &TestModelHLCVersion{}
+				require.NoError(t, frozen.First(stored, g.ID).Error)
+				require.Equal(t, g.Version, stored.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "SequenceVersions"), func(t *testing.T) {
+				tag, err := optimistic.ParseVersionTag("sequence=test_model_versions_seq")
+				require.NoError(t, err)
+				require.Equal(t, optimistic.StrategySequence, tag.Strategy)
+				require.Equal(t, "test_model_versions_seq", tag.Params[optimistic.ParamSequence])
+				_, err = optimistic.ParseVersionTag("sequence")
+				require.ErrorIs(t, err, optimistic.ErrInvalidVersionTag)
+				tag, err = optimistic.ParseVersionTag("sequence=app.order_versions")
+				require.NoError(t, err)
+				require.Equal(t, "app.order_versions", tag.Params[optimistic.ParamSequence])
+				for _, name := range []string{"seq FROM DUAL; DROP TABLE test_models --", "1seq", "a.b.c", "seq()"} {
+					_, err = optimistic.ParseVersionTag("sequence=" + name)
+					require.ErrorIs(t, err, optimistic.ErrInvalidVersionTag, name)
+				}
+
+				switch db.Dialector.Name() {
+				case "postgres", "oracle":
+				default:
+					require.ErrorIs(t, db.Create(&TestModelSequenceVersion{Description: "foo"}).Error, optimistic.ErrInvalidVersionTag)
+					return
+				}
+				_ = db.Exec("DROP SEQUENCE test_model_versions_seq").Error
+				require.NoError(t, db.Exec("CREATE SEQUENCE test_model_versions_seq").Error)
+				require.NoError(t, db.Migrator().DropTable(&TestModelSequenceVersion{}))
+				require.NoError(t, db.AutoMigrate(&TestModelSequenceVersion{}))
+
+				m := &TestModelSequenceVersion{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				require.Positive(t, m.Version)
+				stale := *m
+				for _, d := range []string{"bar", "baz"} {
+					prev := m.Version
+					require.NoError(t, db.Model(m).Update("description", d).Error)
+					require.Greater(t, m.Version, prev)
+				}
+				stale.Description = "qux"
+				require.ErrorIs(t, db.Updates(&stale).Error, optimistic.ErrOptimisticLock)
+
+				stored := &TestModelSequenceVersion{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.Equal(t, m.Version, stored.Version)
+				if db.Dialector.Name() == "postgres" {
+					var drawn int64
+					require.NoError(t, db.Raw("SELECT last_value FROM test_model_versions_seq").Scan(&drawn).Error)
+					require.Equal(t, drawn, m.Version, "the version is the sequence's last value")
+				}
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "XminVersions"), func(t *testing.T) {
+				dry, _ := openStandInConn(tt, testPostgres, optimistic.WithDryRunGuards())
+				sess := dry.Session(&gorm.Session{DryRun: true})
+				stmt := sess.First(&TestModelXminVersion{}, 1).Statement
+				require.Equal(t, `SELECT "test_model_xmin_versions"."id","test_model_xmin_versions"."description","test_model_xmin_versions"."xmin" FROM "test_model_xmin_versions" WHERE "test_model_xmin_versions"."id" = $1 ORDER BY "test_model_xmin_versions"."id" LIMIT $2`, stmt.SQL.String(), "queries list xmin, which SELECT * leaves out")
+				stmt = sess.Select("description").First(&TestModelXminVersion{}, 1).Statement
+				require.Equal(t, `SELECT "description" FROM "test_model_xmin_versions" WHERE "test_model_xmin_versions"."id" = $1 ORDER BY "test_model_xmin_versions"."id" LIMIT $2`, stmt.SQL.String(), "selects are kept")
+				stmt = sess.Updates(&TestModelXminVersion{ID: 1, Description: "bar", Version: 7}).Statement
+				require.NoError(t, stmt.Error)
+				require.Equal(t, `UPDATE "test_model_xmin_versions" SET "description"=$1 WHERE "test_model_xmin_versions"."id" = $2 AND "test_model_xmin_versions"."xmin" = $3 RETURNING "test_model_xmin_versions"."id","test_model_xmin_versions"."description","test_model_xmin_versions"."xmin"`, stmt.SQL.String())
+				require.Equal(t, []any{"bar", uint64(1), uint32(7)}, stmt.Vars)
+				stmt = sess.Delete(&TestModelXminVersion{ID: 1, Version: 7}).Statement
+				require.NoError(t, stmt.Error)
+				require.Equal(t, `DELETE FROM "test_model_xmin_versions" WHERE "test_model_xmin_versions"."xmin" = $1 AND "test_model_xmin_versions"."id" = $2`, stmt.SQL.String())
+
+				postgresDb, conn := openStandInConn(tt, testPostgres)
+				conn.reply = "SELECT 1 AS id, 'bar' AS description, 8 AS xmin"
+				written := &TestModelXminVersion{ID: 1, Description: "bar", Version: 7}
+				require.NoError(t, postgresDb.Updates(written).Error)
+				require.EqualValues(t, 8, written.Version, "the update reads back the xmin it caused")
+				conn.reply = "SELECT 1 AS id, 'baz' AS description, 9 AS xmin WHERE 0 = 1"
+				conflicting := &TestModelXminVersion{ID: 1, Description: "baz", Version: 7}
+				require.ErrorIs(t, postgresDb.Updates(conflicting).Error, optimistic.ErrOptimisticLock, "no row returned is a conflict")
+				require.EqualValues(t, 7, conflicting.Version)
+
+				if db.Dialector.Name() != "postgres" {
+					require.ErrorIs(t, db.Create(&TestModelXminVersion{Description: "foo"}).Error, optimistic.ErrInvalidVersionTag)
+					return
+				}
+				require.NoError(t, db.Migrator().DropTable(&TestModelXminVersion{}))
+				require.NoError(t, db.AutoMigrate(&TestModelXminVersion{}))
+
+				m := &TestModelXminVersion{Description: "foo"}
+				require.NoError(t, db.Create(m).Error)
+				require.NotZero(t, m.Version)
+				stale := *m
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+				require.NotEqual(t, stale.Version, m.Version)
+				stale.Description = "baz"
+				require.ErrorIs(t, db.Updates(&stale).Error, optimistic.ErrOptimisticLock)
+
+				stored := &TestModelXminVersion{}
+				require.NoError(t, db.First(stored, m.ID).Error)
+				require.Equal(t, m.Version, stored.Version)
+				require.Equal(t, "bar", stored.Description)
+
+				touched := m.Version
+				require.NoError(t, optimistic.Touch(db, m))
+				require.NotEqual(t, touched, m.Version)
+				require.ErrorIs(t, db.Delete(&stale).Error, optimistic.ErrOptimisticLock)
+				require.NoError(t, db.Delete(m).Error)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "RowVersions"), func(t *testing.T) {
+				var token optimistic.RowVersion
+				require.NoError(t, token.Scan([]byte{0, 0, 0, 0, 0, 0, 0x07, 0xd1}))
+				require.Equal(t, optimistic.RowVersion(2001), token)
+				stored, err := token.Value()
+				require.NoError(t, err)
+				require.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0x07, 0xd1}, stored)
+				require.Error(t, token.Scan([]byte{1}))
+				require.Equal(t, "rowversion", optimistic.ColumnType("sqlserver", optimistic.StrategyRowVersion))
+
+				require.ErrorIs(t, db.Create(&TestModelRowVersion{Description: "foo"}).Error, optimistic.ErrInvalidVersionTag)
+
+				dry, _ := openStandInConn(tt, testSqlserver, optimistic.WithDryRunGuards())
+				m := &TestModelRowVersion{ID: 1, Description: "bar", Version: 7}
+				stmt := dry.Session(&gorm.Session{DryRun: true}).Updates(m).Statement
+				require.NoError(t, stmt.Error)
+				require.Equal(t, `UPDATE "test_model_row_versions" SET "description"=@p1 OUTPUT INSERTED."version" WHERE "test_model_row_versions"."id" = @p2 AND "test_model_row_versions"."version" = @p3`, stmt.SQL.String())
+				require.Equal(t, optimistic.RowVersion(7), stmt.Vars[2])
+				require.Equal(t, optimistic.RowVersion(7), m.Version, "a dry run reads nothing back")
+
+				sqlserverDb, conn := openStandInConn(tt, testSqlserver)
+				conn.reply = "SELECT x'0000000000000008'"
+				require.NoError(t, sqlserverDb.Updates(m).Error)
+				require.Equal(t, optimistic.RowVersion(8), m.Version, "the update reads back the token it caused")
+				require.Contains(t, conn.statements[len(conn.statements)-1], `OUTPUT INSERTED."version"`)
+
+				stale := &TestModelRowVersion{ID: 1, Description: "baz", Version: 7}
+				conn.reply = "SELECT x'0000000000000009' WHERE 0 = 1"
+				require.ErrorIs(t, sqlserverDb.Updates(stale).Error, optimistic.ErrOptimisticLock, "no row output is a conflict")
+				require.Equal(t, optimistic.RowVersion(7), stale.Version)
+
+				conn.statements = nil
+				require.ErrorIs(t, sqlserverDb.Delete(stale).Error, optimistic.ErrOptimisticLock)
+				require.Equal(t, `DELETE FROM "test_model_row_versions" WHERE "test_model_row_versions"."version" = @p1 AND "test_model_row_versions"."id" = @p2`, conn.statements[0])
+				conn.affected = 1
+				require.NoError(t, sqlserverDb.Delete(m).Error)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "DeleteResolvesWrappedConflicts"), func(t *testing.T) {
+				wrapping, _ := setupDatabase(tt, true)
+				require.NoError(t, wrapping.Use(optimistic.NewOptimisticLock()))
+				require.NoError(t, wrapping.Callback().Delete().Before("optimistic:resolve_delete").Register("test:wrap_conflict", func(db *gorm.DB) {
+					if errors.Is(db.Error, optimistic.ErrOptimisticLock) {
+						db.Error = fmt.Errorf("deleting: %w", db.Error)
+					}
+				}))
+
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, wrapping.Create(m).Error)
+				stale := &TestModel{ID: m.ID, Version: m.Version}
+				m.Description = "bar"
+				require.NoError(t, wrapping.Updates(m).Error)
+
+				err := wrapping.Clauses(optimistic.Conflict{
+					OnVersionMismatch: func(current any, _ map[string]optimistic.Change) any { return current },
+				}).Delete(stale).Error
+				require.NoError(t, err, "wrapped conflicts are resolved too")
+				require.ErrorIs(t, wrapping.First(&TestModel{}, m.ID).Error, gorm.ErrRecordNotFound)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ParallelUpdatePointerItems"), func(t *testing.T) {
+				items := make([]*TestModel, 6)
+				for i := range items {
+					items[i] = &TestModel{Description: fmt.Sprintf("item %d", i)}
+					require.NoError(t, db.Create(items[i]).Error)
+				}
+				held := items[0]
+				// the first row moves on behind the batch's back
+				other := *items[0]
+				require.NoError(t, db.Model(&other).Update("code", 99).Error)
+				for _, item := range items {
+					item.Description += " done"
+				}
+
+				results, err := optimistic.ParallelUpdate(context.Background(), db, items, 3, optimistic.BulkPolicy[*TestModel]{
+					Attempts: 2,
+					Merge: func(_ context.Context, item, current **TestModel) **TestModel {
+						(*current).Description = (*item).Description
+						return current
+					},
+				})
+				require.NoError(t, err)
+				require.Equal(t, 2, results[0].Attempts)
+				for _, item := range items[1:] {
+					require.EqualValues(t, 2, item.Version)
+				}
+				for i, item := range items {
+					fresh := &TestModel{ID: item.ID}
+					require.NoError(t, db.First(fresh).Error)
+					require.Equal(t, fmt.Sprintf("item %d done", i), fresh.Description)
+				}
+				require.Same(t, held, items[0], "pointer items are updated in place")
+				require.EqualValues(t, 99, held.Code)
+				require.EqualValues(t, 3, held.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "GeneratorFailuresFailTheWrite"), func(t *testing.T) {
+				drained := errors.New("entropy drained")
+				failing, _ := setupDatabase(tt, true)
+				m := &TestModelUUIDVersion{ID: 9001, Description: "foo", Version: uuid.New()}
+				require.NoError(t, failing.Create(m).Error)
+				require.NoError(t, failing.Use(optimistic.NewOptimisticLock(optimistic.WithUUIDSource(iotest.ErrReader(drained)))))
+
+				require.ErrorIs(t, failing.Create(&TestModelUUIDVersion{ID: 9002, Description: "bar"}).Error, drained)
+				require.ErrorIs(t, failing.First(&TestModelUUIDVersion{}, 9002).Error, gorm.ErrRecordNotFound)
+				version := m.Version
+				require.ErrorIs(t, failing.Model(m).Update("description", "bar").Error, drained, "the failing source is not swapped for another")
+				stored := &TestModelUUIDVersion{}
+				require.NoError(t, failing.First(stored, m.ID).Error)
+				require.Equal(t, "foo", stored.Description)
+				require.Equal(t, version, stored.Version)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "RekeyIntToUUID"), func(t *testing.T) {
+				require.NoError(t, db.Migrator().DropTable(&TestModelRekeyCounter{}))
+				require.NoError(t, optimistic.AutoMigrate(db, &TestModelRekeyCounter{}))
+				// more rows than Rekey reads at a time
+				counters := make([]TestModelRekeyCounter, 1200)
+				for i := range counters {
+					counters[i].Description = fmt.Sprintf("row %d", i)
+				}
+				require.NoError(t, db.CreateInBatches(&counters, 200).Error)
+				require.NoError(t, db.Model(&counters[0]).Update("description", "moved").Error)
+				require.EqualValues(t, 2, counters[0].Version)
+
+				require.ErrorIs(t, optimistic.Rekey(db, &TestModelRekeyCounter{}, optimistic.StrategyInt, optimistic.StrategyUUID), optimistic.ErrVersionTagMismatch)
+				require.NoError(t, optimistic.Rekey(db, &TestModelRekeyUUID{}, optimistic.StrategyInt, optimistic.StrategyUUID))
+				require.False(t, db.Migrator().HasColumn(&TestModelRekeyUUID{}, "version_rekey"), "the shadow column replaced the version column")
+
+				var rekeyed []TestModelRekeyUUID
+				require.NoError(t, db.Order("id").Find(&rekeyed).Error)
+				require.Len(t, rekeyed, len(counters))
+				seen := make(map[uuid.UUID]bool, len(rekeyed))
+				for _, row := range rekeyed {
+					require.NotEqual(t, uuid.Nil, row.Version)
+					require.False(t, seen[row.Version], "every row has a version of its own")
+					seen[row.Version] = true
+				}
+				require.Equal(t, "moved", rekeyed[0].Description)
+
+				require.NoError(t, optimistic.AutoMigrate(db, &TestModelRekeyUUID{}))
+				m := &rekeyed[1]
+				stale := *m
+				m.Description = "bar"
+				require.NoError(t, db.Updates(m).Error)
+				require.NotEqual(t, stale.Version, m.Version)
+				stale.Description = "baz"
+				require.ErrorIs(t, db.Updates(&stale).Error, optimistic.ErrOptimisticLock)
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "TimeVersionsMatchAtTheColumnPrecision"), func(t *testing.T) {
+				written := time.Date(2026, 10, 16, 12, 0, 0, 123456789, time.UTC)
+				postgresDb, conn := openStandInConn(tt, testPostgres, optimistic.WithClock(func() time.Time { return written }))
+				_, err := conn.db.Exec("CREATE TABLE replies (id integer, description text, version datetime)")
+				require.NoError(t, err)
+				conn.reply = "SELECT id, description, version FROM replies"
+				for _, c := range []struct {
+					stored time.Time
+					match  bool
+				}{
+					{written.Truncate(time.Microsecond), true},
+					{written.Round(time.Microsecond), true},
+					{written.Truncate(time.Millisecond), false},
+					{written.Truncate(time.Second), false},
+				} {
+					_, err = conn.db.Exec("DELETE FROM replies")
+					require.NoError(t, err)
+					_, err = conn.db.Exec("INSERT INTO replies VALUES (1, 'bar', ?)", c.stored)
+					require.NoError(t, err)
+					m := &TestPostgresModelTimeVersion{ID: 1, Description: "bar", Version: written.Add(-time.Hour)}
+					err = postgresDb.Updates(m).Error
+					if c.match {
+						require.NoError(t, err, "timestamptz keeps microseconds")
+					} else {
+						require.ErrorIs(t, err, optimistic.ErrOptimisticLock, "%s is not what a microsecond column keeps of %s", c.stored, written)
+					}
+				}
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "ReconcileBacksOffBrokenQueues"), func(t *testing.T) {
+				queue := &brokenQueue{}
+				broken, _ := setupDatabase(tt, true)
+				require.NoError(t, broken.Use(optimistic.NewOptimisticLock(optimistic.WithReconciliation(queue, 3))))
+
+				ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+				defer cancel()
+				require.NoError(t, optimistic.Reconcile(ctx, broken, 1))
+				// 10ms doubling fits about five attempts into 300ms
+				require.Less(t, queue.dequeues.Load(), int64(10))
+				require.Greater(t, queue.dequeues.Load(), int64(1))
+			})
+
+			t.Run(fmt.Sprintf("%s-%s", testDatabaseName, "GetForUpdateLeavesTheHandleAlone"), func(t *testing.T) {
+				postgresDb, conn := openStandInConn(tt, testPostgres)
+				conn.affected = 1
+				err := postgresDb.Transaction(func(tx *gorm.DB) error {
+					ctx := tx.Statement.Context
+					conn.reply = "SELECT 1 AS id, 'foo' AS description, 0 AS code, false AS enabled, 1 AS version"
+					locked := &TestModel{ID: 1}
+					require.NoError(t, optimistic.GetForUpdate(tx, locked))
+					require.True(t, ctx == tx.Statement.Context, "the handle keeps its context")
+					require.NoError(t, optimistic.GetForUpdate(tx, locked))
+
+					conn.statements = nil
+					conn.reply = "SELECT 1 AS id, 'bar' AS description, 0 AS code, false AS enabled, 2 AS version"
+					locked.Description = "bar"
+					require.NoError(t, tx.Updates(locked).Error)
+					require.EqualValues(t, 2, locked.Version)
+					require.NotContains(t, conn.statements[0][strings.Index(conn.statements[0], "WHERE"):], "version", "the lock guards the row")
+
+					// a transaction remembers only the rows it locked itself
+					return postgresDb.Transaction(func(other *gorm.DB) error {
+						conn.statements = nil
+						conn.reply = "SELECT 1 AS id, 'baz' AS description, 0 AS code, false AS enabled, 3 AS version"
+						locked.Description = "baz"
+						require.NoError(t, other.Updates(locked).Error)
+						require.Contains(t, conn.statements[0][strings.Index(conn.statements[0], "WHERE"):], "version")
+						return nil
+					})
+				})
+				require.NoError(t, err)
+
+				// the plugin's transactions take prepared statements
+				prepared := db.Session(&gorm.Session{PrepareStmt: true})
+				m := &TestModel{Description: "foo"}
+				require.NoError(t, prepared.Create(m).Error)
+				require.NoError(t, prepared.Transaction(func(tx *gorm.DB) error {
+					m.Description = "bar"
+					return tx.Updates(m).Error
+				}))
+				require.EqualValues(t, 2, m.Version)
+			})
+
+		})
+	}
+}
+
+func TestOptimisticLockingSuite(f *testing.T) {
+	l := slog.Default()
+	for _, db := range dbs {
+		switch db {
+		case testSqlite:
+		case testSqlserver:
+			// the suite's models use types SQL Server lacks; TestSqlserverSuite covers it
+			continue
+		default:
+			setupDatabase(&errorF{l: l, db: db})
+		}
+		f.Run("OptimisticLockSuite", runSuite(db))
+		switch db {
+		case testSqlite:
+		default:
+			if _, container := findDbContextInfo(testDbContexts[db]); container != nil {
+				_ = container.Terminate(testDbContexts[db])
+			}
+		}
+	}
+}
+
+// TestSqlserverSuite runs the rowversion models against SQL Server, the only database
+// that has them.
+func TestSqlserverSuite(f *testing.T) {
+	if !slices.Contains(dbs, testSqlserver) {
+		f.Skip("sqlserver is not among the databases")
+	}
+	tt := &errorF{l: slog.Default(), db: testSqlserver}
+	db, _ := setupDatabase(tt)
+	defer func() {
+		if _, container := findDbContextInfo(testDbContexts[testSqlserver]); container != nil {
+			_ = container.Terminate(testDbContexts[testSqlserver])
+		}
+	}()
+
+	f.Run("RowVersions", func(t *testing.T) {
+		m := &TestModelRowVersion{Description: "foo"}
+		require.NoError(t, db.Create(m).Error)
+		require.NotZero(t, m.Version)
+		stale := *m
+		m.Description = "bar"
+		require.NoError(t, db.Updates(m).Error)
+		require.Greater(t, m.Version, stale.Version)
+		stale.Description = "baz"
+		require.ErrorIs(t, db.Updates(&stale).Error, optimistic.ErrOptimisticLock)
+
+		loaded := &TestModelRowVersion{}
+		require.NoError(t, db.First(loaded, m.ID).Error)
+		require.Equal(t, m.Version, loaded.Version)
+		require.Equal(t, "bar", loaded.Description)
+
+		touched := m.Version
+		require.NoError(t, optimistic.Touch(db, m))
+		require.Greater(t, m.Version, touched)
+		require.ErrorIs(t, db.Delete(&stale).Error, optimistic.ErrOptimisticLock)
+		require.NoError(t, db.Delete(m).Error)
+	})
+
+	f.Run("RowVersionsRejectTriggers", func(t *testing.T) {
+		m := &TestModelRowVersion{Description: "foo"}
+		require.NoError(t, db.Create(m).Error)
+		require.NoError(t, db.Exec(`CREATE TRIGGER test_model_row_versions_audit ON test_model_row_versions AFTER UPDATE AS SET NOCOUNT ON`).Error)
+		defer func() {
+			require.NoError(t, db.Exec(`DROP TRIGGER test_model_row_versions_audit`).Error)
+		}()
+		m.Description = "bar"
+		err := db.Updates(m).Error
+		require.Error(t, err, "SQL Server rejects OUTPUT without INTO on tables with triggers")
+		require.Contains(t, err.Error(), "OUTPUT")
+	})
+}
+
+// BenchmarkUpdate compares guarded updates of the common numeric version and key model
+// with the same updates run without the plugin.
+func BenchmarkUpdate(b *testing.B) {
+	for _, guarded := range []bool{false, true} {
+		name := "plain"
+		if guarded {
+			name = "guarded"
+		}
+		b.Run(name+"/struct", func(b *testing.B) {
+			db := openBenchDatabase(b, guarded)
+			m := &TestModel{Description: "foo"}
+			require.NoError(b, db.Create(m).Error)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.Code = uint64(i) + 1
+				if err := db.Updates(m).Error; err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/map", func(b *testing.B) {
+			db := openBenchDatabase(b, guarded)
+			m := &TestModel{Description: "foo"}
+			require.NoError(b, db.Create(m).Error)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.Model(m).Updates(map[string]any{"code": i + 1}).Error; err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
 	}
 }
@@ -0,0 +1,46 @@
+// Package optimistictest provides helpers for testing code that uses the optimistic plugin.
+package optimistictest
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/cmmoran/optimistic"
+)
+
+// Epoch is the time the Deterministic clock starts at.
+var Epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Seed seeds the UUID and ULID sources of Deterministic.
+const Seed = 1
+
+// Deterministic returns options that make generated versions reproducible, so snapshots
+// of generated SQL and versions match across runs:
+//
+//	db.Use(optimistic.NewOptimisticLock(optimistictest.Deterministic()...))
+//
+// The clock starts at Epoch and advances one millisecond per reading, so consecutive time
+// versions still differ, and UUID and ULID versions are drawn from sources seeded with
+// Seed. Versions are only reproduced by the same sequence of writes; concurrent writes
+// interleave nondeterministically.
+func Deterministic() []optimistic.ConfigOption {
+	return []optimistic.ConfigOption{
+		optimistic.WithClock(NewClock(Epoch, time.Millisecond)),
+		optimistic.WithUUIDSource(rand.New(rand.NewSource(Seed))),
+		optimistic.WithULIDEntropy(rand.New(rand.NewSource(Seed + 1))),
+	}
+}
+
+// NewClock returns a clock starting at start that advances by step on every reading.
+func NewClock(start time.Time, step time.Duration) func() time.Time {
+	var mu sync.Mutex
+	next := start
+	return func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now := next
+		next = next.Add(step)
+		return now
+	}
+}
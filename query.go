@@ -0,0 +1,194 @@
+package optimistic
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	minVersionClauseName      = "optimistic:min_version"
+	assertedVersionClauseName = "optimistic:asserted_version"
+)
+
+// ErrStaleRead is returned (wrapped in a *StaleReadError) when a read guarded by
+// AtLeast only found rows older than the requested version.
+var ErrStaleRead = errors.New("stale read")
+
+// StaleReadError reports that the rows a query targets exist but none of them have
+// reached the minimum version, e.g. because the query was routed to a lagging replica.
+type StaleReadError struct {
+	Table      string
+	MinVersion any
+	// minVersion is MinVersion as rendered by the plugin's VersionStringer
+	minVersion string
+}
+
+func (e *StaleReadError) Error() string {
+	if e.minVersion != "" {
+		return fmt.Sprintf("%s: %s has no row at version >= %s", ErrStaleRead, e.Table, e.minVersion)
+	}
+	return fmt.Sprintf("%s: %s has no row at version >= %v", ErrStaleRead, e.Table, e.MinVersion)
+}
+
+func (e *StaleReadError) Unwrap() error { return ErrStaleRead }
+
+// MinVersion restricts a query to rows whose version is at least Version. Use AtLeast
+// to construct it:
+//
+//	err := db.Clauses(optimistic.AtLeast(m.Version)).First(&m).Error
+//	if errors.Is(err, optimistic.ErrStaleRead) {
+//		// retry against the primary
+//	}
+//
+// Only numeric, time and ULID versions have a meaningful ordering.
+type MinVersion struct {
+	Version any
+}
+
+// AtLeast returns a query clause requiring the loaded row's version to be >= version.
+func AtLeast(version any) MinVersion {
+	return MinVersion{Version: version}
+}
+
+func (x MinVersion) Name() string                 { return minVersionClauseName }
+func (x MinVersion) Build(clause.Builder)         {}
+func (x MinVersion) MergeClause(c *clause.Clause) { c.Expression = x }
+
+// minVersionGuard marks the predicate injected for MinVersion so it can be told
+// apart from the caller's own conditions.
+type minVersionGuard struct {
+	clause.Gte
+}
+
+// injectMinVersion adds `version >= ?` to queries carrying a MinVersion clause.
+func (p *Plugin) injectMinVersion(db *gorm.DB) {
+	if db.Error != nil || db.DryRun {
+		return
+	}
+	c, ok := db.Statement.Clauses[minVersionClauseName]
+	if !ok {
+		return
+	}
+	f := p.findVersionField(db.Statement.Schema)
+	if f == nil {
+		_ = db.AddError(fmt.Errorf("%w: %s", ErrNoVersionField, db.Statement.Table))
+		return
+	}
+	version, err := p.decodeVersion(db.Statement.Context, db.Statement.Table, c.Expression.(MinVersion).Version)
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{minVersionGuard{clause.Gte{
+		Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName},
+		Value:  version,
+	}}}})
+}
+
+// verifyMinVersion turns an empty result into a *StaleReadError when the rows exist
+// but were filtered out only by the MinVersion guard.
+func (p *Plugin) verifyMinVersion(db *gorm.DB) {
+	if db.DryRun || db.RowsAffected > 0 {
+		return
+	}
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		return
+	}
+	c, ok := db.Statement.Clauses[minVersionClauseName]
+	if !ok {
+		return
+	}
+	var exprs []clause.Expression
+	if wc, ok := db.Statement.Clauses[clause.Where{}.Name()]; ok {
+		if where, ok := wc.Expression.(clause.Where); ok {
+			for _, expr := range where.Exprs {
+				if _, guard := expr.(minVersionGuard); !guard {
+					exprs = append(exprs, expr)
+				}
+			}
+		}
+	}
+
+	var count int64
+	probe := db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+	// Must reset Error
+	probe.Error = nil
+	probe = probe.Table(db.Statement.Table)
+	if len(exprs) > 0 {
+		probe = probe.Clauses(clause.Where{Exprs: exprs})
+	}
+	if err := probe.Count(&count).Error; err != nil || count == 0 {
+		return
+	}
+	minVersion := c.Expression.(MinVersion).Version
+	db.Error = &StaleReadError{Table: db.Statement.Table, MinVersion: minVersion, minVersion: p.versionString(minVersion)}
+}
+
+// AssertedVersion fails a query whose rows are not at Version. Use AssertVersion to
+// construct it. It only checks reads; ExpectVersion, from Expect, guards writes.
+type AssertedVersion struct {
+	Version any
+}
+
+// AssertVersion returns a query clause failing with a *ConflictError, which wraps
+// ErrOptimisticLock, when a row the query loads is at another version than version. APIs
+// can validate the version a client sent without writing anything:
+//
+//	err := db.Clauses(optimistic.AssertVersion(req.Version)).First(&order, req.ID).Error
+//	if errors.Is(err, optimistic.ErrOptimisticLock) {
+//		// the client's copy is stale; 409 Conflict
+//	}
+//
+// version is compared as models carry it, encoded by any VersionCodec. Queries finding no
+// row are left alone.
+func AssertVersion(version any) AssertedVersion {
+	return AssertedVersion{Version: version}
+}
+
+func (x AssertedVersion) Name() string                 { return assertedVersionClauseName }
+func (x AssertedVersion) Build(clause.Builder)         {}
+func (x AssertedVersion) MergeClause(c *clause.Clause) { c.Expression = x }
+
+// assertVersion checks the rows loaded by a query carrying an AssertedVersion clause.
+func (p *Plugin) assertVersion(db *gorm.DB) {
+	c, ok := db.Statement.Clauses[assertedVersionClauseName]
+	if !ok || db.DryRun {
+		return
+	}
+	stmt := db.Statement
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		_ = db.AddError(fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Table))
+		return
+	}
+	if db.Error != nil {
+		return
+	}
+	expected := derefValue(c.Expression.(AssertedVersion).Version)
+	check := func(rv reflect.Value) bool {
+		rv = reflect.Indirect(rv)
+		if rv.Kind() != reflect.Struct {
+			return true
+		}
+		version, _ := f.ValueOf(stmt.Context, rv)
+		if valuesEqual(stmt.DB.Dialector.Name(), expected, derefValue(version)) {
+			return true
+		}
+		db.Error = &ConflictError{Table: stmt.Table, CurrentVersion: version}
+		return false
+	}
+	switch rv := reflect.Indirect(stmt.ReflectValue); rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if !check(rv.Index(i)) {
+				return
+			}
+		}
+	default:
+		check(rv)
+	}
+}
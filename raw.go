@@ -0,0 +1,169 @@
+package optimistic
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrRawStatement is returned (wrapped) by ExecChecked for statements it cannot guard.
+var ErrRawStatement = errors.New("raw statement cannot be guarded")
+
+// ExecChecked runs the hand-written UPDATE sql against the row of model under the lock
+// semantics of guarded updates: the version is bumped along with the statement's own
+// assignments, and the statement only applies while the row is still at model's version
+// and key:
+//
+//	err := optimistic.ExecChecked(db, &order,
+//		"UPDATE orders SET views = views + ?, seen_at = ?", 1, now)
+//	if errors.Is(err, optimistic.ErrOptimisticLock) {
+//		// order changed since it was loaded
+//	}
+//
+// sql must be a plain `UPDATE ... SET ...`, optionally with a WHERE clause its guards are
+// ANDed to, and take positional `?` arguments. On success model carries the new version;
+// reload it for the other columns the statement changed. Conflict clauses, hooks and the
+// version registry do not apply to raw statements.
+func ExecChecked(db *gorm.DB, model any, sql string, args ...any) error {
+	stmt, err := parseTarget(db, model)
+	if err != nil {
+		return err
+	}
+	if !stmt.ReflectValue.CanAddr() {
+		return gorm.ErrInvalidValue
+	}
+	p := pluginFor(db)
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Table)
+	}
+	if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(sql)), "UPDATE") {
+		return fmt.Errorf("%w: not an UPDATE", ErrRawStatement)
+	}
+	identity := p.identityFields(stmt.Schema)
+	if _, ok := registryKey(stmt, identity, stmt.ReflectValue); !ok {
+		return gorm.ErrPrimaryKeyRequired
+	}
+	modelVal, _ := f.ValueOf(stmt.Context, stmt.ReflectValue)
+	from, err := p.decodeVersion(stmt.Context, stmt.Table, modelVal)
+	if err != nil {
+		return err
+	}
+	strategy, err := p.versionStrategy(f)
+	if err != nil {
+		return err
+	}
+
+	head, cond, placeholders := splitWhere(sql)
+	if placeholders > len(args) || (cond == "" && placeholders < len(args)) {
+		return fmt.Errorf("%w: %d arguments for %d placeholders", ErrRawStatement, len(args), placeholders)
+	}
+	column := stmt.Quote(f.DBName)
+	var (
+		b    strings.Builder
+		vars = slices.Clone(args[:placeholders])
+		to   any
+	)
+	fresh := db.Session(&gorm.Session{NewDB: true})
+	// Must reset Error
+	fresh.Error = nil
+	b.WriteString(strings.TrimRight(head, " \t\r\n"))
+	switch strategy {
+	case StrategyInt:
+		n, _ := asUint64(from)
+		to = n + 1
+		_, _ = fmt.Fprintf(&b, ", %s = %s + 1", column, column)
+	case StrategyXmin, StrategyRowVersion:
+		// the database bumps these itself, read back below
+	default:
+		// generated versions report failures on fresh
+		if to = p.newVersionValue(fresh, f, strategy, from); fresh.Error != nil {
+			return fresh.Error
+		}
+		_, _ = fmt.Fprintf(&b, ", %s = ?", column)
+		vars = append(vars, to)
+	}
+	b.WriteString(" WHERE ")
+	if cond != "" {
+		_, _ = fmt.Fprintf(&b, "(%s) AND ", strings.TrimSpace(cond))
+		vars = append(vars, args[placeholders:]...)
+	}
+	for _, cond := range identityConds(stmt, identity) {
+		eq := cond.(clause.Eq)
+		_, _ = fmt.Fprintf(&b, "%s = ? AND ", stmt.Quote(eq.Column.(clause.Column).Name))
+		vars = append(vars, eq.Value)
+	}
+	_, _ = fmt.Fprintf(&b, "%s = ?", column)
+	vars = append(vars, from)
+
+	result := fresh.Exec(b.String(), vars...)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOptimisticLock
+	}
+	// model gets the version encoded below
+	stored := fresh.WithContext(p.keepStored(stmt.Context))
+	switch strategy {
+	case StrategyTime:
+		// the column may keep less precision than NowFunc
+		if version, err := p.storedVersion(stored, stmt, f); err == nil {
+			to = version
+		}
+	case StrategyXmin, StrategyRowVersion:
+		if to, err = p.storedVersion(stored, stmt, f); err != nil {
+			return err
+		}
+	default:
+	}
+	encoded, err := p.encodeVersion(stmt.Context, stmt.Table, to)
+	if err != nil {
+		return err
+	}
+	return f.Set(stmt.Context, stmt.ReflectValue, encoded)
+}
+
+// splitWhere splits sql at its top-level WHERE keyword, outside of parentheses and quotes,
+// into the statement before it and the condition after it, and counts the `?`
+// placeholders before it.
+func splitWhere(sql string) (head, cond string, placeholders int) {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '?':
+			placeholders++
+		case depth == 0 && (c == 'W' || c == 'w') && isKeywordAt(sql, i, "WHERE"):
+			return sql[:i], sql[i+len("WHERE"):], placeholders
+		}
+	}
+	return sql, "", placeholders
+}
+
+// isKeywordAt reports whether sql holds the keyword kw at i as a word of its own.
+func isKeywordAt(sql string, i int, kw string) bool {
+	end := i + len(kw)
+	if end > len(sql) || !strings.EqualFold(sql[i:end], kw) {
+		return false
+	}
+	word := func(c byte) bool {
+		return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	return (i == 0 || !word(sql[i-1])) && (end == len(sql) || !word(sql[end]))
+}
@@ -0,0 +1,223 @@
+package optimistic
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrQueueFull is returned by a ReconcileQueue that cannot take more conflicts.
+	ErrQueueFull = errors.New("reconcile queue is full")
+	// ErrNoReconcileQueue is returned by Reconcile when no queue was configured with
+	// WithReconciliation.
+	ErrNoReconcileQueue = errors.New("no reconcile queue configured")
+)
+
+// Reconciliation is an aborted conflict queued for asynchronous reconciliation.
+type Reconciliation struct {
+	Table      string
+	PrimaryKey map[string]any
+	// Attempted is a copy of the model the aborted update tried to write.
+	Attempted any
+	// Changes are the columns the aborted update assigned, version excluded. Map-based
+	// updates do not assign them to Attempted.
+	Changes map[string]any
+	// Report describes the conflict as GetConflictReport did for the aborted update.
+	Report ConflictReport
+	// Attempts counts the times the merged value itself hit a conflict.
+	Attempts int
+}
+
+// MergePolicy reconciles a queued conflict with current, a pointer to the row as stored
+// now. It returns the value to write, typically current with the attempted changes
+// applied so the write is guarded by the current version, or nil to drop the conflict.
+type MergePolicy func(ctx context.Context, r Reconciliation, current any) any
+
+// ReconcileQueue holds conflicts awaiting reconciliation. Implementations may keep them
+// in memory, see NewMemoryQueue, or persist them, for example in a table. Enqueue is
+// called from within the aborted update and should not block.
+type ReconcileQueue interface {
+	Enqueue(ctx context.Context, r Reconciliation) error
+	// Dequeue waits for the next conflict until ctx is done.
+	Dequeue(ctx context.Context) (Reconciliation, error)
+}
+
+// NewMemoryQueue returns an in-memory ReconcileQueue holding up to size conflicts; it
+// rejects further conflicts with ErrQueueFull. Queued conflicts are lost when the process
+// exits.
+func NewMemoryQueue(size int) ReconcileQueue {
+	return &memoryQueue{items: make(chan Reconciliation, size)}
+}
+
+type memoryQueue struct {
+	items chan Reconciliation
+}
+
+func (q *memoryQueue) Enqueue(_ context.Context, r Reconciliation) error {
+	select {
+	case q.items <- r:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (q *memoryQueue) Dequeue(ctx context.Context) (Reconciliation, error) {
+	select {
+	case r := <-q.items:
+		return r, nil
+	case <-ctx.Done():
+		return Reconciliation{}, ctx.Err()
+	}
+}
+
+// WithReconciliation queues unresolved conflicts of tables with a MergePolicy on queue,
+// for Reconcile to settle in the background. The aborted update still fails with
+// ErrOptimisticLock, and its ConflictReport has ResolutionQueued. A merged value that
+// conflicts again is queued anew until it was attempted maxAttempts times.
+//
+// Conflicts are queued as they occur, including those of transactions that later roll
+// back, so policies should only apply changes that are valid on their own.
+func WithReconciliation(queue ReconcileQueue, maxAttempts int) ConfigOption {
+	return func(cfg *Config) {
+		cfg.reconcileQueue = queue
+		cfg.reconcileAttempts = maxAttempts
+	}
+}
+
+// WithMergePolicy reconciles queued conflicts of table with policy, see WithReconciliation.
+func WithMergePolicy(table string, policy MergePolicy) ConfigOption {
+	return func(cfg *Config) {
+		if cfg.mergePolicies == nil {
+			cfg.mergePolicies = map[string]MergePolicy{}
+		}
+		cfg.mergePolicies[table] = policy
+	}
+}
+
+// Reconcile pauses a worker whose queue fails to dequeue, starting at
+// reconcileBackoff and doubling up to reconcileMaxBackoff while the failures last.
+const (
+	reconcileBackoff    = 10 * time.Millisecond
+	reconcileMaxBackoff = time.Second
+)
+
+// reconciling marks the context of writes made by Reconcile, whose conflicts it requeues
+// itself.
+type reconciling struct{}
+
+// Reconcile settles the conflicts queued on db's plugin with workers goroutines until ctx
+// is done:
+//
+//	go optimistic.Reconcile(ctx, db, 4)
+//
+// Conflicts whose row is gone, or whose policy returns nil, are dropped. Failures are
+// reported through db's logger; a worker whose queue fails to dequeue pauses before
+// trying again, doubling the pause up to a second while the failures last.
+func Reconcile(ctx context.Context, db *gorm.DB, workers int) error {
+	p := pluginFor(db)
+	if p.reconcileQueue == nil {
+		return ErrNoReconcileQueue
+	}
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pause := reconcileBackoff
+			for {
+				r, err := p.reconcileQueue.Dequeue(ctx)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					db.Logger.Error(ctx, "[%s] dequeuing conflict: %v", p.Name(), err)
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(pause):
+					}
+					pause = min(pause*2, reconcileMaxBackoff)
+					continue
+				}
+				pause = reconcileBackoff
+				p.reconcile(ctx, db, r)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// reconcile applies the table's policy to r and writes the merged value.
+func (p *Plugin) reconcile(ctx context.Context, db *gorm.DB, r Reconciliation) {
+	policy, ok := p.mergePolicies[r.Table]
+	if !ok {
+		return
+	}
+	tx := db.WithContext(context.WithValue(ctx, reconciling{}, true))
+	current, _, err := loadCurrent(tx, r.Attempted)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			db.Logger.Error(ctx, "[%s] reconciling %s %v: %v", p.Name(), r.Table, r.PrimaryKey, err)
+		}
+		return
+	}
+	merged := policy(ctx, r, current)
+	if merged == nil {
+		return
+	}
+	err = tx.Model(merged).Updates(merged).Error
+	if errors.Is(err, ErrOptimisticLock) && r.Attempts+1 < p.reconcileAttempts {
+		r.Attempts++
+		err = p.reconcileQueue.Enqueue(ctx, r)
+	}
+	if err != nil {
+		db.Logger.Error(ctx, "[%s] reconciling %s %v: %v", p.Name(), r.Table, r.PrimaryKey, err)
+	}
+}
+
+// enqueueReconciliation queues the unresolved conflict of db for Reconcile.
+func (p *Plugin) enqueueReconciliation(db *gorm.DB, report *ConflictReport) {
+	if p.reconcileQueue == nil || report.Resolution != ResolutionUnresolved {
+		return
+	}
+	stmt := db.Statement
+	if _, ok := p.mergePolicies[stmt.Table]; !ok || stmt.Context.Value(reconciling{}) != nil {
+		return
+	}
+	rv := reflect.Indirect(stmt.ReflectValue)
+	if rv.Kind() != reflect.Struct {
+		return
+	}
+	attempted := reflect.New(rv.Type())
+	attempted.Elem().Set(rv)
+	r := Reconciliation{
+		Table:      stmt.Table,
+		PrimaryKey: report.PrimaryKey,
+		Attempted:  attempted.Interface(),
+		Changes:    map[string]any{},
+		Report:     *report,
+	}
+	f := p.findVersionField(stmt.Schema)
+	if c, ok := stmt.Clauses[clause.Set{}.Name()]; ok {
+		set, _ := c.Expression.(clause.Set)
+		for _, a := range set {
+			if f == nil || a.Column.Name != f.DBName {
+				r.Changes[a.Column.Name] = a.Value
+			}
+		}
+	}
+	r.Report.Resolution = ResolutionQueued
+	if err := p.reconcileQueue.Enqueue(stmt.Context, r); err != nil {
+		db.Logger.Warn(stmt.Context, "[%s] queuing conflict on %s: %v", p.Name(), stmt.Table, err)
+		return
+	}
+	report.Resolution = ResolutionQueued
+}
@@ -0,0 +1,218 @@
+package optimistic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// DefaultRegistryTable is the registry table of WithVersionRegistry("").
+const DefaultRegistryTable = "optimistic_versions"
+
+// ErrNoRegistry is returned by the registry helpers when the plugin has no registry.
+var ErrNoRegistry = errors.New("no version registry")
+
+// RegistryEntry is a row of the version registry: the version a row of SourceTable, keyed
+// by RowKey, was last written with.
+type RegistryEntry struct {
+	SourceTable string `gorm:"primaryKey;size:64"`
+	RowKey      string `gorm:"primaryKey;size:191"`
+	Version     string `gorm:"size:64;not null"`
+	UpdatedAt   time.Time
+}
+
+// WithVersionRegistry mirrors the version of every row the plugin creates, updates under
+// the guard or deletes into a compact registry table, DefaultRegistryTable when table is
+// empty. Other services, caches and replicas can then tell whether their copy of a row is
+// current from its key alone, without reading the wide row:
+//
+//	db.Use(optimistic.NewOptimisticLock(optimistic.WithVersionRegistry("")))
+//	_ = optimistic.MigrateRegistry(db)
+//
+//	current, err := optimistic.RegisteredVersion(db, &cached)
+//	if err == nil && current != optimistic.RegistryVersion(cached.Version) {
+//		// the cached order is stale
+//	}
+//
+// Entries are written by the statement's own transaction, so they commit or roll back
+// with the row; statements run with SkipDefaultTransaction write them separately. Rows
+// changed by updates the plugin does not guard, such as updates without a model or
+// scoped deletes, are not mirrored. Versions are registered as stored, before any
+// VersionCodec.
+func WithVersionRegistry(table string) ConfigOption {
+	return func(cfg *Config) {
+		if table == "" {
+			table = DefaultRegistryTable
+		}
+		cfg.registryTable = table
+	}
+}
+
+// MigrateRegistry creates or migrates the registry table of the plugin installed on db.
+func MigrateRegistry(db *gorm.DB) error {
+	p := pluginFor(db)
+	if p.registryTable == "" {
+		return ErrNoRegistry
+	}
+	return db.Table(p.registryTable).AutoMigrate(&RegistryEntry{})
+}
+
+// RegisteredVersion returns the version the registry holds for the row of model, or
+// gorm.ErrRecordNotFound when it holds none.
+func RegisteredVersion(db *gorm.DB, model any) (string, error) {
+	p := pluginFor(db)
+	if p.registryTable == "" {
+		return "", ErrNoRegistry
+	}
+	stmt := &gorm.Statement{DB: db, Context: context.Background()}
+	if db.Statement != nil && db.Statement.Context != nil {
+		stmt.Context = db.Statement.Context
+	}
+	if err := stmt.Parse(model); err != nil {
+		return "", err
+	}
+	key, ok := registryKey(stmt, p.identityFields(stmt.Schema), reflect.Indirect(reflect.ValueOf(model)))
+	if !ok {
+		return "", gorm.ErrPrimaryKeyRequired
+	}
+	var entry RegistryEntry
+	err := db.Session(&gorm.Session{NewDB: true}).Table(p.registryTable).
+		Where("source_table = ? AND row_key = ?", stmt.Table, key).
+		Take(&entry).Error
+	return entry.Version, err
+}
+
+// RegistryVersion renders version as the registry stores it: times in UTC, everything
+// else with fmt's %v.
+func RegistryVersion(version any) string {
+	switch v := derefValue(version).(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// registryKey renders the identity of row rv, its values joined by commas, or reports
+// false when any of them is zero.
+func registryKey(stmt *gorm.Statement, identity []*schema.Field, rv reflect.Value) (string, bool) {
+	if len(identity) == 0 {
+		return "", false
+	}
+	values := make([]string, 0, len(identity))
+	for _, f := range identity {
+		val, zero := identityValue(stmt.Context, f, rv)
+		if zero {
+			return "", false
+		}
+		values = append(values, fmt.Sprint(val))
+	}
+	return strings.Join(values, ","), true
+}
+
+// registerCreated registers the versions of the rows db created.
+func (p *Plugin) registerCreated(db *gorm.DB) {
+	if p.registryTable == "" || db.Error != nil || db.DryRun || db.RowsAffected == 0 {
+		return
+	}
+	stmt := db.Statement
+	f := p.findVersionField(stmt.Schema)
+	if f == nil {
+		return
+	}
+	identity := p.identityFields(stmt.Schema)
+	var entries []RegistryEntry
+	add := func(rv reflect.Value) {
+		rv = reflect.Indirect(rv)
+		if rv.Kind() != reflect.Struct || rv.Type() != stmt.Schema.ModelType {
+			return
+		}
+		key, ok := registryKey(stmt, identity, rv)
+		version, zero := f.ValueOf(stmt.Context, rv)
+		if ok && !zero {
+			entries = append(entries, RegistryEntry{SourceTable: stmt.Table, RowKey: key, Version: RegistryVersion(version)})
+		}
+	}
+	switch rv := reflect.Indirect(stmt.ReflectValue); rv.Kind() {
+	case reflect.Struct:
+		add(rv)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			add(rv.Index(i))
+		}
+	}
+	p.writeRegistry(db, entries)
+}
+
+// registerUpdated registers the version a guarded update of a model wrote. It runs before
+// the update is verified, within its transaction, so a conflicting update, which matched
+// no row, registers nothing.
+func (p *Plugin) registerUpdated(db *gorm.DB) {
+	if p.registryTable == "" || db.Error != nil || db.DryRun || db.RowsAffected == 0 || !isTargetedModelUpdate(db.Statement) {
+		return
+	}
+	stmt := db.Statement
+	f := p.findVersionField(stmt.Schema)
+	rv := reflect.Indirect(stmt.ReflectValue)
+	if f == nil || rv.Kind() != reflect.Struct {
+		return
+	}
+	to, guarded := p.writtenVersion(db, f)
+	if !guarded {
+		return
+	}
+	key, ok := registryKey(stmt, p.identityFields(stmt.Schema), rv)
+	if !ok {
+		return
+	}
+	p.writeRegistry(db, []RegistryEntry{{SourceTable: stmt.Table, RowKey: key, Version: RegistryVersion(to)}})
+}
+
+// unregisterDeleted removes the registry entry of the model row db deleted.
+func (p *Plugin) unregisterDeleted(db *gorm.DB) {
+	if p.registryTable == "" || db.Error != nil || db.DryRun || db.RowsAffected == 0 {
+		return
+	}
+	stmt := db.Statement
+	rv := reflect.Indirect(stmt.ReflectValue)
+	if p.findVersionField(stmt.Schema) == nil || rv.Kind() != reflect.Struct {
+		return
+	}
+	key, ok := registryKey(stmt, p.identityFields(stmt.Schema), rv)
+	if !ok {
+		return
+	}
+	fresh := db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+	// Must reset Error
+	fresh.Error = nil
+	if err := fresh.Table(p.registryTable).
+		Where("source_table = ? AND row_key = ?", stmt.Table, key).
+		Delete(&RegistryEntry{}).Error; err != nil {
+		_ = db.AddError(err)
+	}
+}
+
+// writeRegistry upserts entries through db's connection, joining its transaction.
+func (p *Plugin) writeRegistry(db *gorm.DB, entries []RegistryEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	fresh := db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+	// Must reset Error
+	fresh.Error = nil
+	if err := fresh.Table(p.registryTable).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "source_table"}, {Name: "row_key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"version", "updated_at"}),
+	}).Create(&entries).Error; err != nil {
+		_ = db.AddError(err)
+	}
+}
@@ -0,0 +1,277 @@
+package optimistic
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrRekeyUnsupported is returned by Rekey for versions the database assigns.
+var ErrRekeyUnsupported = errors.New("rekey between these strategies is not supported")
+
+// rekeyBatchSize is how many rows Rekey reads at a time.
+const rekeyBatchSize = 500
+
+// rekeyShadowSuffix names the shadow column of Rekey after the version column.
+const rekeyShadowSuffix = "_rekey"
+
+func (s Strategy) String() string {
+	switch s {
+	case StrategyInt:
+		return StrategyNameInt
+	case StrategyUUID:
+		return StrategyNameUUID
+	case StrategyULID:
+		return StrategyNameULID
+	case StrategyTime:
+		return StrategyNameTime
+	case StrategyCustom:
+		return StrategyNameCustom
+	case StrategyString:
+		return StrategyNameString
+	case StrategyKSUID:
+		return StrategyNameKSUID
+	case StrategyXID:
+		return StrategyNameXID
+	case StrategySnowflake:
+		return StrategyNameSnowflake
+	case StrategyUnixNano:
+		return StrategyNameUnixNano
+	case StrategyHLC:
+		return StrategyNameHLC
+	case StrategySequence:
+		return StrategyNameSequence
+	case StrategyXmin:
+		return StrategyNameXmin
+	case StrategyRowVersion:
+		return StrategyNameRowVersion
+	default:
+		return "unknown"
+	}
+}
+
+// Rekey moves the version column of model's table from one strategy to another in
+// batches, so the migration does not need downtime:
+//
+//	err := optimistic.Rekey(db, &Order{}, optimistic.StrategyInt, optimistic.StrategyUUID)
+//
+// model must declare its version field with the target strategy; Rekey refuses to run
+// otherwise. Versions the database assigns, xmin and rowversion ones, cannot be rekeyed.
+//
+// UUID and ULID versions share a column, so they are rekeyed in place. Tag the model's
+// version field with the target strategy before calling Rekey, so the plugin already
+// generates target values for every write made during the migration. Each row still
+// holding a value of the source strategy is rewritten with a guarded update, so
+// concurrent writers are never overwritten: a row written meanwhile already carries a
+// target value and is left alone. Until its row is rekeyed, an old value must still scan
+// into the field, so use a field type that reads both formats if the application keeps
+// reading the table in the meantime.
+//
+// Other strategies need a column of their own. Rekey adds a shadow column, named after
+// the version column with a "_rekey" suffix and of the field's data type or ColumnType,
+// and gives every row a first target version in it, guarded by the row's current version
+// so a row written meanwhile is filled again by a later batch. It then drops the old
+// column and renames the shadow column in its place, in a transaction that first fills
+// the rows created meanwhile. The application keeps writing with the source model while
+// Rekey runs and switches to model once it returns; writes of the source model fail after
+// the swap. The swapped column is nullable until AutoMigrate applies the field's
+// constraints. Running Rekey again resumes an interrupted migration.
+func Rekey(db *gorm.DB, model any, from, to Strategy) error {
+	if from == to || assignedByDatabase(from) || assignedByDatabase(to) {
+		return fmt.Errorf("%w: %s to %s", ErrRekeyUnsupported, from, to)
+	}
+	p := pluginFor(db)
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	sch := stmt.Schema
+	f := p.findVersionField(sch)
+	if f == nil {
+		return fmt.Errorf("%w: %s", ErrNoVersionField, sch.Table)
+	}
+	if strategy, err := p.versionStrategy(f); err != nil {
+		return err
+	} else if strategy != to {
+		return fmt.Errorf("%w: %s.%s is a %s version, tag it %q before rekeying",
+			ErrVersionTagMismatch, sch.Name, f.Name, strategy, "version:"+to.String())
+	}
+	identity := p.identityFields(sch)
+	if len(identity) == 0 {
+		return gorm.ErrMissingWhereClause
+	}
+	if sharesColumn(from) && sharesColumn(to) {
+		return p.rekeyInPlace(db, sch, f, from, to, identity)
+	}
+	return p.rekeyShadow(db, sch, f, to, identity)
+}
+
+// rekeyInPlace rewrites the rows of sch whose version f holds a value of strategy from
+// with one of strategy to.
+func (p *Plugin) rekeyInPlace(db *gorm.DB, sch *schema.Schema, f *schema.Field, from, to Strategy, identity []*schema.Field) error {
+	columns, order := rekeyColumns(f, identity)
+	for offset := 0; ; offset += rekeyBatchSize {
+		// read raw values: rows of the source strategy need not scan into the field type
+		var batch []map[string]any
+		err := db.Session(&gorm.Session{NewDB: true}).Unscoped().
+			Table(sch.Table).
+			Select(columns).
+			Clauses(clause.OrderBy{Columns: order}).
+			Offset(offset).Limit(rekeyBatchSize).
+			Find(&batch).Error
+		if err != nil {
+			return err
+		}
+		for _, row := range batch {
+			if !isStrategyValue(from, row[f.DBName]) {
+				continue
+			}
+			fresh := db.Session(&gorm.Session{NewDB: true})
+			next := p.newVersionValue(fresh, f, to, nil)
+			if fresh.Error != nil {
+				return fresh.Error
+			}
+			// unscoped, so the plugin's own guard and bump stay out of the way
+			err := fresh.Unscoped().
+				Model(reflect.New(sch.ModelType).Interface()).
+				Where(clause.Where{Exprs: rowGuard(columns, row)}).
+				UpdateColumn(f.DBName, next).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(batch) < rekeyBatchSize {
+			return nil
+		}
+	}
+}
+
+// rekeyShadow moves the version f of sch to strategy to through a shadow column.
+func (p *Plugin) rekeyShadow(db *gorm.DB, sch *schema.Schema, f *schema.Field, to Strategy, identity []*schema.Field) error {
+	model := reflect.New(sch.ModelType).Interface()
+	shadow := f.DBName + rekeyShadowSuffix
+	if !db.Migrator().HasColumn(model, shadow) {
+		advise(f, ColumnType(db.Dialector.Name(), to))
+		err := db.Exec("ALTER TABLE ? ADD ? "+db.Dialector.DataTypeOf(f), clause.Table{Name: sch.Table}, clause.Column{Name: shadow}).Error
+		if err != nil {
+			return err
+		}
+	}
+	if err := p.fillShadow(db, sch, f, to, identity, shadow); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := p.fillShadow(tx, sch, f, to, identity, shadow); err != nil {
+			return err
+		}
+		if err := tx.Migrator().DropColumn(model, f.DBName); err != nil {
+			return err
+		}
+		return tx.Migrator().RenameColumn(model, shadow, f.DBName)
+	})
+}
+
+// fillShadow gives every row of sch without one a first version of strategy to in the
+// shadow column, until none is left. Each row is filled with an update guarded by its
+// identity and current version, so rows written meanwhile come back in a later batch.
+func (p *Plugin) fillShadow(db *gorm.DB, sch *schema.Schema, f *schema.Field, to Strategy, identity []*schema.Field, shadow string) error {
+	columns, order := rekeyColumns(f, identity)
+	empty := clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: shadow}, Value: nil}
+	for {
+		var batch []map[string]any
+		err := db.Session(&gorm.Session{NewDB: true}).Unscoped().
+			Table(sch.Table).
+			Select(columns).
+			Where(empty).
+			Clauses(clause.OrderBy{Columns: order}).
+			Limit(rekeyBatchSize).
+			Find(&batch).Error
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+		var filled int64
+		for _, row := range batch {
+			fresh := db.Session(&gorm.Session{NewDB: true})
+			var first any = uint64(1)
+			if to != StrategyInt {
+				if first = p.newVersionValue(fresh, f, to, nil); fresh.Error != nil {
+					return fresh.Error
+				}
+			}
+			res := fresh.Unscoped().
+				Table(sch.Table).
+				Where(clause.Where{Exprs: append(rowGuard(columns, row), empty)}).
+				UpdateColumn(shadow, first)
+			if res.Error != nil {
+				return res.Error
+			}
+			filled += res.RowsAffected
+		}
+		if filled == 0 {
+			// every row of the batch was written meanwhile; running Rekey again resumes
+			return fmt.Errorf("%w: %s: rekeying a batch", ErrOptimisticLock, sch.Table)
+		}
+	}
+}
+
+// rekeyColumns returns the identity and version columns Rekey reads, and the order it
+// reads rows in.
+func rekeyColumns(f *schema.Field, identity []*schema.Field) ([]string, []clause.OrderByColumn) {
+	columns := make([]string, 0, len(identity)+1)
+	order := make([]clause.OrderByColumn, 0, len(identity))
+	for _, idf := range identity {
+		columns = append(columns, idf.DBName)
+		order = append(order, clause.OrderByColumn{Column: clause.Column{Table: clause.CurrentTable, Name: idf.DBName}})
+	}
+	return append(columns, f.DBName), order
+}
+
+// rowGuard matches the row read as row, as long as its columns are unchanged.
+func rowGuard(columns []string, row map[string]any) []clause.Expression {
+	exprs := make([]clause.Expression, 0, len(columns)+1)
+	for _, col := range columns {
+		exprs = append(exprs, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: col}, Value: row[col]})
+	}
+	return exprs
+}
+
+// sharesColumn reports whether versions of s can be rekeyed within their column.
+func sharesColumn(s Strategy) bool {
+	return s == StrategyUUID || s == StrategyULID
+}
+
+// assignedByDatabase reports whether the database assigns versions of s itself.
+func assignedByDatabase(s Strategy) bool {
+	return s == StrategyXmin || s == StrategyRowVersion
+}
+
+// isStrategyValue reports whether the raw column value v was written by strategy s. Text
+// columns tell UUIDs (36 characters) from ULIDs (26 characters) by length; binary columns
+// by the UUID version and variant bits, which a ULID only carries by chance.
+func isStrategyValue(s Strategy, v any) bool {
+	var raw []byte
+	switch v := v.(type) {
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		return false
+	}
+	switch len(raw) {
+	case 36:
+		return s == StrategyUUID
+	case 26:
+		return s == StrategyULID
+	case 16:
+		version := raw[6] >> 4
+		isUUID := raw[8]&0xc0 == 0x80 && version >= 1 && version <= 8
+		return isUUID == (s == StrategyUUID)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,100 @@
+package optimistic
+
+import (
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// Resolution describes how a version conflict was settled.
+type Resolution string
+
+const (
+	// ResolutionUnresolved means no Conflict handler ran and the conflict surfaced as an error.
+	ResolutionUnresolved Resolution = "unresolved"
+	// ResolutionCanceled means the handler returned nil and the update was abandoned.
+	ResolutionCanceled Resolution = "canceled"
+	// ResolutionAcceptedCurrent means the handler kept the persisted row as-is.
+	ResolutionAcceptedCurrent Resolution = "accepted_current"
+	// ResolutionMerged means the handler's merged value was written successfully.
+	ResolutionMerged Resolution = "merged"
+	// ResolutionMergeFailed means writing the handler's merged value failed as well.
+	ResolutionMergeFailed Resolution = "merge_failed"
+	// ResolutionQueued means the conflict surfaced as an error and was queued for
+	// reconciliation, see WithReconciliation.
+	ResolutionQueued Resolution = "queued"
+)
+
+// ConflictReport is a machine-readable description of a version conflict, suitable for
+// returning from API layers as a conflict response body. Retrieve it with GetConflictReport.
+//
+// CurrentVersion and FieldChanges are only populated when the current row was reloaded,
+// which happens when the statement carried a Conflict clause.
+type ConflictReport struct {
+	Table           string            `json:"table"`
+	PrimaryKey      map[string]any    `json:"pk"`
+	ExpectedVersion any               `json:"expectedVersion"`
+	CurrentVersion  any               `json:"currentVersion,omitempty"`
+	FieldChanges    map[string]Change `json:"fieldChanges,omitempty"`
+	Resolution      Resolution        `json:"resolution"`
+}
+
+// GetConflictReport returns the report attached to the statement executed by tx, if a
+// version conflict occurred.
+func GetConflictReport(tx *gorm.DB) (*ConflictReport, bool) {
+	if tx == nil || tx.Statement == nil {
+		return nil, false
+	}
+	v, ok := tx.InstanceGet(contextKeyConflictReport)
+	if !ok {
+		return nil, false
+	}
+	report, ok := v.(*ConflictReport)
+	return report, ok
+}
+
+// newConflictReport starts a report for the statement's targeted row.
+func newConflictReport(stmt *gorm.Statement, expected any) *ConflictReport {
+	fields, _ := pluginFor(stmt.DB).stmtIdentity(stmt)
+	report := &ConflictReport{
+		Table:           stmt.Table,
+		PrimaryKey:      make(map[string]any, len(fields)),
+		ExpectedVersion: expected,
+		Resolution:      ResolutionUnresolved,
+	}
+	for _, pf := range fields {
+		report.PrimaryKey[pf.DBName], _ = identityValue(stmt.Context, pf, stmt.ReflectValue)
+	}
+	return report
+}
+
+// describeConflict turns a conflict left unresolved into a *ConflictError carrying the key
+// and versions of report, reading the current version unless a reload already did.
+func (p *Plugin) describeConflict(db *gorm.DB, report *ConflictReport) {
+	var ce *ConflictError
+	switch {
+	case errors.As(db.Error, &ce):
+	case db.Error == ErrOptimisticLock:
+		ce = &ConflictError{Table: db.Statement.Table}
+		db.Error = ce
+	default:
+		// resolved, or failed otherwise
+		return
+	}
+	ce.PrimaryKey, ce.ExpectedVersion = report.PrimaryKey, report.ExpectedVersion
+	f := p.findVersionField(db.Statement.Schema)
+	switch {
+	case ce.CurrentVersion != nil || f == nil:
+	case ce.Current != nil:
+		ce.CurrentVersion, _ = f.ValueOf(db.Statement.Context, reflect.Indirect(reflect.ValueOf(ce.Current)))
+	case report.CurrentVersion != nil:
+		ce.CurrentVersion = report.CurrentVersion
+	default:
+		// the row is gone when it cannot be read
+		ce.CurrentVersion, _ = p.storedVersion(db, db.Statement, f)
+	}
+	if report.CurrentVersion == nil {
+		report.CurrentVersion = ce.CurrentVersion
+	}
+}
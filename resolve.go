@@ -0,0 +1,128 @@
+package optimistic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrUnknownTable is returned by ResolveAll for conflicts of a table the plugin has no
+// model for, see WithModels.
+var ErrUnknownTable = errors.New("no model known for table")
+
+// ErrInvalidConflictKey is returned by ResolveAll for conflicts whose key does not name
+// exactly the key columns of their table, which would not single out the row.
+var ErrInvalidConflictKey = errors.New("conflict key does not match the table's key")
+
+// ResolvePolicy decides a collected conflict given current, a pointer to the row of the
+// report's table as stored now. It returns the value to write, typically current with
+// changes applied so the write is guarded by the current version, or nil to leave the
+// row alone.
+type ResolvePolicy func(ctx context.Context, report ConflictReport, current any) any
+
+// WithModels makes the plugin know the tables of models from the start. ResolveAll
+// otherwise only knows the tables the plugin has handled so far, which a tool resolving
+// conflicts collected by another process may not have touched yet.
+func WithModels(models ...any) ConfigOption {
+	return func(cfg *Config) {
+		cfg.models = append(cfg.models, models...)
+	}
+}
+
+// ResolveAll applies policy to each of conflicts, as collected with GetConflictReport, in
+// a single transaction, for tools triaging conflicts in bulk:
+//
+//	err := optimistic.ResolveAll(db, reports, func(ctx context.Context, r optimistic.ConflictReport, current any) any {
+//		order := current.(*Order)
+//		order.Status = "review"
+//		return order
+//	})
+//
+// Conflicts whose row is gone are skipped. Keys must name the table's primary key, or
+// identity, columns, or ResolveAll fails with ErrInvalidConflictKey before reading any
+// row. The transaction rolls back when any write fails, including with ErrOptimisticLock
+// because the row changed while it was resolved.
+func ResolveAll(db *gorm.DB, conflicts []ConflictReport, policy ResolvePolicy) error {
+	p := pluginFor(db)
+	schemas := make([]*schema.Schema, len(conflicts))
+	conds := make([][]clause.Expression, len(conflicts))
+	for i, report := range conflicts {
+		sch, ok := p.tableSchema(report.Table)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownTable, report.Table)
+		}
+		key, err := p.keyConds(sch, report)
+		if err != nil {
+			return err
+		}
+		schemas[i], conds[i] = sch, key
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		ctx := tx.Statement.Context
+		for i, report := range conflicts {
+			current := reflect.New(schemas[i].ModelType).Interface()
+			err := tx.Session(&gorm.Session{NewDB: true}).
+				Clauses(clause.Where{Exprs: conds[i]}).
+				Take(current).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			resolved := policy(ctx, report, current)
+			if resolved == nil {
+				continue
+			}
+			if err := tx.Session(&gorm.Session{NewDB: true}).Model(resolved).Updates(resolved).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// tableSchema returns the schema of a table the plugin knows a model for.
+func (p *Plugin) tableSchema(table string) (*schema.Schema, bool) {
+	var sch *schema.Schema
+	if p.plans != nil {
+		p.plans.Range(func(key, _ any) bool {
+			if f := key.(*schema.Field); f.Schema.Table == table {
+				sch = f.Schema
+				return false
+			}
+			return true
+		})
+	}
+	return sch, sch != nil
+}
+
+// keyConds matches the row the key of report describes, which must hold a value for each
+// key column of sch and nothing else.
+func (p *Plugin) keyConds(sch *schema.Schema, report ConflictReport) ([]clause.Expression, error) {
+	identity := p.identityFields(sch)
+	if len(identity) == 0 || len(report.PrimaryKey) != len(identity) {
+		return nil, fmt.Errorf("%w: %s %v", ErrInvalidConflictKey, report.Table, report.PrimaryKey)
+	}
+	for _, f := range identity {
+		if report.PrimaryKey[f.DBName] == nil {
+			return nil, fmt.Errorf("%w: %s %v", ErrInvalidConflictKey, report.Table, report.PrimaryKey)
+		}
+	}
+	cols := make([]string, 0, len(report.PrimaryKey))
+	for col := range report.PrimaryKey {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	exprs := make([]clause.Expression, 0, len(cols))
+	for _, col := range cols {
+		exprs = append(exprs, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: col}, Value: report.PrimaryKey[col]})
+	}
+	return exprs, nil
+}
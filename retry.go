@@ -0,0 +1,87 @@
+package optimistic
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetryOption configures RunWithRetry.
+type RetryOption func(*retryConfig)
+
+type retryConfig struct {
+	attempts   int
+	initial    time.Duration
+	maxBackoff time.Duration
+	jitter     float64
+}
+
+// MaxAttempts bounds the runs of the closure, the first included; below 1 means 1. The
+// default is 3.
+func MaxAttempts(n int) RetryOption {
+	return func(cfg *retryConfig) {
+		cfg.attempts = max(n, 1)
+	}
+}
+
+// Backoff pauses initial before the first retry, doubling the pause for every further
+// retry up to maxBackoff. The default is 10ms doubling up to 1s; zero retries right away.
+func Backoff(initial, maxBackoff time.Duration) RetryOption {
+	return func(cfg *retryConfig) {
+		cfg.initial, cfg.maxBackoff = initial, max(initial, maxBackoff)
+	}
+}
+
+// Jitter randomizes every pause by up to fraction of it either way, so writers colliding
+// on a row do not retry in lockstep. The default is 0.2.
+func Jitter(fraction float64) RetryOption {
+	return func(cfg *retryConfig) {
+		cfg.jitter = min(max(fraction, 0), 1)
+	}
+}
+
+// RunWithRetry runs fn in a transaction of db, running it again in a new one for as long
+// as it returns a Retryable error, such as ErrOptimisticLock, and attempts remain:
+//
+//	err := optimistic.RunWithRetry(db, func(tx *gorm.DB) error {
+//		var order Order
+//		if err := tx.First(&order, id).Error; err != nil {
+//			return err
+//		}
+//		order.Status = "paid"
+//		return tx.Updates(&order).Error
+//	}, optimistic.MaxAttempts(5), optimistic.Backoff(20*time.Millisecond, time.Second))
+//
+// fn should load what it modifies, so every run works on current rows. The error is the
+// one of the last run, or the context's when it ends during a pause.
+func RunWithRetry(db *gorm.DB, fn func(tx *gorm.DB) error, opts ...RetryOption) error {
+	cfg := retryConfig{attempts: 3, initial: 10 * time.Millisecond, maxBackoff: time.Second, jitter: 0.2}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	ctx := context.Background()
+	if db.Statement != nil && db.Statement.Context != nil {
+		ctx = db.Statement.Context
+	}
+	pause := cfg.initial
+	for attempt := 1; ; attempt++ {
+		err := db.Transaction(fn)
+		if !Retryable(err) || attempt >= cfg.attempts {
+			return err
+		}
+		if pause > 0 {
+			wait := pause
+			if cfg.jitter > 0 {
+				wait += time.Duration((rand.Float64()*2 - 1) * cfg.jitter * float64(pause))
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			pause = min(2*pause, cfg.maxBackoff)
+		}
+	}
+}
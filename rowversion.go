@@ -0,0 +1,129 @@
+package optimistic
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// RowVersion is a SQL Server rowversion, the eight byte token the database bumps on every
+// write of a row, as the uint64 its bytes hold in big-endian order. Fields of type
+// RowVersion, or []byte fields tagged `version:rowversion`, are versioned with
+// StrategyRowVersion:
+//
+//	type Order struct {
+//		ID      uint64
+//		Version optimistic.RowVersion `gorm:"type:rowversion;->;version"`
+//	}
+//
+// The plugin never writes the column: updates and deletes are guarded with its value, and
+// updates read the token they caused back with `OUTPUT INSERTED`, creates with a SELECT.
+// SQL Server rejects OUTPUT without INTO on tables with triggers.
+type RowVersion uint64
+
+// Scan reads the token of a rowversion column.
+func (r *RowVersion) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*r = 0
+	case []byte:
+		if len(v) != 8 {
+			return fmt.Errorf("optimistic: rowversion of %d bytes", len(v))
+		}
+		*r = RowVersion(binary.BigEndian.Uint64(v))
+	case int64:
+		*r = RowVersion(v)
+	default:
+		return fmt.Errorf("optimistic: cannot scan %T into a rowversion", src)
+	}
+	return nil
+}
+
+// Value returns the token's eight bytes, as rowversion columns compare with.
+func (r RowVersion) Value() (driver.Value, error) {
+	return binary.BigEndian.AppendUint64(nil, uint64(r)), nil
+}
+
+var (
+	tyRowVersion = reflect.TypeOf(RowVersion(0))
+	tyBytes      = reflect.TypeOf([]byte(nil))
+)
+
+// fitsRowVersion reports whether fields of type t can hold rowversion tokens.
+func fitsRowVersion(t reflect.Type) bool {
+	return t == tyRowVersion || t == tyBytes
+}
+
+// checkRowVersion reports why the rowversion field f cannot be used on the plugin's
+// database.
+func (p *Plugin) checkRowVersion(f *schema.Field) error {
+	switch {
+	case f.Creatable || f.Updatable:
+		return fmt.Errorf("%w: %s.%s is assigned by the database and must be read-only, `->`", ErrInvalidVersionTag, f.Schema.Name, f.Name)
+	case p.dialect != "" && p.dialect != "sqlserver":
+		return fmt.Errorf("%w: %s.%s: %s has no rowversion", ErrInvalidVersionTag, f.Schema.Name, f.Name, p.dialect)
+	default:
+		return nil
+	}
+}
+
+// outputVersion has the update of stmt read back the rowversion f it causes, with
+// `OUTPUT INSERTED` between its SET and WHERE clauses, into the model.
+func (p *Plugin) outputVersion(stmt *gorm.Statement, f *schema.Field) {
+	c := stmt.Clauses[clause.Set{}.Name()]
+	c.AfterExpression = outputInserted{column: f.DBName}
+	stmt.Clauses[clause.Set{}.Name()] = c
+	if stmt.DryRun {
+		return
+	}
+	stmt.ConnPool = &outputConnPool{guardedConnPool: guardedConnPool{ConnPool: stmt.ConnPool, stmt: stmt}, field: f}
+}
+
+// outputInserted builds `OUTPUT INSERTED.column`.
+type outputInserted struct {
+	column string
+}
+
+func (o outputInserted) Build(builder clause.Builder) {
+	builder.WriteString("OUTPUT INSERTED.")
+	builder.WriteQuoted(o.column)
+}
+
+type outputConnPool struct {
+	guardedConnPool
+	field *schema.Field
+}
+
+// ExecContext runs the update as a query, setting the model's version to the token it
+// outputs.
+func (c *outputConnPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stmt := c.stmt
+	stmt.ConnPool = c.ConnPool
+	rows, err := c.ConnPool.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var n int64
+	for rows.Next() {
+		token := reflect.New(c.field.IndirectFieldType)
+		if err := rows.Scan(token.Interface()); err != nil {
+			return nil, err
+		}
+		if err := c.field.Set(ctx, stmt.ReflectValue, token.Elem().Interface()); err != nil {
+			return nil, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(n), nil
+}
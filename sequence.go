@@ -0,0 +1,52 @@
+package optimistic
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// sequenceName matches the sequence names tags accept: an identifier, optionally qualified
+// by its schema. Oracle's query names the sequence in the SQL itself rather than binding
+// it.
+var sequenceName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*(\.[A-Za-z_][A-Za-z0-9_$]*)?$`)
+
+// sequenceQueries are the statements drawing the next value of a sequence, by dialect.
+// SQLite and MySQL have no sequences.
+var sequenceQueries = map[string]func(name string) (string, []any){
+	"postgres": func(name string) (string, []any) {
+		return "SELECT nextval(?)", []any{name}
+	},
+	"oracle": func(name string) (string, []any) {
+		return "SELECT " + name + ".NEXTVAL FROM DUAL", nil
+	},
+}
+
+// sequenceVersion draws the next version of the sequence version field f from its
+// sequence, `version:sequence=name`, converted to the field's type. Sequences are shared
+// by every connection to the database, so versions drawn from one are unique and
+// increasing across processes:
+//
+//	Version int64 `gorm:"not null;version:sequence=order_versions"`
+//
+// The sequence must exist. Failures are added to db.
+func (p *Plugin) sequenceVersion(db *gorm.DB, f *schema.Field, params map[string]string) any {
+	query, ok := sequenceQueries[db.Dialector.Name()]
+	if !ok {
+		_ = db.AddError(fmt.Errorf("%w: %s has no sequences", ErrInvalidVersionTag, db.Dialector.Name()))
+		return nil
+	}
+	sql, vars := query(params[ParamSequence])
+	fresh := db.Session(&gorm.Session{NewDB: true})
+	// Must reset Error
+	fresh.Error = nil
+	var n int64
+	if err := fresh.Raw(sql, vars...).Row().Scan(&n); err != nil {
+		_ = db.AddError(fmt.Errorf("sequence %s: %w", params[ParamSequence], err))
+		return nil
+	}
+	return reflect.ValueOf(n).Convert(f.IndirectFieldType).Interface()
+}
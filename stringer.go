@@ -0,0 +1,60 @@
+package optimistic
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// VersionStringer renders a version of any strategy for logs, error messages, metric
+// labels and the like.
+type VersionStringer func(version any) string
+
+// WithVersionStringer renders versions with stringer wherever the plugin writes them into
+// logs or error messages, and in VersionString, so observability output stays consistent:
+//
+//	db.Use(optimistic.NewOptimisticLock(optimistic.WithVersionStringer(optimistic.ShortVersion)))
+//
+// Versions are otherwise rendered with fmt's %v.
+func WithVersionStringer(stringer VersionStringer) ConfigOption {
+	return func(cfg *Config) {
+		cfg.versionStringer = stringer
+	}
+}
+
+// shortVersionLen is how many trailing characters ShortVersion keeps of UUIDs and ULIDs.
+const shortVersionLen = 8
+
+// ShortVersion is a VersionStringer keeping the last 8 characters of UUID and ULID
+// versions, their random part, and rendering time versions in UTC.
+func ShortVersion(version any) string {
+	switch v := derefValue(version).(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		s := v.String()
+		if ty16Byte.AssignableTo(reflect.TypeOf(v)) && len(s) > shortVersionLen {
+			return s[len(s)-shortVersionLen:]
+		}
+		return s
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// VersionString renders version with the VersionStringer of the plugin installed on db.
+func VersionString(db *gorm.DB, version any) string {
+	return pluginFor(db).versionString(version)
+}
+
+// versionString renders version with the configured VersionStringer.
+func (p *Plugin) versionString(version any) string {
+	if p.versionStringer != nil {
+		return p.versionStringer(version)
+	}
+	return fmt.Sprint(derefValue(version))
+}
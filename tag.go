@@ -0,0 +1,292 @@
+package optimistic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// ErrInvalidVersionTag is returned when a version tag carries a parameter the plugin
+// does not understand.
+var ErrInvalidVersionTag = errors.New("invalid version tag")
+
+// Tag names the plugin reads from gorm struct tags, for code generators and validators
+// referencing the identifiers the plugin parses:
+//
+//	tag := fmt.Sprintf(`gorm:"not null;%s:%s,%s"`, optimistic.TagVersion, optimistic.StrategyNameUUID, optimistic.ParamV7)
+const (
+	// TagVersion marks the version field, unless WithTagName names another tag.
+	TagVersion = "version"
+	// TagVersionGroup and TagGroup tag the versions of column groups and their columns.
+	TagVersionGroup = "version_group"
+	TagGroup        = "group"
+	// TagIdentity marks the natural-key columns of models without a primary key.
+	TagIdentity = "identity"
+	// TagFingerprint marks the string field holding the row's fingerprint.
+	TagFingerprint = "fingerprint"
+)
+
+// Strategy names as they appear in version tags, `gorm:"version:uuid"`.
+const (
+	StrategyNameInt        = "int"
+	StrategyNameUUID       = "uuid"
+	StrategyNameULID       = "ulid"
+	StrategyNameTime       = "time"
+	StrategyNameCustom     = "custom"
+	StrategyNameString     = "string"
+	StrategyNameKSUID      = "ksuid"
+	StrategyNameXID        = "xid"
+	StrategyNameSnowflake  = "snowflake"
+	StrategyNameUnixNano   = "unixnano"
+	StrategyNameHLC        = "hlc"
+	StrategyNameSequence   = "sequence"
+	StrategyNameXmin       = "xmin"
+	StrategyNameRowVersion = "rowversion"
+)
+
+// Version tag parameters, following the strategy name.
+const (
+	// ParamUTC and ParamLocal fix the zone of time versions.
+	ParamUTC   = "utc"
+	ParamLocal = "local"
+	// ParamTrunc truncates time versions to s, ms, us or ns, `trunc=ms`.
+	ParamTrunc = "trunc"
+	// ParamV7 generates time-ordered UUID versions.
+	ParamV7 = "v7"
+	// ParamSequence names the sequence of sequence versions, `sequence=order_versions`, an
+	// identifier that may be qualified by its schema.
+	ParamSequence = "sequence"
+)
+
+// ParseStrategy returns the strategy named name, as in a version tag.
+func ParseStrategy(name string) (Strategy, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case StrategyNameInt:
+		return StrategyInt, nil
+	case StrategyNameUUID:
+		return StrategyUUID, nil
+	case StrategyNameULID:
+		return StrategyULID, nil
+	case StrategyNameTime:
+		return StrategyTime, nil
+	case StrategyNameCustom:
+		return StrategyCustom, nil
+	case StrategyNameString:
+		return StrategyString, nil
+	case StrategyNameKSUID:
+		return StrategyKSUID, nil
+	case StrategyNameXID:
+		return StrategyXID, nil
+	case StrategyNameSnowflake:
+		return StrategySnowflake, nil
+	case StrategyNameUnixNano:
+		return StrategyUnixNano, nil
+	case StrategyNameHLC:
+		return StrategyHLC, nil
+	case StrategyNameSequence:
+		return StrategySequence, nil
+	case StrategyNameXmin:
+		return StrategyXmin, nil
+	case StrategyNameRowVersion:
+		return StrategyRowVersion, nil
+	default:
+		return strategyUnknown, fmt.Errorf("%w: unknown strategy %q", ErrInvalidVersionTag, name)
+	}
+}
+
+// VersionTag is a parsed version tag, see ParseVersionTag.
+type VersionTag struct {
+	// Strategy named by the tag, zero for a bare tag, whose field's type decides it.
+	Strategy Strategy
+	// Params maps the parameters following the strategy to their values, "" for flags.
+	Params map[string]string
+}
+
+// ParseVersionTag parses the value of a version tag, what follows `version:`, as the
+// plugin does, and checks its parameters apply to its strategy:
+//
+//	tag, err := optimistic.ParseVersionTag("time,utc,trunc=ms")
+//	// tag.Strategy == optimistic.StrategyTime
+//	// tag.Params == map[string]string{"utc": "", "trunc": "ms"}
+//
+// An empty value is a bare `version` tag.
+func ParseVersionTag(value string) (VersionTag, error) {
+	tag := splitVersionTag(value, TagVersion)
+	if tag.kind == "" {
+		if len(tag.params) > 0 {
+			return VersionTag{}, fmt.Errorf("%w: parameters without a strategy", ErrInvalidVersionTag)
+		}
+		return VersionTag{}, nil
+	}
+	strategy, err := ParseStrategy(tag.kind)
+	if err != nil {
+		return VersionTag{}, err
+	}
+	if key, ok := tag.checkParams(strategy); !ok {
+		return VersionTag{}, fmt.Errorf("%w: %s versions have no parameter %q", ErrInvalidVersionTag, strategy, key)
+	}
+	if key, ok := tag.missingParam(strategy); !ok {
+		return VersionTag{}, fmt.Errorf("%w: %s versions need parameter %q", ErrInvalidVersionTag, strategy, key)
+	}
+	return VersionTag{Strategy: strategy, Params: tag.params}, nil
+}
+
+// versionTag is the parsed value of a version tag. The first element names the strategy
+// and the rest are per-field knobs:
+//
+//	Version time.Time `gorm:"version:time,utc,trunc=ms"`
+//	Version uuid.UUID `gorm:"version:uuid,v7"`
+//
+// Time versions accept `utc` or `local` to fix the zone and `trunc=s|ms|us` to match the
+// precision of the column; UUID versions accept `v7` for time-ordered UUIDs. A strategy
+// may carry a value of its own, kept as the parameter named like it:
+//
+//	Version int64 `gorm:"version:sequence=order_versions"`
+type versionTag struct {
+	kind   string
+	params map[string]string
+}
+
+var truncPrecisions = map[string]time.Duration{
+	"s":  time.Second,
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+	"ns": time.Nanosecond,
+}
+
+// parseVersionTag splits the value of f's version tag into its strategy and parameters.
+func (p *Plugin) parseVersionTag(f *schema.Field) versionTag {
+	return splitVersionTag(f.TagSettings[p.tagName], p.tagName)
+}
+
+// splitVersionTag splits value, the setting of the version tag tagName, into its strategy
+// and parameters.
+func splitVersionTag(value, tagName string) versionTag {
+	parts := strings.Split(value, ",")
+	kind, own, hasOwn := strings.Cut(parts[0], "=")
+	tag := versionTag{kind: strings.ToLower(strings.TrimSpace(kind))}
+	if tag.kind == strings.ToLower(tagName) {
+		// bare `version` tag
+		tag.kind = ""
+	}
+	if hasOwn {
+		tag.params = map[string]string{tag.kind: strings.TrimSpace(own)}
+	}
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if key == "" {
+			continue
+		}
+		if tag.params == nil {
+			tag.params = make(map[string]string, len(parts)-1)
+		}
+		tag.params[strings.ToLower(key)] = value
+	}
+	return tag
+}
+
+// validate reports parameters that do not apply to strategy.
+func (t versionTag) validate(f *schema.Field, strategy Strategy) error {
+	if key, ok := t.checkParams(strategy); !ok {
+		return fmt.Errorf("%w: %s.%s has unsupported parameter %q", ErrInvalidVersionTag, f.Schema.Name, f.Name, key)
+	}
+	if key, ok := t.missingParam(strategy); !ok {
+		return fmt.Errorf("%w: %s.%s needs parameter %q", ErrInvalidVersionTag, f.Schema.Name, f.Name, key)
+	}
+	return nil
+}
+
+// checkParams returns a parameter that does not apply to strategy, reporting false, or
+// true when all of them apply.
+func (t versionTag) checkParams(strategy Strategy) (string, bool) {
+	for key, value := range t.params {
+		ok := false
+		switch strategy {
+		case StrategyTime:
+			switch key {
+			case ParamUTC, ParamLocal:
+				ok = value == ""
+			case ParamTrunc:
+				_, ok = truncPrecisions[strings.ToLower(value)]
+			}
+		case StrategyUUID:
+			ok = key == ParamV7 && value == ""
+		case StrategySequence:
+			ok = key == ParamSequence && sequenceName.MatchString(value)
+		}
+		if !ok {
+			return key, false
+		}
+	}
+	return "", true
+}
+
+// missingParam returns a parameter strategy requires that t lacks, reporting false, or
+// true when t has all of them.
+func (t versionTag) missingParam(strategy Strategy) (string, bool) {
+	if strategy == StrategySequence && t.params[ParamSequence] == "" {
+		return ParamSequence, false
+	}
+	return "", true
+}
+
+// newVersionValue generates the next uuid, ulid, ksuid, xid, snowflake, unixnano, hlc,
+// sequence, time, custom or string version for f. Custom, unixnano and hlc versions follow current,
+// the version replaced, or are the first one for nil.
+func (p *Plugin) newVersionValue(db *gorm.DB, f *schema.Field, strategy Strategy, current any) any {
+	tag := p.parseVersionTag(f)
+	switch strategy {
+	case StrategyULID:
+		return p.generate(db, strategy, p.now(db), p.ulidEntropy, tag.params)
+	case StrategyUUID:
+		return p.generate(db, strategy, p.now(db), p.uuidSource, tag.params)
+	case StrategyKSUID, StrategyXID:
+		return p.generate(db, strategy, p.now(db), nil, tag.params)
+	case StrategySnowflake:
+		return p.snowflakeVersion(db, f)
+	case StrategyUnixNano:
+		return p.unixNanoVersion(db, f, current)
+	case StrategyHLC:
+		return p.hlcVersion(db, f, current)
+	case StrategySequence:
+		return p.sequenceVersion(db, f, tag.params)
+	case StrategyTime:
+		now := p.now(db)
+		if _, ok := tag.params[ParamUTC]; ok {
+			now = now.UTC()
+		} else if _, ok := tag.params[ParamLocal]; ok {
+			now = now.Local()
+		}
+		if d, ok := truncPrecisions[strings.ToLower(tag.params[ParamTrunc])]; ok {
+			now = now.Truncate(d)
+		}
+		return now
+	case StrategyCustom:
+		return p.customVersion(db, f, current)
+	case StrategyString:
+		return p.stringVersion(db, f, tag.params)
+	default:
+		return nil
+	}
+}
+
+// generate draws a value from the strategy's Generator. Failures are added to db, and
+// entropy that fails is never replaced, so versions stay reproducible from it. Strategies
+// without a generator fail their statement before values are generated, see planFor.
+func (p *Plugin) generate(db *gorm.DB, strategy Strategy, now time.Time, entropy io.Reader, params map[string]string) any {
+	gen, err := generatorFor(strategy)
+	if err != nil {
+		return nil
+	}
+	val, err := gen(now, entropy, params)
+	if err != nil {
+		_ = db.AddError(fmt.Errorf("%s version: %w", strategy, err))
+		return nil
+	}
+	return val
+}
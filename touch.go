@@ -0,0 +1,58 @@
+package optimistic
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const touchClauseName = "optimistic:touch"
+
+// touchClause marks the update issued by Touch.
+type touchClause struct{}
+
+func (x touchClause) Name() string                 { return touchClauseName }
+func (x touchClause) Build(clause.Builder)         {}
+func (x touchClause) MergeClause(c *clause.Clause) { c.Expression = x }
+
+// Touch bumps the version of model's row, guarded by model's version, without changing any
+// other column, to invalidate cached copies or to claim the row for its loader:
+//
+//	if err := optimistic.Touch(db, &job); errors.Is(err, optimistic.ErrOptimisticLock) {
+//		// another worker claimed the job first
+//	}
+//
+// On success model carries the new version. Conflict clauses given on db apply as to any
+// update.
+func Touch(db *gorm.DB, model any) error {
+	stmt, err := parseTarget(db, model)
+	if err != nil {
+		return err
+	}
+	p := pluginFor(db)
+	if p.findVersionField(stmt.Schema) == nil {
+		return fmt.Errorf("%w: %s", ErrNoVersionField, stmt.Table)
+	}
+	if _, ok := registryKey(stmt, p.identityFields(stmt.Schema), stmt.ReflectValue); !ok {
+		return gorm.ErrPrimaryKeyRequired
+	}
+	return db.Model(model).Clauses(touchClause{}).Updates(map[string]any{}).Error
+}
+
+// touchAssignment rewrites a column of the row of stmt unchanged, for updates of versions
+// the database assigns itself that assign nothing else, such as touches. It prefers a
+// column outside the key, as SQL Server refuses to set identity columns even to themselves.
+func (p *Plugin) touchAssignment(stmt *gorm.Statement, f *schema.Field) clause.Assignment {
+	identity, _ := p.stmtIdentity(stmt)
+	name := identity[0].DBName
+	for _, sf := range stmt.Schema.Fields {
+		if sf != f && sf.DBName != "" && sf.Updatable && !sf.PrimaryKey {
+			name = sf.DBName
+			break
+		}
+	}
+	column := clause.Column{Name: name}
+	return clause.Assignment{Column: column, Value: column}
+}
@@ -0,0 +1,88 @@
+package optimistic
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrTransient is returned (wrapped in a *TransientError) under WithTransientErrors for
+// guarded updates that failed with a driver error worth retrying.
+var ErrTransient = errors.New("transient error")
+
+// ErrorClassifier reports whether err, which a guarded update failed with, is transient.
+type ErrorClassifier func(err error) bool
+
+// WithTransientErrors wraps driver errors of guarded updates that one of classifiers, or
+// IsTransientDriverError when none are given, deems transient in a *TransientError, so
+// callers retry them like version conflicts:
+//
+//	for attempt := 0; attempt < 3; attempt++ {
+//		if err = save(db); !optimistic.Retryable(err) {
+//			break
+//		}
+//	}
+//
+// Classifiers see the error as returned by the driver as well as translated by the
+// dialector's gorm.ErrorTranslator.
+func WithTransientErrors(classifiers ...ErrorClassifier) ConfigOption {
+	return func(cfg *Config) {
+		if len(classifiers) == 0 {
+			classifiers = []ErrorClassifier{IsTransientDriverError}
+		}
+		cfg.transientClassifiers = classifiers
+	}
+}
+
+// Retryable reports whether err is worth retrying the read-modify-write that returned
+// it: a version conflict, or a transient error under WithTransientErrors.
+func Retryable(err error) bool {
+	return errors.Is(err, ErrOptimisticLock) || errors.Is(err, ErrTransient)
+}
+
+// transientSQLStates are serialization_failure and deadlock_detected, as reported by
+// drivers exposing SQLSTATE codes, e.g. pgx.
+var transientSQLStates = []string{"40001", "40P01"}
+
+// transientMessages identify transient errors of drivers without SQLSTATE codes.
+var transientMessages = []string{
+	"deadlock",                   // MySQL 1213, Oracle ORA-00060
+	"lock wait timeout exceeded", // MySQL 1205
+	"could not serialize access", // PostgreSQL
+	"database is locked",         // SQLite SQLITE_BUSY
+	"ora-08177",                  // Oracle, can't serialize access
+}
+
+// IsTransientDriverError reports whether err is a serialization failure, deadlock or
+// lock timeout of one of the databases the plugin supports.
+func IsTransientDriverError(err error) bool {
+	var coded interface{ SQLState() string }
+	if errors.As(err, &coded) && slices.Contains(transientSQLStates, coded.SQLState()) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return slices.ContainsFunc(transientMessages, func(s string) bool {
+		return strings.Contains(msg, s)
+	})
+}
+
+// classifyError wraps the driver error of a guarded update in a *TransientError when it
+// is transient.
+func (p *Plugin) classifyError(db *gorm.DB) {
+	if len(p.transientClassifiers) == 0 || db.Error == nil || errors.Is(db.Error, ErrOptimisticLock) {
+		return
+	}
+	_, guarded := db.InstanceGet(contextKeyToVersion)
+	if _, grouped := db.InstanceGet(contextKeyGroupVersions); !guarded && !grouped {
+		return
+	}
+	translated := translateError(db, db.Error)
+	for _, classify := range p.transientClassifiers {
+		if classify(db.Error) || classify(translated) {
+			db.Error = &TransientError{Table: db.Statement.Table, Err: db.Error}
+			return
+		}
+	}
+}
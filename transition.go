@@ -0,0 +1,74 @@
+package optimistic
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// ErrIllegalTransition is returned (wrapped in a *TransitionError) by Transition when the
+// allowed edges do not lead from the model's state to the requested one.
+var ErrIllegalTransition = errors.New("illegal transition")
+
+// TransitionError describes a transition Transition refused.
+type TransitionError struct {
+	// Column holds the state.
+	Column string
+	// From is the model's state, To the one requested.
+	From, To string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s of %s: %s -> %s", ErrIllegalTransition, e.Column, e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error { return ErrIllegalTransition }
+
+// Transition moves the state held in column of model to to, if allowed has an edge from
+// the model's state to it, and writes only that column under the version guard:
+//
+//	err := optimistic.Transition(db, &order, "status", map[string][]string{
+//		"open":    {"paid", "cancelled"},
+//		"paid":    {"shipped", "refunded"},
+//		"shipped": {"delivered"},
+//	}, "paid")
+//	switch {
+//	case errors.Is(err, optimistic.ErrIllegalTransition):
+//		// order is not in a state that can become paid
+//	case errors.Is(err, optimistic.ErrOptimisticLock):
+//		// order changed since it was loaded; reload and decide again
+//	}
+//
+// The state is checked against model as loaded, and the guard makes sure the row still is
+// in that state. model keeps its state when the transition fails.
+func Transition(db *gorm.DB, model any, column string, allowed map[string][]string, to string) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	f := stmt.Schema.LookUpField(column)
+	if f == nil {
+		return fmt.Errorf("%w: %s", gorm.ErrInvalidField, column)
+	}
+	rv := reflect.Indirect(reflect.ValueOf(model))
+	if rv.Kind() != reflect.Struct || !rv.CanAddr() {
+		return gorm.ErrInvalidValue
+	}
+	state, _ := f.ValueOf(db.Statement.Context, rv)
+	from := fmt.Sprint(derefValue(state))
+	if !slices.Contains(allowed[from], to) {
+		return &TransitionError{Column: f.DBName, From: from, To: to}
+	}
+
+	if err := f.Set(db.Statement.Context, rv, to); err != nil {
+		return err
+	}
+	if err := db.Model(model).Update(f.DBName, to).Error; err != nil {
+		_ = f.Set(db.Statement.Context, rv, state)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// Package ulidversion generates ULID versions with github.com/oklog/ulid/v2. Import it
+// for its side effect wherever models have ULID version fields:
+//
+//	import _ "github.com/cmmoran/optimistic/ulidversion"
+//
+// ULIDs generated within the same millisecond from the same entropy source are monotonic.
+package ulidversion
+
+import (
+	"crypto/rand"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+
+	"github.com/cmmoran/optimistic"
+)
+
+func init() {
+	optimistic.RegisterGenerator(optimistic.StrategyULID, generate)
+}
+
+// monotonic serializes a monotonic entropy source, which is not safe for concurrent use.
+type monotonic struct {
+	mu      sync.Mutex
+	entropy *ulid.MonotonicEntropy
+}
+
+var (
+	defaultEntropy = &monotonic{entropy: ulid.Monotonic(rand.Reader, 0)}
+	// sources maps configured entropy readers to their monotonic source
+	sources sync.Map
+)
+
+func generate(now time.Time, entropy io.Reader, _ map[string]string) (any, error) {
+	src := defaultEntropy
+	if entropy != nil {
+		s, _ := sources.LoadOrStore(entropy, &monotonic{entropy: ulid.Monotonic(entropy, 0)})
+		src = s.(*monotonic)
+	}
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	return ulid.New(ulid.Timestamp(now), src.entropy)
+}
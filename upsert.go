@@ -0,0 +1,229 @@
+package optimistic
+
+import (
+	"reflect"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// WithUpsertGuard guards the updates of existing rows by creates with an OnConflict clause,
+// on dialects that support `DO UPDATE ... WHERE` and `RETURNING`, postgres and sqlite. A
+// row is only updated while its version still equals the one of the record being saved,
+// and the create fails with ErrOptimisticLock when any row was skipped:
+//
+//	err := db.Clauses(clause.OnConflict{
+//		Columns:   []clause.Column{{Name: "id"}},
+//		DoUpdates: clause.AssignmentColumns([]string{"status"}),
+//	}).Create(&orders).Error
+//
+// Records without a version are inserted as new. Without the guard, upserts still bump the
+// version of the rows they update.
+func WithUpsertGuard() ConfigOption {
+	return func(cfg *Config) {
+		cfg.upsertGuard = true
+	}
+}
+
+// upsertClause returns the statement's OnConflict clause when it updates existing rows.
+func upsertClause(stmt *gorm.Statement) (clause.OnConflict, bool) {
+	c, ok := stmt.Clauses[clause.OnConflict{}.Name()]
+	if !ok {
+		return clause.OnConflict{}, false
+	}
+	onConflict, ok := c.Expression.(clause.OnConflict)
+	return onConflict, ok && !onConflict.DoNothing && (onConflict.UpdateAll || len(onConflict.DoUpdates) > 0)
+}
+
+// guardsUpsert reports whether the create is an upsert the plugin can guard: any upsert
+// under WithUpsertGuard, and child upserts of FullSaveAssociations saves. gorm saves
+// associations with `ON CONFLICT ... DO UPDATE SET` for every column, which would
+// overwrite the version of child rows edited concurrently; guarding needs
+// `DO UPDATE ... WHERE` and `RETURNING`, so only postgres and sqlite qualify.
+func (p *Plugin) guardsUpsert(db *gorm.DB) bool {
+	if !p.returnsOnCreate(db) {
+		return false
+	}
+	onConflict, ok := upsertClause(db.Statement)
+	return ok && (p.upsertGuard || (db.FullSaveAssociations && onConflict.UpdateAll))
+}
+
+// bumpsUpsert reports whether the create is an upsert whose updates the plugin bumps
+// without guarding them. Other dialects than these build upserts their own way.
+func (p *Plugin) bumpsUpsert(db *gorm.DB) bool {
+	switch db.Dialector.Name() {
+	case "postgres", "sqlite", "mysql":
+		_, ok := upsertClause(db.Statement)
+		return ok
+	default:
+		return false
+	}
+}
+
+// guardUpsert rewrites the statement's upsert so an existing row is only overwritten
+// while its version still equals the one being saved, bumping it when it is. Rows
+// skipped by the guard are reported as a conflict by verifyCreate.
+func (p *Plugin) guardUpsert(db *gorm.DB, f *schema.Field, strategy Strategy, rows int) {
+	stmt := db.Statement
+	onConflict := p.rewriteUpsert(db, f, strategy)
+	onConflict.Where.Exprs = append(onConflict.Where.Exprs, clause.Expr{
+		SQL:  "? = excluded.?",
+		Vars: []any{clause.Column{Table: clause.CurrentTable, Name: f.DBName}, clause.Column{Name: f.DBName}},
+	})
+	stmt.AddClause(onConflict)
+
+	// read back bumped versions
+	stmt.AddClause(createReturning(stmt, f))
+	db.InstanceSet(contextKeyGuardedUpsert, rows)
+}
+
+// bumpUpsert rewrites the statement's upsert to bump the version of the rows it updates,
+// reading the versions back where the dialect can.
+func (p *Plugin) bumpUpsert(db *gorm.DB, f *schema.Field, strategy Strategy) {
+	stmt := db.Statement
+	stmt.AddClause(p.rewriteUpsert(db, f, strategy))
+	if p.returnsOnCreate(db) {
+		stmt.AddClause(createReturning(stmt, f))
+	}
+}
+
+// rewriteUpsert returns the statement's upsert with its version assignment replaced by a
+// bump. `UpdateAll` is spelled out so the version column can be left out of it.
+func (p *Plugin) rewriteUpsert(db *gorm.DB, f *schema.Field, strategy Strategy) clause.OnConflict {
+	stmt := db.Statement
+	onConflict, _ := upsertClause(stmt)
+
+	current := clause.Column{Table: clause.CurrentTable, Name: f.DBName}
+	var next any
+	if strategy == StrategyInt {
+		next = clause.Expr{SQL: "? + 1", Vars: []any{current}}
+	} else {
+		next = p.newVersionValue(db, f, strategy, nil)
+	}
+	if onConflict.UpdateAll {
+		onConflict.UpdateAll = false
+		onConflict.DoUpdates = clause.AssignmentColumns(upsertColumns(stmt, f))
+		// gorm only defaults the conflict target for `UpdateAll`
+		if len(onConflict.Columns) == 0 {
+			for _, pk := range stmt.Schema.PrimaryFields {
+				onConflict.Columns = append(onConflict.Columns, clause.Column{Name: pk.DBName})
+			}
+		}
+	} else {
+		onConflict.DoUpdates = slices.DeleteFunc(slices.Clone(onConflict.DoUpdates), func(a clause.Assignment) bool {
+			return a.Column.Name == f.DBName || a.Column.Name == f.Name
+		})
+	}
+	onConflict.DoUpdates = append(onConflict.DoUpdates,
+		clause.Assignment{Column: clause.Column{Name: f.DBName}, Value: next})
+	return onConflict
+}
+
+// returnsOnCreate reports whether inserts can read back the version with `RETURNING`.
+// Other dialects implement it differently for inserts, if at all.
+func (p *Plugin) returnsOnCreate(db *gorm.DB) bool {
+	if p.disableReturning {
+		return false
+	}
+	switch db.Dialector.Name() {
+	case "postgres", "sqlite":
+		return registersReturning(db.Callback().Create().Clauses)
+	default:
+		return false
+	}
+}
+
+// registersReturning reports whether the dialector builds `RETURNING` for statements made
+// of clauses.
+func registersReturning(clauses []string) bool {
+	return slices.Contains(clauses, "RETURNING")
+}
+
+// createReturning returns the version column along with what gorm itself would return,
+// since gorm only adds its own RETURNING when the statement has none.
+func createReturning(stmt *gorm.Statement, f *schema.Field) clause.Returning {
+	returning := clause.Returning{Columns: []clause.Column{{Name: f.DBName}}}
+	for _, df := range stmt.Schema.FieldsWithDefaultDBValue {
+		if df.Readable {
+			returning.Columns = append(returning.Columns, clause.Column{Name: df.DBName})
+		}
+	}
+	return returning
+}
+
+// readBackVersions replaces the versions of freshly inserted rows with the stored ones on
+// dialects where the insert cannot return them.
+func (p *Plugin) readBackVersions(db *gorm.DB, f *schema.Field) {
+	stmt := db.Statement
+	dest := reflect.Indirect(reflect.ValueOf(stmt.Dest))
+	elems := []reflect.Value{dest}
+	if dest.Kind() == reflect.Slice || dest.Kind() == reflect.Array {
+		elems = elems[:0]
+		for i := 0; i < dest.Len(); i++ {
+			elems = append(elems, reflect.Indirect(dest.Index(i)))
+		}
+	}
+	identity := p.identityFields(stmt.Schema)
+	for _, elem := range elems {
+		if elem.Kind() != reflect.Struct {
+			continue
+		}
+		row := &gorm.Statement{DB: db, Context: stmt.Context, Schema: stmt.Schema, ReflectValue: elem}
+		stored := reflect.New(stmt.Schema.ModelType)
+		fresh := db.Session(&gorm.Session{NewDB: true, SkipHooks: true, Context: p.keepStored(stmt.Context)})
+		// Must reset Error
+		fresh.Error = nil
+		err := fresh.Unscoped().
+			Model(stored.Interface()).
+			Select(f.DBName).
+			Where(clause.Where{Exprs: identityConds(row, identity)}).
+			Take(stored.Interface()).Error
+		if err != nil {
+			continue
+		}
+		val, _ := f.ValueOf(stmt.Context, stored.Elem())
+		_ = f.Set(stmt.Context, elem, val)
+	}
+}
+
+// upsertColumns lists the columns gorm would overwrite for `UpdateAll`, minus the version.
+func upsertColumns(stmt *gorm.Statement, f *schema.Field) []string {
+	selectColumns, restricted := stmt.SelectAndOmitColumns(true, false)
+	columns := make([]string, 0, len(stmt.Schema.DBNames))
+	for _, name := range stmt.Schema.DBNames {
+		field := stmt.Schema.FieldsByDBName[name]
+		if field == f || field.PrimaryKey || !field.Creatable || field.AutoCreateTime > 0 {
+			continue
+		}
+		if v, ok := selectColumns[name]; (ok && !v) || (!ok && restricted) {
+			continue
+		}
+		if field.HasDefaultValue && field.DefaultValueInterface == nil {
+			continue
+		}
+		columns = append(columns, name)
+	}
+	return columns
+}
+
+// verifyUpsert fails a guarded upsert when any of its rows was skipped by the guard.
+func verifyUpsert(db *gorm.DB) bool {
+	rows, ok := db.InstanceGet(contextKeyGuardedUpsert)
+	if !ok {
+		return false
+	}
+	if n, _ := rows.(int); db.RowsAffected < int64(n) {
+		_ = db.AddError(ErrOptimisticLock)
+	}
+	return true
+}
+
+// countRows is the number of records a create inserts.
+func countRows(dest reflect.Value) int {
+	if dest.Kind() == reflect.Slice || dest.Kind() == reflect.Array {
+		return dest.Len()
+	}
+	return 1
+}
@@ -0,0 +1,35 @@
+// Package uuidversion generates UUID versions with github.com/google/uuid. Import it for
+// its side effect wherever models have UUID version fields:
+//
+//	import _ "github.com/cmmoran/optimistic/uuidversion"
+//
+// Versions are random (v4) UUIDs, or time-ordered (v7) ones for fields tagged
+// `version:uuid,v7`.
+package uuidversion
+
+import (
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cmmoran/optimistic"
+)
+
+func init() {
+	optimistic.RegisterGenerator(optimistic.StrategyUUID, generate)
+}
+
+func generate(_ time.Time, entropy io.Reader, params map[string]string) (any, error) {
+	_, v7 := params["v7"]
+	switch {
+	case entropy == nil && v7:
+		return uuid.NewV7()
+	case entropy == nil:
+		return uuid.NewRandom()
+	case v7:
+		return uuid.NewV7FromReader(entropy)
+	default:
+		return uuid.NewRandomFromReader(entropy)
+	}
+}
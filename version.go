@@ -0,0 +1,216 @@
+package optimistic
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Version is a numeric version field that carries its own optimistic-locking clauses,
+// so models using it are guarded even on connections where the Plugin is not installed:
+//
+//	type Model struct {
+//		ID      uint64 `gorm:"primaryKey"`
+//		...
+//		Version optimistic.Version
+//	}
+//
+// Creates seed the version with 1, targeted updates add `version = ?` to the WHERE clause
+// and bump it by one, and targeted deletes are guarded the same way. Statements that match
+// no row fail with a *ConflictError wrapping ErrOptimisticLock; add
+// Conflict{AttachCurrent: true} to have it carry the row that blocked the statement.
+// Guarded statements drop clause.Returning, as their affected rows decide the outcome.
+// When the Plugin is installed it recognizes Version fields without a tag and takes over,
+// adding conflict resolution via Conflict.
+//
+// With soft-delete models declare Version before gorm.DeletedAt so the delete guard is
+// added before the soft-delete UPDATE is built.
+type Version uint64
+
+var tyVersion = reflect.TypeOf(Version(0))
+
+func (Version) CreateClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{versionCreateClause{Field: f}}
+}
+
+func (Version) UpdateClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{versionUpdateClause{Field: f}}
+}
+
+func (Version) DeleteClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{versionDeleteClause{Field: f}}
+}
+
+func (Version) QueryClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{versionQueryClause{Field: f}}
+}
+
+type versionCreateClause struct {
+	Field *schema.Field
+}
+
+func (v versionCreateClause) Name() string               { return "" }
+func (v versionCreateClause) Build(clause.Builder)       {}
+func (v versionCreateClause) MergeClause(*clause.Clause) {}
+func (v versionCreateClause) ModifyStatement(stmt *gorm.Statement) {
+	if stmt.SQL.Len() > 0 || pluginInstalled(stmt.DB) {
+		return
+	}
+	pluginFor(stmt.DB).initializeVersion(stmt.DB)
+}
+
+type versionUpdateClause struct {
+	Field *schema.Field
+}
+
+func (v versionUpdateClause) Name() string               { return "" }
+func (v versionUpdateClause) Build(clause.Builder)       {}
+func (v versionUpdateClause) MergeClause(*clause.Clause) {}
+func (v versionUpdateClause) ModifyStatement(stmt *gorm.Statement) {
+	if stmt.SQL.Len() > 0 || pluginInstalled(stmt.DB) {
+		return
+	}
+	db := stmt.DB
+	// Without the plugin's after-callbacks the outcome is verified through RowsAffected,
+	// so RETURNING is never requested here.
+	pluginFor(db).modifyUpdate(false)(db)
+	toVal, ok := db.InstanceGet(contextKeyToVersion)
+	if !ok {
+		return
+	}
+	fromVal, _ := db.InstanceGet(contextKeyFromVersion)
+	guardConnPool(stmt, func(rowsAffected int64) error {
+		if rowsAffected == 0 {
+			return newConflictError(stmt)
+		}
+		next := toVal
+		if _, isExpr := toVal.(clause.Expr); isExpr {
+			n, _ := asUint64(fromVal)
+			next = n + 1
+		}
+		return v.Field.Set(stmt.Context, stmt.ReflectValue, next)
+	})
+}
+
+type versionDeleteClause struct {
+	Field *schema.Field
+}
+
+func (v versionDeleteClause) Name() string               { return "" }
+func (v versionDeleteClause) Build(clause.Builder)       {}
+func (v versionDeleteClause) MergeClause(*clause.Clause) {}
+func (v versionDeleteClause) ModifyStatement(stmt *gorm.Statement) {
+	if stmt.SQL.Len() > 0 || stmt.Unscoped || pluginInstalled(stmt.DB) {
+		return
+	}
+	if !isTargetedModelUpdate(stmt) || reflect.Indirect(stmt.ReflectValue).Kind() != reflect.Struct {
+		return
+	}
+	var exprs []clause.Expression
+	if len(stmt.Schema.PrimaryFields) == 0 {
+		exprs = identityConds(stmt, pluginFor(stmt.DB).identityFields(stmt.Schema))
+	}
+	oldVal, _ := v.Field.ValueOf(stmt.Context, stmt.ReflectValue)
+	oldVal, err := pluginFor(stmt.DB).decodeVersion(stmt.Context, stmt.Table, oldVal)
+	if err != nil {
+		_ = stmt.AddError(err)
+		return
+	}
+	stmt.AddClause(clause.Where{Exprs: append(exprs, clause.Eq{
+		Column: clause.Column{Table: clause.CurrentTable, Name: v.Field.DBName},
+		Value:  oldVal,
+	})})
+	if stmt.DryRun {
+		return
+	}
+	guardConnPool(stmt, func(rowsAffected int64) error {
+		if rowsAffected == 0 {
+			return newConflictError(stmt)
+		}
+		return nil
+	})
+}
+
+type versionQueryClause struct {
+	Field *schema.Field
+}
+
+func (v versionQueryClause) Name() string               { return "" }
+func (v versionQueryClause) Build(clause.Builder)       {}
+func (v versionQueryClause) MergeClause(*clause.Clause) {}
+func (v versionQueryClause) ModifyStatement(stmt *gorm.Statement) {
+	if stmt.SQL.Len() > 0 || pluginInstalled(stmt.DB) {
+		return
+	}
+	// Without the plugin an AtLeast guard still narrows the read, but an empty result
+	// surfaces as gorm.ErrRecordNotFound rather than a *StaleReadError.
+	if _, ok := stmt.Clauses[minVersionClauseName]; ok {
+		pluginFor(stmt.DB).injectMinVersion(stmt.DB)
+	}
+}
+
+func pluginInstalled(db *gorm.DB) bool {
+	if db == nil || db.Config == nil {
+		return false
+	}
+	_, ok := db.Config.Plugins[Plugin{}.Name()]
+	return ok
+}
+
+// guardConnPool swaps the statement's connection for one that reports the number of
+// affected rows to check once the statement has been executed, then restores it. gorm
+// runs statements carrying RETURNING as queries, whose rows cannot be counted before gorm
+// scans them, so the statement runs without it.
+func guardConnPool(stmt *gorm.Statement, check func(rowsAffected int64) error) {
+	delete(stmt.Clauses, "RETURNING")
+	stmt.ConnPool = &guardedConnPool{ConnPool: stmt.ConnPool, stmt: stmt, check: check}
+}
+
+type guardedConnPool struct {
+	gorm.ConnPool
+	stmt  *gorm.Statement
+	check func(rowsAffected int64) error
+}
+
+func (c *guardedConnPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	c.stmt.ConnPool = c.ConnPool
+	result, err := c.ConnPool.ExecContext(ctx, query, args...)
+	if err != nil {
+		return result, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return result, err
+	}
+	return result, c.check(rows)
+}
+
+// QueryContext only restores the pool: guarded statements never run as queries, as
+// guardConnPool drops their RETURNING clause and the pools embedding guardedConnPool run
+// theirs through ExecContext.
+func (c *guardedConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	c.stmt.ConnPool = c.ConnPool
+	return c.ConnPool.QueryContext(ctx, query, args...)
+}
+
+// Commit and Rollback keep gorm's default transaction working when the statement
+// failed before it was executed and the guarded pool is still in place.
+func (c *guardedConnPool) Commit() error {
+	c.stmt.ConnPool = c.ConnPool
+	if committer, ok := c.ConnPool.(gorm.TxCommitter); ok {
+		return committer.Commit()
+	}
+	return gorm.ErrInvalidTransaction
+}
+
+func (c *guardedConnPool) Rollback() error {
+	c.stmt.ConnPool = c.ConnPool
+	if committer, ok := c.ConnPool.(gorm.TxCommitter); ok {
+		return committer.Rollback()
+	}
+	return gorm.ErrInvalidTransaction
+}
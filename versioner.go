@@ -0,0 +1,68 @@
+package optimistic
+
+import (
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Versioner is implemented by version types of your own, which version their fields with
+// StrategyCustom: the plugin seeds new rows with the Next of the type's zero value, bumps
+// rows to the Next of their loaded version and compares versions read back with Equal:
+//
+//	type Revision string // "r1", "r2", ...
+//
+//	func (r Revision) Next(time.Time) any {
+//		n, _ := strconv.Atoi(strings.TrimPrefix(string(r), "r"))
+//		return Revision("r" + strconv.Itoa(n+1))
+//	}
+//	func (r Revision) IsZero() bool          { return r == "" }
+//	func (r Revision) Equal(other any) bool { return r == other }
+//
+// The type must be a column type the database driver can store, through driver.Valuer
+// and sql.Scanner if need be.
+type Versioner interface {
+	// Next returns the version following this one, for a write at now.
+	Next(now time.Time) any
+	// IsZero reports whether the version is unset.
+	IsZero() bool
+	// Equal reports whether other, a version as read back from the database, is this one.
+	Equal(other any) bool
+}
+
+var tyVersioner = reflect.TypeOf((*Versioner)(nil)).Elem()
+
+// implementsVersioner reports whether values of t, or pointers to them, are Versioners.
+func implementsVersioner(t reflect.Type) bool {
+	return t.Implements(tyVersioner) || reflect.PointerTo(t).Implements(tyVersioner)
+}
+
+// asVersioner returns v, a value of t or nil for t's zero value, as a Versioner.
+func asVersioner(v any, t reflect.Type) (Versioner, bool) {
+	if ver, ok := v.(Versioner); ok {
+		return ver, true
+	}
+	rv := reflect.ValueOf(derefValue(v))
+	if !rv.IsValid() {
+		rv = reflect.Zero(t)
+	}
+	if !rv.Type().ConvertibleTo(t) {
+		return nil, false
+	}
+	ptr := reflect.New(t)
+	ptr.Elem().Set(rv.Convert(t))
+	ver, ok := ptr.Interface().(Versioner)
+	return ver, ok
+}
+
+// customVersion returns the version of the custom version field f following current, or
+// the first version for nil.
+func (p *Plugin) customVersion(db *gorm.DB, f *schema.Field, current any) any {
+	ver, ok := asVersioner(current, f.IndirectFieldType)
+	if !ok {
+		return nil
+	}
+	return ver.Next(p.now(db))
+}
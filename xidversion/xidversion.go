@@ -0,0 +1,25 @@
+// Package xidversion generates xid versions with github.com/rs/xid. Import it for its side
+// effect wherever models have xid version fields:
+//
+//	import _ "github.com/cmmoran/optimistic/xidversion"
+//
+// xids order by the second they were generated in and by a per-process counter within it.
+// They take no entropy source.
+package xidversion
+
+import (
+	"io"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/cmmoran/optimistic"
+)
+
+func init() {
+	optimistic.RegisterGenerator(optimistic.StrategyXID, generate)
+}
+
+func generate(now time.Time, _ io.Reader, _ map[string]string) (any, error) {
+	return xid.NewWithTime(now), nil
+}
@@ -0,0 +1,60 @@
+package optimistic
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// xminColumn is the postgres system column holding the id of the transaction that wrote
+// a row version. Fields tagged `version:xmin` map it instead of a column of their own, so
+// tables need no schema change to be guarded:
+//
+//	Version uint32 `gorm:"column:xmin;->;-:migration;version:xmin"`
+//
+// postgres sets xmin on every write, so the plugin only guards updates and deletes with
+// `xmin = ?` and reads it back with RETURNING. Updates made by one transaction share its
+// xmin, so a row updated twice within a transaction keeps its version.
+const xminColumn = "xmin"
+
+// checkXmin reports why the xmin version field f cannot be used on the plugin's database.
+func (p *Plugin) checkXmin(f *schema.Field) error {
+	switch {
+	case f.DBName != xminColumn:
+		return fmt.Errorf("%w: %s.%s needs `column:%s`", ErrInvalidVersionTag, f.Schema.Name, f.Name, xminColumn)
+	case f.Creatable || f.Updatable:
+		return fmt.Errorf("%w: %s.%s maps a system column and must be read-only, `->`", ErrInvalidVersionTag, f.Schema.Name, f.Name)
+	case p.dialect != "" && p.dialect != "postgres":
+		return fmt.Errorf("%w: %s.%s: %s has no xmin", ErrInvalidVersionTag, f.Schema.Name, f.Name, p.dialect)
+	case p.dialect != "" && !p.returning:
+		return fmt.Errorf("%w: %s.%s: xmin versions are read back with RETURNING", ErrInvalidVersionTag, f.Schema.Name, f.Name)
+	default:
+		return nil
+	}
+}
+
+// selectXmin lists the columns of queries of models with xmin versions, as `SELECT *`
+// leaves system columns out. Queries with selects, omits or joins list them already.
+func (p *Plugin) selectXmin(db *gorm.DB) {
+	stmt := db.Statement
+	if stmt.Schema == nil || len(stmt.Selects) > 0 || len(stmt.Omits) > 0 || len(stmt.Joins) > 0 {
+		return
+	}
+	if _, ok := stmt.Clauses[clause.Select{}.Name()]; ok {
+		return
+	}
+	if from, ok := stmt.Clauses[clause.From{}.Name()].Expression.(clause.From); ok && len(from.Joins) > 0 {
+		return
+	}
+	f := p.findVersionField(stmt.Schema)
+	if f == nil || p.planFor(f).strategy != StrategyXmin {
+		return
+	}
+	columns := make([]clause.Column, 0, len(stmt.Schema.DBNames))
+	for _, name := range stmt.Schema.DBNames {
+		columns = append(columns, clause.Column{Table: clause.CurrentTable, Name: name})
+	}
+	stmt.AddClause(clause.Select{Distinct: stmt.Distinct, Columns: columns})
+}